@@ -1,40 +1,246 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/Chinsusu/proxy-manager/api/internal/alerting"
+	"github.com/Chinsusu/proxy-manager/api/internal/audit"
+	"github.com/Chinsusu/proxy-manager/api/internal/checkhistory"
 	"github.com/Chinsusu/proxy-manager/api/internal/config"
 	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/database/migrations"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/expiry"
+	"github.com/Chinsusu/proxy-manager/api/internal/geoip"
 	"github.com/Chinsusu/proxy-manager/api/internal/handlers"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/liveness"
+	"github.com/Chinsusu/proxy-manager/api/internal/logging"
+	"github.com/Chinsusu/proxy-manager/api/internal/metrics"
 	"github.com/Chinsusu/proxy-manager/api/internal/middleware"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/rollout"
+	"github.com/Chinsusu/proxy-manager/api/internal/scheduler"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
+	"github.com/Chinsusu/proxy-manager/api/internal/tracing"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/Chinsusu/proxy-manager/api/internal/webhooks"
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	if err := run(); err != nil {
+		code := 1
+		if errors.Is(err, errUsage) {
+			code = 2
+		}
+		os.Exit(code)
+	}
+}
+
+// run starts the API, or the subcommand named by the first argument, and
+// returns once it is done. Errors are logged where they happen; main exits
+// with them only after the deferred cleanups here have run.
+func run() error {
 	// Load configuration
 	cfg := config.Load()
 
+	level, err := logging.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		level = slog.LevelInfo
+	}
+	logging.Setup(level)
+	if err != nil {
+		slog.Warn("Invalid LOG_LEVEL, using info", "log_level", cfg.LogLevel)
+	}
+
+	// Install the trace exporter; spans are no-ops when tracing is off
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg)
+	if err != nil {
+		return failed("Failed to set up tracing", err)
+	}
+	// Flush the spans still batched on the way out
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			slog.Warn("Failed to flush traces", "error", err)
+		}
+	}()
+
+	// Migrations do not touch credentials, so they run without the key
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return runMigrations(cfg, os.Args[2:])
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "generate-key" {
+		if err := secrets.CreateKeyFile(cfg.CredentialsKeyFile); err != nil {
+			return failed("Failed to generate credentials key", err)
+		}
+		slog.Info("Created credentials key file", "path", cfg.CredentialsKeyFile)
+		return nil
+	}
+
 	// Connect to database
 	db, err := database.Connect(cfg)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		return failed("Failed to connect to database", err)
+	}
+
+	// Load the key proxy credentials are sealed with, and check that it
+	// opens those already stored
+	sealed, err := db.SealedSamples()
+	if err != nil {
+		return failed("Failed to read stored credentials", err)
+	}
+	keyring, err := secrets.Load(cfg, sealed)
+	if err != nil {
+		return failed("Failed to load credentials key", err)
+	}
+	secrets.Configure(keyring, cfg.EncryptProxyUsernames)
+
+	if len(os.Args) > 1 && os.Args[1] == "rotate-credentials" {
+		return rotateCredentials(db, keyring, cfg)
 	}
 
+	store := repository.New(db)
+
+	// Background loops run until SIGINT or SIGTERM, and are waited for on
+	// the way out so none is stopped in the middle of a transaction
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	var background sync.WaitGroup
+	defer func() {
+		stop()
+		background.Wait()
+	}()
+	start := func(loop func(context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			loop(ctx)
+		}()
+	}
+
+	// Start mapping schedule evaluation
+	sched := scheduler.New(store)
+	start(sched.Run)
+
+	// Start purging expired items from the trash
+	purger := trash.New(store, cfg.TrashRetention)
+	start(purger.Run)
+
+	// Start marking servers offline whose agent stopped reporting in
+	monitor := liveness.New(store, cfg.ServerOfflineAfter)
+	start(monitor.Run)
+
+	// Start warning about proxies before they expire and disabling expired ones
+	expiryMonitor := expiry.New(store, cfg.ExpiryWarnBefore, cfg.DisableExpiredProxies)
+	start(expiryMonitor.Run)
+
+	// Start checking proxies and downsampling their check history
+	geo, err := geoip.Open(cfg.GeoIPDBPath, cfg.GeoIPASNDBPath)
+	if err != nil {
+		return failed("Failed to open GeoIP databases", err)
+	}
+	defer geo.Close()
+	checker := healthcheck.New()
+	checker.EchoURL = cfg.ExitIPEchoURL
+	checker.Geo = geo
+	checker.JudgeURL = cfg.JudgeURL
+	checkMonitor := checkhistory.New(store, checker, cfg.HealthCheckInterval, cfg.CheckRawRetention, cfg.CheckHistoryRetention)
+	start(checkMonitor.Run)
+
+	// Start staged rollouts of change sets, which check their targets'
+	// proxies with the same checker
+	runner := rollout.New(store, checker)
+	start(runner.Run)
+
+	// Serve the anonymity judge on an address of its own, so proxies reach
+	// it without the headers of the reverse proxy in front of the API. A
+	// server that fails stops the API.
+	serverErrs := make(chan error, 2)
+	var judge *http.Server
+	if cfg.JudgeBind != "" {
+		judge = &http.Server{Addr: cfg.JudgeBind, Handler: healthcheck.Judge(), ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			if err := judge.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				serverErrs <- failed("Judge server stopped", err)
+			}
+		}()
+	}
+
+	// Start sending queued webhook deliveries
+	dispatcher := webhooks.NewDispatcher(store, cfg.WebhookAllowPrivate)
+	start(dispatcher.Run)
+
+	// Start evaluating alert rules and sending their notifications
+	alertEngine := alerting.New(store)
+	start(alertEngine.Run)
+	notifier := alerting.NewNotifier(store, alerting.SMTPConfig{
+		Host:     cfg.SMTPHost,
+		Port:     cfg.SMTPPort,
+		Username: cfg.SMTPUsername,
+		Password: cfg.SMTPPassword,
+		From:     cfg.SMTPFrom,
+	}, cfg.WebhookAllowPrivate)
+	start(notifier.Run)
+
 	// Initialize handlers
-	authHandler := handlers.NewAuthHandler(db, cfg)
-	adminHandler := handlers.NewAdminHandler(db)
-	serverHandler := handlers.NewServerHandler(db)
-	proxyHandler := handlers.NewProxyHandler(db)
-	mappingHandler := handlers.NewMappingHandler(db)
-	agentHandler := handlers.NewAgentHandler(db)
-	groupHandler := handlers.NewGroupHandler(db)
+	authHandler := handlers.NewAuthHandler(store, cfg)
+	adminHandler := handlers.NewAdminHandler(store)
+	serverHandler := handlers.NewServerHandler(store)
+	proxyHandler := handlers.NewProxyHandler(store)
+	mappingHandler := handlers.NewMappingHandler(store)
+	agentHandler := handlers.NewAgentHandler(store)
+	groupHandler := handlers.NewGroupHandler(store)
+	scheduleHandler := handlers.NewScheduleHandler(store, sched)
+	stateHandler := handlers.NewStateHandler(store)
+	versionHandler := handlers.NewVersionHandler(store)
+	changeSetHandler := handlers.NewChangeSetHandler(store, runner)
+	bulkHandler := handlers.NewBulkHandler(store, checker)
+	trashHandler := handlers.NewTrashHandler(store, cfg.TrashRetention)
+	webhookHandler := handlers.NewWebhookHandler(store)
+	alertHandler := handlers.NewAlertHandler(store, notifier)
+	statsHandler := handlers.NewStatsHandler(store)
+	quarantineHandler := handlers.NewQuarantineHandler(store)
+	providerHandler := handlers.NewProviderHandler(store)
+
+	apiMetrics, err := metrics.New(db)
+	if err != nil {
+		return failed("Failed to set up metrics", err)
+	}
+
+	// Hand the domain events handlers publish to their subscribers
+	bus := events.NewBus(store)
+	bus.Subscribe("webhooks", webhooks.Subscriber)
+	bus.Subscribe("audit", audit.Subscriber)
+	bus.Subscribe("metrics", apiMetrics.Subscriber)
+	bus.Subscribe("alerts", alerting.Subscriber)
+	start(bus.Run)
 
 	// Setup Gin router
 	gin.SetMode(gin.ReleaseMode)
-	r := gin.Default()
+	r := gin.New()
 
-	// Global middlewares
+	// Global middlewares. Tracing comes first so access logs carry the
+	// trace ID.
+	r.Use(tracing.Middleware())
+	r.Use(logging.Middleware())
 	r.Use(middleware.CORS())
+	r.Use(apiMetrics.Middleware())
+
+	// Prometheus metrics, outside /api/v1 and JWT auth
+	r.GET("/metrics", apiMetrics.Handler(cfg.MetricsToken))
 
 	// API v1 routes
 	v1 := r.Group("/api/v1")
@@ -67,6 +273,7 @@ func main() {
 			groups.POST("", groupHandler.CreateGroup)
 			groups.PUT("/:id", groupHandler.UpdateGroup)
 			groups.DELETE("/:id", groupHandler.DeleteGroup)
+			groups.GET("/:id/stats", statsHandler.GetGroupStats)
 		}
 		
 		// Servers - base CRUD
@@ -77,14 +284,41 @@ func main() {
 			servers.GET("/:id", serverHandler.GetServer)
 			servers.PATCH("/:id", serverHandler.UpdateServer)
 			servers.DELETE("/:id", serverHandler.DeleteServer)
+			servers.POST("/:id/maintenance", serverHandler.EnterMaintenance)
+			servers.POST("/:id/resume", serverHandler.ResumeService)
 			
 			// Server sub-resources
 			servers.GET("/:id/proxies", proxyHandler.GetServerProxies)
 			servers.POST("/:id/proxies", proxyHandler.CreateServerProxy)
 			servers.GET("/:id/mappings", mappingHandler.GetServerMappings)
 			servers.POST("/:id/mappings", mappingHandler.CreateServerMapping)
+
+			// Declarative server config
+			servers.GET("/:id/state", stateHandler.GetServerState)
+			servers.POST("/:id/plan", stateHandler.PlanServerState)
+			servers.POST("/:id/apply", stateHandler.ApplyServerState)
+
+			// Config versions
+			servers.GET("/:id/versions", versionHandler.GetServerVersions)
+			servers.GET("/:id/versions/:version", versionHandler.GetServerVersion)
+			servers.GET("/:id/versions/:version/diff", versionHandler.DiffServerVersions)
+			servers.POST("/:id/rollback/:version", versionHandler.RollbackServer)
 		}
 		
+		// Change sets - staged rollouts across servers
+		changeSets := protected.Group("/changesets")
+		{
+			changeSets.GET("", changeSetHandler.GetChangeSets)
+			changeSets.POST("", changeSetHandler.CreateChangeSet)
+			changeSets.GET("/:id", changeSetHandler.GetChangeSet)
+			changeSets.PATCH("/:id", changeSetHandler.UpdateChangeSet)
+			changeSets.DELETE("/:id", changeSetHandler.DeleteChangeSet)
+			changeSets.GET("/:id/plan", changeSetHandler.PlanChangeSet)
+			changeSets.POST("/:id/start", changeSetHandler.StartChangeSet)
+			changeSets.POST("/:id/halt", changeSetHandler.HaltChangeSet)
+			changeSets.POST("/:id/rollback", changeSetHandler.RollbackChangeSet)
+		}
+
 		// Global Proxies
 		proxies := protected.Group("/proxies")
 		{
@@ -93,10 +327,27 @@ func main() {
 			proxies.GET("/:id", proxyHandler.GetProxy)
 			proxies.PATCH("/:id", proxyHandler.UpdateProxy)
 			proxies.DELETE("/:id", proxyHandler.DeleteProxy)
+			proxies.POST("/:id/reveal", middleware.RequireRole("admin"), proxyHandler.RevealProxyCredentials)
 			
 			// Move proxy endpoints
 			proxies.PUT("/:id/group", proxyHandler.MoveProxyToGroup)
 			proxies.PUT("/bulk-move", proxyHandler.BulkMoveProxiesToGroup)
+			proxies.PUT("/:id/server", proxyHandler.MoveProxyToServer)
+			proxies.PUT("/bulk-server", proxyHandler.BulkMoveProxiesToServer)
+
+			// Bulk operations by IDs or filter
+			proxies.POST("/bulk", bulkHandler.BulkProxies)
+
+			// Check history and stats
+			proxies.GET("/stats", statsHandler.GetProxiesStats)
+			proxies.GET("/:id/stats", statsHandler.GetProxyStats)
+			proxies.GET("/:id/checks", statsHandler.GetProxyChecks)
+
+			// Exit IPs used by several proxies
+			proxies.GET("/shared-exit-ips", proxyHandler.GetSharedExitIPs)
+
+			// Take a proxy out of quarantine
+			proxies.POST("/:id/release", quarantineHandler.ReleaseProxy)
 		}
 		
 		// Global Mappings
@@ -107,6 +358,77 @@ func main() {
 			mappings.GET("/:id", mappingHandler.GetMapping)
 			mappings.PATCH("/:id", mappingHandler.UpdateMapping)
 			mappings.DELETE("/:id", mappingHandler.DeleteMapping)
+			mappings.POST("/bulk", bulkHandler.BulkMappings)
+
+			// Mapping schedules
+			mappings.GET("/:id/schedules", scheduleHandler.GetMappingSchedules)
+			mappings.POST("/:id/schedules", scheduleHandler.CreateMappingSchedule)
+			mappings.PATCH("/:id/schedules/:schedule_id", scheduleHandler.UpdateMappingSchedule)
+			mappings.DELETE("/:id/schedules/:schedule_id", scheduleHandler.DeleteMappingSchedule)
+		}
+
+		// Trash - deleted servers, proxies, mappings and groups
+		trashItems := protected.Group("/trash")
+		{
+			trashItems.GET("", trashHandler.GetTrash)
+			trashItems.POST("/:type/:id/restore", trashHandler.RestoreTrashItem)
+		}
+
+		// Webhooks - event subscriptions and their delivery log. Admin
+		// only: they send requests from the API's network and log the answers.
+		webhookRoutes := protected.Group("/webhooks", middleware.RequireRole("admin"))
+		{
+			webhookRoutes.GET("", webhookHandler.GetWebhooks)
+			webhookRoutes.POST("", webhookHandler.CreateWebhook)
+			webhookRoutes.GET("/:id", webhookHandler.GetWebhook)
+			webhookRoutes.PATCH("/:id", webhookHandler.UpdateWebhook)
+			webhookRoutes.DELETE("/:id", webhookHandler.DeleteWebhook)
+			webhookRoutes.POST("/:id/test", webhookHandler.TestWebhook)
+			webhookRoutes.GET("/:id/deliveries", webhookHandler.GetDeliveries)
+			webhookRoutes.POST("/:id/deliveries/:delivery_id/retry", webhookHandler.RetryDelivery)
+		}
+
+		// Alerts - rules, the alerts they raise, silences and channels
+		alerts := protected.Group("/alerts")
+		{
+			alerts.GET("", alertHandler.GetAlerts)
+			alerts.GET("/:id", alertHandler.GetAlert)
+			alerts.GET("/rules", alertHandler.GetAlertRules)
+			alerts.POST("/rules", alertHandler.CreateAlertRule)
+			alerts.GET("/rules/:id", alertHandler.GetAlertRule)
+			alerts.PATCH("/rules/:id", alertHandler.UpdateAlertRule)
+			alerts.DELETE("/rules/:id", alertHandler.DeleteAlertRule)
+			alerts.GET("/channels", alertHandler.GetAlertChannels)
+			alerts.POST("/channels", alertHandler.CreateAlertChannel)
+			alerts.GET("/channels/:id", alertHandler.GetAlertChannel)
+			alerts.PATCH("/channels/:id", alertHandler.UpdateAlertChannel)
+			alerts.DELETE("/channels/:id", alertHandler.DeleteAlertChannel)
+			alerts.POST("/channels/:id/test", alertHandler.TestAlertChannel)
+			alerts.GET("/silences", alertHandler.GetAlertSilences)
+			alerts.POST("/silences", alertHandler.CreateAlertSilence)
+			alerts.DELETE("/silences/:id", alertHandler.DeleteAlertSilence)
+		}
+
+		// Quarantine - policies and the transitions they made
+		quarantineRoutes := protected.Group("/quarantine")
+		{
+			quarantineRoutes.GET("/policies", quarantineHandler.GetQuarantinePolicies)
+			quarantineRoutes.POST("/policies", quarantineHandler.CreateQuarantinePolicy)
+			quarantineRoutes.GET("/policies/:id", quarantineHandler.GetQuarantinePolicy)
+			quarantineRoutes.PATCH("/policies/:id", quarantineHandler.UpdateQuarantinePolicy)
+			quarantineRoutes.DELETE("/policies/:id", quarantineHandler.DeleteQuarantinePolicy)
+			quarantineRoutes.GET("/transitions", quarantineHandler.GetQuarantineTransitions)
+		}
+
+		// Providers - who proxies were bought from, with spend and expiry
+		providers := protected.Group("/providers")
+		{
+			providers.GET("", providerHandler.GetProviders)
+			providers.POST("", providerHandler.CreateProvider)
+			providers.GET("/report", providerHandler.GetProviderReport)
+			providers.GET("/:id", providerHandler.GetProvider)
+			providers.PATCH("/:id", providerHandler.UpdateProvider)
+			providers.DELETE("/:id", providerHandler.DeleteProvider)
 		}
 	}
 
@@ -118,6 +440,107 @@ func main() {
 		agents.POST("/:agent_id/ack", agentHandler.Ack)
 	}
 
-	log.Printf("Server starting on %s", cfg.APIBind)
-	log.Fatal(r.Run(cfg.APIBind))
+	// Serve until SIGINT or SIGTERM, then finish the requests in flight and
+	// the background loops and return, so the deferred cleanups run
+	srv := &http.Server{Addr: cfg.APIBind, Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+			serverErrs <- failed("Server stopped", err)
+		}
+	}()
+	slog.Info("Server starting", "bind", cfg.APIBind)
+
+	var serveErr error
+	select {
+	case <-ctx.Done():
+	case serveErr = <-serverErrs:
+	}
+	slog.Info("Server shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Failed to shut down the server cleanly", "error", err)
+	}
+	if judge != nil {
+		if err := judge.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to shut down the judge server cleanly", "error", err)
+		}
+	}
+	return serveErr
+}
+
+// errUsage is returned for a subcommand called the wrong way, whose usage
+// has been printed
+var errUsage = errors.New("invalid usage")
+
+// failed logs err as why the API stops and returns it
+func failed(msg string, err error) error {
+	slog.Error(msg, "error", err)
+	return err
+}
+
+// rotateCredentials re-seals all stored proxy credentials with the current
+// key. Run it after moving the old key to CREDENTIALS_PREVIOUS_KEY_FILES or
+// CREDENTIALS_PREVIOUS_KEYS; the old key can be dropped once it finishes.
+func rotateCredentials(db *database.DB, keyring *secrets.Keyring, cfg *config.Config) error {
+	proxies, snapshots, targets, err := db.RotateCredentials(keyring, cfg.EncryptProxyUsernames)
+	if err != nil {
+		return failed("Credential rotation failed", err)
+	}
+	slog.Info("Re-encrypted credentials", "proxies", proxies, "snapshots", snapshots, "change_set_targets", targets, "key_id", keyring.KeyID())
+	return nil
+}
+
+// runMigrations implements `migrate up`, `migrate down [steps]` and
+// `migrate status`. Down reverts one migration unless steps is given.
+func runMigrations(cfg *config.Config, args []string) error {
+	db, err := database.Open(cfg)
+	if err != nil {
+		return failed("Failed to connect to database", err)
+	}
+	migrator, err := migrations.New(db.DB)
+	if err != nil {
+		return failed("Failed to load migrations", err)
+	}
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		applied, err := migrator.Up()
+		if err != nil {
+			return failed(fmt.Sprintf("Applied %d migrations, then failed", applied), err)
+		}
+		slog.Info("Applied migrations", "count", applied)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return failed("Invalid number of steps", fmt.Errorf("%q is not a positive number", args[1]))
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		if err != nil {
+			return failed(fmt.Sprintf("Reverted %d migrations, then failed", reverted), err)
+		}
+		slog.Info("Reverted migrations", "count", reverted)
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			return failed("Failed to read migration status", err)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.AppliedAt != nil {
+				applied = "applied " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d  %-30s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: main migrate [up|down [steps]|status]")
+		return errUsage
+	}
+	return nil
 }
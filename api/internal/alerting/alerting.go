@@ -0,0 +1,179 @@
+// Package alerting evaluates alert rules and notifies their channels. An
+// Engine evaluates every rule at its interval and tracks an alert per
+// subject through pending, firing and resolved, publishing AlertFiring and
+// AlertResolved. Subscriber queues those as notifications for the channels
+// of the rule, and a Notifier sends them.
+package alerting
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+const (
+	// tick is how often rules are checked for being due. Rule intervals
+	// are at least this.
+	tick = 10 * time.Second
+	// retention is how long resolved alerts are kept
+	retention = 30 * 24 * time.Hour
+)
+
+// MinInterval is the shortest evaluation interval of a rule
+const MinInterval = tick
+
+// Engine evaluates alert rules
+type Engine struct {
+	store *repository.Store
+}
+
+func New(store *repository.Store) *Engine {
+	return &Engine{store: store}
+}
+
+// Run evaluates due rules every tick and purges old alerts every hour,
+// until ctx is cancelled
+func (e *Engine) Run(ctx context.Context) {
+	ticker := time.NewTicker(tick)
+	defer ticker.Stop()
+	var lastPurge time.Time
+	for {
+		now := time.Now()
+		if _, err := e.Evaluate(ctx, now); err != nil {
+			slog.Error("Alert evaluation failed", "component", "alerting", "error", err)
+		}
+		if now.Sub(lastPurge) >= time.Hour {
+			if purged, err := e.store.Alerts.Purge(ctx, now.Add(-retention)); err != nil {
+				slog.Error("Alert purge failed", "component", "alerting", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged resolved alerts", "component", "alerting", "alerts", purged)
+			}
+			lastPurge = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Evaluate evaluates every enabled rule whose interval passed at now and
+// returns their number. Rules claimed by another engine are skipped, and a
+// rule failing to evaluate is logged and retried at its next interval.
+func (e *Engine) Evaluate(ctx context.Context, now time.Time) (int, error) {
+	rules, err := e.store.AlertRules.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	evaluated := 0
+	for _, rule := range rules {
+		interval := time.Duration(rule.IntervalSeconds) * time.Second
+		if !rule.Enabled || (rule.EvaluatedAt != nil && now.Sub(*rule.EvaluatedAt) < interval) {
+			continue
+		}
+		var claimed bool
+		err := e.store.Transaction(ctx, func(tx *repository.Store) error {
+			var err error
+			if claimed, err = tx.AlertRules.Claim(ctx, rule.ID, now.Add(-interval), now); err != nil || !claimed {
+				return err
+			}
+			return evaluate(ctx, tx, rule, now)
+		})
+		if err != nil {
+			slog.Error("Alert rule evaluation failed", "component", "alerting", "rule_id", rule.ID, "error", err)
+			continue
+		}
+		if claimed {
+			evaluated++
+		}
+	}
+	return evaluated, nil
+}
+
+// evaluate checks a rule's condition and moves its alerts along: new
+// subjects become pending, pending alerts fire once the condition held for
+// the rule's duration, and firing alerts whose condition cleared resolve.
+func evaluate(ctx context.Context, tx *repository.Store, rule models.AlertRule, now time.Time) error {
+	found, err := conditions(ctx, tx, rule, now)
+	if err != nil {
+		return err
+	}
+	open, err := tx.Alerts.List(ctx, repository.AlertFilter{RuleID: &rule.ID, States: []string{models.AlertPending, models.AlertFiring}})
+	if err != nil {
+		return err
+	}
+
+	hold := time.Duration(rule.ForSeconds) * time.Second
+	for _, alert := range open {
+		f, holds := found[alert.Subject]
+		delete(found, alert.Subject)
+		switch {
+		case !holds && alert.State == models.AlertPending:
+			if err := tx.Alerts.Delete(ctx, alert.ID); err != nil {
+				return err
+			}
+		case !holds:
+			alert.State, alert.ResolvedAt = models.AlertResolved, &now
+			if err := tx.Alerts.Update(ctx, alert.ID, repository.Fields{"state": alert.State, "resolved_at": now}); err != nil {
+				return err
+			}
+			if err := events.Publish(ctx, tx, events.AlertResolved{Alert: events.NewAlertState(alert, rule)}); err != nil {
+				return err
+			}
+		default:
+			alert.Summary, alert.Value = f.summary, f.value
+			fields := repository.Fields{"summary": f.summary, "value": f.value}
+			fire := alert.State == models.AlertPending && now.Sub(alert.ActiveAt) >= hold
+			if fire {
+				alert.State, alert.FiredAt = models.AlertFiring, &now
+				fields["state"], fields["fired_at"] = alert.State, now
+			}
+			if err := tx.Alerts.Update(ctx, alert.ID, fields); err != nil {
+				return err
+			}
+			if fire {
+				if err := events.Publish(ctx, tx, events.AlertFiring{Alert: events.NewAlertState(alert, rule)}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	// Subjects the condition newly holds for, in a stable order
+	subjects := make([]string, 0, len(found))
+	for subject := range found {
+		subjects = append(subjects, subject)
+	}
+	sort.Strings(subjects)
+	for _, subject := range subjects {
+		f := found[subject]
+		alert := models.Alert{
+			RuleID:   rule.ID,
+			Subject:  subject,
+			Summary:  f.summary,
+			State:    models.AlertPending,
+			Value:    f.value,
+			ActiveAt: now,
+		}
+		if hold == 0 {
+			alert.State, alert.FiredAt = models.AlertFiring, &now
+		}
+		if err := tx.Alerts.Create(ctx, &alert); err != nil {
+			return err
+		}
+		if alert.State == models.AlertFiring {
+			if err := events.Publish(ctx, tx, events.AlertFiring{Alert: events.NewAlertState(alert, rule)}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
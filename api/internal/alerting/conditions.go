@@ -0,0 +1,180 @@
+package alerting
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Kinds are the rule kinds
+var Kinds = []string{
+	models.AlertGroupFailing,
+	models.AlertServerOffline,
+	models.AlertVersionLag,
+	models.AlertLatencyP95,
+	models.AlertProxyExpiring,
+}
+
+// DefaultWindow is the latency window of latency_p95 rules that set none
+const DefaultWindow = 15 * time.Minute
+
+// finding is a rule's condition holding for one subject
+type finding struct {
+	summary string
+	value   float64
+}
+
+// conditions returns the subjects a rule's condition holds for at now
+func conditions(ctx context.Context, tx *repository.Store, rule models.AlertRule, now time.Time) (map[string]finding, error) {
+	switch rule.Kind {
+	case models.AlertGroupFailing:
+		return groupFailing(ctx, tx, rule)
+	case models.AlertServerOffline, models.AlertVersionLag:
+		return serverConditions(ctx, tx, rule, now)
+	case models.AlertLatencyP95:
+		return latencyP95(ctx, tx, rule, now)
+	case models.AlertProxyExpiring:
+		return proxyExpiring(ctx, tx, rule, now)
+	}
+	return nil, fmt.Errorf("unknown rule kind %q", rule.Kind)
+}
+
+// groupFailing holds if more than the threshold percentage of the proxies
+// of the rule's group, or of all proxies, failed their last health check
+func groupFailing(ctx context.Context, tx *repository.Store, rule models.AlertRule) (map[string]finding, error) {
+	proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{GroupID: rule.GroupID})
+	if err != nil || len(proxies) == 0 {
+		return nil, err
+	}
+
+	failing := 0
+	for _, proxy := range proxies {
+		if proxy.Health == healthcheck.HealthFail {
+			failing++
+		}
+	}
+	percent := 100 * float64(failing) / float64(len(proxies))
+	if percent <= rule.Threshold {
+		return nil, nil
+	}
+
+	subject, scope := "proxies", "all proxies"
+	if rule.GroupID != nil {
+		subject, scope = "group:"+strconv.FormatUint(uint64(*rule.GroupID), 10), "group "+proxies[0].Group.Name
+	}
+	return map[string]finding{subject: {
+		summary: fmt.Sprintf("%d of %d proxies failing in %s (%.0f%%)", failing, len(proxies), scope, percent),
+		value:   percent,
+	}}, nil
+}
+
+// serverConditions holds server_offline for offline servers and
+// version_lag for servers that have not applied their config version.
+// Servers whose agent never reported in are left out.
+func serverConditions(ctx context.Context, tx *repository.Store, rule models.AlertRule, now time.Time) (map[string]finding, error) {
+	servers, err := tx.Servers.List(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]finding)
+	for _, server := range servers {
+		if (rule.ServerID != nil && server.ID != *rule.ServerID) || server.LastSeenAt == nil {
+			continue
+		}
+		subject := "server:" + strconv.FormatUint(uint64(server.ID), 10)
+		switch {
+		case rule.Kind == models.AlertServerOffline && server.Status != "online":
+			found[subject] = finding{
+				summary: fmt.Sprintf("Server %s is offline, last seen %s", server.Name, server.LastSeenAt.UTC().Format(time.RFC3339)),
+				value:   math.Round(now.Sub(*server.LastSeenAt).Seconds()),
+			}
+		case rule.Kind == models.AlertVersionLag && server.AppliedVersion < server.ConfigVersion:
+			found[subject] = finding{
+				summary: fmt.Sprintf("Server %s applied version %d of %d", server.Name, server.AppliedVersion, server.ConfigVersion),
+				value:   float64(server.ConfigVersion - server.AppliedVersion),
+			}
+		}
+	}
+	return found, nil
+}
+
+// latencyP95 holds for every proxy of the rule's group, or of all proxies,
+// whose ok checks within the rule's window have a p95 latency above the
+// threshold in milliseconds
+func latencyP95(ctx context.Context, tx *repository.Store, rule models.AlertRule, now time.Time) (map[string]finding, error) {
+	proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{GroupID: rule.GroupID})
+	if err != nil || len(proxies) == 0 {
+		return nil, err
+	}
+	window := time.Duration(rule.WindowSeconds) * time.Second
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	ids := make([]uint, 0, len(proxies))
+	for _, proxy := range proxies {
+		ids = append(ids, proxy.ID)
+	}
+	latencies, err := tx.Checks.Latencies(ctx, ids, now.Add(-window))
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]finding)
+	for _, proxy := range proxies {
+		samples := latencies[proxy.ID]
+		if len(samples) == 0 {
+			continue
+		}
+		p95 := Percentile(samples, 95)
+		if float64(p95) <= rule.Threshold {
+			continue
+		}
+		found["proxy:"+strconv.FormatUint(uint64(proxy.ID), 10)] = finding{
+			summary: fmt.Sprintf("Proxy %s p95 latency is %d ms over %d checks in %s", proxy.Label, p95, len(samples), window),
+			value:   float64(p95),
+		}
+	}
+	return found, nil
+}
+
+// proxyExpiring holds for every proxy of the rule's group, or of all
+// proxies, whose expiry date is less than the threshold in days after now,
+// including expired ones. Its value is the days left, negative once expired.
+func proxyExpiring(ctx context.Context, tx *repository.Store, rule models.AlertRule, now time.Time) (map[string]finding, error) {
+	until := now.Add(time.Duration(rule.Threshold * float64(24*time.Hour)))
+	proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{GroupID: rule.GroupID, ExpiresBefore: &until})
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(map[string]finding)
+	for _, proxy := range proxies {
+		days := proxy.ExpiresAt.Sub(now).Hours() / 24
+		summary := fmt.Sprintf("Proxy %s expires %s, in %.1f days", proxy.Label, proxy.ExpiresAt.UTC().Format(time.RFC3339), days)
+		if days < 0 {
+			summary = fmt.Sprintf("Proxy %s expired %s", proxy.Label, proxy.ExpiresAt.UTC().Format(time.RFC3339))
+		}
+		value := math.Round(days*10) / 10
+		if value == 0 {
+			value = 0 // not -0 for proxies that just expired
+		}
+		found["proxy:"+strconv.FormatUint(uint64(proxy.ID), 10)] = finding{summary: summary, value: value}
+	}
+	return found, nil
+}
+
+// Percentile returns the p-th percentile of values by the nearest-rank
+// method. values is sorted in place and must not be empty.
+func Percentile(values []int, p float64) int {
+	sort.Ints(values)
+	rank := int(math.Ceil(p / 100 * float64(len(values))))
+	return values[max(rank, 1)-1]
+}
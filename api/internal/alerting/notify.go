@@ -0,0 +1,441 @@
+package alerting
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/retry"
+	"github.com/Chinsusu/proxy-manager/api/internal/webhooks"
+)
+
+const (
+	// pollInterval is how often due notifications are sent
+	pollInterval = 5 * time.Second
+	// batchSize bounds the notifications sent per poll
+	batchSize = 50
+	// MaxAttempts is how often a notification is tried before it is failed
+	MaxAttempts = 5
+	// firstRetry doubles after each failed attempt up to maxRetry
+	firstRetry = 30 * time.Second
+	maxRetry   = time.Hour
+	// lease is how long a claimed notification is left alone
+	lease = 2 * time.Minute
+	// sendTimeout bounds one attempt
+	sendTimeout = 30 * time.Second
+	// responseBodyLimit is how much of a failed bot API response is kept
+	responseBodyLimit = 512
+)
+
+var backoff = retry.Backoff{First: firstRetry, Max: maxRetry}
+
+// DefaultTelegramURL is the bot API of telegram channels that set no URL
+const DefaultTelegramURL = "https://api.telegram.org"
+
+// Subscriber queues notifications of firing and resolved alerts for the
+// enabled channels of their rule, as an event bus handler. Webhook channels
+// are queued as deliveries of their webhook at once. Alerts matching a
+// silence when the event occurred are recorded as silenced.
+func Subscriber(ctx context.Context, tx *repository.Store, record events.Record) error {
+	var alert events.AlertState
+	switch e := record.Event.(type) {
+	case events.AlertFiring:
+		alert = e.Alert
+	case events.AlertResolved:
+		alert = e.Alert
+	default:
+		return nil
+	}
+	event := record.Event.Name()
+
+	rule, err := tx.AlertRules.Get(ctx, alert.RuleID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var channelIDs []uint
+	if err := json.Unmarshal([]byte(rule.Channels), &channelIDs); err != nil {
+		return err
+	}
+	silenced, err := isSilenced(ctx, tx, alert, record.OccurredAt)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range channelIDs {
+		channel, err := tx.AlertChannels.Get(ctx, id)
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !channel.Enabled {
+			continue
+		}
+
+		notification := models.AlertNotification{
+			AlertID:       alert.ID,
+			ChannelID:     channel.ID,
+			Event:         event,
+			Payload:       string(payload),
+			Status:        models.NotificationPending,
+			NextAttemptAt: time.Now(),
+		}
+		switch {
+		case silenced:
+			notification.Status = models.NotificationSilenced
+		case channel.Type == models.ChannelWebhook:
+			if err := sendWebhook(ctx, tx, *channel, event, alert, record.OccurredAt); err != nil {
+				notification.Status, notification.Error = models.NotificationFailed, err.Error()
+			} else {
+				notification.Status = models.NotificationSent
+			}
+		}
+		if err := tx.AlertNotifications.Create(ctx, &notification); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isSilenced reports whether a silence covered an alert at the given time
+func isSilenced(ctx context.Context, tx *repository.Store, alert events.AlertState, at time.Time) (bool, error) {
+	silences, err := tx.AlertSilences.List(ctx, at)
+	if err != nil {
+		return false, err
+	}
+	for _, silence := range silences {
+		if silence.StartsAt.After(at) {
+			continue
+		}
+		if (silence.RuleID == nil || *silence.RuleID == alert.RuleID) && (silence.Subject == "" || silence.Subject == alert.Subject) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// sendWebhook queues an alert event for the webhook of a channel. The
+// webhook's own retries and delivery log take it from there.
+func sendWebhook(ctx context.Context, tx *repository.Store, channel models.AlertChannel, event string, alert events.AlertState, occurredAt time.Time) error {
+	if channel.WebhookID == nil {
+		return errors.New("webhook was deleted")
+	}
+	webhook, err := tx.Webhooks.Get(ctx, *channel.WebhookID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return errors.New("webhook was deleted")
+	}
+	if err != nil {
+		return err
+	}
+	if !webhook.Enabled {
+		return errors.New("webhook is disabled")
+	}
+	_, err = webhooks.Send(ctx, tx, *webhook, event, alert, occurredAt)
+	return err
+}
+
+// SMTPConfig is the mail server email channels are sent through. Port 465
+// uses TLS from the start; other ports upgrade with STARTTLS when the
+// server offers it.
+type SMTPConfig struct {
+	Host     string // empty disables email channels
+	Port     int
+	Username string // empty sends without authentication
+	Password string
+	From     string
+}
+
+// Notifier sends the queued notifications of email and telegram channels.
+// Unless allowPrivate, bot API requests are only sent to public addresses,
+// like webhooks.
+type Notifier struct {
+	store  *repository.Store
+	queue  retry.Queue[models.AlertNotification]
+	smtp   SMTPConfig
+	client *http.Client
+}
+
+func NewNotifier(store *repository.Store, smtp SMTPConfig, allowPrivate bool) *Notifier {
+	return &Notifier{
+		store: store,
+		queue: retry.Queue[models.AlertNotification]{
+			Table: store.AlertNotifications,
+			Batch: batchSize,
+			Lease: lease,
+			Row: func(notification *models.AlertNotification) (uint, *int) {
+				return notification.ID, &notification.Attempts
+			},
+		},
+		smtp:   smtp,
+		client: &http.Client{Transport: webhooks.NewTransport(allowPrivate), Timeout: sendTimeout},
+	}
+}
+
+// Run sends due notifications every pollInterval until ctx is cancelled
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := n.Send(ctx, time.Now()); err != nil {
+			slog.Error("Alert notification failed", "component", "alerting", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send makes one attempt at every notification due at now and returns the
+// number attempted. Notifications claimed by another notifier are skipped.
+func (n *Notifier) Send(ctx context.Context, now time.Time) (int, error) {
+	return n.queue.Work(ctx, now, func(ctx context.Context, notification models.AlertNotification) (repository.Fields, error) {
+		channel, err := n.store.AlertChannels.Get(ctx, notification.ChannelID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return nil, err
+		}
+		return n.attempt(ctx, channel, notification), nil
+	})
+}
+
+// attempt sends a notification once and returns how to update it
+func (n *Notifier) attempt(ctx context.Context, channel *models.AlertChannel, notification models.AlertNotification) repository.Fields {
+	start := time.Now()
+	fields := repository.Fields{"last_attempt_at": start, "error": ""}
+
+	var alert events.AlertState
+	err := json.Unmarshal([]byte(notification.Payload), &alert)
+	if err == nil {
+		if channel == nil {
+			err = errors.New("channel was deleted")
+		} else {
+			err = n.deliver(ctx, *channel, Message(notification.Event, alert))
+		}
+	}
+	if err == nil {
+		fields["status"] = models.NotificationSent
+		return fields
+	}
+
+	fields["error"] = err.Error()
+	if channel == nil || notification.Attempts >= MaxAttempts {
+		fields["status"] = models.NotificationFailed
+		slog.Warn("Alert notification failed", "component", "alerting", "channel_id", notification.ChannelID, "notification_id", notification.ID, "event", notification.Event, "attempts", notification.Attempts, "error", err)
+	} else {
+		fields["next_attempt_at"] = start.Add(backoff.After(notification.Attempts))
+	}
+	return fields
+}
+
+// Test sends a test message to a channel at once, or for a webhook channel
+// queues a ping of its webhook
+func (n *Notifier) Test(ctx context.Context, channel models.AlertChannel) error {
+	if channel.Type == models.ChannelWebhook {
+		if channel.WebhookID == nil {
+			return errors.New("webhook was deleted")
+		}
+		webhook, err := n.store.Webhooks.Get(ctx, *channel.WebhookID)
+		if err != nil {
+			return err
+		}
+		_, err = webhooks.Ping(ctx, n.store, *webhook)
+		return err
+	}
+	return n.deliver(ctx, channel, Notification{
+		Title: "Test notification",
+		Body:  fmt.Sprintf("This is a test of the alert channel %q.\n", channel.Name),
+	})
+}
+
+// Notification is the text of a message to a channel
+type Notification struct {
+	Title string
+	Body  string
+}
+
+// Message returns the text of an alert firing or resolving
+func Message(event string, alert events.AlertState) Notification {
+	status := "FIRING"
+	if event == events.NameAlertResolved {
+		status = "RESOLVED"
+	}
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "%s\n\n", alert.Summary)
+	fmt.Fprintf(&body, "Rule: %s (%s)\n", alert.Rule, alert.Kind)
+	fmt.Fprintf(&body, "Subject: %s\n", alert.Subject)
+	fmt.Fprintf(&body, "Value: %s (threshold %s)\n", formatValue(alert.Value), formatValue(alert.Threshold))
+	fmt.Fprintf(&body, "Active since: %s\n", alert.ActiveAt.UTC().Format(time.RFC3339))
+	if alert.FiredAt != nil {
+		fmt.Fprintf(&body, "Fired at: %s\n", alert.FiredAt.UTC().Format(time.RFC3339))
+	}
+	if alert.ResolvedAt != nil {
+		fmt.Fprintf(&body, "Resolved at: %s\n", alert.ResolvedAt.UTC().Format(time.RFC3339))
+	}
+	return Notification{Title: fmt.Sprintf("[%s] %s", status, alert.Rule), Body: body.String()}
+}
+
+func formatValue(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// deliver sends a message to an email or telegram channel
+func (n *Notifier) deliver(ctx context.Context, channel models.AlertChannel, message Notification) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	switch channel.Type {
+	case models.ChannelEmail:
+		return n.sendEmail(ctx, SplitRecipients(channel.Target), message)
+	case models.ChannelTelegram:
+		token, err := channel.Token.Open()
+		if err != nil {
+			return fmt.Errorf("failed to decrypt bot token: %w", err)
+		}
+		return n.sendTelegram(ctx, channel, token, message)
+	}
+	return fmt.Errorf("unknown channel type %q", channel.Type)
+}
+
+// SplitRecipients returns the addresses of a comma-separated list
+func SplitRecipients(list string) []string {
+	var recipients []string
+	for _, address := range strings.Split(list, ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			recipients = append(recipients, address)
+		}
+	}
+	return recipients
+}
+
+func (n *Notifier) sendEmail(ctx context.Context, recipients []string, message Notification) error {
+	if n.smtp.Host == "" {
+		return errors.New("SMTP is not configured; set SMTP_HOST")
+	}
+	if len(recipients) == 0 {
+		return errors.New("channel has no recipients")
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(n.smtp.Host, strconv.Itoa(n.smtp.Port)))
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	tlsConfig := &tls.Config{ServerName: n.smtp.Host}
+	if n.smtp.Port == 465 {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, n.smtp.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if n.smtp.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", n.smtp.Username, n.smtp.Password, n.smtp.Host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(n.smtp.From); err != nil {
+		return err
+	}
+	for _, recipient := range recipients {
+		if err := client.Rcpt(recipient); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(n.email(recipients, message)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// email returns a plain text message with its headers
+func (n *Notifier) email(recipients []string, message Notification) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", n.smtp.From)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(recipients, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Title))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	return b.Bytes()
+}
+
+// sendTelegram posts a message with the sendMessage method of a bot API
+func (n *Notifier) sendTelegram(ctx context.Context, channel models.AlertChannel, token string, message Notification) error {
+	base := strings.TrimSuffix(channel.URL, "/")
+	if base == "" {
+		base = DefaultTelegramURL
+	}
+	body, err := json.Marshal(map[string]string{"chat_id": channel.Target, "text": message.Title + "\n\n" + message.Body})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+"/bot"+token+"/sendMessage", bytes.NewReader(body))
+	if err != nil {
+		return errors.New("invalid bot API URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The URL holds the bot token, so it stays out of the error
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("bot API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, responseBodyLimit))
+	// Postgres text takes neither invalid UTF-8 nor NUL
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(excerpt), "\uFFFD"), "\x00", "")
+	return fmt.Errorf("bot API answered %d: %s", resp.StatusCode, text)
+}
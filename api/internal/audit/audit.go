@@ -0,0 +1,72 @@
+// Package audit writes changes to servers, proxies and mappings to the
+// audit log
+package audit
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Subscriber records created, updated and deleted events, proxies going
+// into and out of quarantine and expired proxies being disabled, as an
+// event bus handler. Changes the API made on its own are recorded as
+// "system".
+func Subscriber(ctx context.Context, tx *repository.Store, record events.Record) error {
+	entry := models.AuditLog{CreatedAt: record.OccurredAt}
+	var before, after interface{}
+	switch e := record.Event.(type) {
+	case events.ServerCreated:
+		entry.Resource, entry.Action, entry.Actor, after = "server", "create", e.Actor, e.Server
+	case events.ServerUpdated:
+		entry.Resource, entry.Action, entry.Actor, before, after = "server", "update", e.Actor, e.Before, e.After
+	case events.ServerDeleted:
+		entry.Resource, entry.Action, entry.Actor, before = "server", "delete", e.Actor, e.Server
+	case events.ProxyCreated:
+		entry.Resource, entry.Action, entry.Actor, after = "proxy", "create", e.Actor, e.Proxy
+	case events.ProxyUpdated:
+		entry.Resource, entry.Action, entry.Actor, before, after = "proxy", "update", e.Actor, e.Before, e.After
+	case events.ProxyDeleted:
+		entry.Resource, entry.Action, entry.Actor, before = "proxy", "delete", e.Actor, e.Proxy
+	case events.ProxyQuarantined:
+		entry.Resource, entry.Action, before = "proxy", "quarantine", e.Proxy
+	case events.ProxyRestored:
+		entry.Resource, entry.Action, entry.Actor, before = "proxy", "restore", e.Actor, e.Proxy
+		if e.Action == models.QuarantineReleased {
+			entry.Action = "release"
+		}
+	case events.ProxyExpired:
+		entry.Resource, entry.Action, before = "proxy", "disable", e.Proxy
+	case events.MappingCreated:
+		entry.Resource, entry.Action, entry.Actor, after = "mapping", "create", e.Actor, e.Mapping
+	case events.MappingUpdated:
+		entry.Resource, entry.Action, entry.Actor, before, after = "mapping", "update", e.Actor, e.Before, e.After
+	case events.MappingDeleted:
+		entry.Resource, entry.Action, entry.Actor, before = "mapping", "delete", e.Actor, e.Mapping
+	default:
+		return nil
+	}
+
+	if entry.Actor == "" {
+		entry.Actor = "system"
+	}
+	var err error
+	if entry.Before, err = encode(before); err != nil {
+		return err
+	}
+	if entry.After, err = encode(after); err != nil {
+		return err
+	}
+	return tx.Audit.Record(ctx, &entry)
+}
+
+func encode(state interface{}) (string, error) {
+	if state == nil {
+		return "", nil
+	}
+	b, err := json.Marshal(state)
+	return string(b), err
+}
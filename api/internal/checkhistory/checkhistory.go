@@ -0,0 +1,310 @@
+// Package checkhistory keeps the health check results of proxies over time.
+// A Monitor checks every proxy at an interval and downsamples checks older
+// than the raw retention into hourly rollups, which are kept for the
+// history retention. Summarize turns the history into uptime, latency
+// percentiles and failure streaks.
+package checkhistory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/quarantine"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Bucket is the span of one rollup
+const Bucket = time.Hour
+
+// compactInterval is how often old checks are downsampled
+const compactInterval = 10 * time.Minute
+
+// Monitor checks proxies and compacts their history
+type Monitor struct {
+	store        *repository.Store
+	checker      *healthcheck.Checker
+	interval     time.Duration
+	rawRetention time.Duration
+	retention    time.Duration
+}
+
+// New returns a monitor checking every proxy at interval, zero for never.
+// Checks are kept for rawRetention and their rollups for retention; zero
+// keeps them forever.
+func New(store *repository.Store, checker *healthcheck.Checker, interval, rawRetention, retention time.Duration) *Monitor {
+	return &Monitor{store: store, checker: checker, interval: interval, rawRetention: rawRetention, retention: retention}
+}
+
+// Run checks proxies every interval and compacts the history every
+// compactInterval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	if m.interval > 0 {
+		var checks sync.WaitGroup
+		checks.Add(1)
+		go func() {
+			defer checks.Done()
+			m.runChecks(ctx)
+		}()
+		defer checks.Wait()
+	}
+
+	ticker := time.NewTicker(compactInterval)
+	defer ticker.Stop()
+	for {
+		if compacted, err := m.Compact(ctx, time.Now()); err != nil {
+			slog.Error("Check history compaction failed", "component", "checkhistory", "error", err)
+		} else if compacted > 0 {
+			slog.Info("Compacted check history", "component", "checkhistory", "checks", compacted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) runChecks(ctx context.Context) {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		if _, err := m.CheckAll(ctx); err != nil {
+			slog.Error("Proxy health checks failed", "component", "checkhistory", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckAll checks every proxy, records the results and returns their
+// number. Proxies deleted while being checked are skipped.
+func (m *Monitor) CheckAll(ctx context.Context) (int, error) {
+	proxies, err := m.store.Proxies.List(ctx, repository.ProxyFilter{})
+	if err != nil {
+		return 0, err
+	}
+	results, err := Check(ctx, m.store, m.checker, proxies)
+	return len(results), err
+}
+
+// Check checks proxies now, records the results and returns them by proxy
+// ID. Proxies deleted while being checked are skipped.
+func Check(ctx context.Context, store *repository.Store, checker *healthcheck.Checker, proxies []models.Proxy) (map[uint]healthcheck.Result, error) {
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+
+	results := checker.CheckAll(ctx, proxies)
+	recorded := make(map[uint]healthcheck.Result, len(results))
+	for _, proxy := range proxies {
+		result, ok := results[proxy.ID]
+		if !ok {
+			continue
+		}
+		err := store.Transaction(ctx, func(tx *repository.Store) error {
+			// Reloaded for the health to compare with
+			current, err := tx.Proxies.Get(ctx, proxy.ID)
+			if err != nil {
+				return err
+			}
+			return Record(ctx, tx, *current, result)
+		})
+		if errors.Is(err, repository.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return recorded, err
+		}
+		recorded[proxy.ID] = result
+	}
+	return recorded, nil
+}
+
+// Record stores the result of checking a proxy as its health, exit IP,
+// location and anonymity and in its history, quarantines or restores it as
+// its policy says, and publishes ProxyHealthChanged if the health changed
+func Record(ctx context.Context, tx *repository.Store, proxy models.Proxy, result healthcheck.Result) error {
+	check := models.ProxyCheck{
+		ProxyID:    proxy.ID,
+		CheckedAt:  result.CheckedAt,
+		OK:         result.Health == healthcheck.HealthOK,
+		Error:      result.Error,
+		ErrorClass: result.ErrorClass,
+		ExitIP:     result.ExitIP,
+	}
+	if check.OK {
+		check.LatencyMS = int(result.Latency.Milliseconds())
+	}
+	fields := repository.Fields{"health": result.Health}
+	if result.ExitIP != "" {
+		fields["exit_ip"] = result.ExitIP
+		fields["country"] = result.Location.Country
+		fields["city"] = result.Location.City
+		fields["asn"] = result.Location.ASN
+		fields["as_org"] = result.Location.ASOrg
+	}
+	if result.Anonymity != "" {
+		fields["anonymity"] = result.Anonymity
+	}
+	if err := tx.Proxies.Update(ctx, proxy.ID, fields); err != nil {
+		return err
+	}
+	if result.ExitIP != "" && result.ExitIP != proxy.ExitIP {
+		if err := warnSharedExitIP(ctx, tx, proxy, result.ExitIP); err != nil {
+			return err
+		}
+	}
+	if err := tx.Checks.Record(ctx, &check); err != nil {
+		return err
+	}
+	if err := quarantine.Observe(ctx, tx, proxy, check.OK, result.Error); err != nil {
+		return err
+	}
+	if result.Health != proxy.Health {
+		return events.Publish(ctx, tx, events.ProxyHealthChanged{Proxy: events.NewProxyState(proxy), Health: result.Health, Error: result.Error})
+	}
+	return nil
+}
+
+// warnSharedExitIP logs the proxies a proxy newly shares its exit IP with
+func warnSharedExitIP(ctx context.Context, tx *repository.Store, proxy models.Proxy, exitIP string) error {
+	sharing, err := tx.Proxies.List(ctx, repository.ProxyFilter{ExitIP: exitIP})
+	if err != nil {
+		return err
+	}
+	var others []uint
+	for _, other := range sharing {
+		if other.ID != proxy.ID {
+			others = append(others, other.ID)
+		}
+	}
+	if len(others) > 0 {
+		slog.Warn("Proxies share an exit IP", "component", "checkhistory", "proxy_id", proxy.ID, "exit_ip", exitIP, "shared_with", others)
+	}
+	return nil
+}
+
+// Compact downsamples the checks of every hour that ended more than the
+// raw retention before now into rollups, one hour per transaction, and
+// purges rollups older than the retention. It returns the number of checks
+// downsampled.
+func (m *Monitor) Compact(ctx context.Context, now time.Time) (int, error) {
+	compacted := 0
+	if m.rawRetention > 0 {
+		cutoff := now.Add(-m.rawRetention).Truncate(Bucket)
+		for {
+			oldest, err := m.store.Checks.Oldest(ctx)
+			if err != nil {
+				return compacted, err
+			}
+			if oldest == nil || !oldest.Before(cutoff) {
+				break
+			}
+			start := oldest.Local().Truncate(Bucket)
+			var n int
+			err = m.store.Transaction(ctx, func(tx *repository.Store) error {
+				var err error
+				n, err = compactBucket(ctx, tx, start)
+				return err
+			})
+			if err != nil {
+				return compacted, err
+			}
+			if n == 0 {
+				return compacted, fmt.Errorf("no checks to compact from %s", start.Format(time.RFC3339))
+			}
+			compacted += n
+		}
+	}
+
+	if m.retention > 0 {
+		if _, err := m.store.Checks.PurgeRollups(ctx, now.Add(-m.retention)); err != nil {
+			return compacted, err
+		}
+	}
+	return compacted, nil
+}
+
+// compactBucket replaces the checks of the hour from start by a rollup per
+// proxy, merged into any rollup the hour already has
+func compactBucket(ctx context.Context, tx *repository.Store, start time.Time) (int, error) {
+	span := repository.CheckFilter{Since: start, Until: start.Add(Bucket)}
+	checks, err := tx.Checks.List(ctx, span)
+	if err != nil {
+		return 0, err
+	}
+	existing, err := tx.Checks.Rollups(ctx, span)
+	if err != nil {
+		return 0, err
+	}
+
+	byProxy := make(map[uint][]models.ProxyCheck)
+	var order []uint
+	for _, check := range checks {
+		if _, seen := byProxy[check.ProxyID]; !seen {
+			order = append(order, check.ProxyID)
+		}
+		byProxy[check.ProxyID] = append(byProxy[check.ProxyID], check)
+	}
+	previous := make(map[uint]models.ProxyCheckRollup, len(existing))
+	for _, rollup := range existing {
+		previous[rollup.ProxyID] = rollup
+	}
+
+	for _, proxyID := range order {
+		rollup := summarizeBucket(proxyID, start, byProxy[proxyID])
+		if before, ok := previous[proxyID]; ok {
+			rollup = mergeRollups(before, rollup)
+		}
+		if err := tx.Checks.SaveRollup(ctx, &rollup); err != nil {
+			return 0, err
+		}
+	}
+	_, err = tx.Checks.Delete(ctx, span)
+	return len(checks), err
+}
+
+// summarizeBucket sums up the checks of one proxy within a bucket
+func summarizeBucket(proxyID uint, start time.Time, checks []models.ProxyCheck) models.ProxyCheckRollup {
+	rollup := models.ProxyCheckRollup{ProxyID: proxyID, BucketStart: start, Checks: len(checks)}
+	var latencies []int
+	for _, check := range checks {
+		if !check.OK {
+			rollup.Failures++
+			continue
+		}
+		latencies = append(latencies, check.LatencyMS)
+		rollup.LatencyMax = max(rollup.LatencyMax, check.LatencyMS)
+	}
+	if len(latencies) > 0 {
+		rollup.LatencyP50 = percentile(weigh(latencies), 50)
+		rollup.LatencyP95 = percentile(weigh(latencies), 95)
+	}
+	return rollup
+}
+
+// mergeRollups adds a new rollup of a bucket to the one it already has,
+// weighing the percentiles by ok checks
+func mergeRollups(before, after models.ProxyCheckRollup) models.ProxyCheckRollup {
+	merged := before
+	merged.Checks += after.Checks
+	merged.Failures += after.Failures
+	merged.LatencyMax = max(before.LatencyMax, after.LatencyMax)
+	p50 := []sample{{before.LatencyP50, before.Checks - before.Failures}, {after.LatencyP50, after.Checks - after.Failures}}
+	p95 := []sample{{before.LatencyP95, before.Checks - before.Failures}, {after.LatencyP95, after.Checks - after.Failures}}
+	merged.LatencyP50 = percentile(p50, 50)
+	merged.LatencyP95 = percentile(p95, 95)
+	return merged
+}
@@ -0,0 +1,148 @@
+package checkhistory
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// newTestStore returns a store on a fresh SQLite database in memory
+func newTestStore(t *testing.T) *repository.Store {
+	t.Helper()
+
+	db, err := database.Connect(&config.Config{
+		DatabaseURL:    "sqlite://:memory:",
+		MigrateOnStart: true,
+		AdminEmail:     "admin@example.com",
+		AdminPassword:  "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return repository.New(db)
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name    string
+		samples []sample
+		p       float64
+		want    int
+	}{
+		{"none", nil, 50, 0},
+		{"no weight", []sample{{100, 0}, {200, 0}}, 50, 0},
+		{"single", weigh([]int{42}), 95, 42},
+		{"median of raw checks", weigh([]int{50, 10, 40, 20, 30}), 50, 30},
+		{"p95 of raw checks", weigh([]int{50, 10, 40, 20, 30}), 95, 50},
+		{"lowest rank is the first", weigh([]int{30, 10, 20}), 0, 10},
+		{"even count takes the lower median", weigh([]int{10, 20, 30, 40}), 50, 20},
+		{"weight moves the median", []sample{{10, 1}, {100, 3}}, 50, 100},
+		{"weight below the rank", []sample{{10, 1}, {100, 3}}, 25, 10},
+		{"rollups without ok checks count for nothing", []sample{{500, 0}, {20, 1}}, 95, 20},
+		{"rollups and raw checks", []sample{{100, 4}, {200, 2}, {300, 1}}, 50, 100},
+		{"tail from a raw check", []sample{{100, 4}, {200, 2}, {300, 1}}, 95, 300},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := percentile(tt.samples, tt.p); got != tt.want {
+				t.Fatalf("percentile(%v, %v) = %d, want %d", tt.samples, tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompactKeepsStats(t *testing.T) {
+	store := newTestStore(t)
+	ctx := context.Background()
+
+	proxy := models.Proxy{Label: "p1", Type: "http", Host: "10.0.0.1", Port: 8080}
+	if err := store.Proxies.Create(ctx, &proxy); err != nil {
+		t.Fatal(err)
+	}
+	record := func(at time.Time, ok bool, latency int) {
+		t.Helper()
+		check := models.ProxyCheck{ProxyID: proxy.ID, CheckedAt: at, OK: ok, LatencyMS: latency}
+		if err := store.Checks.Record(ctx, &check); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().Truncate(Bucket)
+	first := now.Add(-72 * time.Hour)
+	second := first.Add(Bucket)
+	for i := 0; i < 4; i++ {
+		record(first.Add(time.Duration(i)*time.Minute), true, 100)
+	}
+	record(first.Add(10*time.Minute), false, 0)
+	record(second.Add(time.Minute), true, 200)
+	record(second.Add(2*time.Minute), true, 200)
+	// Recent checks stay raw
+	record(now.Add(-time.Hour), true, 300)
+
+	since := now.Add(-7 * 24 * time.Hour)
+	_, before, err := Summarize(ctx, store, []uint{proxy.ID}, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	monitor := New(store, nil, 0, 48*time.Hour, 0)
+	compacted, err := monitor.Compact(ctx, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compacted != 7 {
+		t.Fatalf("compacted %d checks, want 7", compacted)
+	}
+	rollups, err := store.Checks.Rollups(ctx, repository.CheckFilter{ProxyIDs: []uint{proxy.ID}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([][4]int, 0, len(rollups))
+	for _, rollup := range rollups {
+		got = append(got, [4]int{rollup.Checks, rollup.Failures, rollup.LatencyP50, rollup.LatencyP95})
+	}
+	if want := [][4]int{{5, 1, 100, 100}, {2, 0, 200, 200}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("rollups (checks, failures, p50, p95) = %v, want %v", got, want)
+	}
+
+	// Uptime and percentiles come out the same from the rollups
+	_, after, err := Summarize(ctx, store, []uint{proxy.ID}, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.Checks != before.Checks || after.Failures != before.Failures || *after.UptimePercent != *before.UptimePercent {
+		t.Fatalf("after compaction: %d checks, %d failures, %v%% uptime; before: %d, %d, %v%%",
+			after.Checks, after.Failures, *after.UptimePercent, before.Checks, before.Failures, *before.UptimePercent)
+	}
+	if *after.LatencyP50MS != *before.LatencyP50MS || *after.LatencyP95MS != *before.LatencyP95MS {
+		t.Fatalf("after compaction: p50 %d, p95 %d; before: %d, %d",
+			*after.LatencyP50MS, *after.LatencyP95MS, *before.LatencyP50MS, *before.LatencyP95MS)
+	}
+	if *after.UptimePercent != 87.5 || *after.LatencyP50MS != 100 || *after.LatencyP95MS != 300 {
+		t.Fatalf("uptime %v%%, p50 %d, p95 %d; want 87.5%%, 100, 300", *after.UptimePercent, *after.LatencyP50MS, *after.LatencyP95MS)
+	}
+
+	// A late check of a compacted hour is merged into its rollup
+	record(first.Add(30*time.Minute), true, 400)
+	if compacted, err := monitor.Compact(ctx, now); err != nil || compacted != 1 {
+		t.Fatalf("compacted %d late checks, %v; want 1", compacted, err)
+	}
+	rollups, err = store.Checks.Rollups(ctx, repository.CheckFilter{ProxyIDs: []uint{proxy.ID}, Until: second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rollups) != 1 || rollups[0].Checks != 6 || rollups[0].Failures != 1 || rollups[0].LatencyP50 != 100 || rollups[0].LatencyMax != 400 {
+		t.Fatalf("merged rollup = %+v, want 6 checks, 1 failure, p50 100, max 400", rollups)
+	}
+}
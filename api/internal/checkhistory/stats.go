@@ -0,0 +1,161 @@
+package checkhistory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Stats sums up the history of a proxy, or of several, within a window.
+// Latency percentiles are exact over checks still kept raw and weighted
+// from the hourly percentiles of rollups before that. Streaks and the last
+// check only count raw checks.
+type Stats struct {
+	Checks               int        `json:"checks"`
+	Failures             int        `json:"failures"`
+	UptimePercent        *float64   `json:"uptime_percent"` // nil without checks
+	LatencyP50MS         *int       `json:"latency_p50_ms"` // nil without ok checks
+	LatencyP95MS         *int       `json:"latency_p95_ms"`
+	FailureStreak        int        `json:"failure_streak"` // failed checks in a row up to the last one
+	LongestFailureStreak int        `json:"longest_failure_streak"`
+	LastCheckedAt        *time.Time `json:"last_checked_at"`
+}
+
+// Summarize returns the stats of each of proxyIDs since the given time,
+// and of all of them together. For several proxies the streaks are those
+// of the proxy with the longest one.
+func Summarize(ctx context.Context, store *repository.Store, proxyIDs []uint, since time.Time) (map[uint]Stats, Stats, error) {
+	byProxy := make(map[uint]Stats, len(proxyIDs))
+	if len(proxyIDs) == 0 {
+		return byProxy, Stats{}, nil
+	}
+	filter := repository.CheckFilter{ProxyIDs: proxyIDs, Since: since}
+	rollups, err := store.Checks.Rollups(ctx, filter)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+	checks, err := store.Checks.List(ctx, filter)
+	if err != nil {
+		return nil, Stats{}, err
+	}
+
+	summaries := make(map[uint]*summary, len(proxyIDs))
+	for _, id := range proxyIDs {
+		summaries[id] = &summary{}
+	}
+	for _, rollup := range rollups {
+		if s, ok := summaries[rollup.ProxyID]; ok {
+			s.addRollup(rollup)
+		}
+	}
+	for _, check := range checks {
+		if s, ok := summaries[check.ProxyID]; ok {
+			s.addCheck(check)
+		}
+	}
+
+	var total summary
+	for id, s := range summaries {
+		byProxy[id] = s.result()
+		total.merge(s)
+	}
+	return byProxy, total.result(), nil
+}
+
+// summary accumulates the history of one or more proxies
+type summary struct {
+	stats    Stats
+	streak   int // failed checks in a row so far
+	p50, p95 []sample
+}
+
+// addRollup adds a rollup; rollups come before the checks
+func (s *summary) addRollup(rollup models.ProxyCheckRollup) {
+	s.stats.Checks += rollup.Checks
+	s.stats.Failures += rollup.Failures
+	ok := rollup.Checks - rollup.Failures
+	s.p50 = append(s.p50, sample{rollup.LatencyP50, ok})
+	s.p95 = append(s.p95, sample{rollup.LatencyP95, ok})
+}
+
+// addCheck adds a check; checks come oldest first
+func (s *summary) addCheck(check models.ProxyCheck) {
+	s.stats.Checks++
+	checkedAt := check.CheckedAt
+	s.stats.LastCheckedAt = &checkedAt
+	if !check.OK {
+		s.stats.Failures++
+		s.streak++
+		s.stats.LongestFailureStreak = max(s.stats.LongestFailureStreak, s.streak)
+		return
+	}
+	s.streak = 0
+	s.p50 = append(s.p50, sample{check.LatencyMS, 1})
+	s.p95 = append(s.p95, sample{check.LatencyMS, 1})
+}
+
+// merge adds the history of another proxy
+func (s *summary) merge(other *summary) {
+	s.stats.Checks += other.stats.Checks
+	s.stats.Failures += other.stats.Failures
+	s.streak = max(s.streak, other.streak)
+	s.stats.LongestFailureStreak = max(s.stats.LongestFailureStreak, other.stats.LongestFailureStreak)
+	if last := other.stats.LastCheckedAt; last != nil && (s.stats.LastCheckedAt == nil || last.After(*s.stats.LastCheckedAt)) {
+		s.stats.LastCheckedAt = last
+	}
+	s.p50 = append(s.p50, other.p50...)
+	s.p95 = append(s.p95, other.p95...)
+}
+
+func (s *summary) result() Stats {
+	stats := s.stats
+	stats.FailureStreak = s.streak
+	if stats.Checks > 0 {
+		uptime := math.Round(10000*float64(stats.Checks-stats.Failures)/float64(stats.Checks)) / 100
+		stats.UptimePercent = &uptime
+	}
+	if stats.Checks > stats.Failures {
+		p50, p95 := percentile(s.p50, 50), percentile(s.p95, 95)
+		stats.LatencyP50MS, stats.LatencyP95MS = &p50, &p95
+	}
+	return stats
+}
+
+// sample is a latency in milliseconds that counts weight times
+type sample struct {
+	value  int
+	weight int
+}
+
+func weigh(values []int) []sample {
+	samples := make([]sample, len(values))
+	for i, value := range values {
+		samples[i] = sample{value, 1}
+	}
+	return samples
+}
+
+// percentile returns the p-th percentile of samples by the nearest-rank
+// method, zero if their weights add up to none. samples is sorted in place.
+func percentile(samples []sample, p float64) int {
+	sort.Slice(samples, func(i, j int) bool { return samples[i].value < samples[j].value })
+	total := 0
+	for _, s := range samples {
+		total += s.weight
+	}
+	if total <= 0 {
+		return 0
+	}
+	rank := max(int(math.Ceil(p/100*float64(total))), 1)
+	seen := 0
+	for _, s := range samples {
+		if seen += s.weight; seen >= rank {
+			return s.value
+		}
+	}
+	return samples[len(samples)-1].value
+}
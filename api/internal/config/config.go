@@ -13,6 +13,54 @@ type Config struct {
 	AdminEmail       string
 	AdminPassword    string
 	JWTExpiration    time.Duration
+	MigrateOnStart   bool // apply pending schema migrations on start
+	TrashRetention   time.Duration // deleted items are purged after this; zero keeps them
+	MetricsToken     string // bearer token required on /metrics; empty leaves it open
+	ServerOfflineAfter time.Duration // servers whose agent was not seen for this long go offline; zero disables
+
+	// Proxy health check history
+	HealthCheckInterval   time.Duration // every proxy is checked this often; zero disables
+	CheckRawRetention     time.Duration // checks are downsampled to hourly rollups after this; zero keeps them
+	CheckHistoryRetention time.Duration // rollups are purged after this; zero keeps them
+
+	// Exit IP discovery and geolocation
+	ExitIPEchoURL  string // URL answering with the caller's IP; empty disables discovery
+	GeoIPDBPath    string // MaxMind-format City or Country database; empty disables
+	GeoIPASNDBPath string // MaxMind-format ASN database; empty disables
+
+	// Anonymity classification
+	JudgeURL  string // judge requested through each proxy; empty disables
+	JudgeBind string // address to serve the bundled judge on; empty does not serve it
+
+	// Proxy expiry
+	ExpiryWarnBefore      time.Duration // proxies expiring within this are warned about once; zero disables
+	DisableExpiredProxies bool          // leave expired proxies out of agent configs
+
+	// Logging
+	LogLevel           string        // debug, info, warn or error
+	LogSQL             bool          // log every SQL statement, not only failed and slow ones
+	SlowQueryThreshold time.Duration // SQL statements taking longer are logged; zero disables
+
+	// Tracing, exported over OTLP/HTTP as set by the OTEL_EXPORTER_OTLP_*
+	// variables
+	TracesExporter string // otlp or none
+
+	// Outbound requests to URLs set through the API
+	WebhookAllowPrivate bool // let webhooks and bot API channels reach loopback, private and link-local addresses
+
+	// Mail server of email alert channels
+	SMTPHost     string // empty disables email channels
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	SMTPFrom     string
+
+	// Proxy credential encryption
+	CredentialsKey              string // base64, overrides the key file
+	CredentialsKeyFile          string
+	CredentialsPreviousKeys     string // comma-separated base64 keys still accepted for decryption
+	CredentialsPreviousKeyFiles string // comma-separated key files still accepted for decryption
+	EncryptProxyUsernames       bool
 }
 
 func Load() *Config {
@@ -23,6 +71,44 @@ func Load() *Config {
 		AdminEmail:    getEnv("API_ADMIN_EMAIL", "admin@example.com"),
 		AdminPassword: getEnv("API_ADMIN_PASSWORD", "admin_password"),
 		JWTExpiration: time.Hour * time.Duration(getEnvAsInt("JWT_EXPIRATION_HOURS", 1)),
+		MigrateOnStart: getEnv("MIGRATE_ON_START", "true") == "true",
+		TrashRetention: 24 * time.Hour * time.Duration(getEnvAsInt("TRASH_RETENTION_DAYS", 30)),
+		MetricsToken:   os.Getenv("METRICS_TOKEN"),
+		ServerOfflineAfter: time.Second * time.Duration(getEnvAsInt("SERVER_OFFLINE_AFTER_SECONDS", 300)),
+
+		HealthCheckInterval:   time.Second * time.Duration(getEnvAsInt("HEALTH_CHECK_INTERVAL_SECONDS", 300)),
+		CheckRawRetention:     time.Hour * time.Duration(getEnvAsInt("CHECK_RAW_RETENTION_HOURS", 48)),
+		CheckHistoryRetention: 24 * time.Hour * time.Duration(getEnvAsInt("CHECK_HISTORY_RETENTION_DAYS", 90)),
+
+		ExitIPEchoURL:  os.Getenv("EXIT_IP_ECHO_URL"),
+		GeoIPDBPath:    os.Getenv("GEOIP_DB_PATH"),
+		GeoIPASNDBPath: os.Getenv("GEOIP_ASN_DB_PATH"),
+
+		JudgeURL:  os.Getenv("JUDGE_URL"),
+		JudgeBind: os.Getenv("JUDGE_BIND"),
+
+		ExpiryWarnBefore:      24 * time.Hour * time.Duration(getEnvAsInt("PROXY_EXPIRY_WARN_DAYS", 7)),
+		DisableExpiredProxies: getEnv("DISABLE_EXPIRED_PROXIES", "false") == "true",
+
+		LogLevel:           getEnv("LOG_LEVEL", "info"),
+		LogSQL:             getEnv("LOG_SQL", "false") == "true",
+		SlowQueryThreshold: time.Millisecond * time.Duration(getEnvAsInt("SLOW_QUERY_MS", 200)),
+
+		TracesExporter: getEnv("OTEL_TRACES_EXPORTER", "none"),
+
+		WebhookAllowPrivate: getEnv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "false") == "true",
+
+		SMTPHost:     os.Getenv("SMTP_HOST"),
+		SMTPPort:     getEnvAsInt("SMTP_PORT", 587),
+		SMTPUsername: os.Getenv("SMTP_USERNAME"),
+		SMTPPassword: os.Getenv("SMTP_PASSWORD"),
+		SMTPFrom:     getEnv("SMTP_FROM", "pgm-alerts@localhost"),
+
+		CredentialsKey:              os.Getenv("CREDENTIALS_KEY"),
+		CredentialsKeyFile:          getEnv("CREDENTIALS_KEY_FILE", "data/credentials.key"),
+		CredentialsPreviousKeys:     os.Getenv("CREDENTIALS_PREVIOUS_KEYS"),
+		CredentialsPreviousKeyFiles: os.Getenv("CREDENTIALS_PREVIOUS_KEY_FILES"),
+		EncryptProxyUsernames:       getEnv("ENCRYPT_PROXY_USERNAMES", "false") == "true",
 	}
 }
 
@@ -0,0 +1,386 @@
+package configstate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
+)
+
+// ErrVersionConflict is returned when the server's config version is not the
+// one the caller planned against.
+var ErrVersionConflict = errors.New("config version changed since the plan was made")
+
+// StateError is returned when a document cannot be planned or applied
+// against the server's current state.
+type StateError struct {
+	Message string
+}
+
+func (e *StateError) Error() string {
+	return e.Message
+}
+
+// Result is the outcome of planning or applying a document
+type Result struct {
+	Plan          *Plan `json:"plan"`
+	ConfigVersion int   `json:"config_version"`
+	Applied       bool  `json:"applied"`
+}
+
+// serverState is a server's proxies and mappings as stored
+type serverState struct {
+	server   models.Server
+	proxies  []models.Proxy
+	mappings []models.Mapping
+	doc      *Document
+}
+
+// Load returns the document describing a server's current state
+func Load(ctx context.Context, store *repository.Store, serverID uint) (*Document, int, error) {
+	state, err := loadState(ctx, store, serverID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return state.doc, state.server.ConfigVersion, nil
+}
+
+// FromSnapshot returns the document describing a server at a snapshot
+func FromSnapshot(snapshot *models.ConfigSnapshot) (*Document, error) {
+	state, err := database.DecodeState(snapshot)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := FromModels(state.ProxyRows(), state.Mappings, state.Groups)
+	if err != nil {
+		return nil, &StateError{Message: err.Error()}
+	}
+	return doc, nil
+}
+
+// PlanServer compares a server's current state with the desired document
+func PlanServer(ctx context.Context, store *repository.Store, serverID uint, desired *Document) (*Result, error) {
+	state, err := loadState(ctx, store, serverID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Result{
+		Plan:          Diff(state.doc, desired),
+		ConfigVersion: state.server.ConfigVersion,
+	}, nil
+}
+
+// ApplyServer makes the server match the desired document in one transaction
+// and bumps its config version once. If expectedVersion is set the apply is
+// refused unless the server is still at that version. Deleted proxies and
+// mappings go to the trash as deleted by actor, and every proxy and mapping
+// it changes is published as an event by actor.
+func ApplyServer(ctx context.Context, store *repository.Store, serverID uint, desired *Document, expectedVersion *int, actor string) (*Result, error) {
+	result := &Result{}
+
+	err := store.Transaction(ctx, func(tx *repository.Store) error {
+		state, err := loadState(ctx, tx, serverID)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && state.server.ConfigVersion != *expectedVersion {
+			return ErrVersionConflict
+		}
+
+		result.Plan = Diff(state.doc, desired)
+		result.ConfigVersion = state.server.ConfigVersion
+		if result.Plan.Empty() {
+			return nil
+		}
+
+		if err := execute(ctx, tx, state, result.Plan, actor); err != nil {
+			return err
+		}
+
+		if err := tx.Servers.BumpConfigVersion(ctx, serverID); err != nil {
+			return err
+		}
+		server, err := tx.Servers.Get(ctx, serverID, false)
+		if err != nil {
+			return err
+		}
+
+		result.ConfigVersion = server.ConfigVersion
+		result.Applied = true
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func loadState(ctx context.Context, tx *repository.Store, serverID uint) (*serverState, error) {
+	server, err := tx.Servers.Get(ctx, serverID, false)
+	if err != nil {
+		return nil, err
+	}
+	state := &serverState{server: *server}
+
+	if state.proxies, err = tx.Proxies.List(ctx, repository.ProxyFilter{ServerID: &serverID}); err != nil {
+		return nil, err
+	}
+	if state.mappings, err = tx.Mappings.List(ctx, repository.MappingFilter{ServerID: &serverID}); err != nil {
+		return nil, err
+	}
+
+	groups, err := tx.Groups.List(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	groupNames := make(map[uint]string, len(groups))
+	for _, group := range groups {
+		groupNames[group.ID] = group.Name
+	}
+
+	doc, err := FromModels(state.proxies, state.mappings, groupNames)
+	if err != nil {
+		return nil, &StateError{Message: err.Error()}
+	}
+	state.doc = doc
+
+	return state, nil
+}
+
+// execute runs the plan. Proxies are created and updated first so mappings
+// can point at them, and deleted last once no mapping uses them.
+func execute(ctx context.Context, tx *repository.Store, state *serverState, plan *Plan, actor string) error {
+	groupIDs, err := resolveGroups(ctx, tx, plan)
+	if err != nil {
+		return err
+	}
+
+	proxyIDs := make(map[string]uint, len(state.proxies))
+	proxiesByLabel := make(map[string]models.Proxy, len(state.proxies))
+	for _, proxy := range state.proxies {
+		proxyIDs[proxy.Label] = proxy.ID
+		proxiesByLabel[proxy.Label] = proxy
+	}
+	// Rows are published like changes made through the handlers
+	changes := events.NewChanges(actor)
+	// Deletes go to the trash like those of the handlers, so they can be
+	// restored on their own
+	toTrash := func(resourceType string, id uint, label string, cascade *trash.Cascade) error {
+		entry := models.TrashEntry{ResourceType: resourceType, ResourceID: id, Label: label, ServerID: &state.server.ID, DeletedBy: actor}
+		return trash.Record(ctx, tx, entry, cascade)
+	}
+	// FromModels keeps the order of its input, so doc mappings line up with rows
+	mappingsByKey := make(map[string]models.Mapping, len(state.mappings))
+	for i, mapping := range state.mappings {
+		mappingsByKey[state.doc.Mappings[i].Key()] = mapping
+	}
+
+	serverID := state.server.ID
+
+	for _, change := range plan.Changes {
+		if change.Resource != ResourceProxy {
+			continue
+		}
+		spec := change.proxy
+
+		plainUsername, setUsername := credential(spec.Username)
+		username, err := secrets.SealText(plainUsername)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt credentials of proxy %q: %w", spec.Label, err)
+		}
+		plainPassword, setPassword := credential(spec.Password)
+		password, err := secrets.SealSecret(plainPassword)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt credentials of proxy %q: %w", spec.Label, err)
+		}
+
+		switch change.Action {
+		case ActionCreate:
+			proxy := models.Proxy{
+				ServerID: &serverID,
+				Label:    spec.Label,
+				Type:     spec.Type,
+				Host:     spec.Host,
+				Port:     spec.Port,
+				Username: username,
+				Password: password,
+				Health:   "unknown",
+			}
+			if spec.Group != nil && *spec.Group != "" {
+				groupID := groupIDs[*spec.Group]
+				proxy.GroupID = &groupID
+			}
+			if err := tx.Proxies.Create(ctx, &proxy); err != nil {
+				return fmt.Errorf("failed to create proxy %q: %w", spec.Label, err)
+			}
+			proxyIDs[spec.Label] = proxy.ID
+			changes.ProxyCreated(proxy.ID)
+
+		case ActionUpdate:
+			updates := repository.Fields{
+				"type": spec.Type,
+				"host": spec.Host,
+				"port": spec.Port,
+			}
+			if setUsername {
+				updates["username"] = username
+			}
+			if setPassword {
+				updates["password"] = password
+			}
+			if spec.Group != nil {
+				if *spec.Group == "" {
+					updates["group_id"] = nil
+				} else {
+					updates["group_id"] = groupIDs[*spec.Group]
+				}
+			}
+			changes.Proxies(proxiesByLabel[spec.Label])
+			if err := tx.Proxies.Update(ctx, proxyIDs[spec.Label], updates); err != nil {
+				return fmt.Errorf("failed to update proxy %q: %w", spec.Label, err)
+			}
+		}
+	}
+
+	for _, change := range plan.Changes {
+		if change.Resource != ResourceMapping || change.Action != ActionDelete {
+			continue
+		}
+		mapping := mappingsByKey[change.Key]
+		changes.Mappings(mapping)
+		var cascade trash.Cascade
+		if err := trash.DeleteMapping(ctx, tx, mapping.ID, &cascade); err != nil {
+			return fmt.Errorf("failed to delete mapping %q: %w", change.Key, err)
+		}
+		if err := toTrash(models.TrashMapping, mapping.ID, mapping.ClientCIDR, &cascade); err != nil {
+			return fmt.Errorf("failed to delete mapping %q: %w", change.Key, err)
+		}
+	}
+
+	for _, change := range plan.Changes {
+		if change.Resource != ResourceMapping {
+			continue
+		}
+		spec := change.mapping
+
+		switch change.Action {
+		case ActionCreate:
+			ports := append([]int(nil), spec.DstPorts...)
+			sort.Ints(ports)
+			dstPortsJSON, err := json.Marshal(ports)
+			if err != nil {
+				return err
+			}
+
+			upstream := proxyIDs[spec.Upstream]
+			mapping := models.Mapping{
+				ServerID:        serverID,
+				ClientCIDR:      spec.ClientCIDR,
+				DstPorts:        string(dstPortsJSON),
+				UpstreamProxyID: &upstream,
+				Enabled:         spec.IsEnabled(),
+				Notes:           spec.Notes,
+			}
+			if err := tx.Mappings.Create(ctx, &mapping); err != nil {
+				return fmt.Errorf("failed to create mapping %q: %w", change.Key, err)
+			}
+			changes.MappingCreated(mapping.ID)
+			// Create skips false because the column defaults to true
+			if !spec.IsEnabled() {
+				if err := tx.Mappings.Update(ctx, mapping.ID, repository.Fields{"enabled": false}); err != nil {
+					return fmt.Errorf("failed to create mapping %q: %w", change.Key, err)
+				}
+			}
+
+		case ActionUpdate:
+			updates := repository.Fields{
+				"upstream_proxy_id": proxyIDs[spec.Upstream],
+				"enabled":           spec.IsEnabled(),
+				"notes":             spec.Notes,
+			}
+			changes.Mappings(mappingsByKey[change.Key])
+			if err := tx.Mappings.Update(ctx, mappingsByKey[change.Key].ID, updates); err != nil {
+				return fmt.Errorf("failed to update mapping %q: %w", change.Key, err)
+			}
+		}
+	}
+
+	for _, change := range plan.Changes {
+		if change.Resource != ResourceProxy || change.Action != ActionDelete {
+			continue
+		}
+		proxyID := proxyIDs[change.Key]
+		// Schedules switching to the proxy have nowhere left to switch to,
+		// so they go to the trash along with it
+		schedules, err := tx.Schedules.List(ctx, repository.ScheduleFilter{UpstreamProxyIDs: []uint{proxyID}})
+		if err != nil {
+			return fmt.Errorf("failed to delete schedules using proxy %q: %w", change.Key, err)
+		}
+		var cascade trash.Cascade
+		for _, schedule := range schedules {
+			cascade.Schedules = append(cascade.Schedules, schedule.ID)
+		}
+		if len(cascade.Schedules) > 0 {
+			if err := tx.Schedules.DeleteMany(ctx, cascade.Schedules); err != nil {
+				return fmt.Errorf("failed to delete schedules using proxy %q: %w", change.Key, err)
+			}
+		}
+		changes.Proxies(proxiesByLabel[change.Key])
+		if err := tx.Proxies.Delete(ctx, proxyID); err != nil {
+			return fmt.Errorf("failed to delete proxy %q: %w", change.Key, err)
+		}
+		if err := toTrash(models.TrashProxy, proxyID, change.Key, &cascade); err != nil {
+			return fmt.Errorf("failed to delete proxy %q: %w", change.Key, err)
+		}
+	}
+
+	return changes.Publish(ctx, tx)
+}
+
+// resolveGroups maps the group names used by the plan to group IDs
+func resolveGroups(ctx context.Context, tx *repository.Store, plan *Plan) (map[string]uint, error) {
+	ids := make(map[string]uint)
+	var byName map[string]uint
+
+	for _, change := range plan.Changes {
+		if change.proxy == nil || change.Action == ActionDelete || change.proxy.Group == nil {
+			continue
+		}
+		name := *change.proxy.Group
+		if name == "" {
+			continue
+		}
+		if _, ok := ids[name]; ok {
+			continue
+		}
+
+		if byName == nil {
+			groups, err := tx.Groups.List(ctx, false)
+			if err != nil {
+				return nil, err
+			}
+			byName = make(map[string]uint, len(groups))
+			for _, group := range groups {
+				byName[group.Name] = group.ID
+			}
+		}
+		id, ok := byName[name]
+		if !ok {
+			return nil, &StateError{Message: fmt.Sprintf("group %q does not exist", name)}
+		}
+		ids[name] = id
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,296 @@
+package configstate
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"gopkg.in/yaml.v3"
+)
+
+var validProxyTypes = map[string]bool{"http": true, "https": true, "socks4": true, "socks5": true}
+
+// Document is the full desired state of one server. Proxies are identified
+// by label, mappings by client CIDR and destination ports.
+type Document struct {
+	Proxies  []ProxySpec   `json:"proxies" yaml:"proxies"`
+	Mappings []MappingSpec `json:"mappings" yaml:"mappings"`
+}
+
+// ProxySpec is an upstream proxy in a Document
+type ProxySpec struct {
+	Label    string  `json:"label" yaml:"label"`
+	Type     string  `json:"type" yaml:"type"`
+	Host     string  `json:"host" yaml:"host"`
+	Port     int     `json:"port" yaml:"port"`
+	Username *string `json:"username,omitempty" yaml:"username,omitempty"` // omitted or masked leaves it unchanged
+	Password *string `json:"password,omitempty" yaml:"password,omitempty"` // omitted or masked leaves it unchanged
+	Group    *string `json:"group,omitempty" yaml:"group,omitempty"`       // group name; omitted leaves the group unchanged, "" clears it
+}
+
+// credential returns a credential of a spec and whether it is set. Omitted
+// and masked credentials are not set: the masked value is what an export
+// shows, so sending it back means unchanged.
+func credential(value *string) (string, bool) {
+	if value == nil || *value == secrets.Mask {
+		return "", false
+	}
+	return *value, true
+}
+
+// Seal encrypts the credentials set in the document, so it can be stored.
+// Usernames are sealed only when configured to be, like those of proxies.
+func (d *Document) Seal() error {
+	for i := range d.Proxies {
+		proxy := &d.Proxies[i]
+		if username, ok := credential(proxy.Username); ok && username != "" {
+			sealed, err := secrets.SealText(username)
+			if err != nil {
+				return fmt.Errorf("cannot encrypt the username of proxy %q: %w", proxy.Label, err)
+			}
+			value := string(sealed)
+			proxy.Username = &value
+		}
+		if password, ok := credential(proxy.Password); ok && password != "" {
+			sealed, err := secrets.SealSecret(password)
+			if err != nil {
+				return fmt.Errorf("cannot encrypt the password of proxy %q: %w", proxy.Label, err)
+			}
+			value := string(sealed)
+			proxy.Password = &value
+		}
+	}
+	return nil
+}
+
+// Open decrypts the credentials of a document sealed by Seal. Credentials
+// stored in the clear before documents were sealed are kept as they are.
+func (d *Document) Open() error {
+	for i := range d.Proxies {
+		proxy := &d.Proxies[i]
+		if proxy.Username != nil {
+			username, err := secrets.Text(*proxy.Username).Open()
+			if err != nil {
+				return fmt.Errorf("cannot decrypt the username of proxy %q: %w", proxy.Label, err)
+			}
+			proxy.Username = &username
+		}
+		if proxy.Password != nil {
+			password, err := secrets.Secret(*proxy.Password).Open()
+			if err != nil {
+				return fmt.Errorf("cannot decrypt the password of proxy %q: %w", proxy.Label, err)
+			}
+			proxy.Password = &password
+		}
+	}
+	return nil
+}
+
+// Mask replaces the credentials set in the document with secrets.Mask, so
+// it can be shown. A masked document applied again leaves them unchanged.
+func (d *Document) Mask() {
+	masked := func(value *string) *string {
+		if value == nil || *value == "" {
+			return value
+		}
+		mask := secrets.Mask
+		return &mask
+	}
+	for i := range d.Proxies {
+		d.Proxies[i].Username = masked(d.Proxies[i].Username)
+		d.Proxies[i].Password = masked(d.Proxies[i].Password)
+	}
+}
+
+// MappingSpec is a mapping in a Document
+type MappingSpec struct {
+	ClientCIDR string `json:"client_cidr" yaml:"client_cidr"`
+	DstPorts   []int  `json:"dst_ports" yaml:"dst_ports"`
+	Upstream   string `json:"upstream" yaml:"upstream"` // proxy label
+	Enabled    *bool  `json:"enabled,omitempty" yaml:"enabled,omitempty"`
+	Notes      string `json:"notes,omitempty" yaml:"notes,omitempty"`
+}
+
+// Key identifies the mapping within a server
+func (m MappingSpec) Key() string {
+	ports := append([]int(nil), m.DstPorts...)
+	sort.Ints(ports)
+
+	parts := make([]string, len(ports))
+	for i, port := range ports {
+		parts[i] = strconv.Itoa(port)
+	}
+	return m.ClientCIDR + " -> " + strings.Join(parts, ",")
+}
+
+// IsEnabled returns the enabled flag, defaulting to true
+func (m MappingSpec) IsEnabled() bool {
+	return m.Enabled == nil || *m.Enabled
+}
+
+// IsYAML reports whether a content type carries a YAML document
+func IsYAML(contentType string) bool {
+	switch contentType {
+	case "application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml":
+		return true
+	}
+	return false
+}
+
+// Parse decodes a JSON or YAML document and validates it. Unknown fields are
+// rejected so typos do not silently drop settings.
+func Parse(body []byte, contentType string) (*Document, error) {
+	var doc Document
+
+	if IsYAML(contentType) {
+		dec := yaml.NewDecoder(bytes.NewReader(body))
+		dec.KnownFields(true)
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid YAML document: %w", err)
+		}
+	} else {
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&doc); err != nil {
+			return nil, fmt.Errorf("invalid JSON document: %w", err)
+		}
+	}
+
+	if err := doc.Validate(); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// Validate checks every proxy and mapping and the references between them
+func (d *Document) Validate() error {
+	labels := make(map[string]bool, len(d.Proxies))
+	for i, proxy := range d.Proxies {
+		if proxy.Label == "" {
+			return fmt.Errorf("proxies[%d]: label is required", i)
+		}
+		if labels[proxy.Label] {
+			return fmt.Errorf("proxies[%d]: duplicate label %q", i, proxy.Label)
+		}
+		labels[proxy.Label] = true
+
+		if !validProxyTypes[proxy.Type] {
+			return fmt.Errorf("proxies[%d]: invalid proxy type %q. Must be: http, https, socks4, socks5", i, proxy.Type)
+		}
+		if proxy.Host == "" {
+			return fmt.Errorf("proxies[%d]: host is required", i)
+		}
+		if proxy.Port < 1 || proxy.Port > 65535 {
+			return fmt.Errorf("proxies[%d]: port must be between 1 and 65535", i)
+		}
+	}
+
+	keys := make(map[string]bool, len(d.Mappings))
+	for i, mapping := range d.Mappings {
+		if mapping.ClientCIDR == "" {
+			return fmt.Errorf("mappings[%d]: client_cidr is required", i)
+		}
+		if len(mapping.DstPorts) == 0 {
+			return fmt.Errorf("mappings[%d]: dst_ports cannot be empty", i)
+		}
+		for _, port := range mapping.DstPorts {
+			if port < 1 || port > 65535 {
+				return fmt.Errorf("mappings[%d]: all ports must be between 1 and 65535", i)
+			}
+		}
+		if !labels[mapping.Upstream] {
+			return fmt.Errorf("mappings[%d]: upstream %q is not a proxy in this document", i, mapping.Upstream)
+		}
+
+		key := mapping.Key()
+		if keys[key] {
+			return fmt.Errorf("mappings[%d]: duplicate mapping %q", i, key)
+		}
+		keys[key] = true
+	}
+
+	return nil
+}
+
+// FromModels builds the document describing a server's current proxies and
+// mappings. groups maps group IDs to names.
+func FromModels(proxies []models.Proxy, mappings []models.Mapping, groups map[uint]string) (*Document, error) {
+	doc := &Document{
+		Proxies:  make([]ProxySpec, 0, len(proxies)),
+		Mappings: make([]MappingSpec, 0, len(mappings)),
+	}
+
+	labels := make(map[uint]string, len(proxies))
+	seen := make(map[string]bool, len(proxies))
+	for _, proxy := range proxies {
+		if seen[proxy.Label] {
+			return nil, fmt.Errorf("server has more than one proxy labeled %q; labels must be unique to manage the server declaratively", proxy.Label)
+		}
+		seen[proxy.Label] = true
+		labels[proxy.ID] = proxy.Label
+
+		group := ""
+		if proxy.GroupID != nil {
+			group = groups[*proxy.GroupID]
+		}
+
+		username, err := proxy.Username.Open()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt the username of proxy %q: %w", proxy.Label, err)
+		}
+		password, err := proxy.Password.Open()
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt the password of proxy %q: %w", proxy.Label, err)
+		}
+
+		doc.Proxies = append(doc.Proxies, ProxySpec{
+			Label:    proxy.Label,
+			Type:     proxy.Type,
+			Host:     proxy.Host,
+			Port:     proxy.Port,
+			Username: &username,
+			Password: &password,
+			Group:    &group,
+		})
+	}
+
+	keys := make(map[string]bool, len(mappings))
+	for _, mapping := range mappings {
+		var ports []int
+		if err := json.Unmarshal([]byte(mapping.DstPorts), &ports); err != nil {
+			return nil, fmt.Errorf("mapping %d has invalid dst_ports %q", mapping.ID, mapping.DstPorts)
+		}
+
+		if mapping.UpstreamProxyID == nil {
+			return nil, fmt.Errorf("mapping %d has no upstream proxy; assign one or delete the mapping to manage the server declaratively", mapping.ID)
+		}
+		upstream, ok := labels[*mapping.UpstreamProxyID]
+		if !ok {
+			return nil, fmt.Errorf("mapping %d points at proxy %d which is not on this server", mapping.ID, *mapping.UpstreamProxyID)
+		}
+
+		enabled := mapping.Enabled
+		spec := MappingSpec{
+			ClientCIDR: mapping.ClientCIDR,
+			DstPorts:   ports,
+			Upstream:   upstream,
+			Enabled:    &enabled,
+			Notes:      mapping.Notes,
+		}
+
+		key := spec.Key()
+		if keys[key] {
+			return nil, fmt.Errorf("server has more than one mapping for %q; mappings must be unique to manage the server declaratively", key)
+		}
+		keys[key] = true
+
+		doc.Mappings = append(doc.Mappings, spec)
+	}
+
+	return doc, nil
+}
@@ -0,0 +1,187 @@
+package configstate
+
+import "sort"
+
+// Change actions
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change resources
+const (
+	ResourceProxy   = "proxy"
+	ResourceMapping = "mapping"
+)
+
+const maskedValue = "********"
+
+// Plan lists the changes needed to turn the current state into the desired one
+type Plan struct {
+	Changes []Change    `json:"changes"`
+	Summary PlanSummary `json:"summary"`
+}
+
+// PlanSummary counts changes per action
+type PlanSummary struct {
+	Create int `json:"create"`
+	Update int `json:"update"`
+	Delete int `json:"delete"`
+}
+
+// Change is a single proxy or mapping to create, update or delete
+type Change struct {
+	Resource string        `json:"resource"`
+	Action   string        `json:"action"`
+	Key      string        `json:"key"`
+	Fields   []FieldChange `json:"fields,omitempty"`
+
+	proxy   *ProxySpec
+	mapping *MappingSpec
+}
+
+// FieldChange is a changed field of an updated resource. Credentials are masked.
+type FieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old"`
+	New   interface{} `json:"new"`
+}
+
+// Empty reports whether the plan has nothing to do
+func (p *Plan) Empty() bool {
+	return len(p.Changes) == 0
+}
+
+// Diff compares two documents. Proxies come before mappings, and changes
+// are ordered by key so the same inputs always give the same plan.
+func Diff(current, desired *Document) *Plan {
+	plan := &Plan{Changes: []Change{}}
+
+	currentProxies := make(map[string]ProxySpec, len(current.Proxies))
+	for _, proxy := range current.Proxies {
+		currentProxies[proxy.Label] = proxy
+	}
+	desiredProxies := make(map[string]ProxySpec, len(desired.Proxies))
+	for _, proxy := range desired.Proxies {
+		desiredProxies[proxy.Label] = proxy
+	}
+
+	for _, label := range sortedKeys(desiredProxies) {
+		want := desiredProxies[label]
+		have, ok := currentProxies[label]
+		if !ok {
+			plan.add(Change{Resource: ResourceProxy, Action: ActionCreate, Key: label, proxy: &want})
+			continue
+		}
+		if fields := diffProxy(have, want); len(fields) > 0 {
+			plan.add(Change{Resource: ResourceProxy, Action: ActionUpdate, Key: label, Fields: fields, proxy: &want})
+		}
+	}
+	for _, label := range sortedKeys(currentProxies) {
+		if _, ok := desiredProxies[label]; !ok {
+			have := currentProxies[label]
+			plan.add(Change{Resource: ResourceProxy, Action: ActionDelete, Key: label, proxy: &have})
+		}
+	}
+
+	currentMappings := make(map[string]MappingSpec, len(current.Mappings))
+	for _, mapping := range current.Mappings {
+		currentMappings[mapping.Key()] = mapping
+	}
+	desiredMappings := make(map[string]MappingSpec, len(desired.Mappings))
+	for _, mapping := range desired.Mappings {
+		desiredMappings[mapping.Key()] = mapping
+	}
+
+	for _, key := range sortedKeys(desiredMappings) {
+		want := desiredMappings[key]
+		have, ok := currentMappings[key]
+		if !ok {
+			plan.add(Change{Resource: ResourceMapping, Action: ActionCreate, Key: key, mapping: &want})
+			continue
+		}
+		if fields := diffMapping(have, want); len(fields) > 0 {
+			plan.add(Change{Resource: ResourceMapping, Action: ActionUpdate, Key: key, Fields: fields, mapping: &want})
+		}
+	}
+	for _, key := range sortedKeys(currentMappings) {
+		if _, ok := desiredMappings[key]; !ok {
+			have := currentMappings[key]
+			plan.add(Change{Resource: ResourceMapping, Action: ActionDelete, Key: key, mapping: &have})
+		}
+	}
+
+	return plan
+}
+
+func (p *Plan) add(change Change) {
+	p.Changes = append(p.Changes, change)
+	switch change.Action {
+	case ActionCreate:
+		p.Summary.Create++
+	case ActionUpdate:
+		p.Summary.Update++
+	case ActionDelete:
+		p.Summary.Delete++
+	}
+}
+
+func diffProxy(have, want ProxySpec) []FieldChange {
+	var fields []FieldChange
+	if have.Type != want.Type {
+		fields = append(fields, FieldChange{Field: "type", Old: have.Type, New: want.Type})
+	}
+	if have.Host != want.Host {
+		fields = append(fields, FieldChange{Field: "host", Old: have.Host, New: want.Host})
+	}
+	if have.Port != want.Port {
+		fields = append(fields, FieldChange{Field: "port", Old: have.Port, New: want.Port})
+	}
+	haveUsername, _ := credential(have.Username)
+	if username, ok := credential(want.Username); ok && haveUsername != username {
+		fields = append(fields, FieldChange{Field: "username", Old: haveUsername, New: username})
+	}
+	havePassword, _ := credential(have.Password)
+	if password, ok := credential(want.Password); ok && havePassword != password {
+		fields = append(fields, FieldChange{Field: "password", Old: mask(havePassword), New: mask(password)})
+	}
+	if want.Group != nil && (have.Group == nil || *have.Group != *want.Group) {
+		old := ""
+		if have.Group != nil {
+			old = *have.Group
+		}
+		fields = append(fields, FieldChange{Field: "group", Old: old, New: *want.Group})
+	}
+	return fields
+}
+
+func diffMapping(have, want MappingSpec) []FieldChange {
+	var fields []FieldChange
+	if have.Upstream != want.Upstream {
+		fields = append(fields, FieldChange{Field: "upstream", Old: have.Upstream, New: want.Upstream})
+	}
+	if have.IsEnabled() != want.IsEnabled() {
+		fields = append(fields, FieldChange{Field: "enabled", Old: have.IsEnabled(), New: want.IsEnabled()})
+	}
+	if have.Notes != want.Notes {
+		fields = append(fields, FieldChange{Field: "notes", Old: have.Notes, New: want.Notes})
+	}
+	return fields
+}
+
+func mask(secret string) string {
+	if secret == "" {
+		return ""
+	}
+	return maskedValue
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
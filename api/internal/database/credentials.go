@@ -0,0 +1,253 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"gorm.io/gorm"
+)
+
+// SealedSamples returns a sealed value from each place credentials are
+// stored, trashed rows included, for checking that the credentials key
+// opens them. It returns none while nothing is sealed.
+func (db *DB) SealedSamples() ([]string, error) {
+	sources := []struct{ table, column string }{
+		{"proxies", "password"},
+		{"proxies", "username"},
+		{"webhooks", "secret"},
+		{"alert_channels", "token"},
+		{"config_snapshots", "config"},
+		{"change_set_targets", "document"},
+	}
+	var samples []string
+	for _, source := range sources {
+		var values []string
+		err := db.Table(source.table).Where(source.column+" LIKE ?", secrets.SealedLike).
+			Limit(1).Pluck(source.column, &values).Error
+		if err != nil {
+			return nil, fmt.Errorf("%s.%s: %w", source.table, source.column, err)
+		}
+		for _, value := range values {
+			if sample := secrets.FindSealed(value); sample != "" {
+				samples = append(samples, sample)
+			}
+		}
+	}
+	return samples, nil
+}
+
+// RotateCredentials re-seals every stored proxy credential, including the
+// copies in config snapshots and change set documents, with the current key
+// of k. Plain text credentials from before encryption are sealed. Usernames
+// are sealed if sealUsernames is set and stored in the clear otherwise. It
+// returns the number of proxy, snapshot and change set target rows
+// rewritten.
+func (db *DB) RotateCredentials(k *secrets.Keyring, sealUsernames bool) (int, int, int, error) {
+	var proxyCount, snapshotCount, targetCount int
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var proxies []models.Proxy
+		if err := tx.Select("id", "username", "password").Find(&proxies).Error; err != nil {
+			return err
+		}
+		for _, proxy := range proxies {
+			agentProxy := models.NewAgentProxy(proxy)
+			changed, err := resealProxy(k, &agentProxy, sealUsernames)
+			if err != nil {
+				return fmt.Errorf("proxy %d: %w", proxy.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := tx.Model(&models.Proxy{}).Where("id = ?", proxy.ID).Updates(map[string]interface{}{
+				"username": agentProxy.Username,
+				"password": agentProxy.Password,
+			}).Error; err != nil {
+				return err
+			}
+			proxyCount++
+		}
+
+		var snapshots []models.ConfigSnapshot
+		if err := tx.Select("id", "config", "state").Find(&snapshots).Error; err != nil {
+			return err
+		}
+		for _, snapshot := range snapshots {
+			config, state, changed, err := resealSnapshot(k, &snapshot, sealUsernames)
+			if err != nil {
+				return fmt.Errorf("snapshot %d: %w", snapshot.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := tx.Model(&models.ConfigSnapshot{}).Where("id = ?", snapshot.ID).Updates(map[string]interface{}{
+				"config": config,
+				"state":  state,
+			}).Error; err != nil {
+				return err
+			}
+			snapshotCount++
+		}
+
+		var targets []models.ChangeSetTarget
+		if err := tx.Select("id", "document").Find(&targets).Error; err != nil {
+			return err
+		}
+		for _, target := range targets {
+			document, changed, err := resealDocument(k, target.Document, sealUsernames)
+			if err != nil {
+				return fmt.Errorf("change set target %d: %w", target.ID, err)
+			}
+			if !changed {
+				continue
+			}
+			if err := tx.Model(&models.ChangeSetTarget{}).Where("id = ?", target.ID).Update("document", document).Error; err != nil {
+				return err
+			}
+			targetCount++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return proxyCount, snapshotCount, targetCount, nil
+}
+
+// resealDocument re-seals the proxy credentials of a change set target's
+// document. Only the credentials are decoded, so the rest of the document
+// is kept as stored.
+func resealDocument(k *secrets.Keyring, document string, sealUsernames bool) (string, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(document), &doc); err != nil {
+		return "", false, fmt.Errorf("invalid document: %w", err)
+	}
+	var proxies []map[string]json.RawMessage
+	if raw, ok := doc["proxies"]; ok {
+		if err := json.Unmarshal(raw, &proxies); err != nil {
+			return "", false, fmt.Errorf("invalid document: %w", err)
+		}
+	}
+
+	changed := false
+	for _, proxy := range proxies {
+		for field, seal := range map[string]bool{"username": sealUsernames, "password": true} {
+			raw, ok := proxy[field]
+			if !ok {
+				continue
+			}
+			var value *string
+			if err := json.Unmarshal(raw, &value); err != nil {
+				return "", false, fmt.Errorf("invalid document: %w", err)
+			}
+			// Masked credentials leave the stored ones unchanged
+			if value == nil || *value == "" || *value == secrets.Mask {
+				continue
+			}
+			resealed, c, err := resealValue(k, *value, seal)
+			if err != nil {
+				return "", false, err
+			}
+			if !c {
+				continue
+			}
+			if proxy[field], err = json.Marshal(resealed); err != nil {
+				return "", false, err
+			}
+			changed = true
+		}
+	}
+	if !changed {
+		return "", false, nil
+	}
+
+	raw, err := json.Marshal(proxies)
+	if err != nil {
+		return "", false, err
+	}
+	doc["proxies"] = raw
+	resealed, err := json.Marshal(doc)
+	if err != nil {
+		return "", false, err
+	}
+	return string(resealed), true, nil
+}
+
+func resealSnapshot(k *secrets.Keyring, snapshot *models.ConfigSnapshot, sealUsernames bool) (string, string, bool, error) {
+	var config models.AgentPullResponse
+	if err := json.Unmarshal([]byte(snapshot.Config), &config); err != nil {
+		return "", "", false, fmt.Errorf("invalid snapshot config: %w", err)
+	}
+	state, err := DecodeState(snapshot)
+	if err != nil {
+		return "", "", false, err
+	}
+
+	changed := false
+	reseal := func(p *models.AgentProxy) error {
+		c, err := resealProxy(k, p, sealUsernames)
+		changed = changed || c
+		return err
+	}
+	for i := range config.Proxies {
+		if err := reseal(&config.Proxies[i]); err != nil {
+			return "", "", false, err
+		}
+	}
+	for i := range config.Mappings {
+		if err := reseal(&config.Mappings[i].UpstreamProxy); err != nil {
+			return "", "", false, err
+		}
+	}
+	for i := range state.Proxies {
+		if err := reseal(&state.Proxies[i]); err != nil {
+			return "", "", false, err
+		}
+	}
+	if !changed {
+		return "", "", false, nil
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", "", false, err
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return "", "", false, err
+	}
+	return string(configJSON), string(stateJSON), true, nil
+}
+
+// resealProxy re-seals a proxy's stored credentials in place and reports
+// whether they changed
+func resealProxy(k *secrets.Keyring, p *models.AgentProxy, sealUsernames bool) (bool, error) {
+	username, usernameChanged, err := resealValue(k, p.Username, sealUsernames)
+	if err != nil {
+		return false, err
+	}
+	password, passwordChanged, err := resealValue(k, p.Password, true)
+	if err != nil {
+		return false, err
+	}
+	p.Username, p.Password = username, password
+	return usernameChanged || passwordChanged, nil
+}
+
+func resealValue(k *secrets.Keyring, value string, seal bool) (string, bool, error) {
+	if !seal {
+		if !secrets.IsSealed(value) {
+			return value, false, nil
+		}
+		plaintext, err := k.Open(value)
+		return plaintext, err == nil, err
+	}
+
+	if !k.NeedsRotation(value) {
+		return value, false, nil
+	}
+	sealed, err := k.Reseal(value)
+	return sealed, err == nil, err
+}
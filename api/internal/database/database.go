@@ -2,56 +2,100 @@ package database
 
 import (
 	"fmt"
-	"log"
-	"time"
+	"log/slog"
 
 	"github.com/Chinsusu/proxy-manager/api/internal/config"
+	"github.com/Chinsusu/proxy-manager/api/internal/database/migrations"
+	"github.com/Chinsusu/proxy-manager/api/internal/logging"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/tracing"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
-	"gorm.io/gorm/logger"
 )
 
 type DB struct {
 	*gorm.DB
 }
 
-// Connect establishes database connection and runs migrations
-func Connect(cfg *config.Config) (*DB, error) {
+// Open connects to the Postgres or SQLite database of cfg.DatabaseURL
+// without touching the schema
+func Open(cfg *config.Config) (*DB, error) {
 	gormConfig := &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
+		Logger: logging.NewGormLogger(cfg.LogSQL, cfg.SlowQueryThreshold),
 	}
 	
-	db, err := gorm.Open(postgres.Open(cfg.DatabaseURL), gormConfig)
+	backend, err := openBackend(cfg.DatabaseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := gorm.Open(backend.dialector, gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 
+	// Queries made while handling a traced request get their own spans
+	if err := db.Use(tracing.GormPlugin()); err != nil {
+		return nil, fmt.Errorf("failed to set up query tracing: %w", err)
+	}
+
 	// Configure connection pool
 	sqlDB, err := db.DB()
 	if err != nil {
 		return nil, err
 	}
-	sqlDB.SetMaxOpenConns(25)
+	sqlDB.SetMaxOpenConns(backend.maxOpenConns)
 	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetConnMaxLifetime(30 * time.Minute)
+	sqlDB.SetConnMaxLifetime(backend.connMaxLifetime)
+
+	return &DB{db}, nil
+}
+
+// Connect establishes database connection and runs migrations. It refuses
+// to start on a schema newer than the binary, and on pending migrations if
+// they are not applied on start.
+func Connect(cfg *config.Config) (*DB, error) {
+	dbWrapper, err := Open(cfg)
+	if err != nil {
+		return nil, err
+	}
 
 	// Run migrations
-	if err := models.AutoMigrate(db); err != nil {
+	if err := dbWrapper.migrate(cfg.MigrateOnStart); err != nil {
 		return nil, fmt.Errorf("migration failed: %w", err)
 	}
-
-	dbWrapper := &DB{db}
 	
 	// Seed admin user
 	if err := dbWrapper.SeedAdminUser(cfg.AdminEmail, cfg.AdminPassword); err != nil {
-		log.Printf("Warning: failed to seed admin user: %v", err)
+		slog.Warn("Failed to seed admin user", "error", err)
 	}
 
 	return dbWrapper, nil
 }
 
+func (db *DB) migrate(apply bool) error {
+	migrator, err := migrations.New(db.DB)
+	if err != nil {
+		return err
+	}
+	pending, err := migrator.Check()
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		return nil
+	}
+	if !apply {
+		return fmt.Errorf("%d pending migrations; run `migrate up` or set MIGRATE_ON_START=true", pending)
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return err
+	}
+	slog.Info("Applied migrations", "count", applied, "schema_version", migrator.Latest())
+	return nil
+}
+
 // SeedAdminUser creates admin user if not exists
 func (db *DB) SeedAdminUser(email, password string) error {
 	var existingUser models.User
@@ -79,15 +123,22 @@ func (db *DB) SeedAdminUser(email, password string) error {
 		return fmt.Errorf("failed to create admin user: %w", err)
 	}
 
-	log.Printf("Admin user created with email: %s", email)
+	slog.Info("Admin user created", "email", email)
 	return nil
 }
 
-// IncrementConfigVersion increments config version for a server
+// IncrementConfigVersion increments config version for a server and
+// records a snapshot of the config at the new version
 func (db *DB) IncrementConfigVersion(serverID uint) error {
-	return db.Model(&models.Server{}).
-		Where("id = ?", serverID).
-		UpdateColumn("config_version", gorm.Expr("config_version + 1")).Error
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.Server{}).
+			Where("id = ?", serverID).
+			UpdateColumn("config_version", gorm.Expr("config_version + 1")).Error
+		if err != nil {
+			return err
+		}
+		return (&DB{tx}).RecordConfigSnapshot(serverID)
+	})
 }
 
 // GetCurrentConfigVersion returns current config version for a server
@@ -0,0 +1,360 @@
+// Package migrations applies the versioned SQL migrations embedded in the
+// binary. Each migration is a pair of files <version>_<name>.up.sql and
+// <version>_<name>.down.sql in the directory of its SQL dialect, and runs in
+// its own transaction. Applied versions are recorded in schema_migrations.
+package migrations
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+//go:embed postgres sqlite
+var embedded embed.FS
+
+// ErrSchemaAhead is returned when the database has migrations applied that
+// this binary does not know about
+var ErrSchemaAhead = errors.New("database schema is newer than this binary")
+
+// lockKey is the Postgres advisory lock held while migrating, so replicas
+// starting together do not apply the same migration twice
+const lockKey = 7406373301
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one schema change
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Status is a migration and whether it is applied
+type Status struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Applied   bool       `json:"applied"`
+	AppliedAt *time.Time `json:"applied_at"`
+}
+
+// appliedMigration is a row of schema_migrations
+type appliedMigration struct {
+	Version   int `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (appliedMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Migrator applies migrations to one database
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+// New returns a migrator with the migrations for the database's dialect
+func New(db *gorm.DB) (*Migrator, error) {
+	dialect := db.Dialector.Name()
+	dir, err := fs.Sub(embedded, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return newMigrator(db, dir)
+}
+
+func newMigrator(db *gorm.DB, dir fs.FS) (*Migrator, error) {
+	migrations, err := load(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(migrations) == 0 {
+		return nil, fmt.Errorf("no migrations for database dialect %q", db.Dialector.Name())
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func load(dir fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(dir, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		body, err := fs.ReadFile(dir, path.Join(".", entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %04d_%s has no up step", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Latest returns the newest migration version this binary knows
+func (m *Migrator) Latest() int {
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Current returns the newest applied migration version, 0 if none
+func (m *Migrator) Current() (int, error) {
+	if err := m.ensureTable(); err != nil {
+		return 0, err
+	}
+	return current(m.db)
+}
+
+func current(db *gorm.DB) (int, error) {
+	var version *int
+	if err := db.Model(&appliedMigration{}).Select("MAX(version)").Scan(&version).Error; err != nil {
+		return 0, err
+	}
+	if version == nil {
+		return 0, nil
+	}
+	return *version, nil
+}
+
+// Check returns ErrSchemaAhead if the database is at a version this binary
+// does not have, and the number of migrations still to apply otherwise
+func (m *Migrator) Check() (int, error) {
+	version, err := m.Current()
+	if err != nil {
+		return 0, err
+	}
+	if version > m.Latest() {
+		return 0, fmt.Errorf("%w: database is at version %d, binary supports up to %d", ErrSchemaAhead, version, m.Latest())
+	}
+
+	pending := 0
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order and returns how many it
+// applied
+func (m *Migrator) Up() (int, error) {
+	if _, err := m.Check(); err != nil {
+		return 0, err
+	}
+
+	applied := 0
+	for _, migration := range m.migrations {
+		done, err := m.step(func(tx *gorm.DB, version int) (bool, error) {
+			if migration.Version <= version {
+				return false, nil
+			}
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return false, err
+			}
+			return true, tx.Create(&appliedMigration{
+				Version:   migration.Version,
+				Name:      migration.Name,
+				AppliedAt: time.Now(),
+			}).Error
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %04d_%s: %w", migration.Version, migration.Name, err)
+		}
+		if done {
+			applied++
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the newest steps applied migrations and returns how many it
+// reverted
+func (m *Migrator) Down(steps int) (int, error) {
+	if err := m.ensureTable(); err != nil {
+		return 0, err
+	}
+
+	reverted := 0
+	for reverted < steps {
+		var name string
+		done, err := m.step(func(tx *gorm.DB, version int) (bool, error) {
+			if version == 0 {
+				return false, nil
+			}
+			migration, ok := m.find(version)
+			if !ok {
+				return false, fmt.Errorf("%w: cannot revert unknown migration %d", ErrSchemaAhead, version)
+			}
+			name = fmt.Sprintf("%04d_%s", migration.Version, migration.Name)
+			if migration.Down == "" {
+				return false, fmt.Errorf("migration %s cannot be reverted", name)
+			}
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return false, err
+			}
+			return true, tx.Where("version = ?", version).Delete(&appliedMigration{}).Error
+		})
+		if err != nil {
+			if name != "" {
+				err = fmt.Errorf("migration %s: %w", name, err)
+			}
+			return reverted, err
+		}
+		if !done {
+			break
+		}
+		reverted++
+	}
+	return reverted, nil
+}
+
+// Status lists every known migration, and any applied one this binary does
+// not know, oldest first
+func (m *Migrator) Status() ([]Status, error) {
+	if err := m.ensureTable(); err != nil {
+		return nil, err
+	}
+
+	var rows []appliedMigration
+	if err := m.db.Order("version").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	applied := make(map[int]appliedMigration, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if row, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &row.AppliedAt
+			delete(applied, migration.Version)
+		}
+		statuses = append(statuses, status)
+	}
+	for _, row := range rows {
+		if _, unknown := applied[row.Version]; unknown {
+			statuses = append(statuses, Status{Version: row.Version, Name: row.Name, Applied: true, AppliedAt: &row.AppliedAt})
+		}
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+	return statuses, nil
+}
+
+// step runs fn in a transaction with the current version, holding the
+// migration lock on Postgres so concurrent migrators see each other's work
+func (m *Migrator) step(fn func(tx *gorm.DB, version int) (bool, error)) (bool, error) {
+	if m.db.Dialector.Name() == "sqlite" {
+		return m.stepSQLite(fn)
+	}
+
+	var done bool
+	err := m.db.Transaction(func(tx *gorm.DB) error {
+		if tx.Dialector.Name() == "postgres" {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockKey).Error; err != nil {
+				return err
+			}
+		}
+		version, err := current(tx)
+		if err != nil {
+			return err
+		}
+		done, err = fn(tx, version)
+		return err
+	})
+	return done, err
+}
+
+// stepSQLite runs a step with foreign keys off, as SQLite needs for the
+// table rebuilds that stand in for ALTER TABLE: dropping the old table
+// would otherwise delete or orphan the rows referencing it. The pragma is
+// per connection and has no effect inside a transaction, so the step holds
+// one connection throughout, and every key is checked before committing.
+func (m *Migrator) stepSQLite(fn func(tx *gorm.DB, version int) (bool, error)) (bool, error) {
+	var done bool
+	err := m.db.Connection(func(conn *gorm.DB) error {
+		if err := conn.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer conn.Exec("PRAGMA foreign_keys = ON")
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			version, err := current(tx)
+			if err != nil {
+				return err
+			}
+			if done, err = fn(tx, version); err != nil {
+				return err
+			}
+
+			var violations []struct {
+				Table  string
+				Parent string
+			}
+			if err := tx.Raw("PRAGMA foreign_key_check").Scan(&violations).Error; err != nil {
+				return err
+			}
+			if len(violations) > 0 {
+				return fmt.Errorf("%d rows violate foreign keys, first in %s referencing %s", len(violations), violations[0].Table, violations[0].Parent)
+			}
+			return nil
+		})
+	})
+	return done, err
+}
+
+func (m *Migrator) find(version int) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+func (m *Migrator) ensureTable() error {
+	return m.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TIMESTAMP NOT NULL
+	)`).Error
+}
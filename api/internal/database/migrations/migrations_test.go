@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestMigrator returns a migrator on a fresh SQLite database in memory
+func newTestMigrator(t *testing.T) *Migrator {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:?_pragma=foreign_keys(1)"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Every connection to :memory: opens a new empty database
+	sqlDB.SetMaxOpenConns(1)
+	t.Cleanup(func() { sqlDB.Close() })
+
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+// tables returns the names of the tables in the database, sorted
+func tables(t *testing.T, m *Migrator) []string {
+	t.Helper()
+
+	var names []string
+	if err := m.db.Raw("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name").Scan(&names).Error; err != nil {
+		t.Fatal(err)
+	}
+	return names
+}
+
+func TestDownUpRoundTrip(t *testing.T) {
+	m := newTestMigrator(t)
+
+	applied, err := m.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied != len(m.migrations) {
+		t.Fatalf("applied %d migrations, want %d", applied, len(m.migrations))
+	}
+	if applied, err := m.Up(); err != nil || applied != 0 {
+		t.Fatalf("second Up applied %d migrations, %v; want none", applied, err)
+	}
+	schema := tables(t, m)
+
+	// Every migration reverts and applies again, leaving the same tables
+	reverted, err := m.Down(len(m.migrations))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reverted != len(m.migrations) {
+		t.Fatalf("reverted %d migrations, want %d", reverted, len(m.migrations))
+	}
+	if version, err := m.Current(); err != nil || version != 0 {
+		t.Fatalf("version after reverting all = %d, %v; want 0", version, err)
+	}
+	if reverted, err := m.Down(1); err != nil || reverted != 0 {
+		t.Fatalf("Down on an empty schema reverted %d migrations, %v; want none", reverted, err)
+	}
+
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if got := tables(t, m); !reflect.DeepEqual(got, schema) {
+		t.Fatalf("tables after the round trip = %v, want %v", got, schema)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, status := range statuses {
+		if !status.Applied {
+			t.Fatalf("migration %04d_%s is not applied after the round trip", status.Version, status.Name)
+		}
+	}
+}
+
+func TestSchemaAhead(t *testing.T) {
+	m := newTestMigrator(t)
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A newer binary applied a migration this one does not know
+	ahead := appliedMigration{Version: m.Latest() + 1, Name: "from_the_future", AppliedAt: time.Now()}
+	if err := m.db.Create(&ahead).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Check(); !errors.Is(err, ErrSchemaAhead) {
+		t.Fatalf("Check: got %v, want ErrSchemaAhead", err)
+	}
+	if _, err := m.Up(); !errors.Is(err, ErrSchemaAhead) {
+		t.Fatalf("Up: got %v, want ErrSchemaAhead", err)
+	}
+	if _, err := m.Down(1); !errors.Is(err, ErrSchemaAhead) {
+		t.Fatalf("Down: got %v, want ErrSchemaAhead", err)
+	}
+	statuses, err := m.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last := statuses[len(statuses)-1]; last.Version != ahead.Version || !last.Applied {
+		t.Fatalf("status lists %+v last, want the unknown migration %d", last, ahead.Version)
+	}
+}
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS change_set_targets;
+DROP TABLE IF EXISTS change_sets;
+DROP TABLE IF EXISTS config_snapshots;
+DROP TABLE IF EXISTS audit_logs;
+DROP TABLE IF EXISTS mapping_schedules;
+DROP TABLE IF EXISTS mappings;
+DROP TABLE IF EXISTS proxies;
+DROP TABLE IF EXISTS servers;
+DROP TABLE IF EXISTS proxy_groups;
+DROP TABLE IF EXISTS users;
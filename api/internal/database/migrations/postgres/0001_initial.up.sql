@@ -0,0 +1,170 @@
+-- Baseline schema. Every statement is idempotent so databases created by
+-- the old AutoMigrate on boot are adopted as they are.
+
+CREATE TABLE IF NOT EXISTS users (
+    id            BIGSERIAL PRIMARY KEY,
+    email         TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    role          TEXT DEFAULT 'admin',
+    created_at    TIMESTAMPTZ,
+    updated_at    TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS proxy_groups (
+    id          BIGSERIAL PRIMARY KEY,
+    name        TEXT NOT NULL,
+    description TEXT,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_proxy_groups_name ON proxy_groups (name);
+
+CREATE TABLE IF NOT EXISTS servers (
+    id                 BIGSERIAL PRIMARY KEY,
+    name               TEXT NOT NULL,
+    tags               TEXT,
+    wan_iface          TEXT,
+    lan_iface          TEXT,
+    last_seen_at       TIMESTAMPTZ,
+    status             TEXT DEFAULT 'offline',
+    agent_token        TEXT NOT NULL,
+    config_version     BIGINT DEFAULT 0,
+    applied_version    BIGINT DEFAULT 0,
+    applied_status     TEXT,
+    applied_at         TIMESTAMPTZ,
+    service_state      TEXT DEFAULT 'active',
+    maintenance_reason TEXT,
+    maintenance_since  TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_servers_agent_token ON servers (agent_token);
+
+-- Columns added after 1.2.0, missing from databases that never ran a newer
+-- AutoMigrate
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS applied_version BIGINT DEFAULT 0;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS applied_status TEXT;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS applied_at TIMESTAMPTZ;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS service_state TEXT DEFAULT 'active';
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS maintenance_reason TEXT;
+ALTER TABLE servers ADD COLUMN IF NOT EXISTS maintenance_since TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS proxies (
+    id         BIGSERIAL PRIMARY KEY,
+    server_id  BIGINT,
+    group_id   BIGINT,
+    label      TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    host       TEXT NOT NULL,
+    port       BIGINT NOT NULL,
+    username   TEXT,
+    password   TEXT,
+    health     TEXT DEFAULT 'unknown',
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id)
+);
+
+CREATE TABLE IF NOT EXISTS mappings (
+    id                BIGSERIAL PRIMARY KEY,
+    server_id         BIGINT NOT NULL,
+    client_cidr       TEXT NOT NULL,
+    dst_ports         TEXT,
+    upstream_proxy_id BIGINT NOT NULL,
+    enabled           BOOLEAN DEFAULT true,
+    notes             TEXT,
+    created_at        TIMESTAMPTZ,
+    updated_at        TIMESTAMPTZ,
+    CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id)
+);
+
+-- AutoMigrate named the ClientCIDR column client_c_id_r
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM information_schema.columns
+               WHERE table_name = 'mappings' AND column_name = 'client_c_id_r') THEN
+        ALTER TABLE mappings RENAME COLUMN client_c_id_r TO client_cidr;
+    END IF;
+END
+$$;
+
+CREATE TABLE IF NOT EXISTS mapping_schedules (
+    id                 BIGSERIAL PRIMARY KEY,
+    mapping_id         BIGINT NOT NULL,
+    name               TEXT,
+    kind               TEXT NOT NULL,
+    cron_expr          TEXT,
+    duration_minutes   BIGINT,
+    weekdays           TEXT,
+    start_time         TEXT,
+    end_time           TEXT,
+    timezone           TEXT NOT NULL,
+    action             TEXT NOT NULL,
+    upstream_proxy_id  BIGINT,
+    enabled            BOOLEAN,
+    active             BOOLEAN,
+    last_transition_at TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ,
+    updated_at         TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_mapping_schedules_mapping_id ON mapping_schedules (mapping_id);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id         BIGSERIAL PRIMARY KEY,
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    resource   TEXT NOT NULL,
+    before     TEXT,
+    after      TEXT,
+    created_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS config_snapshots (
+    id         BIGSERIAL PRIMARY KEY,
+    server_id  BIGINT NOT NULL,
+    version    BIGINT NOT NULL,
+    config     TEXT NOT NULL,
+    state      TEXT NOT NULL,
+    proxies    BIGINT,
+    mappings   BIGINT,
+    created_at TIMESTAMPTZ
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_config_snapshots_server_version ON config_snapshots (server_id, version);
+
+CREATE TABLE IF NOT EXISTS change_sets (
+    id                  BIGSERIAL PRIMARY KEY,
+    name                TEXT NOT NULL,
+    description         TEXT,
+    status              TEXT NOT NULL,
+    current_wave        BIGINT,
+    ack_timeout_seconds BIGINT,
+    health_wait_seconds BIGINT,
+    error               TEXT,
+    started_at          TIMESTAMPTZ,
+    finished_at         TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ,
+    updated_at          TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_change_sets_status ON change_sets (status);
+
+CREATE TABLE IF NOT EXISTS change_set_targets (
+    id              BIGSERIAL PRIMARY KEY,
+    change_set_id   BIGINT NOT NULL,
+    server_id       BIGINT NOT NULL,
+    wave            BIGINT,
+    document        TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    base_version    BIGINT,
+    applied_version BIGINT,
+    failing_before  BIGINT,
+    error           TEXT,
+    applied_at      TIMESTAMPTZ,
+    acked_at        TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    CONSTRAINT fk_change_sets_targets FOREIGN KEY (change_set_id) REFERENCES change_sets (id)
+);
+CREATE INDEX IF NOT EXISTS idx_change_set_targets_change_set_id ON change_set_targets (change_set_id);
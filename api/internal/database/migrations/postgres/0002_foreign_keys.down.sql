@@ -0,0 +1,22 @@
+ALTER TABLE mapping_schedules
+    DROP CONSTRAINT IF EXISTS fk_mappings_schedules,
+    DROP CONSTRAINT IF EXISTS fk_proxies_schedules;
+
+DROP INDEX IF EXISTS idx_mappings_upstream_proxy_id;
+DROP INDEX IF EXISTS idx_mappings_server_id;
+ALTER TABLE mappings
+    DROP CONSTRAINT IF EXISTS fk_servers_mappings,
+    DROP CONSTRAINT IF EXISTS fk_proxies_mappings,
+    ADD CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id),
+    ADD CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id);
+
+ALTER TABLE proxies
+    DROP CONSTRAINT IF EXISTS fk_servers_proxies,
+    DROP CONSTRAINT IF EXISTS fk_proxy_groups_proxies,
+    ADD CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id),
+    ADD CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id);
+
+-- Mappings without an upstream cannot be represented before this migration
+DELETE FROM mapping_schedules WHERE mapping_id IN (SELECT id FROM mappings WHERE upstream_proxy_id IS NULL);
+DELETE FROM mappings WHERE upstream_proxy_id IS NULL;
+ALTER TABLE mappings ALTER COLUMN upstream_proxy_id SET NOT NULL;
@@ -0,0 +1,39 @@
+-- Foreign keys with defined delete behavior:
+--   server deleted        -> its mappings and their schedules go with it,
+--                            its proxies become unassigned
+--   proxy or group in use -> the delete is refused; the API resolves the
+--                            dependents first (force=disable or force=remove)
+-- A mapping whose upstream proxy was deleted with force=disable is kept,
+-- disabled and without an upstream.
+
+ALTER TABLE mappings ALTER COLUMN upstream_proxy_id DROP NOT NULL;
+
+-- Rows left dangling before the keys were enforced
+DELETE FROM mappings WHERE server_id NOT IN (SELECT id FROM servers);
+DELETE FROM mapping_schedules WHERE mapping_id NOT IN (SELECT id FROM mappings);
+UPDATE mappings SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id NOT IN (SELECT id FROM proxies);
+UPDATE mapping_schedules SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id NOT IN (SELECT id FROM proxies);
+UPDATE proxies SET server_id = NULL WHERE server_id NOT IN (SELECT id FROM servers);
+UPDATE proxies SET group_id = NULL WHERE group_id NOT IN (SELECT id FROM proxy_groups);
+
+ALTER TABLE proxies
+    DROP CONSTRAINT IF EXISTS fk_servers_proxies,
+    DROP CONSTRAINT IF EXISTS fk_proxy_groups_proxies,
+    ADD CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE SET NULL,
+    ADD CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id) ON DELETE RESTRICT;
+
+ALTER TABLE mappings
+    DROP CONSTRAINT IF EXISTS fk_servers_mappings,
+    DROP CONSTRAINT IF EXISTS fk_proxies_mappings,
+    ADD CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id) ON DELETE RESTRICT;
+CREATE INDEX IF NOT EXISTS idx_mappings_server_id ON mappings (server_id);
+CREATE INDEX IF NOT EXISTS idx_mappings_upstream_proxy_id ON mappings (upstream_proxy_id);
+
+ALTER TABLE mapping_schedules
+    DROP CONSTRAINT IF EXISTS fk_mappings_schedules,
+    DROP CONSTRAINT IF EXISTS fk_proxies_schedules,
+    ADD CONSTRAINT fk_mappings_schedules FOREIGN KEY (mapping_id) REFERENCES mappings (id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_proxies_schedules FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id) ON DELETE RESTRICT;
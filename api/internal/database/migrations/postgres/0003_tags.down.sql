@@ -0,0 +1,2 @@
+ALTER TABLE mappings DROP COLUMN tags;
+ALTER TABLE proxies DROP COLUMN tags;
@@ -0,0 +1,26 @@
+-- Rows in the trash would come back to life without deleted_at, so they are
+-- deleted for good first
+DELETE FROM mapping_schedules WHERE deleted_at IS NOT NULL
+    OR mapping_id IN (SELECT id FROM mappings WHERE deleted_at IS NOT NULL);
+UPDATE mapping_schedules SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id IN (SELECT id FROM proxies WHERE deleted_at IS NOT NULL);
+DELETE FROM mappings WHERE deleted_at IS NOT NULL
+    OR server_id IN (SELECT id FROM servers WHERE deleted_at IS NOT NULL);
+UPDATE mappings SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id IN (SELECT id FROM proxies WHERE deleted_at IS NOT NULL);
+UPDATE proxies SET group_id = NULL
+    WHERE group_id IN (SELECT id FROM proxy_groups WHERE deleted_at IS NOT NULL);
+DELETE FROM proxies WHERE deleted_at IS NOT NULL;
+DELETE FROM proxy_groups WHERE deleted_at IS NOT NULL;
+DELETE FROM servers WHERE deleted_at IS NOT NULL;
+
+DROP TABLE trash_entries;
+
+DROP INDEX idx_proxy_groups_name;
+CREATE UNIQUE INDEX idx_proxy_groups_name ON proxy_groups (name);
+
+ALTER TABLE proxy_groups DROP COLUMN deleted_at;
+ALTER TABLE mapping_schedules DROP COLUMN deleted_at;
+ALTER TABLE mappings DROP COLUMN deleted_at;
+ALTER TABLE proxies DROP COLUMN deleted_at;
+ALTER TABLE servers DROP COLUMN deleted_at;
@@ -0,0 +1,15 @@
+-- Domain events, written in the transaction of the change they describe and
+-- handed to the event bus subscribers after commit.
+
+CREATE TABLE outbox_events (
+    id              BIGSERIAL PRIMARY KEY,
+    name            TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    handled         TEXT NOT NULL DEFAULT '[]',
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_error      TEXT,
+    processed_at    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ
+);
+CREATE INDEX idx_outbox_events_due ON outbox_events (processed_at, next_attempt_at);
@@ -0,0 +1,93 @@
+-- Alert rules with the alerts they raise, silences, notification channels
+-- and the notifications queued for them. Proxy check results are kept for
+-- latency rules.
+
+CREATE TABLE alert_channels (
+    id         BIGSERIAL PRIMARY KEY,
+    name       TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    target     TEXT,
+    url        TEXT,
+    token      TEXT,
+    webhook_id BIGINT,
+    enabled    BOOLEAN NOT NULL DEFAULT true,
+    created_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ,
+    CONSTRAINT fk_webhooks_alert_channels FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE SET NULL
+);
+
+CREATE TABLE alert_rules (
+    id               BIGSERIAL PRIMARY KEY,
+    name             TEXT NOT NULL,
+    kind             TEXT NOT NULL,
+    group_id         BIGINT,
+    server_id        BIGINT,
+    threshold        DOUBLE PRECISION NOT NULL DEFAULT 0,
+    for_seconds      BIGINT NOT NULL DEFAULT 0,
+    interval_seconds BIGINT NOT NULL,
+    window_seconds   BIGINT NOT NULL DEFAULT 0,
+    channels         TEXT NOT NULL DEFAULT '[]',
+    enabled          BOOLEAN NOT NULL DEFAULT true,
+    evaluated_at     TIMESTAMPTZ,
+    created_at       TIMESTAMPTZ,
+    updated_at       TIMESTAMPTZ
+);
+
+CREATE TABLE alerts (
+    id          BIGSERIAL PRIMARY KEY,
+    rule_id     BIGINT NOT NULL,
+    subject     TEXT NOT NULL,
+    summary     TEXT,
+    state       TEXT NOT NULL,
+    value       DOUBLE PRECISION NOT NULL DEFAULT 0,
+    active_at   TIMESTAMPTZ NOT NULL,
+    fired_at    TIMESTAMPTZ,
+    resolved_at TIMESTAMPTZ,
+    created_at  TIMESTAMPTZ,
+    updated_at  TIMESTAMPTZ,
+    CONSTRAINT fk_alert_rules_alerts FOREIGN KEY (rule_id) REFERENCES alert_rules (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alerts_rule_state ON alerts (rule_id, state);
+
+CREATE TABLE alert_silences (
+    id         BIGSERIAL PRIMARY KEY,
+    rule_id    BIGINT,
+    subject    TEXT,
+    comment    TEXT,
+    created_by TEXT,
+    starts_at  TIMESTAMPTZ NOT NULL,
+    ends_at    TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ,
+    CONSTRAINT fk_alert_rules_silences FOREIGN KEY (rule_id) REFERENCES alert_rules (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alert_silences_ends_at ON alert_silences (ends_at);
+
+CREATE TABLE alert_notifications (
+    id              BIGSERIAL PRIMARY KEY,
+    alert_id        BIGINT NOT NULL,
+    channel_id      BIGINT NOT NULL,
+    event           TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_attempt_at TIMESTAMPTZ,
+    error           TEXT,
+    created_at      TIMESTAMPTZ,
+    updated_at      TIMESTAMPTZ,
+    CONSTRAINT fk_alerts_notifications FOREIGN KEY (alert_id) REFERENCES alerts (id) ON DELETE CASCADE,
+    CONSTRAINT fk_alert_channels_notifications FOREIGN KEY (channel_id) REFERENCES alert_channels (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alert_notifications_alert_id ON alert_notifications (alert_id);
+CREATE INDEX idx_alert_notifications_due ON alert_notifications (status, next_attempt_at);
+
+CREATE TABLE proxy_checks (
+    id         BIGSERIAL PRIMARY KEY,
+    proxy_id   BIGINT NOT NULL,
+    checked_at TIMESTAMPTZ NOT NULL,
+    ok         BOOLEAN NOT NULL,
+    latency_ms BIGINT,
+    error      TEXT,
+    CONSTRAINT fk_proxies_checks FOREIGN KEY (proxy_id) REFERENCES proxies (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_proxy_checks_proxy_checked_at ON proxy_checks (proxy_id, checked_at);
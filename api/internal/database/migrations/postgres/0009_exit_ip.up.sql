@@ -0,0 +1,8 @@
+-- Exit IP of proxies as an echo endpoint sees it through them, with its
+-- location from the GeoIP databases
+ALTER TABLE proxies ADD COLUMN exit_ip TEXT;
+ALTER TABLE proxies ADD COLUMN country TEXT;
+ALTER TABLE proxies ADD COLUMN city TEXT;
+ALTER TABLE proxies ADD COLUMN asn BIGINT;
+ALTER TABLE proxies ADD COLUMN as_org TEXT;
+CREATE INDEX idx_proxies_exit_ip ON proxies (exit_ip);
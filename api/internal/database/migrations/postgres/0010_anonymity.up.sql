@@ -0,0 +1,4 @@
+-- Anonymity class of proxies by what a judge sees of requests through them:
+-- transparent, anonymous or elite
+ALTER TABLE proxies ADD COLUMN anonymity TEXT;
+CREATE INDEX idx_proxies_anonymity ON proxies (anonymity);
@@ -0,0 +1,7 @@
+DROP TABLE quarantine_transitions;
+DROP TABLE quarantine_policies;
+DROP INDEX idx_proxies_quarantined_at;
+ALTER TABLE proxies DROP COLUMN quarantine_policy_id;
+ALTER TABLE proxies DROP COLUMN quarantined_at;
+ALTER TABLE proxies DROP COLUMN consecutive_successes;
+ALTER TABLE proxies DROP COLUMN consecutive_failures;
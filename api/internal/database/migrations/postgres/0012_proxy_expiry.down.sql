@@ -0,0 +1,9 @@
+DROP INDEX idx_proxies_expires_at;
+DROP INDEX idx_proxies_provider_id;
+ALTER TABLE proxies DROP COLUMN disabled_at;
+ALTER TABLE proxies DROP COLUMN expiry_warned_at;
+ALTER TABLE proxies DROP COLUMN order_ref;
+ALTER TABLE proxies DROP COLUMN cost;
+ALTER TABLE proxies DROP COLUMN expires_at;
+ALTER TABLE proxies DROP COLUMN provider_id;
+DROP TABLE providers;
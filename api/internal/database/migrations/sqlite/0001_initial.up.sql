@@ -0,0 +1,150 @@
+-- Baseline schema, same as postgres/0001_initial.up.sql
+
+CREATE TABLE IF NOT EXISTS users (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    email         TEXT NOT NULL,
+    password_hash TEXT NOT NULL,
+    role          TEXT DEFAULT 'admin',
+    created_at    DATETIME,
+    updated_at    DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_users_email ON users (email);
+
+CREATE TABLE IF NOT EXISTS proxy_groups (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    name        TEXT NOT NULL,
+    description TEXT,
+    created_at  DATETIME,
+    updated_at  DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_proxy_groups_name ON proxy_groups (name);
+
+CREATE TABLE IF NOT EXISTS servers (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    name               TEXT NOT NULL,
+    tags               TEXT,
+    wan_iface          TEXT,
+    lan_iface          TEXT,
+    last_seen_at       DATETIME,
+    status             TEXT DEFAULT 'offline',
+    agent_token        TEXT NOT NULL,
+    config_version     INTEGER DEFAULT 0,
+    applied_version    INTEGER DEFAULT 0,
+    applied_status     TEXT,
+    applied_at         DATETIME,
+    service_state      TEXT DEFAULT 'active',
+    maintenance_reason TEXT,
+    maintenance_since  DATETIME,
+    created_at         DATETIME,
+    updated_at         DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_servers_agent_token ON servers (agent_token);
+
+CREATE TABLE IF NOT EXISTS proxies (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id  INTEGER,
+    group_id   INTEGER,
+    label      TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    host       TEXT NOT NULL,
+    port       INTEGER NOT NULL,
+    username   TEXT,
+    password   TEXT,
+    health     TEXT DEFAULT 'unknown',
+    created_at DATETIME,
+    updated_at DATETIME,
+    CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id)
+);
+
+CREATE TABLE IF NOT EXISTS mappings (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id         INTEGER NOT NULL,
+    client_cidr       TEXT NOT NULL,
+    dst_ports         TEXT,
+    upstream_proxy_id INTEGER NOT NULL,
+    enabled           NUMERIC DEFAULT true,
+    notes             TEXT,
+    created_at        DATETIME,
+    updated_at        DATETIME,
+    CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id)
+);
+
+CREATE TABLE IF NOT EXISTS mapping_schedules (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    mapping_id         INTEGER NOT NULL,
+    name               TEXT,
+    kind               TEXT NOT NULL,
+    cron_expr          TEXT,
+    duration_minutes   INTEGER,
+    weekdays           TEXT,
+    start_time         TEXT,
+    end_time           TEXT,
+    timezone           TEXT NOT NULL,
+    action             TEXT NOT NULL,
+    upstream_proxy_id  INTEGER,
+    enabled            NUMERIC,
+    active             NUMERIC,
+    last_transition_at DATETIME,
+    created_at         DATETIME,
+    updated_at         DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_mapping_schedules_mapping_id ON mapping_schedules (mapping_id);
+
+CREATE TABLE IF NOT EXISTS audit_logs (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    actor      TEXT NOT NULL,
+    action     TEXT NOT NULL,
+    resource   TEXT NOT NULL,
+    before     TEXT,
+    after      TEXT,
+    created_at DATETIME
+);
+
+CREATE TABLE IF NOT EXISTS config_snapshots (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id  INTEGER NOT NULL,
+    version    INTEGER NOT NULL,
+    config     TEXT NOT NULL,
+    state      TEXT NOT NULL,
+    proxies    INTEGER,
+    mappings   INTEGER,
+    created_at DATETIME
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_config_snapshots_server_version ON config_snapshots (server_id, version);
+
+CREATE TABLE IF NOT EXISTS change_sets (
+    id                  INTEGER PRIMARY KEY AUTOINCREMENT,
+    name                TEXT NOT NULL,
+    description         TEXT,
+    status              TEXT NOT NULL,
+    current_wave        INTEGER,
+    ack_timeout_seconds INTEGER,
+    health_wait_seconds INTEGER,
+    error               TEXT,
+    started_at          DATETIME,
+    finished_at         DATETIME,
+    created_at          DATETIME,
+    updated_at          DATETIME
+);
+CREATE INDEX IF NOT EXISTS idx_change_sets_status ON change_sets (status);
+
+CREATE TABLE IF NOT EXISTS change_set_targets (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    change_set_id   INTEGER NOT NULL,
+    server_id       INTEGER NOT NULL,
+    wave            INTEGER,
+    document        TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    base_version    INTEGER,
+    applied_version INTEGER,
+    failing_before  INTEGER,
+    error           TEXT,
+    applied_at      DATETIME,
+    acked_at        DATETIME,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    CONSTRAINT fk_change_sets_targets FOREIGN KEY (change_set_id) REFERENCES change_sets (id)
+);
+CREATE INDEX IF NOT EXISTS idx_change_set_targets_change_set_id ON change_set_targets (change_set_id);
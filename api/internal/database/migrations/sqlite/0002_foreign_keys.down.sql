@@ -0,0 +1,66 @@
+-- Rebuilds the tables with the keys of 0001_initial. Mappings without an
+-- upstream cannot be represented there and are deleted.
+
+DELETE FROM mapping_schedules WHERE mapping_id IN (SELECT id FROM mappings WHERE upstream_proxy_id IS NULL);
+DELETE FROM mappings WHERE upstream_proxy_id IS NULL;
+
+CREATE TABLE proxies_new (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id  INTEGER,
+    group_id   INTEGER,
+    label      TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    host       TEXT NOT NULL,
+    port       INTEGER NOT NULL,
+    username   TEXT,
+    password   TEXT,
+    health     TEXT DEFAULT 'unknown',
+    created_at DATETIME,
+    updated_at DATETIME,
+    CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id)
+);
+INSERT INTO proxies_new SELECT id, server_id, group_id, label, type, host, port, username, password, health, created_at, updated_at FROM proxies;
+DROP TABLE proxies;
+ALTER TABLE proxies_new RENAME TO proxies;
+
+CREATE TABLE mappings_new (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id         INTEGER NOT NULL,
+    client_cidr       TEXT NOT NULL,
+    dst_ports         TEXT,
+    upstream_proxy_id INTEGER NOT NULL,
+    enabled           NUMERIC DEFAULT true,
+    notes             TEXT,
+    created_at        DATETIME,
+    updated_at        DATETIME,
+    CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id),
+    CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id)
+);
+INSERT INTO mappings_new SELECT id, server_id, client_cidr, dst_ports, upstream_proxy_id, enabled, notes, created_at, updated_at FROM mappings;
+DROP TABLE mappings;
+ALTER TABLE mappings_new RENAME TO mappings;
+
+CREATE TABLE mapping_schedules_new (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    mapping_id         INTEGER NOT NULL,
+    name               TEXT,
+    kind               TEXT NOT NULL,
+    cron_expr          TEXT,
+    duration_minutes   INTEGER,
+    weekdays           TEXT,
+    start_time         TEXT,
+    end_time           TEXT,
+    timezone           TEXT NOT NULL,
+    action             TEXT NOT NULL,
+    upstream_proxy_id  INTEGER,
+    enabled            NUMERIC,
+    active             NUMERIC,
+    last_transition_at DATETIME,
+    created_at         DATETIME,
+    updated_at         DATETIME
+);
+INSERT INTO mapping_schedules_new SELECT id, mapping_id, name, kind, cron_expr, duration_minutes, weekdays, start_time, end_time, timezone, action, upstream_proxy_id, enabled, active, last_transition_at, created_at, updated_at FROM mapping_schedules;
+DROP TABLE mapping_schedules;
+ALTER TABLE mapping_schedules_new RENAME TO mapping_schedules;
+CREATE INDEX idx_mapping_schedules_mapping_id ON mapping_schedules (mapping_id);
@@ -0,0 +1,85 @@
+-- Foreign keys with defined delete behavior:
+--   server deleted        -> its mappings and their schedules go with it,
+--                            its proxies become unassigned
+--   proxy or group in use -> the delete is refused; the API resolves the
+--                            dependents first (force=disable or force=remove)
+-- A mapping whose upstream proxy was deleted with force=disable is kept,
+-- disabled and without an upstream.
+--
+-- SQLite cannot alter constraints, so the tables are rebuilt. The migrator
+-- turns key enforcement off meanwhile and checks every key before commit.
+
+-- Rows left dangling before the keys were enforced
+DELETE FROM mappings WHERE server_id NOT IN (SELECT id FROM servers);
+DELETE FROM mapping_schedules WHERE mapping_id NOT IN (SELECT id FROM mappings);
+UPDATE mappings SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id NOT IN (SELECT id FROM proxies);
+UPDATE mapping_schedules SET upstream_proxy_id = NULL, enabled = false
+    WHERE upstream_proxy_id NOT IN (SELECT id FROM proxies);
+UPDATE proxies SET server_id = NULL WHERE server_id NOT IN (SELECT id FROM servers);
+UPDATE proxies SET group_id = NULL WHERE group_id NOT IN (SELECT id FROM proxy_groups);
+
+CREATE TABLE proxies_new (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id  INTEGER,
+    group_id   INTEGER,
+    label      TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    host       TEXT NOT NULL,
+    port       INTEGER NOT NULL,
+    username   TEXT,
+    password   TEXT,
+    health     TEXT DEFAULT 'unknown',
+    created_at DATETIME,
+    updated_at DATETIME,
+    CONSTRAINT fk_servers_proxies FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE SET NULL,
+    CONSTRAINT fk_proxy_groups_proxies FOREIGN KEY (group_id) REFERENCES proxy_groups (id) ON DELETE RESTRICT
+);
+INSERT INTO proxies_new SELECT id, server_id, group_id, label, type, host, port, username, password, health, created_at, updated_at FROM proxies;
+DROP TABLE proxies;
+ALTER TABLE proxies_new RENAME TO proxies;
+
+CREATE TABLE mappings_new (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    server_id         INTEGER NOT NULL,
+    client_cidr       TEXT NOT NULL,
+    dst_ports         TEXT,
+    upstream_proxy_id INTEGER,
+    enabled           NUMERIC DEFAULT true,
+    notes             TEXT,
+    created_at        DATETIME,
+    updated_at        DATETIME,
+    CONSTRAINT fk_servers_mappings FOREIGN KEY (server_id) REFERENCES servers (id) ON DELETE CASCADE,
+    CONSTRAINT fk_proxies_mappings FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id) ON DELETE RESTRICT
+);
+INSERT INTO mappings_new SELECT id, server_id, client_cidr, dst_ports, upstream_proxy_id, enabled, notes, created_at, updated_at FROM mappings;
+DROP TABLE mappings;
+ALTER TABLE mappings_new RENAME TO mappings;
+CREATE INDEX idx_mappings_server_id ON mappings (server_id);
+CREATE INDEX idx_mappings_upstream_proxy_id ON mappings (upstream_proxy_id);
+
+CREATE TABLE mapping_schedules_new (
+    id                 INTEGER PRIMARY KEY AUTOINCREMENT,
+    mapping_id         INTEGER NOT NULL,
+    name               TEXT,
+    kind               TEXT NOT NULL,
+    cron_expr          TEXT,
+    duration_minutes   INTEGER,
+    weekdays           TEXT,
+    start_time         TEXT,
+    end_time           TEXT,
+    timezone           TEXT NOT NULL,
+    action             TEXT NOT NULL,
+    upstream_proxy_id  INTEGER,
+    enabled            NUMERIC,
+    active             NUMERIC,
+    last_transition_at DATETIME,
+    created_at         DATETIME,
+    updated_at         DATETIME,
+    CONSTRAINT fk_mappings_schedules FOREIGN KEY (mapping_id) REFERENCES mappings (id) ON DELETE CASCADE,
+    CONSTRAINT fk_proxies_schedules FOREIGN KEY (upstream_proxy_id) REFERENCES proxies (id) ON DELETE RESTRICT
+);
+INSERT INTO mapping_schedules_new SELECT id, mapping_id, name, kind, cron_expr, duration_minutes, weekdays, start_time, end_time, timezone, action, upstream_proxy_id, enabled, active, last_transition_at, created_at, updated_at FROM mapping_schedules;
+DROP TABLE mapping_schedules;
+ALTER TABLE mapping_schedules_new RENAME TO mapping_schedules;
+CREATE INDEX idx_mapping_schedules_mapping_id ON mapping_schedules (mapping_id);
@@ -0,0 +1,6 @@
+-- Free-form tags on proxies and mappings, a JSON array like servers.tags.
+-- Tags only select items for bulk operations and are not sent to agents.
+ALTER TABLE proxies ADD COLUMN tags TEXT DEFAULT '[]';
+ALTER TABLE mappings ADD COLUMN tags TEXT DEFAULT '[]';
+UPDATE proxies SET tags = '[]' WHERE tags IS NULL;
+UPDATE mappings SET tags = '[]' WHERE tags IS NULL;
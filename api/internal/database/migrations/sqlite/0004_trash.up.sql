@@ -0,0 +1,32 @@
+-- Soft delete: deleted servers, proxies, mappings and groups stay in their
+-- tables with deleted_at set until they are restored or purged. Schedules
+-- are soft-deleted along with their mapping or upstream proxy.
+
+ALTER TABLE servers ADD COLUMN deleted_at DATETIME;
+ALTER TABLE proxies ADD COLUMN deleted_at DATETIME;
+ALTER TABLE mappings ADD COLUMN deleted_at DATETIME;
+ALTER TABLE mapping_schedules ADD COLUMN deleted_at DATETIME;
+ALTER TABLE proxy_groups ADD COLUMN deleted_at DATETIME;
+
+CREATE INDEX idx_servers_deleted_at ON servers (deleted_at);
+CREATE INDEX idx_proxies_deleted_at ON proxies (deleted_at);
+CREATE INDEX idx_mappings_deleted_at ON mappings (deleted_at);
+CREATE INDEX idx_mapping_schedules_deleted_at ON mapping_schedules (deleted_at);
+CREATE INDEX idx_proxy_groups_deleted_at ON proxy_groups (deleted_at);
+
+-- A group in the trash does not hold on to its name
+DROP INDEX IF EXISTS idx_proxy_groups_name;
+CREATE UNIQUE INDEX idx_proxy_groups_name ON proxy_groups (name) WHERE deleted_at IS NULL;
+
+CREATE TABLE trash_entries (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    resource_type TEXT NOT NULL,
+    resource_id   INTEGER NOT NULL,
+    label         TEXT,
+    server_id     INTEGER,
+    deleted_by    TEXT,
+    cascaded      TEXT,
+    deleted_at    DATETIME NOT NULL
+);
+CREATE INDEX idx_trash_entries_resource ON trash_entries (resource_type, resource_id);
+CREATE INDEX idx_trash_entries_deleted_at ON trash_entries (deleted_at);
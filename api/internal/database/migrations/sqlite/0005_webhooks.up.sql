@@ -0,0 +1,34 @@
+-- Webhook subscriptions and their delivery outbox. Deliveries are queued in
+-- the transaction of the change they report and sent until they succeed or
+-- run out of attempts.
+
+CREATE TABLE webhooks (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT NOT NULL,
+    url        TEXT NOT NULL,
+    secret     TEXT,
+    events     TEXT NOT NULL,
+    enabled    NUMERIC NOT NULL DEFAULT true,
+    created_at DATETIME,
+    updated_at DATETIME
+);
+
+CREATE TABLE webhook_deliveries (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    webhook_id      INTEGER NOT NULL,
+    event_id        TEXT NOT NULL,
+    event           TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_attempt_at DATETIME,
+    response_status INTEGER,
+    response_body   TEXT,
+    error           TEXT,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    CONSTRAINT fk_webhooks_deliveries FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_webhook_deliveries_webhook_id ON webhook_deliveries (webhook_id);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (status, next_attempt_at);
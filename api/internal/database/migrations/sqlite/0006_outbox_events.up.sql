@@ -0,0 +1,15 @@
+-- Domain events, written in the transaction of the change they describe and
+-- handed to the event bus subscribers after commit.
+
+CREATE TABLE outbox_events (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    name            TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    handled         TEXT NOT NULL DEFAULT '[]',
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_error      TEXT,
+    processed_at    DATETIME,
+    created_at      DATETIME
+);
+CREATE INDEX idx_outbox_events_due ON outbox_events (processed_at, next_attempt_at);
@@ -0,0 +1,6 @@
+DROP TABLE proxy_checks;
+DROP TABLE alert_notifications;
+DROP TABLE alert_silences;
+DROP TABLE alerts;
+DROP TABLE alert_rules;
+DROP TABLE alert_channels;
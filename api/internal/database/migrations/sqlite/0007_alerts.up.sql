@@ -0,0 +1,93 @@
+-- Alert rules with the alerts they raise, silences, notification channels
+-- and the notifications queued for them. Proxy check results are kept for
+-- latency rules.
+
+CREATE TABLE alert_channels (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT NOT NULL,
+    type       TEXT NOT NULL,
+    target     TEXT,
+    url        TEXT,
+    token      TEXT,
+    webhook_id INTEGER,
+    enabled    NUMERIC NOT NULL DEFAULT true,
+    created_at DATETIME,
+    updated_at DATETIME,
+    CONSTRAINT fk_webhooks_alert_channels FOREIGN KEY (webhook_id) REFERENCES webhooks (id) ON DELETE SET NULL
+);
+
+CREATE TABLE alert_rules (
+    id               INTEGER PRIMARY KEY AUTOINCREMENT,
+    name             TEXT NOT NULL,
+    kind             TEXT NOT NULL,
+    group_id         INTEGER,
+    server_id        INTEGER,
+    threshold        REAL NOT NULL DEFAULT 0,
+    for_seconds      INTEGER NOT NULL DEFAULT 0,
+    interval_seconds INTEGER NOT NULL,
+    window_seconds   INTEGER NOT NULL DEFAULT 0,
+    channels         TEXT NOT NULL DEFAULT '[]',
+    enabled          NUMERIC NOT NULL DEFAULT true,
+    evaluated_at     DATETIME,
+    created_at       DATETIME,
+    updated_at       DATETIME
+);
+
+CREATE TABLE alerts (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id     INTEGER NOT NULL,
+    subject     TEXT NOT NULL,
+    summary     TEXT,
+    state       TEXT NOT NULL,
+    value       REAL NOT NULL DEFAULT 0,
+    active_at   DATETIME NOT NULL,
+    fired_at    DATETIME,
+    resolved_at DATETIME,
+    created_at  DATETIME,
+    updated_at  DATETIME,
+    CONSTRAINT fk_alert_rules_alerts FOREIGN KEY (rule_id) REFERENCES alert_rules (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alerts_rule_state ON alerts (rule_id, state);
+
+CREATE TABLE alert_silences (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    rule_id    INTEGER,
+    subject    TEXT,
+    comment    TEXT,
+    created_by TEXT,
+    starts_at  DATETIME NOT NULL,
+    ends_at    DATETIME NOT NULL,
+    created_at DATETIME,
+    CONSTRAINT fk_alert_rules_silences FOREIGN KEY (rule_id) REFERENCES alert_rules (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alert_silences_ends_at ON alert_silences (ends_at);
+
+CREATE TABLE alert_notifications (
+    id              INTEGER PRIMARY KEY AUTOINCREMENT,
+    alert_id        INTEGER NOT NULL,
+    channel_id      INTEGER NOT NULL,
+    event           TEXT NOT NULL,
+    payload         TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    attempts        INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at DATETIME NOT NULL,
+    last_attempt_at DATETIME,
+    error           TEXT,
+    created_at      DATETIME,
+    updated_at      DATETIME,
+    CONSTRAINT fk_alerts_notifications FOREIGN KEY (alert_id) REFERENCES alerts (id) ON DELETE CASCADE,
+    CONSTRAINT fk_alert_channels_notifications FOREIGN KEY (channel_id) REFERENCES alert_channels (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_alert_notifications_alert_id ON alert_notifications (alert_id);
+CREATE INDEX idx_alert_notifications_due ON alert_notifications (status, next_attempt_at);
+
+CREATE TABLE proxy_checks (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    proxy_id   INTEGER NOT NULL,
+    checked_at DATETIME NOT NULL,
+    ok         NUMERIC NOT NULL,
+    latency_ms INTEGER,
+    error      TEXT,
+    CONSTRAINT fk_proxies_checks FOREIGN KEY (proxy_id) REFERENCES proxies (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_proxy_checks_proxy_checked_at ON proxy_checks (proxy_id, checked_at);
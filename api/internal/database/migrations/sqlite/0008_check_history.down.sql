@@ -0,0 +1,4 @@
+DROP TABLE proxy_check_rollups;
+DROP INDEX idx_proxy_checks_checked_at;
+ALTER TABLE proxy_checks DROP COLUMN exit_ip;
+ALTER TABLE proxy_checks DROP COLUMN error_class;
@@ -0,0 +1,20 @@
+-- Health check history. Checks record what kind of error failed them and
+-- the exit IP once it is known. Checks are downsampled into hourly rollups
+-- once they are older than the raw retention.
+ALTER TABLE proxy_checks ADD COLUMN error_class TEXT;
+ALTER TABLE proxy_checks ADD COLUMN exit_ip TEXT;
+CREATE INDEX idx_proxy_checks_checked_at ON proxy_checks (checked_at);
+
+CREATE TABLE proxy_check_rollups (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    proxy_id     INTEGER NOT NULL,
+    bucket_start DATETIME NOT NULL,
+    checks       INTEGER NOT NULL,
+    failures     INTEGER NOT NULL,
+    latency_p50  INTEGER,
+    latency_p95  INTEGER,
+    latency_max  INTEGER,
+    CONSTRAINT fk_proxies_check_rollups FOREIGN KEY (proxy_id) REFERENCES proxies (id) ON DELETE CASCADE
+);
+CREATE UNIQUE INDEX idx_proxy_check_rollups_bucket ON proxy_check_rollups (proxy_id, bucket_start);
+CREATE INDEX idx_proxy_check_rollups_bucket_start ON proxy_check_rollups (bucket_start);
@@ -0,0 +1,6 @@
+DROP INDEX idx_proxies_exit_ip;
+ALTER TABLE proxies DROP COLUMN as_org;
+ALTER TABLE proxies DROP COLUMN asn;
+ALTER TABLE proxies DROP COLUMN city;
+ALTER TABLE proxies DROP COLUMN country;
+ALTER TABLE proxies DROP COLUMN exit_ip;
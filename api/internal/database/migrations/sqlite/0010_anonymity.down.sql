@@ -0,0 +1,2 @@
+DROP INDEX idx_proxies_anonymity;
+ALTER TABLE proxies DROP COLUMN anonymity;
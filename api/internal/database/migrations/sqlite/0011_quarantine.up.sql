@@ -0,0 +1,39 @@
+-- Automatic quarantine of failing proxies. Proxies count their failed and
+-- ok checks in a row; a policy quarantines the proxies of a group after
+-- enough failures, which leaves them out of agent configs until enough
+-- successes restore them. Every transition is recorded.
+ALTER TABLE proxies ADD COLUMN consecutive_failures INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxies ADD COLUMN consecutive_successes INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE proxies ADD COLUMN quarantined_at DATETIME;
+ALTER TABLE proxies ADD COLUMN quarantine_policy_id INTEGER;
+CREATE INDEX idx_proxies_quarantined_at ON proxies (quarantined_at);
+
+CREATE TABLE quarantine_policies (
+    id                INTEGER PRIMARY KEY AUTOINCREMENT,
+    name              TEXT NOT NULL,
+    group_id          INTEGER,
+    failures          INTEGER NOT NULL,
+    successes         INTEGER NOT NULL,
+    fallback_proxy_id INTEGER,
+    fallback_group_id INTEGER,
+    enabled           NUMERIC NOT NULL DEFAULT true,
+    created_at        DATETIME,
+    updated_at        DATETIME
+);
+-- One policy per group, and one default policy with no group
+CREATE UNIQUE INDEX idx_quarantine_policies_group ON quarantine_policies ((COALESCE(group_id, 0)));
+
+CREATE TABLE quarantine_transitions (
+    id             INTEGER PRIMARY KEY AUTOINCREMENT,
+    proxy_id       INTEGER NOT NULL,
+    policy_id      INTEGER,
+    server_id      INTEGER,
+    action         TEXT NOT NULL,
+    reason         TEXT,
+    actor          TEXT,
+    config_version INTEGER,
+    created_at     DATETIME,
+    CONSTRAINT fk_proxies_quarantine_transitions FOREIGN KEY (proxy_id) REFERENCES proxies (id) ON DELETE CASCADE
+);
+CREATE INDEX idx_quarantine_transitions_proxy ON quarantine_transitions (proxy_id, created_at);
+CREATE INDEX idx_quarantine_transitions_created_at ON quarantine_transitions (created_at);
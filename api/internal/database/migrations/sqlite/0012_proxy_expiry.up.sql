@@ -0,0 +1,25 @@
+-- Proxy expiry and provider subscriptions. Proxies can belong to the
+-- provider they were bought from and have an expiry date, a monthly cost
+-- and an order reference. Proxies are warned about before they expire and
+-- can be disabled, which leaves them out of agent configs, once they have.
+-- Deleting a provider keeps its proxies without one. Costs are exact to the
+-- cent.
+CREATE TABLE providers (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    name       TEXT NOT NULL,
+    website    TEXT NOT NULL DEFAULT '',
+    notes      TEXT NOT NULL DEFAULT '',
+    created_at DATETIME,
+    updated_at DATETIME
+);
+CREATE UNIQUE INDEX idx_providers_name ON providers (name);
+
+ALTER TABLE proxies ADD COLUMN provider_id INTEGER
+    CONSTRAINT fk_providers_proxies REFERENCES providers (id) ON DELETE SET NULL;
+ALTER TABLE proxies ADD COLUMN expires_at DATETIME;
+ALTER TABLE proxies ADD COLUMN cost NUMERIC(12,2) NOT NULL DEFAULT 0;
+ALTER TABLE proxies ADD COLUMN order_ref TEXT NOT NULL DEFAULT '';
+ALTER TABLE proxies ADD COLUMN expiry_warned_at DATETIME;
+ALTER TABLE proxies ADD COLUMN disabled_at DATETIME;
+CREATE INDEX idx_proxies_provider_id ON proxies (provider_id);
+CREATE INDEX idx_proxies_expires_at ON proxies (expires_at);
@@ -0,0 +1,313 @@
+package database
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SnapshotState is the proxy and mapping rows of a server as stored with a
+// config snapshot, along with the names of the groups they belonged to.
+// Proxy credentials are kept sealed.
+type SnapshotState struct {
+	Proxies  []models.AgentProxy `json:"proxies"`
+	Mappings []models.Mapping    `json:"mappings"`
+	Groups   map[uint]string     `json:"groups"`
+}
+
+// ProxyRows returns the proxies of the state as rows
+func (s *SnapshotState) ProxyRows() []models.Proxy {
+	proxies := make([]models.Proxy, len(s.Proxies))
+	for i, proxy := range s.Proxies {
+		proxies[i] = proxy.Stored()
+	}
+	return proxies
+}
+
+// RenderAgentConfig builds the config an agent of the server receives at its
+// current version, with open schedule windows applied.
+func (db *DB) RenderAgentConfig(serverID uint) (*models.AgentPullResponse, error) {
+	config, _, err := db.renderServer(serverID)
+	return config, err
+}
+
+// AgentConfig returns the config stored for a version of a server, rendering
+// it if the version predates snapshots.
+func (db *DB) AgentConfig(serverID uint, version int) (*models.AgentPullResponse, error) {
+	snapshot, err := db.GetConfigSnapshot(serverID, version)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return db.RenderAgentConfig(serverID)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var config models.AgentPullResponse
+	if err := json.Unmarshal([]byte(snapshot.Config), &config); err != nil {
+		return nil, fmt.Errorf("invalid snapshot config: %w", err)
+	}
+	return &config, nil
+}
+
+// RecordConfigSnapshot stores the config of the server's current version.
+// Recording a version that already has a snapshot is a no-op.
+func (db *DB) RecordConfigSnapshot(serverID uint) error {
+	config, state, err := db.renderServer(serverID)
+	if err != nil {
+		return err
+	}
+
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return err
+	}
+	stateJSON, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	snapshot := models.ConfigSnapshot{
+		ServerID: serverID,
+		Version:  config.Version,
+		Config:   string(configJSON),
+		State:    string(stateJSON),
+		Proxies:  len(config.Proxies),
+		Mappings: len(config.Mappings),
+	}
+
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(&snapshot).Error
+}
+
+// GetConfigSnapshot returns the snapshot of one version of a server
+func (db *DB) GetConfigSnapshot(serverID uint, version int) (*models.ConfigSnapshot, error) {
+	var snapshot models.ConfigSnapshot
+	if err := db.Where("server_id = ? AND version = ?", serverID, version).First(&snapshot).Error; err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// DecodeState returns the proxy and mapping rows stored with a snapshot
+func DecodeState(snapshot *models.ConfigSnapshot) (*SnapshotState, error) {
+	var state SnapshotState
+	if err := json.Unmarshal([]byte(snapshot.State), &state); err != nil {
+		return nil, fmt.Errorf("invalid snapshot state: %w", err)
+	}
+	return &state, nil
+}
+
+// renderServer loads a server's rows and renders its agent config from them
+func (db *DB) renderServer(serverID uint) (*models.AgentPullResponse, *SnapshotState, error) {
+	var server models.Server
+	if err := db.Select("id", "config_version", "service_state").First(&server, serverID).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var proxies []models.Proxy
+	if err := db.Where("server_id = ?", serverID).Order("id").Find(&proxies).Error; err != nil {
+		return nil, nil, err
+	}
+
+	state := &SnapshotState{Groups: make(map[uint]string)}
+	for _, proxy := range proxies {
+		state.Proxies = append(state.Proxies, models.NewAgentProxy(proxy))
+	}
+	if err := db.Where("server_id = ?", serverID).Order("id").Find(&state.Mappings).Error; err != nil {
+		return nil, nil, err
+	}
+
+	var groups []models.ProxyGroup
+	if err := db.Select("id", "name").Find(&groups).Error; err != nil {
+		return nil, nil, err
+	}
+	for _, group := range groups {
+		state.Groups[group.ID] = group.Name
+	}
+
+	var schedules []models.MappingSchedule
+	if err := db.Joins("JOIN mappings ON mappings.id = mapping_schedules.mapping_id").
+		Where("mappings.server_id = ? AND mapping_schedules.active = ? AND mappings.deleted_at IS NULL", serverID, true).
+		Order("mapping_schedules.id").
+		Find(&schedules).Error; err != nil {
+		return nil, nil, err
+	}
+
+	mappings := append([]models.Mapping(nil), state.Mappings...)
+
+	proxyByID := make(map[uint]models.Proxy, len(proxies))
+	for _, proxy := range proxies {
+		proxyByID[proxy.ID] = proxy
+	}
+	for i := range mappings {
+		if id := mappings[i].UpstreamProxyID; id != nil {
+			mappings[i].UpstreamProxy = proxyByID[*id]
+		}
+	}
+	applySchedules(mappings, proxyByID, schedules)
+
+	policies, err := db.quarantinePolicies(proxies)
+	if err != nil {
+		return nil, nil, err
+	}
+	applyQuarantine(mappings, proxies, policies)
+	applyDisabled(mappings, proxyByID)
+
+	mode := models.AgentModeNormal
+	if server.ServiceState == models.ServiceMaintenance {
+		// Drain: mappings stay stored but no new traffic is routed
+		mode = models.AgentModeDrain
+		for i := range mappings {
+			mappings[i].Enabled = false
+		}
+	}
+
+	config := &models.AgentPullResponse{
+		Version:  server.ConfigVersion,
+		Mode:     mode,
+		Proxies:  make([]models.AgentProxy, 0, len(proxies)),
+		Mappings: make([]models.AgentMapping, 0, len(mappings)),
+	}
+	for _, proxy := range proxies {
+		if proxy.QuarantinedAt != nil || proxy.DisabledAt != nil {
+			continue
+		}
+		config.Proxies = append(config.Proxies, models.NewAgentProxy(proxy))
+	}
+	for _, mapping := range mappings {
+		// A mapping without an upstream, or whose upstream is disabled or
+		// quarantined without a fallback, has nothing to route to
+		if mapping.UpstreamProxyID == nil {
+			continue
+		}
+		config.Mappings = append(config.Mappings, models.AgentMapping{
+			Mapping:       mapping,
+			UpstreamProxy: models.NewAgentProxy(mapping.UpstreamProxy),
+		})
+	}
+	return config, state, nil
+}
+
+// applySchedules overlays active schedules onto mappings in place. Schedules
+// are applied in ID order, so the newest one wins when several overlap.
+// switch_upstream targets that are not on the server are ignored.
+func applySchedules(mappings []models.Mapping, proxies map[uint]models.Proxy, schedules []models.MappingSchedule) {
+	byMapping := make(map[uint][]models.MappingSchedule)
+	for _, schedule := range schedules {
+		byMapping[schedule.MappingID] = append(byMapping[schedule.MappingID], schedule)
+	}
+
+	for i := range mappings {
+		mapping := &mappings[i]
+		for _, schedule := range byMapping[mapping.ID] {
+			switch schedule.Action {
+			case models.ScheduleActionEnable:
+				mapping.Enabled = true
+			case models.ScheduleActionDisable:
+				mapping.Enabled = false
+			case models.ScheduleActionSwitchUpstream:
+				if schedule.UpstreamProxyID == nil {
+					continue
+				}
+				if proxy, ok := proxies[*schedule.UpstreamProxyID]; ok {
+					mapping.UpstreamProxyID = &proxy.ID
+					mapping.UpstreamProxy = proxy
+				}
+			}
+		}
+	}
+}
+
+// quarantinePolicies returns the policies that quarantined any of proxies,
+// by ID
+func (db *DB) quarantinePolicies(proxies []models.Proxy) (map[uint]models.QuarantinePolicy, error) {
+	var ids []uint
+	for _, proxy := range proxies {
+		if proxy.QuarantinedAt != nil && proxy.QuarantinePolicyID != nil {
+			ids = append(ids, *proxy.QuarantinePolicyID)
+		}
+	}
+	byID := make(map[uint]models.QuarantinePolicy, len(ids))
+	if len(ids) == 0 {
+		return byID, nil
+	}
+	var policies []models.QuarantinePolicy
+	if err := db.Where("id IN ?", ids).Find(&policies).Error; err != nil {
+		return nil, err
+	}
+	for _, policy := range policies {
+		byID[policy.ID] = policy
+	}
+	return byID, nil
+}
+
+// applyQuarantine routes mappings whose upstream is quarantined to the
+// fallback of the policy that quarantined it: the fallback proxy, else the
+// first proxy of the fallback group that is neither quarantined nor
+// disabled. Fallbacks must be proxies of the server. Mappings without one
+// are left without an upstream. Health is not considered, since a health
+// change does not bump the config version the choice is rendered into; a
+// failing fallback is passed over once it is quarantined itself, which
+// does.
+func applyQuarantine(mappings []models.Mapping, proxies []models.Proxy, policies map[uint]models.QuarantinePolicy) {
+	usable := func(proxy models.Proxy) bool {
+		return proxy.QuarantinedAt == nil && proxy.DisabledAt == nil
+	}
+	byID := make(map[uint]models.Proxy, len(proxies))
+	for _, proxy := range proxies {
+		byID[proxy.ID] = proxy
+	}
+
+	for i := range mappings {
+		mapping := &mappings[i]
+		if mapping.UpstreamProxyID == nil {
+			continue
+		}
+		upstream, ok := byID[*mapping.UpstreamProxyID]
+		if !ok || upstream.QuarantinedAt == nil {
+			continue
+		}
+
+		mapping.UpstreamProxyID = nil
+		mapping.UpstreamProxy = models.Proxy{}
+		if upstream.QuarantinePolicyID == nil {
+			continue
+		}
+		policy := policies[*upstream.QuarantinePolicyID]
+		if policy.FallbackProxyID != nil {
+			if fallback, ok := byID[*policy.FallbackProxyID]; ok && usable(fallback) {
+				mapping.UpstreamProxyID = &fallback.ID
+				mapping.UpstreamProxy = fallback
+				continue
+			}
+		}
+		if policy.FallbackGroupID != nil {
+			for _, fallback := range proxies {
+				if fallback.GroupID != nil && *fallback.GroupID == *policy.FallbackGroupID && usable(fallback) {
+					mapping.UpstreamProxyID = &fallback.ID
+					mapping.UpstreamProxy = fallback
+					break
+				}
+			}
+		}
+	}
+}
+
+// applyDisabled clears the upstream of mappings routed through a disabled
+// proxy, whether stored or switched to by a schedule, so they are left out
+// of the agent config
+func applyDisabled(mappings []models.Mapping, proxies map[uint]models.Proxy) {
+	for i := range mappings {
+		mapping := &mappings[i]
+		if mapping.UpstreamProxyID == nil {
+			continue
+		}
+		if proxy, ok := proxies[*mapping.UpstreamProxyID]; ok && proxy.DisabledAt != nil {
+			mapping.UpstreamProxyID = nil
+			mapping.UpstreamProxy = models.Proxy{}
+		}
+	}
+}
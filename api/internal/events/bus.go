@@ -0,0 +1,202 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/retry"
+)
+
+const (
+	// pollInterval is how often the outbox is checked for new events
+	pollInterval = time.Second
+	// batchSize bounds the events handled per poll
+	batchSize = 100
+	// firstRetry doubles after each failed attempt up to maxRetry. Events
+	// are retried until every subscriber handled them.
+	firstRetry = 10 * time.Second
+	maxRetry   = time.Hour
+	// lease is how long a claimed event is left alone
+	lease = 2 * time.Minute
+	// retention is how long processed events stay in the outbox
+	retention = 7 * 24 * time.Hour
+)
+
+var backoff = retry.Backoff{First: firstRetry, Max: maxRetry}
+
+// Publish writes events to the outbox. Pass the transaction of the change
+// they describe, so they are published if and only if it is committed.
+func Publish(ctx context.Context, tx *repository.Store, events ...Event) error {
+	now := time.Now()
+	for _, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		record := models.OutboxEvent{
+			Name:          event.Name(),
+			Payload:       string(payload),
+			Handled:       "[]",
+			NextAttemptAt: now,
+			CreatedAt:     now,
+		}
+		if err := tx.Outbox.Create(ctx, &record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Record is an event read back from the outbox
+type Record struct {
+	ID         uint
+	OccurredAt time.Time
+	Event      Event
+}
+
+// Handler handles events for one subscriber. It runs in a transaction that
+// also records the event as handled, so its database writes happen once;
+// anything else it does must tolerate a repeat after a crash. An error
+// leaves the event to be retried for this subscriber only.
+type Handler func(ctx context.Context, tx *repository.Store, record Record) error
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+// Bus hands outbox events to its subscribers
+type Bus struct {
+	store       *repository.Store
+	queue       retry.Queue[models.OutboxEvent]
+	subscribers []subscriber
+}
+
+func NewBus(store *repository.Store) *Bus {
+	return &Bus{
+		store: store,
+		queue: retry.Queue[models.OutboxEvent]{
+			Table: store.Outbox,
+			Batch: batchSize,
+			Lease: lease,
+			Row:   func(event *models.OutboxEvent) (uint, *int) { return event.ID, &event.Attempts },
+		},
+	}
+}
+
+// Subscribe registers a handler under a name. The name is stored with the
+// events it handled, so keep it stable. Subscribe before Run.
+func (b *Bus) Subscribe(name string, handler Handler) {
+	b.subscribers = append(b.subscribers, subscriber{name: name, handler: handler})
+}
+
+// Run hands out events every pollInterval and purges old ones every hour,
+// until ctx is cancelled
+func (b *Bus) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var lastPurge time.Time
+	for {
+		now := time.Now()
+		if _, err := b.Dispatch(ctx, now); err != nil {
+			slog.Error("Event dispatch failed", "component", "events", "error", err)
+		}
+		if now.Sub(lastPurge) >= time.Hour {
+			if purged, err := b.store.Outbox.Purge(ctx, now.Add(-retention)); err != nil {
+				slog.Error("Event purge failed", "component", "events", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged processed events", "component", "events", "events", purged)
+			}
+			lastPurge = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch hands every event due at now to the subscribers that have not
+// handled it yet, and returns the number of events it took. Events claimed
+// by another bus are skipped.
+func (b *Bus) Dispatch(ctx context.Context, now time.Time) (int, error) {
+	return b.queue.Work(ctx, now, func(ctx context.Context, event models.OutboxEvent) (repository.Fields, error) {
+		return b.handle(ctx, event), nil
+	})
+}
+
+// handle runs the subscribers that have not handled an event yet and
+// returns how to update it
+func (b *Bus) handle(ctx context.Context, event models.OutboxEvent) repository.Fields {
+	var handled []string
+	record, err := decode(event)
+	if err == nil {
+		err = json.Unmarshal([]byte(event.Handled), &handled)
+	}
+
+	var errs []error
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		done := make(map[string]bool, len(handled))
+		for _, name := range handled {
+			done[name] = true
+		}
+		for _, sub := range b.subscribers {
+			if done[sub.name] {
+				continue
+			}
+			if err := b.run(ctx, sub, record, append(handled, sub.name)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", sub.name, err))
+				continue
+			}
+			handled = append(handled, sub.name)
+		}
+	}
+
+	now := time.Now()
+	if len(errs) == 0 {
+		return repository.Fields{"processed_at": now, "last_error": ""}
+	}
+	err = errors.Join(errs...)
+	slog.Warn("Event handling failed", "component", "events", "event_id", event.ID, "event", event.Name, "attempts", event.Attempts, "error", err)
+	return repository.Fields{"next_attempt_at": now.Add(backoff.After(event.Attempts)), "last_error": err.Error()}
+}
+
+// run hands an event to one subscriber and records it as handled in the
+// same transaction
+func (b *Bus) run(ctx context.Context, sub subscriber, record Record, handled []string) error {
+	list, err := json.Marshal(handled)
+	if err != nil {
+		return err
+	}
+	return b.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := sub.handler(ctx, tx, record); err != nil {
+			return err
+		}
+		return tx.Outbox.Update(ctx, record.ID, repository.Fields{"handled": string(list)})
+	})
+}
+
+// decode restores the typed event of an outbox row. Events of an unknown
+// type, such as ones written by a newer version, are retried.
+func decode(event models.OutboxEvent) (Record, error) {
+	zero, ok := types[event.Name]
+	if !ok {
+		return Record{}, fmt.Errorf("unknown event type %q", event.Name)
+	}
+	value := reflect.New(reflect.TypeOf(zero))
+	if err := json.Unmarshal([]byte(event.Payload), value.Interface()); err != nil {
+		return Record{}, fmt.Errorf("failed to decode %s: %w", event.Name, err)
+	}
+	return Record{ID: event.ID, OccurredAt: event.CreatedAt, Event: value.Elem().Interface().(Event)}, nil
+}
@@ -0,0 +1,127 @@
+package events
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Changes collects the proxies and mappings a change touches, so the events
+// describing it can be published however the rows were written. Record each
+// row before changing it, or its ID after creating it, then call Publish in
+// the same transaction.
+type Changes struct {
+	actor    string
+	proxies  map[uint]*models.Proxy // state before the change, nil if created
+	mappings map[uint]*models.Mapping
+}
+
+func NewChanges(actor string) *Changes {
+	return &Changes{
+		actor:    actor,
+		proxies:  make(map[uint]*models.Proxy),
+		mappings: make(map[uint]*models.Mapping),
+	}
+}
+
+// Proxies records proxies as they are before the change. A proxy recorded
+// twice keeps its first state.
+func (c *Changes) Proxies(before ...models.Proxy) {
+	for i := range before {
+		if _, ok := c.proxies[before[i].ID]; !ok {
+			proxy := before[i]
+			c.proxies[proxy.ID] = &proxy
+		}
+	}
+}
+
+// Mappings records mappings as they are before the change. A mapping
+// recorded twice keeps its first state.
+func (c *Changes) Mappings(before ...models.Mapping) {
+	for i := range before {
+		if _, ok := c.mappings[before[i].ID]; !ok {
+			mapping := before[i]
+			c.mappings[mapping.ID] = &mapping
+		}
+	}
+}
+
+// ProxyCreated records a proxy the change created
+func (c *Changes) ProxyCreated(id uint) {
+	if _, ok := c.proxies[id]; !ok {
+		c.proxies[id] = nil
+	}
+}
+
+// MappingCreated records a mapping the change created
+func (c *Changes) MappingCreated(id uint) {
+	if _, ok := c.mappings[id]; !ok {
+		c.mappings[id] = nil
+	}
+}
+
+// Publish reads the recorded rows back in the transaction and publishes a
+// created, updated or deleted event for each, proxies first and in ID
+// order. A row created and deleted by the same change is not reported.
+func (c *Changes) Publish(ctx context.Context, tx *repository.Store) error {
+	var published []Event
+
+	if ids := sortedIDs(c.proxies); len(ids) > 0 {
+		after, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: ids})
+		if err != nil {
+			return err
+		}
+		current := make(map[uint]models.Proxy, len(after))
+		for _, proxy := range after {
+			current[proxy.ID] = proxy
+		}
+		for _, id := range ids {
+			before := c.proxies[id]
+			now, exists := current[id]
+			switch {
+			case before == nil && exists:
+				published = append(published, ProxyCreated{Proxy: NewProxyState(now), Actor: c.actor})
+			case before != nil && exists:
+				published = append(published, ProxyUpdated{Before: NewProxyState(*before), After: NewProxyState(now), Actor: c.actor})
+			case before != nil:
+				published = append(published, ProxyDeleted{Proxy: NewProxyState(*before), Actor: c.actor})
+			}
+		}
+	}
+
+	if ids := sortedIDs(c.mappings); len(ids) > 0 {
+		after, err := tx.Mappings.List(ctx, repository.MappingFilter{IDs: ids})
+		if err != nil {
+			return err
+		}
+		current := make(map[uint]models.Mapping, len(after))
+		for _, mapping := range after {
+			current[mapping.ID] = mapping
+		}
+		for _, id := range ids {
+			before := c.mappings[id]
+			now, exists := current[id]
+			switch {
+			case before == nil && exists:
+				published = append(published, MappingCreated{Mapping: NewMappingState(now), Actor: c.actor})
+			case before != nil && exists:
+				published = append(published, MappingUpdated{Before: NewMappingState(*before), After: NewMappingState(now), Actor: c.actor})
+			case before != nil:
+				published = append(published, MappingDeleted{Mapping: NewMappingState(*before), Actor: c.actor})
+			}
+		}
+	}
+
+	return Publish(ctx, tx, published...)
+}
+
+func sortedIDs[T any](rows map[uint]*T) []uint {
+	ids := make([]uint, 0, len(rows))
+	for id := range rows {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
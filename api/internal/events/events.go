@@ -0,0 +1,311 @@
+// Package events is the domain event bus. Handlers publish typed events in
+// the transaction of the change they describe, which writes them to the
+// outbox table. After commit a Bus hands each event to the subscribers
+// registered at startup, such as webhooks and the audit log.
+package events
+
+import (
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// Event is a domain event. Name identifies its type in the outbox.
+type Event interface {
+	Name() string
+}
+
+// Event names
+const (
+	NameServerCreated      = "server.created"
+	NameServerUpdated      = "server.updated"
+	NameServerDeleted      = "server.deleted"
+	NameServerOffline      = "server.offline"
+	NameServerOnline       = "server.online"
+	NameProxyCreated       = "proxy.created"
+	NameProxyUpdated       = "proxy.updated"
+	NameProxyDeleted       = "proxy.deleted"
+	NameProxyHealthChanged = "proxy.health_changed"
+	NameProxyQuarantined   = "proxy.quarantined"
+	NameProxyRestored      = "proxy.restored"
+	NameProxyExpiring      = "proxy.expiring"
+	NameProxyExpired       = "proxy.expired"
+	NameMappingCreated     = "mapping.created"
+	NameMappingUpdated     = "mapping.updated"
+	NameMappingDeleted     = "mapping.deleted"
+	NameAgentAcked         = "agent.acked"
+	NameBulkFinished       = "bulk.finished"
+	NameAlertFiring        = "alert.firing"
+	NameAlertResolved      = "alert.resolved"
+)
+
+// types are the zero values of every event type, by name, to decode the
+// outbox with
+var types = map[string]Event{}
+
+func init() {
+	for _, event := range []Event{
+		ServerCreated{}, ServerUpdated{}, ServerDeleted{}, ServerOffline{}, ServerOnline{},
+		ProxyCreated{}, ProxyUpdated{}, ProxyDeleted{}, ProxyHealthChanged{},
+		ProxyQuarantined{}, ProxyRestored{}, ProxyExpiring{}, ProxyExpired{},
+		MappingCreated{}, MappingUpdated{}, MappingDeleted{},
+		AgentAcked{}, BulkFinished{},
+		AlertFiring{}, AlertResolved{},
+	} {
+		types[event.Name()] = event
+	}
+}
+
+// ServerState is a server as an event reports it
+type ServerState struct {
+	ID           uint       `json:"id"`
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	ServiceState string     `json:"service_state"`
+}
+
+func NewServerState(server models.Server) ServerState {
+	return ServerState{
+		ID:           server.ID,
+		Name:         server.Name,
+		Status:       server.Status,
+		LastSeenAt:   server.LastSeenAt,
+		ServiceState: server.ServiceState,
+	}
+}
+
+// ProxyState is a proxy as an event reports it, without credentials
+type ProxyState struct {
+	ID       uint   `json:"id"`
+	ServerID *uint  `json:"server_id"`
+	GroupID  *uint  `json:"group_id"`
+	Label    string `json:"label"`
+	Type     string `json:"type"`
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Health   string `json:"health"`
+}
+
+func NewProxyState(proxy models.Proxy) ProxyState {
+	return ProxyState{
+		ID:       proxy.ID,
+		ServerID: proxy.ServerID,
+		GroupID:  proxy.GroupID,
+		Label:    proxy.Label,
+		Type:     proxy.Type,
+		Host:     proxy.Host,
+		Port:     proxy.Port,
+		Health:   proxy.Health,
+	}
+}
+
+// MappingState is a mapping as an event reports it
+type MappingState struct {
+	ID              uint   `json:"id"`
+	ServerID        uint   `json:"server_id"`
+	ClientCIDR      string `json:"client_cidr"`
+	DstPorts        string `json:"dst_ports"` // JSON array
+	UpstreamProxyID *uint  `json:"upstream_proxy_id"`
+	Enabled         bool   `json:"enabled"`
+}
+
+func NewMappingState(mapping models.Mapping) MappingState {
+	return MappingState{
+		ID:              mapping.ID,
+		ServerID:        mapping.ServerID,
+		ClientCIDR:      mapping.ClientCIDR,
+		DstPorts:        mapping.DstPorts,
+		UpstreamProxyID: mapping.UpstreamProxyID,
+		Enabled:         mapping.Enabled,
+	}
+}
+
+// AlertState is an alert with its rule as an event reports it
+type AlertState struct {
+	ID         uint       `json:"id"`
+	RuleID     uint       `json:"rule_id"`
+	Rule       string     `json:"rule"` // rule name
+	Kind       string     `json:"kind"`
+	Subject    string     `json:"subject"`
+	Summary    string     `json:"summary"`
+	Value      float64    `json:"value"`
+	Threshold  float64    `json:"threshold"`
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+}
+
+func NewAlertState(alert models.Alert, rule models.AlertRule) AlertState {
+	return AlertState{
+		ID:         alert.ID,
+		RuleID:     rule.ID,
+		Rule:       rule.Name,
+		Kind:       rule.Kind,
+		Subject:    alert.Subject,
+		Summary:    alert.Summary,
+		Value:      alert.Value,
+		Threshold:  rule.Threshold,
+		ActiveAt:   alert.ActiveAt,
+		FiredAt:    alert.FiredAt,
+		ResolvedAt: alert.ResolvedAt,
+	}
+}
+
+type ServerCreated struct {
+	Server ServerState `json:"server"`
+	Actor  string      `json:"actor"` // user email, empty for changes the API made on its own
+}
+
+type ServerUpdated struct {
+	Before ServerState `json:"before"`
+	After  ServerState `json:"after"`
+	Actor  string      `json:"actor"`
+}
+
+// ServerDeleted is sent when a server goes to the trash
+type ServerDeleted struct {
+	Server ServerState `json:"server"`
+	Actor  string      `json:"actor"`
+}
+
+// ServerOffline is sent when a server's agent stopped reporting in. Server
+// is the state before it was marked offline.
+type ServerOffline struct {
+	Server ServerState `json:"server"`
+}
+
+// ServerOnline is sent when the agent of an offline server reports in
+// again. Server is the state before it was marked online.
+type ServerOnline struct {
+	Server ServerState `json:"server"`
+}
+
+type ProxyCreated struct {
+	Proxy ProxyState `json:"proxy"`
+	Actor string     `json:"actor"`
+}
+
+type ProxyUpdated struct {
+	Before ProxyState `json:"before"`
+	After  ProxyState `json:"after"`
+	Actor  string     `json:"actor"`
+}
+
+// ProxyDeleted is sent when a proxy goes to the trash
+type ProxyDeleted struct {
+	Proxy ProxyState `json:"proxy"`
+	Actor string     `json:"actor"`
+}
+
+// ProxyHealthChanged is sent when a proxy's health changes, next to the
+// ProxyUpdated of an edit. Proxy is the state before the change.
+type ProxyHealthChanged struct {
+	Proxy  ProxyState `json:"proxy"`
+	Health string     `json:"health"`
+	Error  string     `json:"error,omitempty"` // why the check failed
+}
+
+// ProxyQuarantined is sent when a proxy is quarantined. Proxy is the state
+// before.
+type ProxyQuarantined struct {
+	Proxy         ProxyState `json:"proxy"`
+	PolicyID      *uint      `json:"policy_id"`
+	Reason        string     `json:"reason"`
+	ConfigVersion int        `json:"config_version"` // of the proxy's server after the change
+}
+
+// ProxyRestored is sent when a proxy leaves quarantine, restored by ok
+// checks or released by a user. Proxy is the state before.
+type ProxyRestored struct {
+	Proxy         ProxyState `json:"proxy"`
+	Action        string     `json:"action"` // restored or released
+	Reason        string     `json:"reason"`
+	Actor         string     `json:"actor"`
+	ConfigVersion int        `json:"config_version"`
+}
+
+// ProxyExpiring is sent once when a proxy comes within the warning period
+// of its expiry date
+type ProxyExpiring struct {
+	Proxy     ProxyState `json:"proxy"`
+	ExpiresAt time.Time  `json:"expires_at"`
+}
+
+// ProxyExpired is sent when an expired proxy is disabled. Proxy is the
+// state before.
+type ProxyExpired struct {
+	Proxy         ProxyState `json:"proxy"`
+	ExpiresAt     time.Time  `json:"expires_at"`
+	ConfigVersion int        `json:"config_version"` // of the proxy's server after the change
+}
+
+type MappingCreated struct {
+	Mapping MappingState `json:"mapping"`
+	Actor   string       `json:"actor"`
+}
+
+type MappingUpdated struct {
+	Before MappingState `json:"before"`
+	After  MappingState `json:"after"`
+	Actor  string       `json:"actor"`
+}
+
+// MappingDeleted is sent when a mapping goes to the trash
+type MappingDeleted struct {
+	Mapping MappingState `json:"mapping"`
+	Actor   string       `json:"actor"`
+}
+
+// AgentAcked is sent for every ack of an agent
+type AgentAcked struct {
+	Server        ServerState `json:"server"`
+	Version       int         `json:"version"` // version the agent applied or failed to
+	Status        string      `json:"status"`  // as reported by the agent
+	OK            bool        `json:"ok"`
+	ConfigVersion int         `json:"config_version"`
+}
+
+// BulkFinished is sent when a bulk operation is committed, after the
+// created, updated and deleted events of its items
+type BulkFinished struct {
+	Resource  string `json:"resource"` // proxies or mappings
+	Operation string `json:"operation"`
+	Matched   int    `json:"matched"`
+	Succeeded int    `json:"succeeded"`
+	Skipped   int    `json:"skipped"`
+	Actor     string `json:"actor"`
+}
+
+// AlertFiring is sent when an alert's condition held for its rule's
+// duration
+type AlertFiring struct {
+	Alert AlertState `json:"alert"`
+}
+
+// AlertResolved is sent when the condition of a firing alert no longer
+// holds
+type AlertResolved struct {
+	Alert AlertState `json:"alert"`
+}
+
+func (ServerCreated) Name() string      { return NameServerCreated }
+func (ServerUpdated) Name() string      { return NameServerUpdated }
+func (ServerDeleted) Name() string      { return NameServerDeleted }
+func (ServerOffline) Name() string      { return NameServerOffline }
+func (ServerOnline) Name() string       { return NameServerOnline }
+func (ProxyCreated) Name() string       { return NameProxyCreated }
+func (ProxyUpdated) Name() string       { return NameProxyUpdated }
+func (ProxyDeleted) Name() string       { return NameProxyDeleted }
+func (ProxyHealthChanged) Name() string { return NameProxyHealthChanged }
+func (ProxyQuarantined) Name() string   { return NameProxyQuarantined }
+func (ProxyRestored) Name() string      { return NameProxyRestored }
+func (ProxyExpiring) Name() string      { return NameProxyExpiring }
+func (ProxyExpired) Name() string       { return NameProxyExpired }
+func (MappingCreated) Name() string     { return NameMappingCreated }
+func (MappingUpdated) Name() string     { return NameMappingUpdated }
+func (MappingDeleted) Name() string     { return NameMappingDeleted }
+func (AgentAcked) Name() string         { return NameAgentAcked }
+func (BulkFinished) Name() string       { return NameBulkFinished }
+func (AlertFiring) Name() string        { return NameAlertFiring }
+func (AlertResolved) Name() string      { return NameAlertResolved }
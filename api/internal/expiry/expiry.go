@@ -0,0 +1,131 @@
+// Package expiry warns about proxies before their expiry date and disables
+// them once it passed. A disabled proxy is left out of its server's agent
+// config, and mappings routed through it with it, until its expiry date is
+// moved to the future.
+package expiry
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// checkInterval is how often expiry dates are checked
+const checkInterval = 10 * time.Minute
+
+// Monitor warns about proxies expiring within a warning period and
+// optionally disables expired ones
+type Monitor struct {
+	store      *repository.Store
+	warnBefore time.Duration
+	disable    bool
+}
+
+// New returns a monitor. A warnBefore of zero warns about nothing. If it
+// neither warns nor disables, Run returns at once.
+func New(store *repository.Store, warnBefore time.Duration, disable bool) *Monitor {
+	return &Monitor{store: store, warnBefore: warnBefore, disable: disable}
+}
+
+// Run checks expiry dates every checkInterval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	if m.warnBefore <= 0 && !m.disable {
+		return
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if warned, disabled, err := m.Check(ctx, time.Now()); err != nil {
+			slog.Error("Proxy expiry check failed", "component", "expiry", "error", err)
+		} else if warned > 0 || disabled > 0 {
+			slog.Info("Checked proxy expiry", "component", "expiry", "warned", warned, "disabled", disabled)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check warns about every proxy expiring before now plus the warning period
+// that was not warned about yet, publishing ProxyExpiring, and disables
+// every expired proxy if the monitor disables them, publishing ProxyExpired.
+// It returns the number of proxies warned about and disabled.
+func (m *Monitor) Check(ctx context.Context, now time.Time) (int, int, error) {
+	warned, disabled := 0, 0
+	if m.warnBefore > 0 {
+		notDisabled := false
+		until := now.Add(m.warnBefore)
+		proxies, err := m.store.Proxies.List(ctx, repository.ProxyFilter{ExpiresBefore: &until, Disabled: &notDisabled})
+		if err != nil {
+			return warned, disabled, err
+		}
+		for _, proxy := range proxies {
+			if proxy.ExpiryWarnedAt != nil {
+				continue
+			}
+			if err := m.warn(ctx, proxy, now); err != nil {
+				return warned, disabled, err
+			}
+			warned++
+		}
+	}
+
+	if m.disable {
+		notDisabled := false
+		proxies, err := m.store.Proxies.List(ctx, repository.ProxyFilter{ExpiresBefore: &now, Disabled: &notDisabled})
+		if err != nil {
+			return warned, disabled, err
+		}
+		for _, proxy := range proxies {
+			if err := m.disableProxy(ctx, proxy, now); err != nil {
+				return warned, disabled, err
+			}
+			disabled++
+		}
+	}
+	return warned, disabled, nil
+}
+
+// warn marks a proxy as warned about and publishes ProxyExpiring
+func (m *Monitor) warn(ctx context.Context, proxy models.Proxy, now time.Time) error {
+	return m.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := tx.Proxies.Update(ctx, proxy.ID, repository.Fields{"expiry_warned_at": &now}); err != nil {
+			return err
+		}
+		return events.Publish(ctx, tx, events.ProxyExpiring{Proxy: events.NewProxyState(proxy), ExpiresAt: *proxy.ExpiresAt})
+	})
+}
+
+// disableProxy disables a proxy, bumps the config version of its server and
+// publishes ProxyExpired
+func (m *Monitor) disableProxy(ctx context.Context, proxy models.Proxy, now time.Time) error {
+	return m.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := tx.Proxies.Update(ctx, proxy.ID, repository.Fields{"disabled_at": &now}); err != nil {
+			return err
+		}
+		version := 0
+		if proxy.ServerID != nil {
+			if err := tx.Servers.BumpConfigVersion(ctx, *proxy.ServerID); err != nil {
+				return err
+			}
+			server, err := tx.Servers.Get(ctx, *proxy.ServerID, false)
+			if err != nil {
+				return err
+			}
+			version = server.ConfigVersion
+		}
+		return events.Publish(ctx, tx, events.ProxyExpired{
+			Proxy:         events.NewProxyState(proxy),
+			ExpiresAt:     *proxy.ExpiresAt,
+			ConfigVersion: version,
+		})
+	})
+}
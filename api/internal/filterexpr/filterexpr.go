@@ -0,0 +1,152 @@
+// Package filterexpr parses the filter expressions that select items for
+// bulk operations, such as `type=socks5 health!=ok tag=residential`.
+//
+// An expression is a list of terms separated by spaces, all of which must
+// match. A term is a field, an operator and a value:
+//
+//	field=value    the field equals the value
+//	field!=value   the field does not equal the value
+//	field~value    the field contains the value, ignoring case
+//
+// A value may be quoted with double quotes to include spaces or be empty.
+// An unquoted value may list alternatives separated by commas, which match
+// if any of them does (`type=http,https`). Fields with several values, such
+// as tags, match `=` and `~` if any value does and `!=` if none does.
+package filterexpr
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operators
+const (
+	Equal    = "="
+	NotEqual = "!="
+	Contains = "~"
+)
+
+// Expr is a parsed filter expression
+type Expr struct {
+	terms []term
+}
+
+type term struct {
+	field  string
+	op     string
+	values []string
+}
+
+// Parse parses an expression over the given fields. An empty expression is
+// an error, so a filter never selects everything by accident.
+func Parse(s string, fields []string) (*Expr, error) {
+	known := make(map[string]bool, len(fields))
+	for _, field := range fields {
+		known[field] = true
+	}
+
+	var expr Expr
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		var t term
+		var err error
+		if t, rest, err = parseTerm(rest); err != nil {
+			return nil, err
+		}
+		if !known[t.field] {
+			return nil, fmt.Errorf("unknown field %q, expected one of %s", t.field, strings.Join(fields, ", "))
+		}
+		expr.terms = append(expr.terms, t)
+		rest = strings.TrimSpace(rest)
+	}
+	if len(expr.terms) == 0 {
+		return nil, fmt.Errorf("filter is empty")
+	}
+	return &expr, nil
+}
+
+func parseTerm(s string) (term, string, error) {
+	var t term
+
+	end := strings.IndexAny(s, "=!~")
+	if end <= 0 {
+		return t, "", fmt.Errorf("expected field and operator at %q", s)
+	}
+	t.field = s[:end]
+	if strings.ContainsAny(t.field, " \t\"") {
+		return t, "", fmt.Errorf("expected operator after field in %q", s)
+	}
+
+	s = s[end:]
+	switch {
+	case strings.HasPrefix(s, NotEqual):
+		t.op = NotEqual
+	case strings.HasPrefix(s, Equal):
+		t.op = Equal
+	case strings.HasPrefix(s, Contains):
+		t.op = Contains
+	default:
+		return t, "", fmt.Errorf("unknown operator in %q", t.field+s)
+	}
+	s = s[len(t.op):]
+
+	if strings.HasPrefix(s, `"`) {
+		closing := strings.Index(s[1:], `"`)
+		if closing < 0 {
+			return t, "", fmt.Errorf("unterminated quote in value of %s", t.field)
+		}
+		t.values = []string{s[1 : closing+1]}
+		return t, s[closing+2:], nil
+	}
+
+	end = strings.IndexAny(s, " \t")
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		return t, "", fmt.Errorf("missing value for %s", t.field)
+	}
+	t.values = strings.Split(s[:end], ",")
+	return t, s[end:], nil
+}
+
+// Match reports whether an item with the given field values matches all
+// terms. Fields missing from values have no value.
+func (e *Expr) Match(values map[string][]string) bool {
+	for _, t := range e.terms {
+		if !t.match(values[t.field]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (t term) match(have []string) bool {
+	// A field without values compares as the empty string
+	if len(have) == 0 {
+		have = []string{""}
+	}
+
+	found := false
+	for _, value := range have {
+		for _, want := range t.values {
+			switch t.op {
+			case Contains:
+				found = strings.Contains(strings.ToLower(value), strings.ToLower(want))
+			default:
+				found = value == want
+			}
+			if found {
+				break
+			}
+		}
+		if found {
+			break
+		}
+	}
+
+	if t.op == NotEqual {
+		return !found
+	}
+	return found
+}
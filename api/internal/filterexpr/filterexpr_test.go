@@ -0,0 +1,92 @@
+package filterexpr
+
+import "testing"
+
+var fields = []string{"type", "health", "tag", "label"}
+
+func TestParseErrors(t *testing.T) {
+	tests := []string{
+		``,
+		`   `,
+		`type`,
+		`=http`,
+		`colour=red`,
+		`type=`,
+		`type<http`,
+		`label="open`,
+		`type http=x`,
+	}
+	for _, s := range tests {
+		if _, err := Parse(s, fields); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	item := map[string][]string{
+		"type":   {"socks5"},
+		"health": {"fail"},
+		"tag":    {"dc", "Residential"},
+		"label":  {"Edge Proxy 1"},
+	}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`type=socks5`, true},
+		{`type=http`, false},
+		{`type=http,socks5`, true},
+		{`type!=http,https`, true},
+		{`type!=socks5`, false},
+		{`health!=ok`, true},
+		{`tag=dc`, true},
+		{`tag=mobile`, false},
+		{`tag!=dc`, false},
+		{`tag!=mobile`, true},
+		{`tag~resid`, true},
+		{`tag=residential`, false},
+		{`label="Edge Proxy 1"`, true},
+		{`label~proxy`, true},
+		{`label="Edge Proxy"`, false},
+		{`type=socks5 health=fail tag=dc`, true},
+		{`type=socks5 health=ok`, false},
+		{`  type=socks5   tag=dc  `, true},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr, fields)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := expr.Match(item); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestMatchMissingField(t *testing.T) {
+	// A field without values is the empty string
+	item := map[string][]string{"type": {"http"}}
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{`tag=""`, true},
+		{`tag!=dc`, true},
+		{`tag=dc`, false},
+		{`health!=""`, false},
+	}
+	for _, tt := range tests {
+		expr, err := Parse(tt.expr, fields)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := expr.Match(item); got != tt.want {
+			t.Errorf("%q matches = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Package geoip looks up where IP addresses are in local MaxMind-format
+// databases: a City or Country database for the country and city, and an
+// ASN database for the autonomous system.
+package geoip
+
+import (
+	"errors"
+	"net/netip"
+
+	"github.com/oschwald/geoip2-golang/v2"
+)
+
+// Location of an IP address. Fields are empty if unknown.
+type Location struct {
+	Country string // ISO 3166-1 alpha-2 code
+	City    string // English name
+	ASN     uint
+	ASOrg   string // organization of the ASN
+}
+
+// Locator looks up IP addresses. A nil Locator finds nothing.
+type Locator struct {
+	city *geoip2.Reader
+	asn  *geoip2.Reader
+}
+
+// Open opens the databases at the given paths. An empty path leaves that
+// database out, and with neither Open returns a nil Locator.
+func Open(cityPath, asnPath string) (*Locator, error) {
+	if cityPath == "" && asnPath == "" {
+		return nil, nil
+	}
+	l := &Locator{}
+	var err error
+	if cityPath != "" {
+		if l.city, err = geoip2.Open(cityPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if l.asn, err = geoip2.Open(asnPath); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// Lookup returns the location of ip, the zero Location if ip is invalid or
+// not found
+func (l *Locator) Lookup(ip string) Location {
+	var location Location
+	addr, err := netip.ParseAddr(ip)
+	if l == nil || err != nil {
+		return location
+	}
+	addr = addr.Unmap()
+
+	if l.city != nil {
+		if city, err := l.city.City(addr); err == nil {
+			location.Country = city.Country.ISOCode
+			location.City = city.City.Names.English
+		}
+	}
+	if l.asn != nil {
+		if asn, err := l.asn.ASN(addr); err == nil {
+			location.ASN = asn.AutonomousSystemNumber
+			location.ASOrg = asn.AutonomousSystemOrganization
+		}
+	}
+	return location
+}
+
+// Close closes the databases
+func (l *Locator) Close() error {
+	if l == nil {
+		return nil
+	}
+	var errs []error
+	for _, reader := range []*geoip2.Reader{l.city, l.asn} {
+		if reader != nil {
+			errs = append(errs, reader.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -4,33 +4,22 @@ import (
 	"net/http"
 	"time"
 
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
-	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
 	"github.com/gin-gonic/gin"
 )
 
 type AdminHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewAdminHandler(db *database.DB) *AdminHandler {
-	return &AdminHandler{db: db}
+func NewAdminHandler(store *repository.Store) *AdminHandler {
+	return &AdminHandler{store: store}
 }
 
 // Health returns API health status
 func (h *AdminHandler) Health(c *gin.Context) {
 	// Test database connection
-	sqlDB, err := h.db.DB.DB()
-	if err != nil {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"status":    "error",
-			"timestamp": time.Now(),
-			"error":     "database connection failed",
-		})
-		return
-	}
-
-	if err := sqlDB.Ping(); err != nil {
+	if err := h.store.Ping(c.Request.Context()); err != nil {
 		c.JSON(http.StatusServiceUnavailable, gin.H{
 			"status":    "error",
 			"timestamp": time.Now(),
@@ -45,35 +34,22 @@ func (h *AdminHandler) Health(c *gin.Context) {
 	})
 }
 
-// Summary returns system summary
+// Summary returns system summary. Servers in maintenance and their proxies
+// and mappings are only counted in maintenance_servers.
 func (h *AdminHandler) Summary(c *gin.Context) {
-	var (
-		serverCount       int64
-		proxyCount        int64
-		mappingCount      int64
-		activeServerCount int64
-	)
-
-	// Count total servers
-	h.db.Model(&models.Server{}).Count(&serverCount)
-	
-	// Count total proxies
-	h.db.Model(&models.Proxy{}).Count(&proxyCount)
-	
-	// Count total mappings
-	h.db.Model(&models.Mapping{}).Count(&mappingCount)
-	
-	// Count active servers (last seen within 5 minutes)
-	fiveMinutesAgo := time.Now().Add(-5 * time.Minute)
-	h.db.Model(&models.Server{}).
-		Where("last_seen_at > ? OR status = ?", fiveMinutesAgo, "online").
-		Count(&activeServerCount)
+	// Active servers were last seen within 5 minutes
+	summary, err := h.store.Servers.Summary(c.Request.Context(), time.Now().Add(-5*time.Minute))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch summary"})
+		return
+	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"servers":        serverCount,
-		"proxies":        proxyCount,
-		"mappings":       mappingCount,
-		"active_servers": activeServerCount,
-		"timestamp":      time.Now(),
+		"servers":             summary.Servers,
+		"proxies":             summary.Proxies,
+		"mappings":            summary.Mappings,
+		"active_servers":      summary.ActiveServers,
+		"maintenance_servers": summary.MaintenanceServers,
+		"timestamp":           time.Now(),
 	})
 }
@@ -1,21 +1,25 @@
 package handlers
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/rollout"
 	"github.com/gin-gonic/gin"
 )
 
 type AgentHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewAgentHandler(db *database.DB) *AgentHandler {
-	return &AgentHandler{db: db}
+func NewAgentHandler(store *repository.Store) *AgentHandler {
+	return &AgentHandler{store: store}
 }
 
 // Pull handles agent configuration pull requests
@@ -38,18 +42,14 @@ func (h *AgentHandler) Pull(c *gin.Context) {
 	}
 
 	// Verify agent token
-	var server models.Server
-	if err := h.db.Where("id = ? AND agent_token = ?", serverID, agentToken).First(&server).Error; err != nil {
+	server, err := h.store.Servers.GetByAgentToken(c.Request.Context(), uint(serverID), agentToken)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid agent token"})
 		return
 	}
 
 	// Update last seen timestamp
-	now := time.Now()
-	h.db.Model(&server).Updates(map[string]interface{}{
-		"last_seen_at": &now,
-		"status":       "online",
-	})
+	h.seen(c.Request.Context(), *server, repository.Fields{})
 
 	// Check if config has changed
 	currentVersion := server.ConfigVersion
@@ -59,17 +59,17 @@ func (h *AgentHandler) Pull(c *gin.Context) {
 		return
 	}
 
-	// Fetch proxies and mappings for this server
-	var proxies []models.Proxy
-	var mappings []models.Mapping
-
-	h.db.Where("server_id = ?", serverID).Find(&proxies)
-	h.db.Preload("UpstreamProxy").Where("server_id = ?", serverID).Find(&mappings)
+	// Serve the config recorded for the current version
+	response, err := h.store.Snapshots.AgentConfig(c.Request.Context(), uint(serverID), currentVersion)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load config"})
+		return
+	}
 
-	response := models.AgentPullResponse{
-		Version:  currentVersion,
-		Proxies:  proxies,
-		Mappings: mappings,
+	// Credentials are stored sealed; the agent needs them in the clear
+	if err := response.OpenCredentials(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt credentials"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -99,19 +99,58 @@ func (h *AgentHandler) Ack(c *gin.Context) {
 	}
 
 	// Verify agent token
-	var server models.Server
-	if err := h.db.Where("id = ? AND agent_token = ?", serverID, agentToken).First(&server).Error; err != nil {
+	server, err := h.store.Servers.GetByAgentToken(c.Request.Context(), uint(serverID), agentToken)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid agent token"})
 		return
 	}
 
-	// Log the acknowledgment (could be stored in audit log)
-	// For now, just update last seen
+	// Record which version the agent applied; rollouts wait on this
 	now := time.Now()
-	h.db.Model(&server).Updates(map[string]interface{}{
-		"last_seen_at": &now,
-		"status":       "online",
+	h.seen(c.Request.Context(), *server, repository.Fields{
+		"applied_version": req.Version,
+		"applied_status":  req.Status,
+		"applied_at":      &now,
+	}, events.AgentAcked{
+		Server:        events.NewServerState(*server),
+		Version:       req.Version,
+		Status:        req.Status,
+		OK:            rollout.AckOK(req.Status),
+		ConfigVersion: server.ConfigVersion,
 	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Acknowledgment received"})
 }
+
+// seen records that the agent of a server reported in, along with fields,
+// and publishes events in the same transaction. A server that was offline
+// is reported back online.
+func (h *AgentHandler) seen(ctx context.Context, server models.Server, fields repository.Fields, published ...events.Event) {
+	now := time.Now()
+	fields["last_seen_at"] = &now
+	fields["status"] = "online"
+	if server.Status == "offline" {
+		published = append(published, events.ServerOnline{Server: events.NewServerState(server)})
+	}
+
+	// Most check-ins report nothing, and need no transaction
+	update := func(store *repository.Store) error {
+		return store.Servers.Update(ctx, server.ID, fields)
+	}
+	if len(published) == 0 {
+		if err := update(h.store); err != nil {
+			slog.WarnContext(ctx, "Failed to record agent check-in", "server_id", server.ID, "error", err)
+		}
+		return
+	}
+
+	err := h.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := update(tx); err != nil {
+			return err
+		}
+		return events.Publish(ctx, tx, published...)
+	})
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to record agent check-in", "server_id", server.ID, "error", err)
+	}
+}
@@ -0,0 +1,820 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/alerting"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultAlertLimit and maxAlertLimit bound the alert list
+const (
+	defaultAlertLimit = 100
+	maxAlertLimit     = 500
+)
+
+// defaultAlertInterval is the evaluation interval of rules that set none
+const defaultAlertInterval = 60
+
+type AlertHandler struct {
+	store    *repository.Store
+	notifier *alerting.Notifier
+}
+
+func NewAlertHandler(store *repository.Store, notifier *alerting.Notifier) *AlertHandler {
+	return &AlertHandler{store: store, notifier: notifier}
+}
+
+type CreateAlertRuleRequest struct {
+	Name            string  `json:"name" binding:"required"`
+	Kind            string  `json:"kind" binding:"required"`
+	GroupID         *uint   `json:"group_id"`
+	ServerID        *uint   `json:"server_id"`
+	Threshold       float64 `json:"threshold"`
+	ForSeconds      int     `json:"for_seconds"`
+	IntervalSeconds int     `json:"interval_seconds"` // defaults to 60
+	WindowSeconds   int     `json:"window_seconds"`
+	Channels        []uint  `json:"channels"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+// UpdateAlertRuleRequest changes the fields it sets. A group_id or
+// server_id of 0 clears it.
+type UpdateAlertRuleRequest struct {
+	Name            *string  `json:"name"`
+	Kind            *string  `json:"kind"`
+	GroupID         *uint    `json:"group_id"`
+	ServerID        *uint    `json:"server_id"`
+	Threshold       *float64 `json:"threshold"`
+	ForSeconds      *int     `json:"for_seconds"`
+	IntervalSeconds *int     `json:"interval_seconds"`
+	WindowSeconds   *int     `json:"window_seconds"`
+	Channels        *[]uint  `json:"channels"`
+	Enabled         *bool    `json:"enabled"`
+}
+
+type AlertRuleResponse struct {
+	ID              uint       `json:"id"`
+	Name            string     `json:"name"`
+	Kind            string     `json:"kind"`
+	GroupID         *uint      `json:"group_id"`
+	ServerID        *uint      `json:"server_id"`
+	Threshold       float64    `json:"threshold"`
+	ForSeconds      int        `json:"for_seconds"`
+	IntervalSeconds int        `json:"interval_seconds"`
+	WindowSeconds   int        `json:"window_seconds"`
+	Channels        []uint     `json:"channels"`
+	Enabled         bool       `json:"enabled"`
+	EvaluatedAt     *time.Time `json:"evaluated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type CreateAlertChannelRequest struct {
+	Name      string `json:"name" binding:"required"`
+	Type      string `json:"type" binding:"required"`
+	Target    string `json:"target"`
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	WebhookID *uint  `json:"webhook_id"`
+	Enabled   *bool  `json:"enabled"`
+}
+
+type UpdateAlertChannelRequest struct {
+	Name      *string `json:"name"`
+	Target    *string `json:"target"`
+	URL       *string `json:"url"`
+	Token     *string `json:"token"`
+	WebhookID *uint   `json:"webhook_id"`
+	Enabled   *bool   `json:"enabled"`
+}
+
+// AlertChannelResponse is a channel with its token masked
+type AlertChannelResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	Type      string    `json:"type"`
+	Target    string    `json:"target"`
+	URL       string    `json:"url"`
+	Token     string    `json:"token"` // empty if none is set
+	WebhookID *uint     `json:"webhook_id"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// CreateAlertSilenceRequest silences from starts_at, or now, until ends_at
+// or for duration_seconds
+type CreateAlertSilenceRequest struct {
+	RuleID          *uint      `json:"rule_id"`
+	Subject         string     `json:"subject"`
+	Comment         string     `json:"comment"`
+	StartsAt        *time.Time `json:"starts_at"`
+	EndsAt          *time.Time `json:"ends_at"`
+	DurationSeconds int        `json:"duration_seconds"`
+}
+
+// AlertResponse is an alert with its rule's name. Silenced tells whether a
+// silence covers it now.
+type AlertResponse struct {
+	models.Alert
+	Rule          string                     `json:"rule"`
+	Silenced      bool                       `json:"silenced"`
+	Notifications []models.AlertNotification `json:"notifications,omitempty"` // single alerts only
+}
+
+func newAlertRuleResponse(rule models.AlertRule) AlertRuleResponse {
+	channels := []uint{}
+	json.Unmarshal([]byte(rule.Channels), &channels)
+	return AlertRuleResponse{
+		ID:              rule.ID,
+		Name:            rule.Name,
+		Kind:            rule.Kind,
+		GroupID:         rule.GroupID,
+		ServerID:        rule.ServerID,
+		Threshold:       rule.Threshold,
+		ForSeconds:      rule.ForSeconds,
+		IntervalSeconds: rule.IntervalSeconds,
+		WindowSeconds:   rule.WindowSeconds,
+		Channels:        channels,
+		Enabled:         rule.Enabled,
+		EvaluatedAt:     rule.EvaluatedAt,
+		CreatedAt:       rule.CreatedAt,
+		UpdatedAt:       rule.UpdatedAt,
+	}
+}
+
+func newAlertChannelResponse(channel models.AlertChannel) AlertChannelResponse {
+	resp := AlertChannelResponse{
+		ID:        channel.ID,
+		Name:      channel.Name,
+		Type:      channel.Type,
+		Target:    channel.Target,
+		URL:       channel.URL,
+		WebhookID: channel.WebhookID,
+		Enabled:   channel.Enabled,
+		CreatedAt: channel.CreatedAt,
+		UpdatedAt: channel.UpdatedAt,
+	}
+	if channel.Token != "" {
+		resp.Token = secrets.Mask
+	}
+	return resp
+}
+
+// GetAlerts returns alerts, newest first, optionally of one state or rule
+func (h *AlertHandler) GetAlerts(c *gin.Context) {
+	var filter repository.AlertFilter
+	switch state := c.Query("state"); state {
+	case "":
+	case models.AlertPending, models.AlertFiring, models.AlertResolved:
+		filter.States = []string{state}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "state must be pending, firing or resolved"})
+		return
+	}
+	if value := c.Query("rule_id"); value != "" {
+		id, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule ID"})
+			return
+		}
+		ruleID := uint(id)
+		filter.RuleID = &ruleID
+	}
+	filter.Limit = defaultAlertLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxAlertLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	ctx := c.Request.Context()
+	alerts, err := h.store.Alerts.List(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alerts"})
+		return
+	}
+	resp, err := h.alertResponses(c, alerts)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alerts"})
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetAlert returns an alert with its notifications
+func (h *AlertHandler) GetAlert(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert ID"})
+		return
+	}
+	ctx := c.Request.Context()
+	alert, err := h.store.Alerts.Get(ctx, uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert"})
+		return
+	}
+
+	resp, err := h.alertResponses(c, []models.Alert{*alert})
+	if err == nil {
+		resp[0].Notifications, err = h.store.AlertNotifications.List(ctx, alert.ID)
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert"})
+		return
+	}
+	c.JSON(http.StatusOK, resp[0])
+}
+
+// alertResponses adds rule names and whether open alerts are silenced now
+func (h *AlertHandler) alertResponses(c *gin.Context, alerts []models.Alert) ([]AlertResponse, error) {
+	ctx := c.Request.Context()
+	rules, err := h.store.AlertRules.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[uint]string, len(rules))
+	for _, rule := range rules {
+		names[rule.ID] = rule.Name
+	}
+	now := time.Now()
+	silences, err := h.store.AlertSilences.List(ctx, now)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := make([]AlertResponse, 0, len(alerts))
+	for _, alert := range alerts {
+		item := AlertResponse{Alert: alert, Rule: names[alert.RuleID]}
+		if alert.State != models.AlertResolved {
+			for _, silence := range silences {
+				if !silence.StartsAt.After(now) && (silence.RuleID == nil || *silence.RuleID == alert.RuleID) && (silence.Subject == "" || silence.Subject == alert.Subject) {
+					item.Silenced = true
+					break
+				}
+			}
+		}
+		resp = append(resp, item)
+	}
+	return resp, nil
+}
+
+// GetAlertRules returns all alert rules
+func (h *AlertHandler) GetAlertRules(c *gin.Context) {
+	rules, err := h.store.AlertRules.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert rules"})
+		return
+	}
+
+	resp := make([]AlertRuleResponse, 0, len(rules))
+	for _, rule := range rules {
+		resp = append(resp, newAlertRuleResponse(rule))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetAlertRule returns an alert rule by ID
+func (h *AlertHandler) GetAlertRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newAlertRuleResponse(*rule))
+}
+
+// CreateAlertRule creates an alert rule. It is first evaluated within
+// seconds.
+func (h *AlertHandler) CreateAlertRule(c *gin.Context) {
+	var req CreateAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	rule := models.AlertRule{
+		Name:            req.Name,
+		Kind:            req.Kind,
+		GroupID:         req.GroupID,
+		ServerID:        req.ServerID,
+		Threshold:       req.Threshold,
+		ForSeconds:      req.ForSeconds,
+		IntervalSeconds: req.IntervalSeconds,
+		WindowSeconds:   req.WindowSeconds,
+		Enabled:         req.Enabled == nil || *req.Enabled,
+	}
+	if rule.IntervalSeconds == 0 {
+		rule.IntervalSeconds = defaultAlertInterval
+	}
+	if rule.Kind == models.AlertLatencyP95 && rule.WindowSeconds == 0 {
+		rule.WindowSeconds = int(alerting.DefaultWindow / time.Second)
+	}
+	rule.Channels = encodeIDs(req.Channels)
+	if status, msg := h.validateRule(c, rule, req.Channels); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.store.AlertRules.Create(c.Request.Context(), &rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert rule"})
+		return
+	}
+	c.JSON(http.StatusCreated, newAlertRuleResponse(rule))
+}
+
+// UpdateAlertRule updates an alert rule. Its open alerts are moved along by
+// the next evaluation under the new condition.
+func (h *AlertHandler) UpdateAlertRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateAlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	// Validated as a whole, since what is allowed depends on the kind
+	next := *rule
+	if req.Name != nil {
+		next.Name = *req.Name
+	}
+	if req.Kind != nil {
+		next.Kind = *req.Kind
+	}
+	if req.GroupID != nil {
+		next.GroupID = clearableID(*req.GroupID)
+	}
+	if req.ServerID != nil {
+		next.ServerID = clearableID(*req.ServerID)
+	}
+	if req.Threshold != nil {
+		next.Threshold = *req.Threshold
+	}
+	if req.ForSeconds != nil {
+		next.ForSeconds = *req.ForSeconds
+	}
+	if req.IntervalSeconds != nil {
+		next.IntervalSeconds = *req.IntervalSeconds
+	}
+	if req.WindowSeconds != nil {
+		next.WindowSeconds = *req.WindowSeconds
+	}
+	channels := newAlertRuleResponse(*rule).Channels
+	if req.Channels != nil {
+		channels = *req.Channels
+		next.Channels = encodeIDs(channels)
+	}
+	if req.Enabled != nil {
+		next.Enabled = *req.Enabled
+	}
+	if status, msg := h.validateRule(c, next, channels); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.store.AlertRules.Update(ctx, rule.ID, repository.Fields{
+		"name":             next.Name,
+		"kind":             next.Kind,
+		"group_id":         next.GroupID,
+		"server_id":        next.ServerID,
+		"threshold":        next.Threshold,
+		"for_seconds":      next.ForSeconds,
+		"interval_seconds": next.IntervalSeconds,
+		"window_seconds":   next.WindowSeconds,
+		"channels":         next.Channels,
+		"enabled":          next.Enabled,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert rule"})
+		return
+	}
+
+	updated, err := h.store.AlertRules.Get(ctx, rule.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newAlertRuleResponse(*updated))
+}
+
+// DeleteAlertRule deletes an alert rule with its alerts and silences.
+// Firing alerts go without a resolved notification.
+func (h *AlertHandler) DeleteAlertRule(c *gin.Context) {
+	rule, ok := h.findRule(c)
+	if !ok {
+		return
+	}
+	if err := h.store.AlertRules.Delete(c.Request.Context(), rule.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert rule"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert rule deleted successfully"})
+}
+
+// validateRule returns the status and message of why a rule is invalid,
+// or an empty message
+func (h *AlertHandler) validateRule(c *gin.Context, rule models.AlertRule, channels []uint) (int, string) {
+	switch rule.Kind {
+	case models.AlertGroupFailing:
+		if rule.Threshold < 0 || rule.Threshold >= 100 {
+			return http.StatusBadRequest, "threshold must be a percentage from 0 to below 100"
+		}
+	case models.AlertLatencyP95:
+		if rule.Threshold <= 0 {
+			return http.StatusBadRequest, "threshold must be a latency in milliseconds above 0"
+		}
+	case models.AlertProxyExpiring:
+		if rule.Threshold <= 0 {
+			return http.StatusBadRequest, "threshold must be a number of days above 0"
+		}
+	case models.AlertServerOffline, models.AlertVersionLag:
+	default:
+		return http.StatusBadRequest, "kind must be one of " + strings.Join(alerting.Kinds, ", ")
+	}
+	if rule.IntervalSeconds < int(alerting.MinInterval/time.Second) {
+		return http.StatusBadRequest, fmt.Sprintf("interval_seconds must be at least %d", int(alerting.MinInterval/time.Second))
+	}
+	if rule.ForSeconds < 0 || rule.WindowSeconds < 0 {
+		return http.StatusBadRequest, "for_seconds and window_seconds cannot be negative"
+	}
+
+	ctx := c.Request.Context()
+	serverKind := rule.Kind == models.AlertServerOffline || rule.Kind == models.AlertVersionLag
+	if rule.GroupID != nil {
+		if serverKind {
+			return http.StatusBadRequest, "group_id does not apply to " + rule.Kind + " rules"
+		}
+		if _, err := h.store.Groups.Get(ctx, *rule.GroupID); err != nil {
+			return http.StatusBadRequest, "Group not found"
+		}
+	}
+	if rule.ServerID != nil {
+		if !serverKind {
+			return http.StatusBadRequest, "server_id does not apply to " + rule.Kind + " rules"
+		}
+		if _, err := h.store.Servers.Get(ctx, *rule.ServerID, false); err != nil {
+			return http.StatusBadRequest, "Server not found"
+		}
+	}
+	for _, id := range channels {
+		if _, err := h.store.AlertChannels.Get(ctx, id); errors.Is(err, repository.ErrNotFound) {
+			return http.StatusBadRequest, fmt.Sprintf("Alert channel %d not found", id)
+		} else if err != nil {
+			return http.StatusInternalServerError, "Failed to fetch alert channels"
+		}
+	}
+	return 0, ""
+}
+
+// GetAlertChannels returns all alert channels
+func (h *AlertHandler) GetAlertChannels(c *gin.Context) {
+	channels, err := h.store.AlertChannels.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert channels"})
+		return
+	}
+
+	resp := make([]AlertChannelResponse, 0, len(channels))
+	for _, channel := range channels {
+		resp = append(resp, newAlertChannelResponse(channel))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetAlertChannel returns an alert channel by ID
+func (h *AlertHandler) GetAlertChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newAlertChannelResponse(*channel))
+}
+
+// CreateAlertChannel creates an alert channel
+func (h *AlertHandler) CreateAlertChannel(c *gin.Context) {
+	var req CreateAlertChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	channel := models.AlertChannel{
+		Name:      req.Name,
+		Type:      req.Type,
+		Target:    strings.TrimSpace(req.Target),
+		URL:       req.URL,
+		WebhookID: req.WebhookID,
+		Enabled:   req.Enabled == nil || *req.Enabled,
+	}
+	if msg := h.validateChannel(c, channel, req.Token != ""); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if req.Token != "" {
+		sealed, err := secrets.SealSecret(req.Token)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt token"})
+			return
+		}
+		channel.Token = sealed
+	}
+
+	if err := h.store.AlertChannels.Create(c.Request.Context(), &channel); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create alert channel"})
+		return
+	}
+	c.JSON(http.StatusCreated, newAlertChannelResponse(channel))
+}
+
+// UpdateAlertChannel updates an alert channel. Its type cannot change.
+func (h *AlertHandler) UpdateAlertChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateAlertChannelRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	next := *channel
+	updates := make(repository.Fields)
+	if req.Name != nil {
+		next.Name, updates["name"] = *req.Name, *req.Name
+	}
+	if req.Target != nil {
+		next.Target = strings.TrimSpace(*req.Target)
+		updates["target"] = next.Target
+	}
+	if req.URL != nil {
+		next.URL, updates["url"] = *req.URL, *req.URL
+	}
+	if req.WebhookID != nil {
+		next.WebhookID, updates["webhook_id"] = req.WebhookID, *req.WebhookID
+	}
+	if req.Enabled != nil {
+		next.Enabled, updates["enabled"] = *req.Enabled, *req.Enabled
+	}
+	hasToken := channel.Token != ""
+	token, changed, err := secrets.Update(req.Token)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt token"})
+		return
+	}
+	if changed {
+		hasToken = token != ""
+		updates["token"] = token
+	}
+	if msg := h.validateChannel(c, next, hasToken); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if len(updates) > 0 {
+		if err := h.store.AlertChannels.Update(ctx, channel.ID, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update alert channel"})
+			return
+		}
+	}
+
+	updated, err := h.store.AlertChannels.Get(ctx, channel.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert channel not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newAlertChannelResponse(*updated))
+}
+
+// DeleteAlertChannel deletes an alert channel with its notifications.
+// Rules listing it skip it from then on.
+func (h *AlertHandler) DeleteAlertChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+	if err := h.store.AlertChannels.Delete(c.Request.Context(), channel.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete alert channel"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Alert channel deleted successfully"})
+}
+
+// TestAlertChannel sends a test message to a channel, even if it is
+// disabled. Webhook channels queue a ping of their webhook.
+func (h *AlertHandler) TestAlertChannel(c *gin.Context) {
+	channel, ok := h.findChannel(c)
+	if !ok {
+		return
+	}
+	if err := h.notifier.Test(c.Request.Context(), *channel); err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Test notification failed", "details": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Test notification sent"})
+}
+
+// validateChannel returns why a channel is invalid, or ""
+func (h *AlertHandler) validateChannel(c *gin.Context, channel models.AlertChannel, hasToken bool) string {
+	switch channel.Type {
+	case models.ChannelEmail:
+		recipients := alerting.SplitRecipients(channel.Target)
+		if len(recipients) == 0 {
+			return "target must list the recipient addresses, comma-separated"
+		}
+		for _, recipient := range recipients {
+			if _, err := mail.ParseAddress(recipient); err != nil {
+				return "Invalid recipient " + strconv.Quote(recipient)
+			}
+		}
+	case models.ChannelTelegram:
+		if channel.Target == "" || !hasToken {
+			return "target (chat ID) and token are required for telegram channels"
+		}
+		if channel.URL != "" {
+			if msg := validateWebhookURL(channel.URL); msg != "" {
+				return msg
+			}
+		}
+	case models.ChannelWebhook:
+		if channel.WebhookID == nil {
+			return "webhook_id is required for webhook channels"
+		}
+		if _, err := h.store.Webhooks.Get(c.Request.Context(), *channel.WebhookID); err != nil {
+			return "Webhook not found"
+		}
+	default:
+		return "type must be email, webhook or telegram"
+	}
+	return ""
+}
+
+// GetAlertSilences returns the active and upcoming silences, or with
+// expired=true all of them
+func (h *AlertHandler) GetAlertSilences(c *gin.Context) {
+	endingAfter := time.Now()
+	if c.Query("expired") == "true" {
+		endingAfter = time.Time{}
+	}
+	silences, err := h.store.AlertSilences.List(c.Request.Context(), endingAfter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch silences"})
+		return
+	}
+	if silences == nil {
+		silences = []models.AlertSilence{}
+	}
+	c.JSON(http.StatusOK, silences)
+}
+
+// CreateAlertSilence silences the notifications of a rule's alerts, of one
+// subject, or of everything for a while
+func (h *AlertHandler) CreateAlertSilence(c *gin.Context) {
+	var req CreateAlertSilenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	silence := models.AlertSilence{
+		RuleID:    req.RuleID,
+		Subject:   strings.TrimSpace(req.Subject),
+		Comment:   req.Comment,
+		CreatedBy: c.GetString("email"),
+		StartsAt:  time.Now(),
+	}
+	if req.StartsAt != nil {
+		silence.StartsAt = *req.StartsAt
+	}
+	switch {
+	case req.EndsAt != nil && req.DurationSeconds == 0:
+		silence.EndsAt = *req.EndsAt
+	case req.EndsAt == nil && req.DurationSeconds > 0:
+		silence.EndsAt = silence.StartsAt.Add(time.Duration(req.DurationSeconds) * time.Second)
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Set either ends_at or a positive duration_seconds"})
+		return
+	}
+	if !silence.EndsAt.After(silence.StartsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at must be after starts_at"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.RuleID != nil {
+		if _, err := h.store.AlertRules.Get(ctx, *req.RuleID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Alert rule not found"})
+			return
+		}
+	}
+	if err := h.store.AlertSilences.Create(ctx, &silence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create silence"})
+		return
+	}
+	c.JSON(http.StatusCreated, silence)
+}
+
+// DeleteAlertSilence ends a silence by deleting it
+func (h *AlertHandler) DeleteAlertSilence(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid silence ID"})
+		return
+	}
+	err = h.store.AlertSilences.Delete(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Silence not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete silence"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Silence deleted successfully"})
+}
+
+// findRule loads the alert rule of the :id parameter, or writes the error
+func (h *AlertHandler) findRule(c *gin.Context) (*models.AlertRule, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert rule ID"})
+		return nil, false
+	}
+	rule, err := h.store.AlertRules.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert rule not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert rule"})
+		return nil, false
+	}
+	return rule, true
+}
+
+// findChannel loads the alert channel of the :id parameter, or writes the
+// error
+func (h *AlertHandler) findChannel(c *gin.Context) (*models.AlertChannel, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid alert channel ID"})
+		return nil, false
+	}
+	channel, err := h.store.AlertChannels.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Alert channel not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch alert channel"})
+		return nil, false
+	}
+	return channel, true
+}
+
+// encodeIDs stores IDs as a JSON array, without repeats
+func encodeIDs(ids []uint) string {
+	seen := make(map[uint]bool, len(ids))
+	unique := make([]uint, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	encoded, _ := json.Marshal(unique)
+	return string(encoded)
+}
+
+// clearableID returns nil for 0, which clears an optional reference
+func clearableID(id uint) *uint {
+	if id == 0 {
+		return nil
+	}
+	return &id
+}
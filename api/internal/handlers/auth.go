@@ -5,20 +5,19 @@ import (
 	"time"
 
 	"github.com/Chinsusu/proxy-manager/api/internal/config"
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
-	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type AuthHandler struct {
-	db  *database.DB
-	cfg *config.Config
+	store *repository.Store
+	cfg   *config.Config
 }
 
-func NewAuthHandler(db *database.DB, cfg *config.Config) *AuthHandler {
-	return &AuthHandler{db: db, cfg: cfg}
+func NewAuthHandler(store *repository.Store, cfg *config.Config) *AuthHandler {
+	return &AuthHandler{store: store, cfg: cfg}
 }
 
 type LoginRequest struct {
@@ -40,8 +39,8 @@ func (h *AuthHandler) Login(c *gin.Context) {
 	}
 
 	// Find user by email
-	var user models.User
-	if err := h.db.Where("email = ?", req.Email).First(&user).Error; err != nil {
+	user, err := h.store.Users.GetByEmail(c.Request.Context(), req.Email)
+	if err != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid credentials"})
 		return
 	}
@@ -81,8 +80,15 @@ func (h *AuthHandler) Me(c *gin.Context) {
 		return
 	}
 
-	var user models.User
-	if err := h.db.Where("id = ?", userID).First(&user).Error; err != nil {
+	// JWT numbers decode as float64
+	id, ok := userID.(float64)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found in context"})
+		return
+	}
+
+	user, err := h.store.Users.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
 		return
 	}
@@ -0,0 +1,658 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/checkhistory"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/filterexpr"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
+	"github.com/gin-gonic/gin"
+)
+
+// Bulk operations
+const (
+	bulkDelete         = "delete"
+	bulkEnable         = "enable"
+	bulkDisable        = "disable"
+	bulkSetCredentials = "set_credentials"
+	bulkSetType        = "set_type"
+	bulkRecheckHealth  = "recheck_health"
+	bulkTag            = "tag"
+	bulkUntag          = "untag"
+)
+
+// Outcome of a bulk operation on one item
+const (
+	bulkOK      = "ok"
+	bulkSkipped = "skipped" // nothing to change
+	bulkFailed  = "failed"
+)
+
+var (
+	proxyBulkOperations   = []string{bulkDelete, bulkEnable, bulkDisable, bulkSetCredentials, bulkSetType, bulkRecheckHealth, bulkTag, bulkUntag}
+	mappingBulkOperations = []string{bulkDelete, bulkEnable, bulkDisable, bulkTag, bulkUntag}
+
+	proxyFilterFields   = []string{"id", "server_id", "group_id", "label", "type", "host", "port", "health", "tag", "exit_ip", "country", "city", "asn", "anonymity", "quarantined", "provider_id", "order_ref", "disabled"}
+	mappingFilterFields = []string{"id", "server_id", "upstream_proxy_id", "client_cidr", "enabled", "notes", "tag"}
+)
+
+// maxBulkChecks bounds the proxies a recheck_health request checks while
+// the client waits. Every proxy is checked in the background anyway.
+const maxBulkChecks = 100
+
+// errBulkRollback rolls back a bulk transaction that was a dry run or had
+// failed items
+var errBulkRollback = errors.New("bulk operation rolled back")
+
+type BulkHandler struct {
+	store   *repository.Store
+	checker *healthcheck.Checker
+}
+
+func NewBulkHandler(store *repository.Store, checker *healthcheck.Checker) *BulkHandler {
+	return &BulkHandler{store: store, checker: checker}
+}
+
+// BulkRequest selects items by IDs or by a filter expression and applies
+// one operation to all of them
+type BulkRequest struct {
+	Operation string   `json:"operation" binding:"required"`
+	IDs       []uint   `json:"ids"`
+	Filter    string   `json:"filter"`
+	DryRun    bool     `json:"dry_run"`
+	Force     string   `json:"force"`    // delete proxies: disable or remove
+	Username  *string  `json:"username"` // set_credentials
+	Password  *string  `json:"password"` // set_credentials
+	Type      string   `json:"type"`     // set_type
+	Tags      []string `json:"tags"`     // tag, untag
+}
+
+type BulkItemResult struct {
+	ID             uint                `json:"id"`
+	Status         string              `json:"status"` // ok, skipped, failed
+	Error          string              `json:"error,omitempty"`
+	Health         string              `json:"health,omitempty"`          // recheck_health
+	LatencyMS      *int64              `json:"latency_ms,omitempty"`      // recheck_health, if reachable
+	CheckError     string              `json:"check_error,omitempty"`     // recheck_health, if not
+	ExitIP         string              `json:"exit_ip,omitempty"`         // recheck_health, if discovered
+	ExitIPError    string              `json:"exit_ip_error,omitempty"`   // recheck_health, if not
+	Anonymity      string              `json:"anonymity,omitempty"`       // recheck_health, if classified
+	AnonymityLeaks []string            `json:"anonymity_leaks,omitempty"` // recheck_health, headers that gave the proxy away
+	AnonymityError string              `json:"anonymity_error,omitempty"` // recheck_health, if not classified
+	Dependents     *DependentsResponse `json:"dependents,omitempty"`      // delete, what was released
+}
+
+type BulkResponse struct {
+	Operation string           `json:"operation"`
+	DryRun    bool             `json:"dry_run"`
+	Committed bool             `json:"committed"`
+	Matched   int              `json:"matched"`
+	Succeeded int              `json:"succeeded"`
+	Skipped   int              `json:"skipped"`
+	Failed    int              `json:"failed"`
+	Results   []BulkItemResult `json:"results"`
+}
+
+// bulkRun is one bulk request being applied
+type bulkRun struct {
+	req      BulkRequest
+	actor    string // who runs it, for the trash
+	changes  *events.Changes
+	username secrets.Text
+	password secrets.Secret
+	tags     []string
+	checks   map[uint]healthcheck.Result
+}
+
+// BulkProxies applies an operation to the selected proxies in one
+// transaction
+func (h *BulkHandler) BulkProxies(c *gin.Context) {
+	run, ok := h.bind(c, proxyBulkOperations, proxyFilterFields)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	switch run.req.Operation {
+	case bulkDelete:
+		if run.req.Force != "" && run.req.Force != forceDisable && run.req.Force != forceRemove {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "force must be disable or remove"})
+			return
+		}
+	case bulkSetCredentials:
+		if run.req.Username == nil && run.req.Password == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "set_credentials needs username, password or both"})
+			return
+		}
+		var err error
+		if run.req.Username != nil {
+			if run.username, err = secrets.SealText(*run.req.Username); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+				return
+			}
+		}
+		if run.req.Password != nil {
+			if run.password, err = secrets.SealSecret(*run.req.Password); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+				return
+			}
+		}
+	case bulkSetType:
+		validTypes := map[string]bool{"http": true, "https": true, "socks4": true, "socks5": true}
+		if !validTypes[run.req.Type] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy type"})
+			return
+		}
+	}
+
+	ids, proxies, err := selectProxies(ctx, h.store, run.req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
+		return
+	}
+
+	if run.req.Operation == bulkRecheckHealth && len(ids) > maxBulkChecks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("recheck_health checks at most %d proxies per request; %d are selected", maxBulkChecks, len(ids))})
+		return
+	}
+
+	// Checks open connections, so they run before the transaction and
+	// not at all in a dry run
+	if run.req.Operation == bulkRecheckHealth && !run.req.DryRun {
+		run.checks = h.checker.CheckAll(ctx, proxies)
+	}
+
+	var results []BulkItemResult
+	err = commitBulk(ctx, h.store, run, "proxies", func(tx *repository.Store, affected affectedServers) ([]BulkItemResult, error) {
+		// Reloaded, since the selection was made outside the transaction
+		current, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: ids})
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[uint]models.Proxy, len(current))
+		for _, proxy := range current {
+			byID[proxy.ID] = proxy
+		}
+
+		results = make([]BulkItemResult, 0, len(ids))
+		for _, id := range ids {
+			proxy, ok := byID[id]
+			if !ok {
+				results = append(results, BulkItemResult{ID: id, Status: bulkFailed, Error: "Proxy not found"})
+				continue
+			}
+			result, err := run.applyToProxy(ctx, tx, proxy, affected)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	})
+	h.respond(c, run.req, results, err)
+}
+
+// BulkMappings applies an operation to the selected mappings in one
+// transaction
+func (h *BulkHandler) BulkMappings(c *gin.Context) {
+	run, ok := h.bind(c, mappingBulkOperations, mappingFilterFields)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	ids, err := selectMappings(ctx, h.store, run.req)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mappings"})
+		return
+	}
+
+	var results []BulkItemResult
+	err = commitBulk(ctx, h.store, run, "mappings", func(tx *repository.Store, affected affectedServers) ([]BulkItemResult, error) {
+		current, err := tx.Mappings.List(ctx, repository.MappingFilter{IDs: ids})
+		if err != nil {
+			return nil, err
+		}
+		byID := make(map[uint]models.Mapping, len(current))
+		for _, mapping := range current {
+			byID[mapping.ID] = mapping
+		}
+
+		results = make([]BulkItemResult, 0, len(ids))
+		for _, id := range ids {
+			mapping, ok := byID[id]
+			if !ok {
+				results = append(results, BulkItemResult{ID: id, Status: bulkFailed, Error: "Mapping not found"})
+				continue
+			}
+			result, err := run.applyToMapping(ctx, tx, mapping, affected)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, result)
+		}
+		return results, nil
+	})
+	h.respond(c, run.req, results, err)
+}
+
+// bind reads and validates the parts of a bulk request shared by proxies
+// and mappings
+func (h *BulkHandler) bind(c *gin.Context, operations, fields []string) (*bulkRun, bool) {
+	var req BulkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return nil, false
+	}
+
+	supported := false
+	for _, op := range operations {
+		supported = supported || op == req.Operation
+	}
+	if !supported {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "operation must be one of " + strings.Join(operations, ", ")})
+		return nil, false
+	}
+
+	if (len(req.IDs) == 0) == (strings.TrimSpace(req.Filter) == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provide either ids or filter"})
+		return nil, false
+	}
+	if req.Filter != "" {
+		if _, err := filterexpr.Parse(req.Filter, fields); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid filter: " + err.Error()})
+			return nil, false
+		}
+	}
+
+	run := &bulkRun{req: req, actor: c.GetString("email"), changes: events.NewChanges(c.GetString("email"))}
+	if req.Operation == bulkTag || req.Operation == bulkUntag {
+		for _, tag := range req.Tags {
+			if tag = strings.TrimSpace(tag); tag == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Tags must not be empty"})
+				return nil, false
+			}
+			run.tags = append(run.tags, tag)
+		}
+		if len(run.tags) == 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "No tags provided"})
+			return nil, false
+		}
+	}
+	return run, true
+}
+
+// selectProxies returns the IDs of the selected proxies and those of them
+// that exist. Requested IDs that do not exist are kept, so they are reported
+// as failed instead of being dropped.
+func selectProxies(ctx context.Context, store *repository.Store, req BulkRequest) ([]uint, []models.Proxy, error) {
+	if len(req.IDs) > 0 {
+		proxies, err := store.Proxies.List(ctx, repository.ProxyFilter{IDs: req.IDs})
+		return uniqueIDs(req.IDs), proxies, err
+	}
+
+	// Parsed in bind already
+	expr, _ := filterexpr.Parse(req.Filter, proxyFilterFields)
+	proxies, err := store.Proxies.List(ctx, repository.ProxyFilter{})
+	if err != nil {
+		return nil, nil, err
+	}
+	var ids []uint
+	var matched []models.Proxy
+	for _, proxy := range proxies {
+		if expr.Match(proxyFilterValues(proxy)) {
+			ids = append(ids, proxy.ID)
+			matched = append(matched, proxy)
+		}
+	}
+	return ids, matched, nil
+}
+
+// selectMappings returns the IDs of the selected mappings
+func selectMappings(ctx context.Context, store *repository.Store, req BulkRequest) ([]uint, error) {
+	if len(req.IDs) > 0 {
+		return uniqueIDs(req.IDs), nil
+	}
+
+	expr, _ := filterexpr.Parse(req.Filter, mappingFilterFields)
+	mappings, err := store.Mappings.List(ctx, repository.MappingFilter{})
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint
+	for _, mapping := range mappings {
+		if expr.Match(mappingFilterValues(mapping)) {
+			ids = append(ids, mapping.ID)
+		}
+	}
+	return ids, nil
+}
+
+func proxyFilterValues(p models.Proxy) map[string][]string {
+	return map[string][]string{
+		"id":          {strconv.FormatUint(uint64(p.ID), 10)},
+		"server_id":   {optionalID(p.ServerID)},
+		"group_id":    {optionalID(p.GroupID)},
+		"label":       {p.Label},
+		"type":        {p.Type},
+		"host":        {p.Host},
+		"port":        {strconv.Itoa(p.Port)},
+		"health":      {p.Health},
+		"tag":         decodeTags(p.Tags),
+		"exit_ip":     {p.ExitIP},
+		"country":     {p.Country},
+		"city":        {p.City},
+		"asn":         {strconv.FormatUint(uint64(p.ASN), 10)},
+		"anonymity":   {p.Anonymity},
+		"quarantined": {strconv.FormatBool(p.QuarantinedAt != nil)},
+		"provider_id": {optionalID(p.ProviderID)},
+		"order_ref":   {p.OrderRef},
+		"disabled":    {strconv.FormatBool(p.DisabledAt != nil)},
+	}
+}
+
+func mappingFilterValues(m models.Mapping) map[string][]string {
+	return map[string][]string{
+		"id":                {strconv.FormatUint(uint64(m.ID), 10)},
+		"server_id":         {strconv.FormatUint(uint64(m.ServerID), 10)},
+		"upstream_proxy_id": {optionalID(m.UpstreamProxyID)},
+		"client_cidr":       {m.ClientCIDR},
+		"enabled":           {strconv.FormatBool(m.Enabled)},
+		"notes":             {m.Notes},
+		"tag":               decodeTags(m.Tags),
+	}
+}
+
+// optionalID formats an ID that may be unset as the empty string, so
+// `server_id=""` selects unassigned proxies
+func optionalID(id *uint) string {
+	if id == nil {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*id), 10)
+}
+
+func (r *bulkRun) applyToProxy(ctx context.Context, tx *repository.Store, proxy models.Proxy, affected affectedServers) (BulkItemResult, error) {
+	result := BulkItemResult{ID: proxy.ID, Status: bulkOK}
+
+	switch r.req.Operation {
+	case bulkDelete:
+		deps, err := findDependents(ctx, tx, []uint{proxy.ID})
+		if err != nil {
+			return result, err
+		}
+		if !deps.empty() {
+			resp := deps.response()
+			result.Dependents = &resp
+			if r.req.Force == "" {
+				result.Status = bulkFailed
+				result.Error = "Proxy is used by mappings or schedules. Delete it with force=disable or force=remove."
+				return result, nil
+			}
+		}
+		var cascade trash.Cascade
+		if err := deps.release(ctx, tx, r.req.Force, affected, &cascade, r.changes); err != nil {
+			return result, err
+		}
+		affected.add(proxy.ServerID)
+		r.changes.Proxies(proxy)
+		if err := tx.Proxies.Delete(ctx, proxy.ID); err != nil {
+			return result, err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashProxy, ResourceID: proxy.ID, Label: proxy.Label, ServerID: proxy.ServerID, DeletedBy: r.actor}
+		return result, trash.Record(ctx, tx, entry, &cascade)
+
+	case bulkEnable, bulkDisable:
+		// A disabled proxy is left out of its server's agent config, along
+		// with the mappings routed through it
+		enable := r.req.Operation == bulkEnable
+		if (proxy.DisabledAt == nil) == enable {
+			result.Status = bulkSkipped
+			return result, nil
+		}
+		if enable && proxy.ExpiresAt != nil && !proxy.ExpiresAt.After(time.Now()) {
+			result.Status = bulkFailed
+			result.Error = "Proxy has expired; set a later expires_at to enable it"
+			return result, nil
+		}
+		var disabledAt *time.Time
+		if !enable {
+			now := time.Now()
+			disabledAt = &now
+		}
+		affected.add(proxy.ServerID)
+		r.changes.Proxies(proxy)
+		return result, tx.Proxies.Update(ctx, proxy.ID, repository.Fields{"disabled_at": disabledAt})
+
+	case bulkSetCredentials:
+		updates := make(repository.Fields)
+		if r.req.Username != nil {
+			updates["username"] = r.username
+		}
+		if r.req.Password != nil {
+			updates["password"] = r.password
+		}
+		affected.add(proxy.ServerID)
+		r.changes.Proxies(proxy)
+		return result, tx.Proxies.Update(ctx, proxy.ID, updates)
+
+	case bulkSetType:
+		if proxy.Type == r.req.Type {
+			result.Status = bulkSkipped
+			return result, nil
+		}
+		affected.add(proxy.ServerID)
+		r.changes.Proxies(proxy)
+		return result, tx.Proxies.Update(ctx, proxy.ID, repository.Fields{"type": r.req.Type})
+
+	case bulkRecheckHealth:
+		// Health is not part of the agent config, so no version bump here;
+		// quarantining or restoring the proxy bumps its server itself
+		check, checked := r.checks[proxy.ID]
+		if !checked {
+			return result, nil
+		}
+		result.Health = check.Health
+		result.CheckError = check.Error
+		result.ExitIP = check.ExitIP
+		result.ExitIPError = check.ExitIPError
+		result.Anonymity = check.Anonymity
+		result.AnonymityLeaks = check.AnonymityLeaks
+		result.AnonymityError = check.AnonymityError
+		if check.Health == healthcheck.HealthOK {
+			latency := check.Latency.Milliseconds()
+			result.LatencyMS = &latency
+		}
+		return result, checkhistory.Record(ctx, tx, proxy, check)
+
+	case bulkTag, bulkUntag:
+		tags, changed := r.retag(proxy.Tags)
+		if !changed {
+			result.Status = bulkSkipped
+			return result, nil
+		}
+		r.changes.Proxies(proxy)
+		return result, tx.Proxies.Update(ctx, proxy.ID, repository.Fields{"tags": tags})
+	}
+	return result, nil
+}
+
+func (r *bulkRun) applyToMapping(ctx context.Context, tx *repository.Store, mapping models.Mapping, affected affectedServers) (BulkItemResult, error) {
+	result := BulkItemResult{ID: mapping.ID, Status: bulkOK}
+
+	switch r.req.Operation {
+	case bulkDelete:
+		affected.addID(mapping.ServerID)
+		r.changes.Mappings(mapping)
+		var cascade trash.Cascade
+		if err := trash.DeleteMapping(ctx, tx, mapping.ID, &cascade); err != nil {
+			return result, err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashMapping, ResourceID: mapping.ID, Label: mapping.ClientCIDR, ServerID: &mapping.ServerID, DeletedBy: r.actor}
+		return result, trash.Record(ctx, tx, entry, &cascade)
+
+	case bulkEnable, bulkDisable:
+		enable := r.req.Operation == bulkEnable
+		if mapping.Enabled == enable {
+			result.Status = bulkSkipped
+			return result, nil
+		}
+		if enable && mapping.UpstreamProxyID == nil {
+			result.Status = bulkFailed
+			result.Error = "Mapping has no upstream proxy; set upstream_proxy_id to enable it"
+			return result, nil
+		}
+		affected.addID(mapping.ServerID)
+		r.changes.Mappings(mapping)
+		return result, tx.Mappings.Update(ctx, mapping.ID, repository.Fields{"enabled": enable})
+
+	case bulkTag, bulkUntag:
+		tags, changed := r.retag(mapping.Tags)
+		if !changed {
+			result.Status = bulkSkipped
+			return result, nil
+		}
+		r.changes.Mappings(mapping)
+		return result, tx.Mappings.Update(ctx, mapping.ID, repository.Fields{"tags": tags})
+	}
+	return result, nil
+}
+
+// retag adds or removes the requested tags and returns the new tags column
+// and whether it changed
+func (r *bulkRun) retag(stored string) (string, bool) {
+	tags := decodeTags(stored)
+	has := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		has[tag] = true
+	}
+
+	changed := false
+	if r.req.Operation == bulkTag {
+		for _, tag := range r.tags {
+			if !has[tag] {
+				tags = append(tags, tag)
+				has[tag] = true
+				changed = true
+			}
+		}
+	} else {
+		remove := make(map[string]bool, len(r.tags))
+		for _, tag := range r.tags {
+			remove[tag] = true
+		}
+		kept := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			if remove[tag] {
+				changed = true
+				continue
+			}
+			kept = append(kept, tag)
+		}
+		tags = kept
+	}
+	return encodeTags(tags), changed
+}
+
+// decodeTags reads a tags column. Anything but a JSON array reads as no tags.
+func decodeTags(stored string) []string {
+	var tags []string
+	if err := json.Unmarshal([]byte(stored), &tags); err != nil {
+		return nil
+	}
+	return tags
+}
+
+func encodeTags(tags []string) string {
+	if len(tags) == 0 {
+		return "[]"
+	}
+	encoded, _ := json.Marshal(tags)
+	return string(encoded)
+}
+
+// commitBulk runs a bulk operation in one transaction with one config
+// version bump per affected server. The transaction is rolled back if any
+// item failed or it is a dry run, so a dry run reports exactly what would
+// happen. A committed run publishes BulkFinished.
+func commitBulk(ctx context.Context, store *repository.Store, run *bulkRun, resource string, fn func(tx *repository.Store, affected affectedServers) ([]BulkItemResult, error)) error {
+	return commitChange(ctx, store, func(tx *repository.Store, affected affectedServers) error {
+		results, err := fn(tx, affected)
+		if err != nil {
+			return err
+		}
+		for _, result := range results {
+			if result.Status == bulkFailed {
+				return errBulkRollback
+			}
+		}
+		if run.req.DryRun {
+			// Bump first, so a failing bump shows up in the dry run too
+			if err := affected.bump(ctx, tx); err != nil {
+				return err
+			}
+			return errBulkRollback
+		}
+
+		if err := run.changes.Publish(ctx, tx); err != nil {
+			return err
+		}
+		finished := events.BulkFinished{Resource: resource, Operation: run.req.Operation, Matched: len(results), Actor: run.actor}
+		for _, result := range results {
+			if result.Status == bulkSkipped {
+				finished.Skipped++
+			} else {
+				finished.Succeeded++
+			}
+		}
+		return events.Publish(ctx, tx, finished)
+	})
+}
+
+// respond reports the per-item results. Nothing is committed if any item
+// failed, which is a 422.
+func (h *BulkHandler) respond(c *gin.Context, req BulkRequest, results []BulkItemResult, err error) {
+	if err != nil && !errors.Is(err, errBulkRollback) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Bulk operation failed"})
+		return
+	}
+
+	resp := BulkResponse{
+		Operation: req.Operation,
+		DryRun:    req.DryRun,
+		Committed: err == nil,
+		Matched:   len(results),
+		Results:   results,
+	}
+	if resp.Results == nil {
+		resp.Results = []BulkItemResult{}
+	}
+	for _, result := range results {
+		switch result.Status {
+		case bulkOK:
+			resp.Succeeded++
+		case bulkSkipped:
+			resp.Skipped++
+		case bulkFailed:
+			resp.Failed++
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Failed > 0 && !req.DryRun {
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, resp)
+}
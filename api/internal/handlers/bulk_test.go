@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestBulkEnableDisableProxies(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var first, second ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &first)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p2","type":"http","host":"10.0.0.2","port":8080}`, http.StatusCreated, &second)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80],"upstream_proxy_id":%d}`, serverID, first.ID),
+		http.StatusCreated, nil)
+	version := configVersion(t, store, serverID)
+
+	// Disabling both bumps the server once and leaves them out of its
+	// config, with the mapping routed through p1
+	var resp BulkResponse
+	serve(t, r, http.MethodPost, "/proxies/bulk", fmt.Sprintf(`{"operation":"disable","ids":[%d,%d]}`, first.ID, second.ID), http.StatusOK, &resp)
+	if !resp.Committed || resp.Succeeded != 2 {
+		t.Fatalf("disable: committed %v, succeeded %d", resp.Committed, resp.Succeeded)
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after disable = %d, want %d", got, version+1)
+	}
+	config, err := store.Snapshots.AgentConfig(ctx, serverID, version+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Proxies) != 0 || len(config.Mappings) != 0 {
+		t.Fatalf("config of disabled proxies: %d proxies, %d mappings; want none", len(config.Proxies), len(config.Mappings))
+	}
+
+	// Disabling them again changes nothing
+	serve(t, r, http.MethodPost, "/proxies/bulk", `{"operation":"disable","filter":"disabled=true"}`, http.StatusOK, &resp)
+	if resp.Matched != 2 || resp.Skipped != 2 {
+		t.Fatalf("disable again: matched %d, skipped %d; want both skipped", resp.Matched, resp.Skipped)
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after disabling again = %d, want %d", got, version+1)
+	}
+
+	serve(t, r, http.MethodPost, "/proxies/bulk", fmt.Sprintf(`{"operation":"enable","ids":[%d]}`, first.ID), http.StatusOK, &resp)
+	if !resp.Committed || resp.Succeeded != 1 {
+		t.Fatalf("enable: committed %v, succeeded %d", resp.Committed, resp.Succeeded)
+	}
+	config, err = store.Snapshots.AgentConfig(ctx, serverID, configVersion(t, store, serverID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Proxies) != 1 || len(config.Mappings) != 1 {
+		t.Fatalf("config after enabling p1: %d proxies, %d mappings; want one each", len(config.Proxies), len(config.Mappings))
+	}
+
+	// An expired proxy stays disabled until renewed
+	expired := time.Now().Add(-time.Hour)
+	if err := store.Proxies.Update(ctx, second.ID, repository.Fields{"expires_at": &expired}); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, r, http.MethodPost, "/proxies/bulk", fmt.Sprintf(`{"operation":"enable","ids":[%d]}`, second.ID), http.StatusUnprocessableEntity, &resp)
+	if resp.Committed || resp.Failed != 1 {
+		t.Fatalf("enable expired: committed %v, failed %d", resp.Committed, resp.Failed)
+	}
+	if proxy, err := store.Proxies.Get(ctx, second.ID); err != nil || proxy.DisabledAt == nil {
+		t.Fatalf("expired proxy was enabled: %v", err)
+	}
+}
+
+func TestBulkDryRunRollsBack(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var first, second ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &first)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p2","type":"socks5","host":"10.0.0.2","port":1080}`, http.StatusCreated, &second)
+	version := configVersion(t, store, serverID)
+	published := outboxNames(t, store)
+
+	// A dry run reports what would happen and changes nothing
+	var resp BulkResponse
+	serve(t, r, http.MethodPost, "/proxies/bulk", `{"operation":"set_type","filter":"type=http,socks5","type":"https","dry_run":true}`, http.StatusOK, &resp)
+	if resp.Committed || !resp.DryRun || resp.Matched != 2 || resp.Succeeded != 2 {
+		t.Fatalf("dry run: committed %v, matched %d, succeeded %d", resp.Committed, resp.Matched, resp.Succeeded)
+	}
+	serve(t, r, http.MethodPost, "/proxies/bulk", fmt.Sprintf(`{"operation":"delete","ids":[%d,%d],"dry_run":true}`, first.ID, second.ID), http.StatusOK, &resp)
+	if resp.Committed || resp.Succeeded != 2 {
+		t.Fatalf("dry run delete: committed %v, succeeded %d", resp.Committed, resp.Succeeded)
+	}
+
+	// A failed item reports the others too, but commits none of them
+	serve(t, r, http.MethodPost, "/proxies/bulk", fmt.Sprintf(`{"operation":"set_type","ids":[%d,999],"type":"https"}`, first.ID), http.StatusUnprocessableEntity, &resp)
+	if resp.Committed || resp.Succeeded != 1 || resp.Failed != 1 {
+		t.Fatalf("partly failed run: committed %v, succeeded %d, failed %d", resp.Committed, resp.Succeeded, resp.Failed)
+	}
+
+	proxies, err := store.Proxies.List(ctx, repository.ProxyFilter{ServerID: &serverID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	types := map[string]string{}
+	for _, proxy := range proxies {
+		types[proxy.Label] = proxy.Type
+	}
+	if want := map[string]string{"p1": "http", "p2": "socks5"}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("proxies after rolled back runs = %v, want %v", types, want)
+	}
+	if got := configVersion(t, store, serverID); got != version {
+		t.Fatalf("version after rolled back runs = %d, want %d", got, version)
+	}
+	if got := outboxNames(t, store); !reflect.DeepEqual(got, published) {
+		t.Fatalf("events after rolled back runs = %v, want %v", got, published)
+	}
+	if _, err := store.Trash.Find(ctx, models.TrashProxy, first.ID); err == nil {
+		t.Fatal("dry run delete put the proxy in the trash")
+	}
+}
+
+func TestBulkRecheckHealthIsCapped(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	for i := 0; i <= maxBulkChecks; i++ {
+		proxy := models.Proxy{Label: fmt.Sprintf("p%d", i), Type: "http", Host: "10.0.0.1", Port: 8000 + i, Tags: `["batch"]`}
+		if err := store.Proxies.Create(ctx, &proxy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var failed struct {
+		Error string `json:"error"`
+	}
+	serve(t, r, http.MethodPost, "/proxies/bulk", `{"operation":"recheck_health","filter":"tag=batch"}`, http.StatusBadRequest, &failed)
+	if !strings.Contains(failed.Error, "at most") {
+		t.Fatalf("error = %q, want the limit", failed.Error)
+	}
+}
@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// affectedServers collects the servers whose agent config a change touches
+type affectedServers map[uint]bool
+
+// add marks servers as affected. Nil and zero IDs, such as the server of an
+// unassigned proxy, are skipped.
+func (a affectedServers) add(ids ...*uint) {
+	for _, id := range ids {
+		if id != nil {
+			a.addID(*id)
+		}
+	}
+}
+
+func (a affectedServers) addID(ids ...uint) {
+	for _, id := range ids {
+		if id > 0 {
+			a[id] = true
+		}
+	}
+}
+
+// bump increments the config version of each server once, in ID order so
+// concurrent changes lock the server rows in the same order
+func (a affectedServers) bump(ctx context.Context, tx *repository.Store) error {
+	for _, id := range keys(a) {
+		if err := tx.Servers.BumpConfigVersion(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commitChange runs fn in a transaction and bumps the config version of
+// every server fn marked as affected in the same transaction. Either the
+// change and all its bumps are committed, or none of them.
+func commitChange(ctx context.Context, store *repository.Store, fn func(tx *repository.Store, affected affectedServers) error) error {
+	return store.Transaction(ctx, func(tx *repository.Store) error {
+		affected := make(affectedServers)
+		if err := fn(tx, affected); err != nil {
+			return err
+		}
+		return affected.bump(ctx, tx)
+	})
+}
+
+// keys returns the IDs of a set in ascending order
+func keys(set map[uint]bool) []uint {
+	ids := make([]uint, 0, len(set))
+	for id := range set {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// uniqueIDs returns ids without duplicates
+func uniqueIDs(ids []uint) []uint {
+	set := make(map[uint]bool, len(ids))
+	for _, id := range ids {
+		set[id] = true
+	}
+	return keys(set)
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/rollout"
+	"github.com/gin-gonic/gin"
+)
+
+type ChangeSetHandler struct {
+	store  *repository.Store
+	runner *rollout.Runner
+}
+
+func NewChangeSetHandler(store *repository.Store, runner *rollout.Runner) *ChangeSetHandler {
+	return &ChangeSetHandler{store: store, runner: runner}
+}
+
+type ChangeSetTargetRequest struct {
+	ServerID uint            `json:"server_id" binding:"required"`
+	Wave     int             `json:"wave"`
+	Document json.RawMessage `json:"document" binding:"required"`
+}
+
+type CreateChangeSetRequest struct {
+	Name              string                   `json:"name" binding:"required"`
+	Description       string                   `json:"description"`
+	AckTimeoutSeconds int                      `json:"ack_timeout_seconds"`
+	HealthWaitSeconds int                      `json:"health_wait_seconds"`
+	Targets           []ChangeSetTargetRequest `json:"targets"`
+}
+
+type UpdateChangeSetRequest struct {
+	Name              *string                   `json:"name"`
+	Description       *string                   `json:"description"`
+	AckTimeoutSeconds *int                      `json:"ack_timeout_seconds"`
+	HealthWaitSeconds *int                      `json:"health_wait_seconds"`
+	Targets           *[]ChangeSetTargetRequest `json:"targets"`
+}
+
+// GetChangeSets returns all change sets, newest first (?status= filters)
+func (h *ChangeSetHandler) GetChangeSets(c *gin.Context) {
+	changeSets, err := h.store.ChangeSets.List(c.Request.Context(), c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch change sets"})
+		return
+	}
+
+	c.JSON(http.StatusOK, changeSets)
+}
+
+// GetChangeSet returns a change set with the documents of its targets,
+// their credentials masked
+func (h *ChangeSetHandler) GetChangeSet(c *gin.Context) {
+	changeSet, ok := h.findChangeSet(c)
+	if !ok {
+		return
+	}
+
+	for i := range changeSet.Targets {
+		doc, err := configstate.Parse([]byte(changeSet.Targets[i].Document), "application/json")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid target document"})
+			return
+		}
+		doc.Mask()
+		masked, err := json.Marshal(doc)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid target document"})
+			return
+		}
+		changeSet.Targets[i].DesiredState = json.RawMessage(masked)
+	}
+
+	c.JSON(http.StatusOK, changeSet)
+}
+
+// CreateChangeSet drafts a change set
+func (h *ChangeSetHandler) CreateChangeSet(c *gin.Context) {
+	var req CreateChangeSetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	targets, err := h.buildTargets(c.Request.Context(), req.Targets)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	changeSet := models.ChangeSet{
+		Name:              req.Name,
+		Description:       req.Description,
+		Status:            models.ChangeSetDraft,
+		AckTimeoutSeconds: req.AckTimeoutSeconds,
+		HealthWaitSeconds: req.HealthWaitSeconds,
+		Targets:           targets,
+	}
+	if changeSet.AckTimeoutSeconds <= 0 {
+		changeSet.AckTimeoutSeconds = rollout.DefaultAckTimeoutSeconds
+	}
+	if changeSet.HealthWaitSeconds <= 0 {
+		changeSet.HealthWaitSeconds = rollout.DefaultHealthWaitSeconds
+	}
+
+	if err := h.store.ChangeSets.Create(c.Request.Context(), &changeSet); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create change set"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, changeSet)
+}
+
+// UpdateChangeSet edits a draft change set. Targets, if given, replace the
+// existing ones.
+func (h *ChangeSetHandler) UpdateChangeSet(c *gin.Context) {
+	changeSet, ok := h.findChangeSet(c)
+	if !ok {
+		return
+	}
+
+	if changeSet.Status != models.ChangeSetDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": "Only draft change sets can be edited"})
+		return
+	}
+
+	var req UpdateChangeSetRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	updates := repository.Fields{}
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.AckTimeoutSeconds != nil && *req.AckTimeoutSeconds > 0 {
+		updates["ack_timeout_seconds"] = *req.AckTimeoutSeconds
+	}
+	if req.HealthWaitSeconds != nil && *req.HealthWaitSeconds > 0 {
+		updates["health_wait_seconds"] = *req.HealthWaitSeconds
+	}
+
+	var targets []models.ChangeSetTarget
+	if req.Targets != nil {
+		var err error
+		if targets, err = h.buildTargets(ctx, *req.Targets); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	err := h.store.Transaction(ctx, func(tx *repository.Store) error {
+		if len(updates) > 0 {
+			if err := tx.ChangeSets.Update(ctx, changeSet.ID, updates); err != nil {
+				return err
+			}
+		}
+		if req.Targets == nil {
+			return nil
+		}
+		return tx.ChangeSets.SetTargets(ctx, changeSet.ID, targets)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update change set"})
+		return
+	}
+
+	if updated, err := h.store.ChangeSets.Get(ctx, changeSet.ID); err == nil {
+		changeSet = updated
+	}
+	c.JSON(http.StatusOK, changeSet)
+}
+
+// DeleteChangeSet deletes a change set that is not running
+func (h *ChangeSetHandler) DeleteChangeSet(c *gin.Context) {
+	changeSet, ok := h.findChangeSet(c)
+	if !ok {
+		return
+	}
+
+	if changeSet.Status == models.ChangeSetRunning {
+		c.JSON(http.StatusConflict, gin.H{"error": "Halt the change set before deleting it"})
+		return
+	}
+
+	if err := h.store.ChangeSets.Delete(c.Request.Context(), changeSet.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete change set"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Change set deleted successfully"})
+}
+
+// PlanChangeSet shows the changes each target's document would make now
+func (h *ChangeSetHandler) PlanChangeSet(c *gin.Context) {
+	changeSet, ok := h.findChangeSet(c)
+	if !ok {
+		return
+	}
+
+	plans := make([]gin.H, 0, len(changeSet.Targets))
+	for _, target := range changeSet.Targets {
+		entry := gin.H{"server_id": target.ServerID, "wave": target.Wave}
+
+		doc, err := configstate.Parse([]byte(target.Document), "application/json")
+		if err == nil {
+			err = doc.Open()
+		}
+		if err == nil {
+			var result *configstate.Result
+			if result, err = configstate.PlanServer(c.Request.Context(), h.store, target.ServerID, doc); err == nil {
+				entry["plan"] = result.Plan
+				entry["config_version"] = result.ConfigVersion
+			}
+		}
+		if err != nil {
+			entry["error"] = err.Error()
+		}
+
+		plans = append(plans, entry)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": changeSet.ID, "targets": plans})
+}
+
+// StartChangeSet begins rolling out a draft change set
+func (h *ChangeSetHandler) StartChangeSet(c *gin.Context) {
+	h.transition(c, h.runner.Start)
+}
+
+// HaltChangeSet stops a running change set without rolling it back
+func (h *ChangeSetHandler) HaltChangeSet(c *gin.Context) {
+	h.transition(c, h.runner.Halt)
+}
+
+// RollbackChangeSet restores the servers a halted or completed change set
+// changed to their versions from before it ran
+func (h *ChangeSetHandler) RollbackChangeSet(c *gin.Context) {
+	h.transition(c, h.runner.Rollback)
+}
+
+func (h *ChangeSetHandler) transition(c *gin.Context, op func(context.Context, uint) (*models.ChangeSet, error)) {
+	changeSet, ok := h.findChangeSet(c)
+	if !ok {
+		return
+	}
+
+	updated, err := op(c.Request.Context(), changeSet.ID)
+	switch {
+	case errors.Is(err, rollout.ErrInvalidState):
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Change set is %s", changeSet.Status)})
+		return
+	case err != nil:
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (h *ChangeSetHandler) findChangeSet(c *gin.Context) (*models.ChangeSet, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid change set ID"})
+		return nil, false
+	}
+
+	changeSet, err := h.store.ChangeSets.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Change set not found"})
+		return nil, false
+	}
+
+	return changeSet, true
+}
+
+// buildTargets validates target requests and turns them into pending targets
+func (h *ChangeSetHandler) buildTargets(ctx context.Context, reqs []ChangeSetTargetRequest) ([]models.ChangeSetTarget, error) {
+	targets := make([]models.ChangeSetTarget, 0, len(reqs))
+	seen := make(map[uint]bool)
+
+	for _, req := range reqs {
+		if seen[req.ServerID] {
+			return nil, fmt.Errorf("server %d is listed more than once", req.ServerID)
+		}
+		seen[req.ServerID] = true
+
+		if req.Wave < 0 {
+			return nil, fmt.Errorf("server %d: wave cannot be negative", req.ServerID)
+		}
+
+		if _, err := h.store.Servers.Get(ctx, req.ServerID, false); err != nil {
+			return nil, fmt.Errorf("server %d not found", req.ServerID)
+		}
+
+		doc, err := configstate.Parse(req.Document, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("server %d: %v", req.ServerID, err)
+		}
+		// Store the normalized document, its credentials sealed like those
+		// of proxies
+		if err := doc.Seal(); err != nil {
+			return nil, err
+		}
+		document, err := json.Marshal(doc)
+		if err != nil {
+			return nil, err
+		}
+
+		targets = append(targets, models.ChangeSetTarget{
+			ServerID: req.ServerID,
+			Wave:     req.Wave,
+			Document: string(document),
+			Status:   models.TargetPending,
+		})
+	}
+
+	return targets, nil
+}
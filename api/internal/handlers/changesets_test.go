@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/rollout"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+)
+
+func TestChangeSetDocumentCredentialsAreSealed(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080,"username":"user","password":"secret"}`, http.StatusCreated, nil)
+
+	var changeSet models.ChangeSet
+	serve(t, r, http.MethodPost, "/changesets", fmt.Sprintf(`{"name":"cs","targets":[{"server_id":%d,"document":{
+		"proxies": [{"label": "p1", "type": "http", "host": "10.0.0.1", "port": 8080, "username": "user", "password": "secret"}],
+		"mappings": []
+	}}]}`, serverID), http.StatusCreated, &changeSet)
+
+	stored, err := store.ChangeSets.Targets(ctx, changeSet.ID)
+	if err != nil || len(stored) != 1 {
+		t.Fatalf("targets: %d, %v", len(stored), err)
+	}
+	if strings.Contains(stored[0].Document, "secret") {
+		t.Fatalf("stored document has the password in the clear: %s", stored[0].Document)
+	}
+
+	// Shown masked
+	serve(t, r, http.MethodGet, fmt.Sprintf("/changesets/%d", changeSet.ID), ``, http.StatusOK, &changeSet)
+	var shown configstate.Document
+	if err := json.Unmarshal(changeSet.Targets[0].DesiredState, &shown); err != nil {
+		t.Fatal(err)
+	}
+	if password := shown.Proxies[0].Password; password == nil || *password != secrets.Mask {
+		t.Fatalf("shown password = %v, want the mask", password)
+	}
+
+	// Planned with the opened credentials, which match the proxy's
+	var plan struct {
+		Targets []struct {
+			Plan  configstate.Plan `json:"plan"`
+			Error string           `json:"error"`
+		} `json:"targets"`
+	}
+	serve(t, r, http.MethodGet, fmt.Sprintf("/changesets/%d/plan", changeSet.ID), ``, http.StatusOK, &plan)
+	if len(plan.Targets) != 1 || plan.Targets[0].Error != "" || !plan.Targets[0].Plan.Empty() {
+		t.Fatalf("plan: %+v", plan.Targets)
+	}
+}
+
+// newTestUpstream listens on loopback and accepts connections, so a proxy
+// pointed at it passes a health check, and returns its port
+func newTestUpstream(t *testing.T) int {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// newRolloutServer creates a server with a proxy p1 at port on loopback
+func newRolloutServer(t *testing.T, r http.Handler, name string, port int) uint {
+	t.Helper()
+
+	var server ServerResponse
+	serve(t, r, http.MethodPost, "/servers", fmt.Sprintf(`{"name":%q}`, name), http.StatusCreated, &server)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", server.ID),
+		fmt.Sprintf(`{"label":"p1","type":"http","host":"127.0.0.1","port":%d}`, port), http.StatusCreated, nil)
+	return server.ID
+}
+
+// rolloutTarget returns a target document for a server that keeps p1 and
+// adds p2, both at port on loopback
+func rolloutTarget(serverID uint, wave, port int) string {
+	return fmt.Sprintf(`{"server_id":%d,"wave":%d,"document":{"proxies":[
+		{"label":"p1","type":"http","host":"127.0.0.1","port":%d},
+		{"label":"p2","type":"http","host":"127.0.0.1","port":%d}
+	],"mappings":[]}}`, serverID, wave, port, port)
+}
+
+// startRollout drafts a change set of targets through the API and starts it
+func startRollout(t *testing.T, r http.Handler, runner *rollout.Runner, targets ...string) uint {
+	t.Helper()
+
+	var changeSet models.ChangeSet
+	serve(t, r, http.MethodPost, "/changesets", fmt.Sprintf(`{"name":"cs","ack_timeout_seconds":300,"health_wait_seconds":60,"targets":[%s]}`,
+		strings.Join(targets, ",")), http.StatusCreated, &changeSet)
+	if _, err := runner.Start(context.Background(), changeSet.ID); err != nil {
+		t.Fatal(err)
+	}
+	return changeSet.ID
+}
+
+// step advances the rollouts at now
+func step(t *testing.T, runner *rollout.Runner, now time.Time) {
+	t.Helper()
+
+	if err := runner.Step(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// ackTarget has the agent of a server ack the version its target applied
+func ackTarget(t *testing.T, store *repository.Store, target models.ChangeSetTarget, at time.Time) {
+	t.Helper()
+
+	if err := store.Servers.Update(context.Background(), target.ServerID, repository.Fields{
+		"applied_version": target.AppliedVersion,
+		"applied_status":  "ok",
+		"applied_at":      &at,
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// rolloutState returns a change set and its targets by server ID
+func rolloutState(t *testing.T, store *repository.Store, id uint) (*models.ChangeSet, map[uint]models.ChangeSetTarget) {
+	t.Helper()
+
+	ctx := context.Background()
+	changeSet, err := store.ChangeSets.Get(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets, err := store.ChangeSets.Targets(ctx, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byServer := make(map[uint]models.ChangeSetTarget, len(targets))
+	for _, target := range targets {
+		byServer[target.ServerID] = target
+	}
+	return changeSet, byServer
+}
+
+// newTestRunner returns a rollout runner whose health checks only connect
+func newTestRunner(store *repository.Store) *rollout.Runner {
+	checker := healthcheck.New()
+	checker.Timeout = 2 * time.Second
+	return rollout.New(store, checker)
+}
+
+func TestRolloutWaitsForAckAndHealthBeforeNextWave(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	runner := newTestRunner(store)
+	port := newTestUpstream(t)
+
+	canary := newRolloutServer(t, r, "edge-1", port)
+	rest := newRolloutServer(t, r, "edge-2", port)
+	id := startRollout(t, r, runner, rolloutTarget(canary, 0, port), rolloutTarget(rest, 1, port))
+
+	now := time.Now()
+	step(t, runner, now)
+	changeSet, targets := rolloutState(t, store, id)
+	if targets[canary].Status != models.TargetApplied || targets[rest].Status != models.TargetPending {
+		t.Fatalf("after the first step: canary %s, rest %s", targets[canary].Status, targets[rest].Status)
+	}
+
+	// Acked, but the health wait is not over
+	ackTarget(t, store, targets[canary], now.Add(10*time.Second))
+	step(t, runner, now.Add(20*time.Second))
+	changeSet, targets = rolloutState(t, store, id)
+	if targets[canary].Status != models.TargetApplied || targets[canary].AckedAt == nil || changeSet.CurrentWave != 0 {
+		t.Fatalf("during the health wait: canary %s, acked at %v, wave %d", targets[canary].Status, targets[canary].AckedAt, changeSet.CurrentWave)
+	}
+
+	// The canary's proxies stayed healthy, so the next wave is applied
+	step(t, runner, now.Add(71*time.Second))
+	changeSet, targets = rolloutState(t, store, id)
+	if targets[canary].Status != models.TargetSucceeded || targets[rest].Status != models.TargetApplied || changeSet.CurrentWave != 1 {
+		t.Fatalf("after the health wait: canary %s, rest %s, wave %d", targets[canary].Status, targets[rest].Status, changeSet.CurrentWave)
+	}
+
+	// Checked by the rollout, not by the periodic health checks
+	proxies, err := store.Proxies.List(context.Background(), repository.ProxyFilter{ServerID: &canary})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, proxy := range proxies {
+		if proxy.Health != healthcheck.HealthOK {
+			t.Fatalf("proxy %s health = %s, want it checked", proxy.Label, proxy.Health)
+		}
+	}
+}
+
+func TestRolloutFailsUnhealthyCanary(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	runner := newTestRunner(store)
+	port := newTestUpstream(t)
+
+	serverID := newRolloutServer(t, r, "edge-1", port)
+	// p2 points at a port nothing listens on, refusing connections
+	broken := fmt.Sprintf(`{"server_id":%d,"wave":0,"document":{"proxies":[
+		{"label":"p1","type":"http","host":"127.0.0.1","port":%d},
+		{"label":"p2","type":"http","host":"127.0.0.1","port":1}
+	],"mappings":[]}}`, serverID, port)
+	id := startRollout(t, r, runner, broken)
+
+	now := time.Now()
+	step(t, runner, now)
+	_, targets := rolloutState(t, store, id)
+	ackTarget(t, store, targets[serverID], now.Add(10*time.Second))
+	step(t, runner, now.Add(20*time.Second))
+
+	changeSet, targets := rolloutState(t, store, id)
+	if changeSet.Status != models.ChangeSetRolledBack || targets[serverID].Status != models.TargetRolledBack {
+		t.Fatalf("change set %s, target %s; want both rolled back", changeSet.Status, targets[serverID].Status)
+	}
+	if !strings.Contains(changeSet.Error, "failing health checks") {
+		t.Fatalf("change set error = %q", changeSet.Error)
+	}
+}
+
+func TestRolloutAckTimeoutRollsBackEarlierWaves(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	runner := newTestRunner(store)
+	ctx := context.Background()
+	port := newTestUpstream(t)
+
+	canary := newRolloutServer(t, r, "edge-1", port)
+	rest := newRolloutServer(t, r, "edge-2", port)
+	id := startRollout(t, r, runner, rolloutTarget(canary, 0, port), rolloutTarget(rest, 1, port))
+
+	now := time.Now()
+	step(t, runner, now)
+	_, targets := rolloutState(t, store, id)
+	ackTarget(t, store, targets[canary], now.Add(10*time.Second))
+	step(t, runner, now.Add(71*time.Second))
+
+	// The second wave is never acked
+	step(t, runner, now.Add(71*time.Second+301*time.Second))
+	changeSet, targets := rolloutState(t, store, id)
+	if changeSet.Status != models.ChangeSetRolledBack || !strings.Contains(changeSet.Error, "did not ack") {
+		t.Fatalf("change set %s, error %q; want rolled back for the missing ack", changeSet.Status, changeSet.Error)
+	}
+	for _, serverID := range []uint{canary, rest} {
+		if targets[serverID].Status != models.TargetRolledBack {
+			t.Fatalf("server %d target %s, want rolled back", serverID, targets[serverID].Status)
+		}
+		proxies, err := store.Proxies.List(ctx, repository.ProxyFilter{ServerID: &serverID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proxies) != 1 || proxies[0].Label != "p1" {
+			t.Fatalf("server %d has %d proxies after the rollback, want p1 alone", serverID, len(proxies))
+		}
+	}
+}
+
+func TestRolloutRollbackKeepsLaterChanges(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	runner := newTestRunner(store)
+	ctx := context.Background()
+	port := newTestUpstream(t)
+
+	serverID := newRolloutServer(t, r, "edge-1", port)
+	id := startRollout(t, r, runner, rolloutTarget(serverID, 0, port))
+
+	now := time.Now()
+	step(t, runner, now)
+	_, targets := rolloutState(t, store, id)
+	ackTarget(t, store, targets[serverID], now.Add(10*time.Second))
+	step(t, runner, now.Add(71*time.Second))
+
+	// Someone changes the server after the rollout completed
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		fmt.Sprintf(`{"label":"p3","type":"http","host":"127.0.0.1","port":%d}`, port), http.StatusCreated, nil)
+	before := configVersion(t, store, serverID)
+
+	if _, err := runner.Rollback(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	changeSet, targets := rolloutState(t, store, id)
+	if !strings.Contains(targets[serverID].Error, configstate.ErrVersionConflict.Error()) || !strings.Contains(changeSet.Error, "rollback failed") {
+		t.Fatalf("target error %q, change set error %q; want the conflict", targets[serverID].Error, changeSet.Error)
+	}
+	if after := configVersion(t, store, serverID); after != before {
+		t.Fatalf("config version went from %d to %d; want the server left alone", before, after)
+	}
+}
+
+func TestRolloutSkipsServerInMaintenance(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	runner := newTestRunner(store)
+	ctx := context.Background()
+	port := newTestUpstream(t)
+
+	serverID := newRolloutServer(t, r, "edge-1", port)
+	if err := store.Servers.Update(ctx, serverID, repository.Fields{"service_state": models.ServiceMaintenance}); err != nil {
+		t.Fatal(err)
+	}
+	before := configVersion(t, store, serverID)
+	id := startRollout(t, r, runner, rolloutTarget(serverID, 0, port))
+
+	step(t, runner, time.Now())
+	changeSet, targets := rolloutState(t, store, id)
+	if targets[serverID].Status != models.TargetSkipped || changeSet.Status != models.ChangeSetCompleted {
+		t.Fatalf("target %s, change set %s; want skipped and completed", targets[serverID].Status, changeSet.Status)
+	}
+	if after := configVersion(t, store, serverID); after != before {
+		t.Fatalf("config version went from %d to %d; want the server left alone", before, after)
+	}
+}
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
+	"github.com/gin-gonic/gin"
+)
+
+// Force modes for deleting proxies that mappings or schedules still use
+const (
+	forceDisable = "disable" // dependents are disabled and lose their upstream
+	forceRemove  = "remove"  // dependents are deleted
+)
+
+// errHasDependents aborts a delete that needs force
+var errHasDependents = errors.New("proxy is still in use")
+
+// DependentsResponse lists what uses the proxies being deleted
+type DependentsResponse struct {
+	Mappings  []MappingRef  `json:"mappings"`
+	Schedules []ScheduleRef `json:"schedules"` // switching to one of the proxies
+}
+
+// dependents are the mappings and schedules that use a set of proxies
+type dependents struct {
+	mappings  []models.Mapping
+	schedules []models.MappingSchedule
+	servers   affectedServers // servers whose config the dependents are part of
+}
+
+// forceMode reads the force query parameter. It is empty if not given and
+// false if invalid.
+func forceMode(c *gin.Context) (string, bool) {
+	switch force := c.Query("force"); force {
+	case "", forceDisable, forceRemove:
+		return force, true
+	}
+	return "", false
+}
+
+func findDependents(ctx context.Context, store *repository.Store, proxyIDs []uint) (*dependents, error) {
+	d := &dependents{servers: make(affectedServers)}
+
+	var err error
+	if d.mappings, err = store.Mappings.List(ctx, repository.MappingFilter{UpstreamProxyIDs: proxyIDs}); err != nil {
+		return nil, err
+	}
+	if d.schedules, err = store.Schedules.List(ctx, repository.ScheduleFilter{UpstreamProxyIDs: proxyIDs}); err != nil {
+		return nil, err
+	}
+
+	for _, mapping := range d.mappings {
+		d.servers.addID(mapping.ServerID)
+	}
+	if len(d.schedules) > 0 {
+		mappingIDs := make([]uint, 0, len(d.schedules))
+		for _, schedule := range d.schedules {
+			mappingIDs = append(mappingIDs, schedule.MappingID)
+		}
+		mappings, err := store.Mappings.List(ctx, repository.MappingFilter{IDs: mappingIDs})
+		if err != nil {
+			return nil, err
+		}
+		for _, mapping := range mappings {
+			d.servers.addID(mapping.ServerID)
+		}
+	}
+	return d, nil
+}
+
+func (d *dependents) empty() bool {
+	return len(d.mappings) == 0 && len(d.schedules) == 0
+}
+
+func (d *dependents) response() DependentsResponse {
+	resp := DependentsResponse{
+		Mappings:  make([]MappingRef, 0, len(d.mappings)),
+		Schedules: make([]ScheduleRef, 0, len(d.schedules)),
+	}
+	for _, m := range d.mappings {
+		resp.Mappings = append(resp.Mappings, MappingRef{ID: m.ID, ServerID: m.ServerID, ClientCIDR: m.ClientCIDR, Enabled: m.Enabled})
+	}
+	for _, s := range d.schedules {
+		resp.Schedules = append(resp.Schedules, ScheduleRef{ID: s.ID, MappingID: s.MappingID, Name: s.Name, Action: s.Action})
+	}
+	return resp
+}
+
+// release disables or removes the dependents, so the proxies they use can
+// be deleted, and marks their servers as affected. If cascade is not nil,
+// what was released is recorded there for a restore from the trash. The
+// mappings are recorded in changes.
+func (d *dependents) release(ctx context.Context, tx *repository.Store, force string, affected affectedServers, cascade *trash.Cascade, changes *events.Changes) error {
+	mappingIDs := make([]uint, 0, len(d.mappings))
+	for _, mapping := range d.mappings {
+		mappingIDs = append(mappingIDs, mapping.ID)
+	}
+	scheduleIDs := make([]uint, 0, len(d.schedules))
+	for _, schedule := range d.schedules {
+		scheduleIDs = append(scheduleIDs, schedule.ID)
+	}
+
+	switch force {
+	case forceDisable:
+		if len(mappingIDs) > 0 {
+			if err := tx.Mappings.UpdateMany(ctx, mappingIDs, repository.Fields{"upstream_proxy_id": nil, "enabled": false}); err != nil {
+				return err
+			}
+		}
+		if len(scheduleIDs) > 0 {
+			if err := tx.Schedules.UpdateMany(ctx, scheduleIDs, repository.Fields{"upstream_proxy_id": nil, "enabled": false, "active": false}); err != nil {
+				return err
+			}
+		}
+		if cascade != nil {
+			for _, mapping := range d.mappings {
+				cascade.Released = append(cascade.Released, trash.ReleasedMapping{ID: mapping.ID, UpstreamProxyID: *mapping.UpstreamProxyID, Enabled: mapping.Enabled})
+			}
+			for _, schedule := range d.schedules {
+				cascade.ReleasedSchedules = append(cascade.ReleasedSchedules, trash.ReleasedSchedule{ID: schedule.ID, UpstreamProxyID: *schedule.UpstreamProxyID, Enabled: schedule.Enabled})
+			}
+		}
+	case forceRemove:
+		if len(scheduleIDs) > 0 {
+			if err := tx.Schedules.DeleteMany(ctx, scheduleIDs); err != nil {
+				return err
+			}
+		}
+		if cascade == nil {
+			cascade = &trash.Cascade{}
+		}
+		cascade.Schedules = append(cascade.Schedules, scheduleIDs...)
+		for _, id := range mappingIDs {
+			if err := trash.DeleteMapping(ctx, tx, id, cascade); err != nil {
+				return err
+			}
+		}
+		cascade.Mappings = append(cascade.Mappings, mappingIDs...)
+	default:
+		if !d.empty() {
+			return errHasDependents
+		}
+	}
+
+	changes.Mappings(d.mappings...)
+	for id := range d.servers {
+		affected[id] = true
+	}
+	return nil
+}
@@ -1,33 +1,36 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
 	
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
 )
 
 type GroupHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewGroupHandler(db *database.DB) *GroupHandler {
-	return &GroupHandler{db: db}
+func NewGroupHandler(store *repository.Store) *GroupHandler {
+	return &GroupHandler{store: store}
 }
 
 // GET /groups
 func (h *GroupHandler) GetGroups(c *gin.Context) {
-	var groups []models.ProxyGroup
-	
-	if err := h.db.Preload("Proxies").Find(&groups).Error; err != nil {
+	groups, err := h.store.Groups.List(c.Request.Context(), true)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch groups"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, groups)
+	c.JSON(http.StatusOK, newGroupResponses(groups, true))
 }
 
 // POST /groups
@@ -39,12 +42,12 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 		return
 	}
 	
-	if err := h.db.Create(&group).Error; err != nil {
+	if err := h.store.Groups.Create(c.Request.Context(), &group); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create group"})
 		return
 	}
 	
-	c.JSON(http.StatusCreated, group)
+	c.JSON(http.StatusCreated, newGroupResponse(group, false))
 }
 
 // PUT /groups/:id
@@ -55,8 +58,8 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 		return
 	}
 	
-	var group models.ProxyGroup
-	if err := h.db.First(&group, id).Error; err != nil {
+	group, err := h.store.Groups.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 		return
 	}
@@ -70,12 +73,12 @@ func (h *GroupHandler) UpdateGroup(c *gin.Context) {
 	group.Name = updateData.Name
 	group.Description = updateData.Description
 	
-	if err := h.db.Save(&group).Error; err != nil {
+	if err := h.store.Groups.Save(c.Request.Context(), group); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update group"})
 		return
 	}
 	
-	c.JSON(http.StatusOK, group)
+	c.JSON(http.StatusOK, newGroupResponse(*group, false))
 }
 
 // DELETE /groups/:id
@@ -86,25 +89,151 @@ func (h *GroupHandler) DeleteGroup(c *gin.Context) {
 		return
 	}
 	
+	force, ok := forceMode(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "force must be disable or remove"})
+		return
+	}
+	
 	// Check if group exists
-	var group models.ProxyGroup
-	if err := h.db.First(&group, id).Error; err != nil {
+	ctx := c.Request.Context()
+	group, err := h.store.Groups.Get(ctx, uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 		return
 	}
 	
-	// Check if group has proxies
-	var proxyCount int64
-	h.db.Model(&models.Proxy{}).Where("group_id = ?", id).Count(&proxyCount)
-	if proxyCount > 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot delete group with proxies. Move proxies to another group first."})
+	// Quarantine policies and alert rules naming the group are not changed
+	// behind the user's back: without its group an alert rule would cover
+	// every proxy. force=disable takes the group's proxies out of it, and
+	// force=remove deletes them with the group, disabling or removing the
+	// mappings that use them.
+	groupID := uint(id)
+	var proxies []models.Proxy
+	var deps *dependents
+	var refs groupRefs
+	err = commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		var err error
+		if refs, err = findGroupRefs(ctx, tx, groupID); err != nil {
+			return err
+		}
+		if !refs.empty() {
+			return errGroupInUse
+		}
+		if proxies, err = tx.Proxies.List(ctx, repository.ProxyFilter{GroupID: &groupID}); err != nil {
+			return err
+		}
+		proxyIDs := make([]uint, 0, len(proxies))
+		for _, proxy := range proxies {
+			proxyIDs = append(proxyIDs, proxy.ID)
+		}
+		if deps, err = findDependents(ctx, tx, proxyIDs); err != nil {
+			return err
+		}
+		if len(proxies) > 0 && force == "" {
+			return errHasDependents
+		}
+
+		changes := events.NewChanges(c.GetString("email"))
+		changes.Proxies(proxies...)
+		var cascade trash.Cascade
+		for _, proxy := range proxies {
+			affected.add(proxy.ServerID)
+		}
+		switch force {
+		case forceDisable:
+			if len(proxyIDs) > 0 {
+				if err := tx.Proxies.UpdateMany(ctx, proxyIDs, repository.Fields{"group_id": nil}); err != nil {
+					return err
+				}
+			}
+			cascade.Ungrouped = proxyIDs
+		case forceRemove:
+			if err := deps.release(ctx, tx, force, affected, &cascade, changes); err != nil {
+				return err
+			}
+			for _, proxy := range proxies {
+				if err := tx.Proxies.Delete(ctx, proxy.ID); err != nil {
+					return err
+				}
+				cascade.Proxies = append(cascade.Proxies, proxy.ID)
+			}
+		}
+		if err := tx.Groups.Delete(ctx, groupID); err != nil {
+			return err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashGroup, ResourceID: groupID, Label: group.Name, DeletedBy: c.GetString("email")}
+		if err := trash.Record(ctx, tx, entry, &cascade); err != nil {
+			return err
+		}
+		return changes.Publish(ctx, tx)
+	})
+	if errors.Is(err, errGroupInUse) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":               "Group is used by quarantine policies or alert rules. Change or delete them first.",
+			"quarantine_policies": refs.policies,
+			"alert_rules":         refs.rules,
+		})
 		return
 	}
-	
-	if err := h.db.Delete(&group).Error; err != nil {
+	if errors.Is(err, errHasDependents) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Cannot delete group with proxies. Move proxies to another group first, or delete the group with force=disable to keep them without a group or force=remove to delete them.",
+			"proxies":    newProxyRefs(proxies),
+			"dependents": deps.response(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete group"})
 		return
 	}
-	
-	c.JSON(http.StatusOK, gin.H{"message": "Group deleted successfully"})
+
+	resp := gin.H{"message": "Group deleted successfully"}
+	if len(proxies) > 0 {
+		resp["force"] = force
+		resp["proxies"] = newProxyRefs(proxies)
+		if force == forceRemove {
+			resp["dependents"] = deps.response()
+		}
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// errGroupInUse aborts deleting a group that policies or rules still name
+var errGroupInUse = errors.New("group is still in use")
+
+// groupRefs are the quarantine policies and alert rules that name a group
+type groupRefs struct {
+	policies []RuleRef
+	rules    []RuleRef
+}
+
+func findGroupRefs(ctx context.Context, store *repository.Store, groupID uint) (groupRefs, error) {
+	refs := groupRefs{policies: []RuleRef{}, rules: []RuleRef{}}
+	names := func(id *uint) bool { return id != nil && *id == groupID }
+
+	policies, err := store.QuarantinePolicies.List(ctx)
+	if err != nil {
+		return refs, err
+	}
+	for _, policy := range policies {
+		if names(policy.GroupID) || names(policy.FallbackGroupID) {
+			refs.policies = append(refs.policies, RuleRef{ID: policy.ID, Name: policy.Name})
+		}
+	}
+	rules, err := store.AlertRules.List(ctx)
+	if err != nil {
+		return refs, err
+	}
+	for _, rule := range rules {
+		if names(rule.GroupID) {
+			refs.rules = append(refs.rules, RuleRef{ID: rule.ID, Name: rule.Name})
+		}
+	}
+	return refs, nil
+}
+
+func (r groupRefs) empty() bool {
+	return len(r.policies) == 0 && len(r.rules) == 0
 }
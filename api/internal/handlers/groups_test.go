@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestDeleteGroupForceDisableKeepsProxies(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var group GroupResponse
+	serve(t, r, http.MethodPost, "/groups", `{"name":"dc"}`, http.StatusCreated, &group)
+	var proxy ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &proxy)
+	if err := store.Proxies.Update(ctx, proxy.ID, repository.Fields{"group_id": group.ID}); err != nil {
+		t.Fatal(err)
+	}
+	var mapping MappingResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80],"upstream_proxy_id":%d}`, serverID, proxy.ID),
+		http.StatusCreated, &mapping)
+
+	// Refused while an alert rule names the group, even with force
+	rule := models.AlertRule{Name: "dc failing", Kind: "group_failing", GroupID: &group.ID, Threshold: 50}
+	if err := store.AlertRules.Create(ctx, &rule); err != nil {
+		t.Fatal(err)
+	}
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/groups/%d?force=disable", group.ID), ``, http.StatusConflict, nil)
+	if err := store.AlertRules.Delete(ctx, rule.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Refused without force while it has proxies
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/groups/%d", group.ID), ``, http.StatusConflict, nil)
+
+	// force=disable keeps the proxy, without a group, and its mapping
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/groups/%d?force=disable", group.ID), ``, http.StatusOK, nil)
+	if _, err := store.Groups.Get(ctx, group.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("deleted group: got %v, want ErrNotFound", err)
+	}
+	stored, err := store.Proxies.Get(ctx, proxy.ID)
+	if err != nil || stored.GroupID != nil {
+		t.Fatalf("proxy after force=disable: %+v, %v", stored, err)
+	}
+	storedMapping, err := store.Mappings.Get(ctx, mapping.ID)
+	if err != nil || !storedMapping.Enabled || storedMapping.UpstreamProxyID == nil {
+		t.Fatalf("mapping after force=disable: %+v, %v", storedMapping, err)
+	}
+
+	// Restoring the group takes the proxy back into it
+	serve(t, r, http.MethodPost, fmt.Sprintf("/trash/group/%d/restore", group.ID), ``, http.StatusOK, nil)
+	stored, err = store.Proxies.Get(ctx, proxy.ID)
+	if err != nil || stored.GroupID == nil || *stored.GroupID != group.ID {
+		t.Fatalf("proxy after restore: %+v, %v", stored, err)
+	}
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/rollout"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/gin-gonic/gin"
+)
+
+// newTestStore returns a store on a fresh SQLite database in memory
+func newTestStore(t *testing.T) *repository.Store {
+	t.Helper()
+
+	keyring, err := secrets.NewKeyring(bytes.Repeat([]byte{1}, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secrets.Configure(keyring, false)
+
+	db, err := database.Connect(&config.Config{
+		DatabaseURL:    "sqlite://:memory:",
+		MigrateOnStart: true,
+		AdminEmail:     "admin@example.com",
+		AdminPassword:  "admin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+
+	return repository.New(db)
+}
+
+// newTestRouter serves the server, group, proxy, mapping, bulk, state,
+// trash and change set routes of the API, to a user without a role
+func newTestRouter(store *repository.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+
+	serverHandler := NewServerHandler(store)
+	groupHandler := NewGroupHandler(store)
+	proxyHandler := NewProxyHandler(store)
+	mappingHandler := NewMappingHandler(store)
+	stateHandler := NewStateHandler(store)
+	trashHandler := NewTrashHandler(store, 0)
+	changeSetHandler := NewChangeSetHandler(store, rollout.New(store, healthcheck.New()))
+	bulkHandler := NewBulkHandler(store, healthcheck.New())
+
+	r.POST("/servers", serverHandler.CreateServer)
+	r.POST("/groups", groupHandler.CreateGroup)
+	r.DELETE("/groups/:id", groupHandler.DeleteGroup)
+	r.POST("/servers/:id/proxies", proxyHandler.CreateServerProxy)
+	r.POST("/servers/:id/mappings", mappingHandler.CreateServerMapping)
+	r.POST("/proxies/bulk", bulkHandler.BulkProxies)
+	r.POST("/mappings/bulk", bulkHandler.BulkMappings)
+	r.PATCH("/proxies/:id", proxyHandler.UpdateProxy)
+	r.DELETE("/proxies/:id", proxyHandler.DeleteProxy)
+	r.PATCH("/mappings/:id", mappingHandler.UpdateMapping)
+	r.DELETE("/mappings/:id", mappingHandler.DeleteMapping)
+	r.GET("/servers/:id/state", stateHandler.GetServerState)
+	r.POST("/servers/:id/apply", stateHandler.ApplyServerState)
+	r.POST("/trash/:type/:id/restore", trashHandler.RestoreTrashItem)
+	r.POST("/changesets", changeSetHandler.CreateChangeSet)
+	r.GET("/changesets/:id", changeSetHandler.GetChangeSet)
+	r.GET("/changesets/:id/plan", changeSetHandler.PlanChangeSet)
+
+	return r
+}
+
+// serve sends a request with a JSON body to the router. It fails the test
+// unless the response has the wanted status, and decodes the response into
+// out if it is not nil.
+func serve(t *testing.T, r http.Handler, method, path, body string, want int, out interface{}) {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	if w.Code != want {
+		t.Fatalf("%s %s: status %d, want %d: %s", method, path, w.Code, want, w.Body.String())
+	}
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+	}
+}
+
+// createServer creates a server through the API and returns its ID
+func createServer(t *testing.T, r http.Handler) uint {
+	t.Helper()
+
+	var server ServerResponse
+	serve(t, r, http.MethodPost, "/servers", `{"name":"edge-1"}`, http.StatusCreated, &server)
+	return server.ID
+}
+
+// configVersion returns a server's config version and checks that the
+// version has a snapshot
+func configVersion(t *testing.T, store *repository.Store, serverID uint) int {
+	t.Helper()
+
+	ctx := context.Background()
+	server, err := store.Servers.Get(ctx, serverID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Snapshots.Get(ctx, serverID, server.ConfigVersion); err != nil {
+		t.Fatalf("no snapshot of version %d: %v", server.ConfigVersion, err)
+	}
+	return server.ConfigVersion
+}
+
+// outboxNames returns the names of the events published so far, in order
+func outboxNames(t *testing.T, store *repository.Store) []string {
+	t.Helper()
+
+	outbox, err := store.Outbox.Due(context.Background(), time.Now().Add(time.Hour), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0, len(outbox))
+	for _, event := range outbox {
+		names = append(names, event.Name)
+	}
+	return names
+}
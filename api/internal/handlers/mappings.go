@@ -5,17 +5,19 @@ import (
 	"net/http"
 	"strconv"
 
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
 	"github.com/gin-gonic/gin"
 )
 
 type MappingHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewMappingHandler(db *database.DB) *MappingHandler {
-	return &MappingHandler{db: db}
+func NewMappingHandler(store *repository.Store) *MappingHandler {
+	return &MappingHandler{store: store}
 }
 
 type CreateMappingRequest struct {
@@ -44,41 +46,40 @@ func (h *MappingHandler) GetServerMappings(c *gin.Context) {
 	}
 
 	// Verify server exists
-	var server models.Server
-	if err := h.db.First(&server, serverID).Error; err != nil {
+	if _, err := h.store.Servers.Get(c.Request.Context(), uint(serverID), false); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	var mappings []models.Mapping
-	result := h.db.Preload("UpstreamProxy").Where("server_id = ?", serverID).Find(&mappings)
-	if result.Error != nil {
+	id := uint(serverID)
+	mappings, err := h.store.Mappings.List(c.Request.Context(), repository.MappingFilter{ServerID: &id})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mappings"})
 		return
 	}
 
-	c.JSON(http.StatusOK, mappings)
+	c.JSON(http.StatusOK, newMappingResponses(mappings))
 }
 
 // GetMappings returns all mappings (optional: can filter by server)
 func (h *MappingHandler) GetMappings(c *gin.Context) {
-	var mappings []models.Mapping
-	query := h.db.Preload("Server").Preload("UpstreamProxy")
+	var filter repository.MappingFilter
 	
 	// Optional server filter
 	if serverID := c.Query("server_id"); serverID != "" {
 		if id, err := strconv.ParseUint(serverID, 10, 32); err == nil {
-			query = query.Where("server_id = ?", id)
+			serverID := uint(id)
+			filter.ServerID = &serverID
 		}
 	}
 
-	result := query.Find(&mappings)
-	if result.Error != nil {
+	mappings, err := h.store.Mappings.List(c.Request.Context(), filter)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mappings"})
 		return
 	}
 
-	c.JSON(http.StatusOK, mappings)
+	c.JSON(http.StatusOK, newMappingResponses(mappings))
 }
 
 // GetMapping returns a single mapping by ID
@@ -89,14 +90,13 @@ func (h *MappingHandler) GetMapping(c *gin.Context) {
 		return
 	}
 
-	var mapping models.Mapping
-	result := h.db.Preload("Server").Preload("UpstreamProxy").First(&mapping, id)
-	if result.Error != nil {
+	mapping, err := h.store.Mappings.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, mapping)
+	c.JSON(http.StatusOK, newMappingResponse(*mapping))
 }
 
 // CreateServerMapping creates a new mapping for a specific server
@@ -117,15 +117,13 @@ func (h *MappingHandler) CreateServerMapping(c *gin.Context) {
 	req.ServerID = uint(serverID)
 
 	// Verify server exists
-	var server models.Server
-	if err := h.db.First(&server, serverID).Error; err != nil {
+	if _, err := h.store.Servers.Get(c.Request.Context(), uint(serverID), false); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
 	// Verify upstream proxy exists and belongs to the same server
-	var proxy models.Proxy
-	if err := h.db.Where("id = ? AND server_id = ?", req.UpstreamProxyID, req.ServerID).First(&proxy).Error; err != nil {
+	if !proxyOnServer(c, h.store, req.UpstreamProxyID, req.ServerID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Upstream proxy not found or belongs to different server"})
 		return
 	}
@@ -160,22 +158,26 @@ func (h *MappingHandler) CreateServerMapping(c *gin.Context) {
 		ServerID:        req.ServerID,
 		ClientCIDR:      req.ClientCIDR,
 		DstPorts:        string(dstPortsJSON),
-		UpstreamProxyID: req.UpstreamProxyID,
+		UpstreamProxyID: &req.UpstreamProxyID,
 		Enabled:         enabled,
 		Notes:           req.Notes,
 	}
 
-	if err := h.db.Create(&mapping).Error; err != nil {
+	// Create the mapping and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.addID(req.ServerID)
+		if err := tx.Mappings.Create(c.Request.Context(), &mapping); err != nil {
+			return err
+		}
+		return events.Publish(c.Request.Context(), tx, events.MappingCreated{Mapping: events.NewMappingState(mapping), Actor: c.GetString("email")})
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping"})
 		return
 	}
 
-	// Increment config version for the server
-	h.db.IncrementConfigVersion(req.ServerID)
-
 	// Reload mapping with relations
-	h.db.Preload("Server").Preload("UpstreamProxy").First(&mapping, mapping.ID)
-	c.JSON(http.StatusCreated, mapping)
+	h.respondWithMapping(c, http.StatusCreated, mapping.ID)
 }
 
 // CreateMapping creates a new mapping (requires server_id in body)
@@ -187,15 +189,13 @@ func (h *MappingHandler) CreateMapping(c *gin.Context) {
 	}
 
 	// Verify server exists
-	var server models.Server
-	if err := h.db.First(&server, req.ServerID).Error; err != nil {
+	if _, err := h.store.Servers.Get(c.Request.Context(), req.ServerID, false); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
 	// Verify upstream proxy exists and belongs to the same server
-	var proxy models.Proxy
-	if err := h.db.Where("id = ? AND server_id = ?", req.UpstreamProxyID, req.ServerID).First(&proxy).Error; err != nil {
+	if !proxyOnServer(c, h.store, req.UpstreamProxyID, req.ServerID) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Upstream proxy not found or belongs to different server"})
 		return
 	}
@@ -230,22 +230,26 @@ func (h *MappingHandler) CreateMapping(c *gin.Context) {
 		ServerID:        req.ServerID,
 		ClientCIDR:      req.ClientCIDR,
 		DstPorts:        string(dstPortsJSON),
-		UpstreamProxyID: req.UpstreamProxyID,
+		UpstreamProxyID: &req.UpstreamProxyID,
 		Enabled:         enabled,
 		Notes:           req.Notes,
 	}
 
-	if err := h.db.Create(&mapping).Error; err != nil {
+	// Create the mapping and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.addID(req.ServerID)
+		if err := tx.Mappings.Create(c.Request.Context(), &mapping); err != nil {
+			return err
+		}
+		return events.Publish(c.Request.Context(), tx, events.MappingCreated{Mapping: events.NewMappingState(mapping), Actor: c.GetString("email")})
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping"})
 		return
 	}
 
-	// Increment config version for the server
-	h.db.IncrementConfigVersion(req.ServerID)
-
 	// Reload mapping with relations
-	h.db.Preload("Server").Preload("UpstreamProxy").First(&mapping, mapping.ID)
-	c.JSON(http.StatusCreated, mapping)
+	h.respondWithMapping(c, http.StatusCreated, mapping.ID)
 }
 
 // UpdateMapping updates an existing mapping
@@ -262,14 +266,14 @@ func (h *MappingHandler) UpdateMapping(c *gin.Context) {
 		return
 	}
 
-	var mapping models.Mapping
-	if err := h.db.First(&mapping, id).Error; err != nil {
+	mapping, err := h.store.Mappings.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
 		return
 	}
 
 	// Update fields if provided
-	updates := make(map[string]interface{})
+	updates := make(repository.Fields)
 	
 	if req.ClientCIDR != nil {
 		updates["client_cidr"] = *req.ClientCIDR
@@ -298,8 +302,7 @@ func (h *MappingHandler) UpdateMapping(c *gin.Context) {
 	
 	if req.UpstreamProxyID != nil {
 		// Verify upstream proxy exists and belongs to the same server
-		var proxy models.Proxy
-		if err := h.db.Where("id = ? AND server_id = ?", *req.UpstreamProxyID, mapping.ServerID).First(&proxy).Error; err != nil {
+		if !proxyOnServer(c, h.store, *req.UpstreamProxyID, mapping.ServerID) {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Upstream proxy not found or belongs to different server"})
 			return
 		}
@@ -307,6 +310,11 @@ func (h *MappingHandler) UpdateMapping(c *gin.Context) {
 	}
 	
 	if req.Enabled != nil {
+		// A mapping whose upstream was deleted stays disabled until it gets a new one
+		if *req.Enabled && mapping.UpstreamProxyID == nil && req.UpstreamProxyID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Mapping has no upstream proxy; set upstream_proxy_id to enable it"})
+			return
+		}
 		updates["enabled"] = *req.Enabled
 	}
 	
@@ -314,17 +322,29 @@ func (h *MappingHandler) UpdateMapping(c *gin.Context) {
 		updates["notes"] = *req.Notes
 	}
 
-	if err := h.db.Model(&mapping).Updates(updates).Error; err != nil {
+	// Update the mapping and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.addID(mapping.ServerID)
+		if err := tx.Mappings.Update(c.Request.Context(), mapping.ID, updates); err != nil {
+			return err
+		}
+		changed, err := tx.Mappings.Get(c.Request.Context(), mapping.ID)
+		if err != nil {
+			return err
+		}
+		return events.Publish(c.Request.Context(), tx, events.MappingUpdated{
+			Before: events.NewMappingState(*mapping),
+			After:  events.NewMappingState(*changed),
+			Actor:  c.GetString("email"),
+		})
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update mapping"})
 		return
 	}
 
-	// Increment config version for the server
-	h.db.IncrementConfigVersion(mapping.ServerID)
-
 	// Reload mapping with updated data
-	h.db.Preload("Server").Preload("UpstreamProxy").First(&mapping, id)
-	c.JSON(http.StatusOK, mapping)
+	h.respondWithMapping(c, http.StatusOK, mapping.ID)
 }
 
 // DeleteMapping deletes a mapping
@@ -335,21 +355,48 @@ func (h *MappingHandler) DeleteMapping(c *gin.Context) {
 		return
 	}
 
-	var mapping models.Mapping
-	if err := h.db.First(&mapping, id).Error; err != nil {
+	mapping, err := h.store.Mappings.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
 		return
 	}
 
-	serverID := mapping.ServerID
-
-	if err := h.db.Delete(&mapping).Error; err != nil {
+	// Move the mapping with its schedules to the trash and bump the
+	// server's config version together
+	ctx := c.Request.Context()
+	err = commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.addID(mapping.ServerID)
+		var cascade trash.Cascade
+		if err := trash.DeleteMapping(ctx, tx, mapping.ID, &cascade); err != nil {
+			return err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashMapping, ResourceID: mapping.ID, Label: mapping.ClientCIDR, ServerID: &mapping.ServerID, DeletedBy: c.GetString("email")}
+		if err := trash.Record(ctx, tx, entry, &cascade); err != nil {
+			return err
+		}
+		return events.Publish(ctx, tx, events.MappingDeleted{Mapping: events.NewMappingState(*mapping), Actor: c.GetString("email")})
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mapping"})
 		return
 	}
 
-	// Increment config version for the server
-	h.db.IncrementConfigVersion(serverID)
-
 	c.JSON(http.StatusOK, gin.H{"message": "Mapping deleted successfully"})
 }
+
+// proxyOnServer reports whether a proxy exists and belongs to a server
+func proxyOnServer(c *gin.Context, store *repository.Store, proxyID, serverID uint) bool {
+	proxy, err := store.Proxies.Get(c.Request.Context(), proxyID)
+	return err == nil && proxy.ServerID != nil && *proxy.ServerID == serverID
+}
+
+// respondWithMapping reloads a mapping with its server and upstream proxy
+// and writes it
+func (h *MappingHandler) respondWithMapping(c *gin.Context, status int, id uint) {
+	mapping, err := h.store.Mappings.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
+		return
+	}
+	c.JSON(status, newMappingResponse(*mapping))
+}
@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestMappingLifecycleBumpsConfigVersion(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var first, second ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &first)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p2","type":"socks5","host":"10.0.0.2","port":1080}`, http.StatusCreated, &second)
+	version := configVersion(t, store, serverID)
+
+	var mapping MappingResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80,443],"upstream_proxy_id":%d}`, serverID, first.ID),
+		http.StatusCreated, &mapping)
+	if mapping.ServerID != serverID || mapping.DstPorts != "[80,443]" {
+		t.Fatalf("created mapping: server %d, ports %s", mapping.ServerID, mapping.DstPorts)
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after create = %d, want %d", got, version+1)
+	}
+
+	serve(t, r, http.MethodPatch, fmt.Sprintf("/mappings/%d", mapping.ID),
+		fmt.Sprintf(`{"upstream_proxy_id":%d,"notes":"moved"}`, second.ID), http.StatusOK, &mapping)
+	if mapping.UpstreamProxyID == nil || *mapping.UpstreamProxyID != second.ID || mapping.Notes != "moved" {
+		t.Fatalf("updated mapping: upstream %v, notes %q", mapping.UpstreamProxyID, mapping.Notes)
+	}
+	if got := configVersion(t, store, serverID); got != version+2 {
+		t.Fatalf("version after update = %d, want %d", got, version+2)
+	}
+
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/mappings/%d", mapping.ID), ``, http.StatusOK, nil)
+	if got := configVersion(t, store, serverID); got != version+3 {
+		t.Fatalf("version after delete = %d, want %d", got, version+3)
+	}
+	if _, err := store.Mappings.Get(ctx, mapping.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("deleted mapping: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.Trash.Find(ctx, models.TrashMapping, mapping.ID); err != nil {
+		t.Fatalf("deleted mapping is not in the trash: %v", err)
+	}
+}
+
+func TestCreateMappingRejectsProxyOfAnotherServer(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+
+	serverID := createServer(t, r)
+	otherID := createServer(t, r)
+	var proxy ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", otherID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &proxy)
+	version := configVersion(t, store, serverID)
+
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80],"upstream_proxy_id":%d}`, serverID, proxy.ID),
+		http.StatusBadRequest, nil)
+	if got := configVersion(t, store, serverID); got != version {
+		t.Fatalf("version after rejected create = %d, want %d", got, version)
+	}
+}
@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultReportDays and maxReportDays bound how far ahead the provider
+// report counts proxies as expiring
+const (
+	defaultReportDays = 30
+	maxReportDays     = 365
+)
+
+type ProviderHandler struct {
+	store *repository.Store
+}
+
+func NewProviderHandler(store *repository.Store) *ProviderHandler {
+	return &ProviderHandler{store: store}
+}
+
+type CreateProviderRequest struct {
+	Name    string `json:"name" binding:"required"`
+	Website string `json:"website"`
+	Notes   string `json:"notes"`
+}
+
+type UpdateProviderRequest struct {
+	Name    *string `json:"name"`
+	Website *string `json:"website"`
+	Notes   *string `json:"notes"`
+}
+
+// SpendLine totals the proxies of one provider or group, or all proxies
+type SpendLine struct {
+	ID           *uint   `json:"id"` // nil for proxies without a provider or group, and the total
+	Name         string  `json:"name"`
+	Proxies      int     `json:"proxies"`
+	Active       int     `json:"active"`       // not expired
+	MonthlyCost  float64 `json:"monthly_cost"` // of the active proxies
+	Expiring     int     `json:"expiring"`     // expire within the report's days
+	ExpiringCost float64 `json:"expiring_cost"`
+	Expired      int     `json:"expired"`
+}
+
+// ExpiringProxy is a proxy in the provider report's expiring inventory
+type ExpiringProxy struct {
+	ProxyRef
+	ServerID   *uint     `json:"server_id"`
+	GroupID    *uint     `json:"group_id"`
+	ProviderID *uint     `json:"provider_id"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Cost       float64   `json:"cost"`
+	OrderRef   string    `json:"order_ref"`
+	Expired    bool      `json:"expired"`
+	Disabled   bool      `json:"disabled"`
+}
+
+// ProviderReportResponse is the spend and expiring inventory of proxies by
+// provider and group
+type ProviderReportResponse struct {
+	Days        int             `json:"days"`
+	GeneratedAt time.Time       `json:"generated_at"`
+	Total       SpendLine       `json:"total"`
+	Providers   []SpendLine     `json:"providers"`
+	Groups      []SpendLine     `json:"groups"`
+	Expiring    []ExpiringProxy `json:"expiring"` // expired or expiring within days, soonest first
+}
+
+// GetProviders returns all providers by name
+func (h *ProviderHandler) GetProviders(c *gin.Context) {
+	providers, err := h.store.Providers.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch providers"})
+		return
+	}
+	c.JSON(http.StatusOK, append([]models.Provider{}, providers...))
+}
+
+// GetProvider returns a provider by ID
+func (h *ProviderHandler) GetProvider(c *gin.Context) {
+	provider, ok := h.findProvider(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, provider)
+}
+
+// CreateProvider creates a provider
+func (h *ProviderHandler) CreateProvider(c *gin.Context) {
+	var req CreateProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	provider := models.Provider{Name: strings.TrimSpace(req.Name), Website: req.Website, Notes: req.Notes}
+	if status, msg := h.validateProvider(c, provider); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	if err := h.store.Providers.Create(c.Request.Context(), &provider); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create provider"})
+		return
+	}
+	c.JSON(http.StatusCreated, provider)
+}
+
+// UpdateProvider updates a provider
+func (h *ProviderHandler) UpdateProvider(c *gin.Context) {
+	provider, ok := h.findProvider(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateProviderRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	next := *provider
+	if req.Name != nil {
+		next.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Website != nil {
+		next.Website = *req.Website
+	}
+	if req.Notes != nil {
+		next.Notes = *req.Notes
+	}
+	if status, msg := h.validateProvider(c, next); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := h.store.Providers.Update(ctx, provider.ID, repository.Fields{
+		"name":    next.Name,
+		"website": next.Website,
+		"notes":   next.Notes,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update provider"})
+		return
+	}
+
+	updated, err := h.store.Providers.Get(ctx, provider.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteProvider deletes a provider. Its proxies are kept without one.
+func (h *ProviderHandler) DeleteProvider(c *gin.Context) {
+	provider, ok := h.findProvider(c)
+	if !ok {
+		return
+	}
+	if err := h.store.Providers.Delete(c.Request.Context(), provider.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete provider"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Provider deleted successfully"})
+}
+
+// GetProviderReport returns the monthly spend on proxies and the proxies
+// expired or expiring within days, by provider and by group
+func (h *ProviderHandler) GetProviderReport(c *gin.Context) {
+	days := defaultReportDays
+	if value := c.Query("days"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxReportDays {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "days must be between 1 and 365"})
+			return
+		}
+		days = n
+	}
+
+	ctx := c.Request.Context()
+	proxies, err := h.store.Proxies.List(ctx, repository.ProxyFilter{})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
+		return
+	}
+	providers, err := h.store.Providers.List(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch providers"})
+		return
+	}
+	providerNames := make(map[uint]string, len(providers))
+	for _, provider := range providers {
+		providerNames[provider.ID] = provider.Name
+	}
+
+	now := time.Now()
+	until := now.Add(time.Duration(days) * 24 * time.Hour)
+	resp := ProviderReportResponse{
+		Days:        days,
+		GeneratedAt: now,
+		Total:       SpendLine{Name: "total"},
+		Expiring:    []ExpiringProxy{},
+	}
+	byProvider := make(map[uint]*SpendLine)
+	byGroup := make(map[uint]*SpendLine)
+	var noProvider, noGroup SpendLine
+	for _, proxy := range proxies {
+		providerLine := &noProvider
+		if proxy.ProviderID != nil {
+			if providerLine = byProvider[*proxy.ProviderID]; providerLine == nil {
+				providerLine = &SpendLine{ID: proxy.ProviderID, Name: providerNames[*proxy.ProviderID]}
+				byProvider[*proxy.ProviderID] = providerLine
+			}
+		}
+		groupLine := &noGroup
+		if proxy.GroupID != nil {
+			if groupLine = byGroup[*proxy.GroupID]; groupLine == nil {
+				groupLine = &SpendLine{ID: proxy.GroupID, Name: proxy.Group.Name}
+				byGroup[*proxy.GroupID] = groupLine
+			}
+		}
+
+		expired := proxy.ExpiresAt != nil && !proxy.ExpiresAt.After(now)
+		expiring := proxy.ExpiresAt != nil && !expired && !proxy.ExpiresAt.After(until)
+		for _, line := range []*SpendLine{&resp.Total, providerLine, groupLine} {
+			line.add(proxy, expired, expiring)
+		}
+		if expired || expiring {
+			resp.Expiring = append(resp.Expiring, ExpiringProxy{
+				ProxyRef:   *newProxyRef(proxy),
+				ServerID:   proxy.ServerID,
+				GroupID:    proxy.GroupID,
+				ProviderID: proxy.ProviderID,
+				ExpiresAt:  *proxy.ExpiresAt,
+				Cost:       proxy.Cost,
+				OrderRef:   proxy.OrderRef,
+				Expired:    expired,
+				Disabled:   proxy.DisabledAt != nil,
+			})
+		}
+	}
+
+	resp.Total.round()
+	resp.Providers = spendLines(byProvider, noProvider)
+	resp.Groups = spendLines(byGroup, noGroup)
+	sort.SliceStable(resp.Expiring, func(i, j int) bool {
+		return resp.Expiring[i].ExpiresAt.Before(resp.Expiring[j].ExpiresAt)
+	})
+	c.JSON(http.StatusOK, resp)
+}
+
+// add counts a proxy in the line
+func (l *SpendLine) add(proxy models.Proxy, expired, expiring bool) {
+	l.Proxies++
+	switch {
+	case expired:
+		l.Expired++
+		return
+	case expiring:
+		l.Expiring++
+		l.ExpiringCost += proxy.Cost
+	}
+	l.Active++
+	l.MonthlyCost += proxy.Cost
+}
+
+// round rounds the line's costs to cents
+func (l *SpendLine) round() {
+	l.MonthlyCost = math.Round(l.MonthlyCost*100) / 100
+	l.ExpiringCost = math.Round(l.ExpiringCost*100) / 100
+}
+
+// spendLines sorts lines by name, followed by the line of proxies without
+// a provider or group if there are any
+func spendLines(byID map[uint]*SpendLine, none SpendLine) []SpendLine {
+	lines := make([]SpendLine, 0, len(byID)+1)
+	for _, line := range byID {
+		line.round()
+		lines = append(lines, *line)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		if lines[i].Name != lines[j].Name {
+			return lines[i].Name < lines[j].Name
+		}
+		return *lines[i].ID < *lines[j].ID
+	})
+	if none.Proxies > 0 {
+		none.round()
+		lines = append(lines, none)
+	}
+	return lines
+}
+
+// findProvider loads the provider of the :id parameter, or writes the error
+func (h *ProviderHandler) findProvider(c *gin.Context) (*models.Provider, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid provider ID"})
+		return nil, false
+	}
+	provider, err := h.store.Providers.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Provider not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch provider"})
+		return nil, false
+	}
+	return provider, true
+}
+
+// validateProvider returns the status and message of why a provider is
+// invalid, or an empty message
+func (h *ProviderHandler) validateProvider(c *gin.Context, provider models.Provider) (int, string) {
+	if provider.Name == "" {
+		return http.StatusBadRequest, "name cannot be empty"
+	}
+	providers, err := h.store.Providers.List(c.Request.Context())
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch providers"
+	}
+	for _, other := range providers {
+		if other.ID != provider.ID && other.Name == provider.Name {
+			return http.StatusConflict, "A provider with this name already exists"
+		}
+	}
+	return 0, ""
+}
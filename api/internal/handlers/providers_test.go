@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+func TestDeleteProviderKeepsProxies(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	provider := models.Provider{Name: "acme"}
+	if err := store.Providers.Create(ctx, &provider); err != nil {
+		t.Fatal(err)
+	}
+	var proxy ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		fmt.Sprintf(`{"label":"p1","type":"http","host":"10.0.0.1","port":8080,"provider_id":%d,"cost":4.256}`, provider.ID),
+		http.StatusCreated, &proxy)
+	if proxy.Cost != 4.26 {
+		t.Fatalf("cost = %v, want it rounded to 4.26", proxy.Cost)
+	}
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p2","type":"http","host":"10.0.0.2","port":8080,"cost":-1}`, http.StatusBadRequest, nil)
+
+	if err := store.Providers.Delete(ctx, provider.ID); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := store.Proxies.Get(ctx, proxy.ID)
+	if err != nil {
+		t.Fatalf("proxy of the deleted provider: %v", err)
+	}
+	if stored.ProviderID != nil || stored.Cost != 4.26 {
+		t.Fatalf("proxy after deleting its provider: provider %v, cost %v", stored.ProviderID, stored.Cost)
+	}
+}
@@ -1,20 +1,31 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
 	"github.com/gin-gonic/gin"
 )
 
 type ProxyHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewProxyHandler(db *database.DB) *ProxyHandler {
-	return &ProxyHandler{db: db}
+func NewProxyHandler(store *repository.Store) *ProxyHandler {
+	return &ProxyHandler{store: store}
 }
 
 type CreateProxyRequest struct {
@@ -25,6 +36,22 @@ type CreateProxyRequest struct {
 	Port     int    `json:"port" binding:"required,min=1,max=65535"`
 	Username string `json:"username"`
 	Password string `json:"password"`
+
+	// Subscription
+	ProviderID *uint      `json:"provider_id"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	Cost       float64    `json:"cost"` // per month
+	OrderRef   string     `json:"order_ref"`
+}
+
+// SharedExitIPResponse is an exit IP of several proxies
+type SharedExitIPResponse struct {
+	ExitIP  string     `json:"exit_ip"`
+	Country string     `json:"country"`
+	City    string     `json:"city"`
+	ASN     uint       `json:"asn"`
+	ASOrg   string     `json:"as_org"`
+	Proxies []ProxyRef `json:"proxies"`
 }
 
 type UpdateProxyRequest struct {
@@ -35,6 +62,12 @@ type UpdateProxyRequest struct {
 	Username *string `json:"username"`
 	Password *string `json:"password"`
 	Health   *string `json:"health"`
+
+	// Subscription. A provider_id of 0 and an empty expires_at clear them.
+	ProviderID *uint    `json:"provider_id"`
+	ExpiresAt  *string  `json:"expires_at"` // RFC 3339
+	Cost       *float64 `json:"cost"`
+	OrderRef   *string  `json:"order_ref"`
 }
 
 // GetServerProxies returns all proxies for a specific server
@@ -46,41 +79,76 @@ func (h *ProxyHandler) GetServerProxies(c *gin.Context) {
 	}
 
 	// Verify server exists
-	var server models.Server
-	if err := h.db.First(&server, serverID).Error; err != nil {
+	if _, err := h.store.Servers.Get(c.Request.Context(), uint(serverID), false); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	var proxies []models.Proxy
-	result := h.db.Where("server_id = ?", serverID).Find(&proxies)
-	if result.Error != nil {
+	id := uint(serverID)
+	filter := repository.ProxyFilter{ServerID: &id}
+	if !proxyQueryFilter(c, &filter) {
+		return
+	}
+	proxies, err := h.store.Proxies.List(c.Request.Context(), filter)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
 		return
 	}
 
-	c.JSON(http.StatusOK, proxies)
+	c.JSON(http.StatusOK, newProxyResponses(proxies))
 }
 
 // GetProxies returns all proxies (optional: can filter by server)
 func (h *ProxyHandler) GetProxies(c *gin.Context) {
-	var proxies []models.Proxy
-	query := h.db.Preload("Server")
+	var filter repository.ProxyFilter
 	
 	// Optional server filter
 	if serverID := c.Query("server_id"); serverID != "" {
 		if id, err := strconv.ParseUint(serverID, 10, 32); err == nil {
-			query = query.Where("server_id = ?", id)
+			serverID := uint(id)
+			filter.ServerID = &serverID
 		}
 	}
+	if !proxyQueryFilter(c, &filter) {
+		return
+	}
+
+	proxies, err := h.store.Proxies.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newProxyResponses(proxies))
+}
 
-	result := query.Find(&proxies)
-	if result.Error != nil {
+// GetSharedExitIPs returns the exit IPs that more than one proxy uses, with
+// those proxies
+func (h *ProxyHandler) GetSharedExitIPs(c *gin.Context) {
+	byIP, err := h.store.Proxies.SharedExitIPs(c.Request.Context())
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
 		return
 	}
 
-	c.JSON(http.StatusOK, proxies)
+	resp := make([]SharedExitIPResponse, 0, len(byIP))
+	for exitIP, proxies := range byIP {
+		resp = append(resp, SharedExitIPResponse{
+			ExitIP:  exitIP,
+			Country: proxies[0].Country,
+			City:    proxies[0].City,
+			ASN:     proxies[0].ASN,
+			ASOrg:   proxies[0].ASOrg,
+			Proxies: newProxyRefs(proxies),
+		})
+	}
+	sort.Slice(resp, func(i, j int) bool {
+		if len(resp[i].Proxies) != len(resp[j].Proxies) {
+			return len(resp[i].Proxies) > len(resp[j].Proxies)
+		}
+		return resp[i].ExitIP < resp[j].ExitIP
+	})
+	c.JSON(http.StatusOK, resp)
 }
 
 // GetProxy returns a single proxy by ID
@@ -91,14 +159,13 @@ func (h *ProxyHandler) GetProxy(c *gin.Context) {
 		return
 	}
 
-	var proxy models.Proxy
-	result := h.db.Preload("Server").First(&proxy, id)
-	if result.Error != nil {
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, proxy)
+	c.JSON(http.StatusOK, newProxyResponse(*proxy))
 }
 
 // CreateServerProxy creates a new proxy for a specific server
@@ -119,8 +186,7 @@ func (h *ProxyHandler) CreateServerProxy(c *gin.Context) {
 	serverIdUint := uint(serverID); req.ServerID = &serverIdUint
 
 	// Verify server exists
-	var server models.Server
-	if err := h.db.First(&server, serverID).Error; err != nil {
+	if _, err := h.store.Servers.Get(c.Request.Context(), uint(serverID), false); err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
@@ -132,28 +198,49 @@ func (h *ProxyHandler) CreateServerProxy(c *gin.Context) {
 		return
 	}
 
-	proxy := models.Proxy{
-		ServerID: req.ServerID,
-		Label:    req.Label,
-		Type:     req.Type,
-		Host:     req.Host,
-		Port:     req.Port,
-		Username: req.Username,
-		Password: req.Password,
-		Health:   "unknown",
+	if !validCost(c, &req.Cost) {
+		return
+	}
+	if !h.validProvider(c, req.ProviderID) {
+		return
 	}
 
-	if err := h.db.Create(&proxy).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy"})
+	username, password, err := sealCredentials(req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
 		return
 	}
 
-	// Increment config version for the server
-	if req.ServerID != nil && *req.ServerID > 0 { h.db.IncrementConfigVersion(*req.ServerID) }
+	proxy := models.Proxy{
+		ServerID:   req.ServerID,
+		Label:      req.Label,
+		Type:       req.Type,
+		Host:       req.Host,
+		Port:       req.Port,
+		Username:   username,
+		Password:   password,
+		Health:     "unknown",
+		ProviderID: req.ProviderID,
+		ExpiresAt:  req.ExpiresAt,
+		Cost:       req.Cost,
+		OrderRef:   req.OrderRef,
+	}
+
+	// Create the proxy and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.add(proxy.ServerID)
+		if err := tx.Proxies.Create(c.Request.Context(), &proxy); err != nil {
+			return err
+		}
+		return events.Publish(c.Request.Context(), tx, events.ProxyCreated{Proxy: events.NewProxyState(proxy), Actor: c.GetString("email")})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy"})
+		return
+	}
 
 	// Reload proxy with server info
-	h.db.Preload("Server").First(&proxy, proxy.ID)
-	c.JSON(http.StatusCreated, proxy)
+	h.respondWithProxy(c, http.StatusCreated, proxy.ID)
 }
 
 // CreateProxy creates a new proxy (requires server_id in body)
@@ -167,8 +254,7 @@ func (h *ProxyHandler) CreateProxy(c *gin.Context) {
 
 	// Verify server exists (if server_id > 0)
 	if req.ServerID != nil && *req.ServerID > 0 {
-		var server models.Server
-		if err := h.db.First(&server, *req.ServerID).Error; err != nil {
+		if _, err := h.store.Servers.Get(c.Request.Context(), *req.ServerID, false); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 			return
 		}
@@ -181,28 +267,49 @@ func (h *ProxyHandler) CreateProxy(c *gin.Context) {
 		return
 	}
 
-	proxy := models.Proxy{
-		ServerID: req.ServerID,
-		Label:    req.Label,
-		Type:     req.Type,
-		Host:     req.Host,
-		Port:     req.Port,
-		Username: req.Username,
-		Password: req.Password,
-		Health:   "unknown",
+	if !validCost(c, &req.Cost) {
+		return
+	}
+	if !h.validProvider(c, req.ProviderID) {
+		return
 	}
 
-	if err := h.db.Create(&proxy).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy"})
+	username, password, err := sealCredentials(req.Username, req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
 		return
 	}
 
-	// Increment config version for the server
-	if req.ServerID != nil && *req.ServerID > 0 { h.db.IncrementConfigVersion(*req.ServerID) }
+	proxy := models.Proxy{
+		ServerID:   req.ServerID,
+		Label:      req.Label,
+		Type:       req.Type,
+		Host:       req.Host,
+		Port:       req.Port,
+		Username:   username,
+		Password:   password,
+		Health:     "unknown",
+		ProviderID: req.ProviderID,
+		ExpiresAt:  req.ExpiresAt,
+		Cost:       req.Cost,
+		OrderRef:   req.OrderRef,
+	}
+
+	// Create the proxy and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.add(proxy.ServerID)
+		if err := tx.Proxies.Create(c.Request.Context(), &proxy); err != nil {
+			return err
+		}
+		return events.Publish(c.Request.Context(), tx, events.ProxyCreated{Proxy: events.NewProxyState(proxy), Actor: c.GetString("email")})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create proxy"})
+		return
+	}
 
 	// Reload proxy with server info
-	h.db.Preload("Server").First(&proxy, proxy.ID)
-	c.JSON(http.StatusCreated, proxy)
+	h.respondWithProxy(c, http.StatusCreated, proxy.ID)
 }
 
 // UpdateProxy updates an existing proxy
@@ -219,14 +326,14 @@ func (h *ProxyHandler) UpdateProxy(c *gin.Context) {
 		return
 	}
 
-	var proxy models.Proxy
-	if err := h.db.First(&proxy, id).Error; err != nil {
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
 		return
 	}
 
 	// Update fields if provided
-	updates := make(map[string]interface{})
+	updates := make(repository.Fields)
 	if req.Label != nil {
 		updates["label"] = *req.Label
 	}
@@ -248,11 +355,21 @@ func (h *ProxyHandler) UpdateProxy(c *gin.Context) {
 		}
 		updates["port"] = *req.Port
 	}
-	if req.Username != nil {
-		updates["username"] = *req.Username
+	username, changed, err := secrets.UpdateText(req.Username)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+		return
 	}
-	if req.Password != nil {
-		updates["password"] = *req.Password
+	if changed {
+		updates["username"] = username
+	}
+	password, changed, err := secrets.Update(req.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt credentials"})
+		return
+	}
+	if changed {
+		updates["password"] = password
 	}
 	if req.Health != nil {
 		validHealth := map[string]bool{"ok": true, "fail": true, "unknown": true}
@@ -262,18 +379,60 @@ func (h *ProxyHandler) UpdateProxy(c *gin.Context) {
 		}
 		updates["health"] = *req.Health
 	}
+	if req.ProviderID != nil {
+		providerID := clearableID(*req.ProviderID)
+		if !h.validProvider(c, providerID) {
+			return
+		}
+		updates["provider_id"] = providerID
+	}
+	if req.Cost != nil {
+		if !validCost(c, req.Cost) {
+			return
+		}
+		updates["cost"] = *req.Cost
+	}
+	if req.OrderRef != nil {
+		updates["order_ref"] = *req.OrderRef
+	}
+	if req.ExpiresAt != nil {
+		var expiresAt *time.Time
+		if *req.ExpiresAt != "" {
+			parsed, err := time.Parse(time.RFC3339, *req.ExpiresAt)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be an RFC 3339 time"})
+				return
+			}
+			expiresAt = &parsed
+		}
+		// A new date is warned about again, and a renewed proxy is enabled
+		updates["expires_at"] = expiresAt
+		updates["expiry_warned_at"] = nil
+		if expiresAt == nil || expiresAt.After(time.Now()) {
+			updates["disabled_at"] = nil
+		}
+	}
 
-	if err := h.db.Model(&proxy).Updates(updates).Error; err != nil {
+	// Update the proxy and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.add(proxy.ServerID)
+		if err := tx.Proxies.Update(c.Request.Context(), proxy.ID, updates); err != nil {
+			return err
+		}
+		if req.Health != nil && *req.Health != proxy.Health {
+			if err := events.Publish(c.Request.Context(), tx, events.ProxyHealthChanged{Proxy: events.NewProxyState(*proxy), Health: *req.Health}); err != nil {
+				return err
+			}
+		}
+		return publishProxiesUpdated(c.Request.Context(), tx, []models.Proxy{*proxy}, c.GetString("email"))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update proxy"})
 		return
 	}
 
-	// Increment config version for the server
-	if proxy.ServerID != nil && *proxy.ServerID > 0 { h.db.IncrementConfigVersion(*proxy.ServerID) }
-
 	// Reload proxy with updated data
-	h.db.Preload("Server").First(&proxy, id)
-	c.JSON(http.StatusOK, proxy)
+	h.respondWithProxy(c, http.StatusOK, proxy.ID)
 }
 
 // DeleteProxy deletes a proxy
@@ -284,23 +443,61 @@ func (h *ProxyHandler) DeleteProxy(c *gin.Context) {
 		return
 	}
 
-	var proxy models.Proxy
-	if err := h.db.First(&proxy, id).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+	force, ok := forceMode(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "force must be disable or remove"})
 		return
 	}
 
-	serverID := proxy.ServerID
+	ctx := c.Request.Context()
+	proxy, err := h.store.Proxies.Get(ctx, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
 
-	if err := h.db.Delete(&proxy).Error; err != nil {
+	// Mappings and schedules using the proxy are resolved in the same
+	// transaction, so none can be added in between
+	var deps *dependents
+	err = commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		var err error
+		if deps, err = findDependents(ctx, tx, []uint{proxy.ID}); err != nil {
+			return err
+		}
+		changes := events.NewChanges(c.GetString("email"))
+		var cascade trash.Cascade
+		if err := deps.release(ctx, tx, force, affected, &cascade, changes); err != nil {
+			return err
+		}
+		affected.add(proxy.ServerID)
+		changes.Proxies(*proxy)
+		if err := tx.Proxies.Delete(ctx, proxy.ID); err != nil {
+			return err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashProxy, ResourceID: proxy.ID, Label: proxy.Label, ServerID: proxy.ServerID, DeletedBy: c.GetString("email")}
+		if err := trash.Record(ctx, tx, entry, &cascade); err != nil {
+			return err
+		}
+		return changes.Publish(ctx, tx)
+	})
+	if errors.Is(err, errHasDependents) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Proxy is used by mappings or schedules. Delete it with force=disable or force=remove.",
+			"dependents": deps.response(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete proxy"})
 		return
 	}
 
-	// Increment config version for the server
-	if serverID != nil && *serverID > 0 { h.db.IncrementConfigVersion(*serverID) }
-
-	c.JSON(http.StatusOK, gin.H{"message": "Proxy deleted successfully"})
+	resp := gin.H{"message": "Proxy deleted successfully"}
+	if !deps.empty() {
+		resp["force"] = force
+		resp["dependents"] = deps.response()
+	}
+	c.JSON(http.StatusOK, resp)
 }
 
 // MoveProxyRequest for moving single proxy to group
@@ -329,35 +526,35 @@ func (h *ProxyHandler) MoveProxyToGroup(c *gin.Context) {
 	}
 
 	// Check if proxy exists
-	var proxy models.Proxy
-	if err := h.db.First(&proxy, id).Error; err != nil {
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), uint(id))
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
 		return
 	}
 
 	// Check if group exists (if group_id is provided)
 	if req.GroupID != nil && *req.GroupID > 0 {
-		var group models.ProxyGroup
-		if err := h.db.First(&group, *req.GroupID).Error; err != nil {
+		if _, err := h.store.Groups.Get(c.Request.Context(), *req.GroupID); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 			return
 		}
 	}
 
-	// Update proxy's group_id
-	if err := h.db.Model(&proxy).Update("group_id", req.GroupID).Error; err != nil {
+	// Update proxy's group_id and bump the server's config version together
+	err = commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.add(proxy.ServerID)
+		if err := tx.Proxies.Update(c.Request.Context(), proxy.ID, repository.Fields{"group_id": req.GroupID}); err != nil {
+			return err
+		}
+		return publishProxiesUpdated(c.Request.Context(), tx, []models.Proxy{*proxy}, c.GetString("email"))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move proxy"})
 		return
 	}
 
-	// Increment config version for the server
-	if proxy.ServerID != nil && *proxy.ServerID > 0 {
-		h.db.IncrementConfigVersion(*proxy.ServerID)
-	}
-
 	// Reload proxy with updated data
-	h.db.Preload("Server").Preload("Group").First(&proxy, id)
-	c.JSON(http.StatusOK, proxy)
+	h.respondWithProxy(c, http.StatusOK, proxy.ID)
 }
 
 // BulkMoveProxiesToGroup moves multiple proxies to a specific group
@@ -375,45 +572,427 @@ func (h *ProxyHandler) BulkMoveProxiesToGroup(c *gin.Context) {
 
 	// Check if group exists (if group_id is provided)
 	if req.GroupID != nil && *req.GroupID > 0 {
-		var group models.ProxyGroup
-		if err := h.db.First(&group, *req.GroupID).Error; err != nil {
+		if _, err := h.store.Groups.Get(c.Request.Context(), *req.GroupID); err != nil {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
 			return
 		}
 	}
 
-	// Get all proxies to be moved
+	// Update all proxies' group_id in bulk and bump every affected server
+	// once
 	var proxies []models.Proxy
-	if err := h.db.Where("id IN ?", req.ProxyIDs).Find(&proxies).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find proxies"})
+	err := commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		var err error
+		if proxies, err = tx.Proxies.List(c.Request.Context(), repository.ProxyFilter{IDs: req.ProxyIDs}); err != nil {
+			return err
+		}
+		if len(proxies) == 0 {
+			return repository.ErrNotFound
+		}
+		for _, proxy := range proxies {
+			affected.add(proxy.ServerID)
+		}
+		if err := tx.Proxies.UpdateMany(c.Request.Context(), req.ProxyIDs, repository.Fields{"group_id": req.GroupID}); err != nil {
+			return err
+		}
+		return publishProxiesUpdated(c.Request.Context(), tx, proxies, c.GetString("email"))
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No proxies found with provided IDs"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move proxies"})
 		return
 	}
 
-	if len(proxies) == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "No proxies found with provided IDs"})
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Proxies moved successfully",
+		"moved_count": len(proxies),
+	})
+}
+
+// What moving proxies to another server does to the mappings using them
+const (
+	mappingsRefuse  = "refuse"  // the move fails if any mapping or schedule uses the proxies
+	mappingsMigrate = "migrate" // the mappings move to the new server along with the proxies
+	mappingsDisable = "disable" // the mappings stay, disabled and without an upstream
+)
+
+// MoveProxyServerRequest for moving a proxy to another server
+type MoveProxyServerRequest struct {
+	ServerID uint   `json:"server_id" binding:"required"`
+	Mappings string `json:"mappings"` // refuse (default), migrate or disable
+}
+
+// BulkMoveProxyServerRequest for moving multiple proxies to another server
+type BulkMoveProxyServerRequest struct {
+	ProxyIDs []uint `json:"proxy_ids" binding:"required"`
+	ServerID uint   `json:"server_id" binding:"required"`
+	Mappings string `json:"mappings"`
+}
+
+// ServerMoveResponse reports the moved proxies and what happened to the
+// mappings and schedules using them
+type ServerMoveResponse struct {
+	Proxies  []ProxyResponse    `json:"proxies"`
+	Mappings string             `json:"mappings"`
+	Migrated []MappingRef       `json:"migrated"`
+	Disabled DependentsResponse `json:"disabled"`
+}
+
+// MoveProxyToServer moves a proxy to another server
+func (h *ProxyHandler) MoveProxyToServer(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy ID"})
+		return
+	}
+
+	var req MoveProxyServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	h.moveProxiesToServer(c, []uint{uint(id)}, req.ServerID, req.Mappings)
+}
+
+// BulkMoveProxiesToServer moves multiple proxies to another server
+func (h *ProxyHandler) BulkMoveProxiesToServer(c *gin.Context) {
+	var req BulkMoveProxyServerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	if len(req.ProxyIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No proxy IDs provided"})
 		return
 	}
 
-	// Update all proxies' group_id in bulk
-	if err := h.db.Model(&models.Proxy{}).Where("id IN ?", req.ProxyIDs).Update("group_id", req.GroupID).Error; err != nil {
+	h.moveProxiesToServer(c, req.ProxyIDs, req.ServerID, req.Mappings)
+}
+
+// moveProxiesToServer reassigns proxies to a server in one transaction,
+// reconciles the mappings and schedules using them, and bumps the config
+// version of the source and destination servers once each
+func (h *ProxyHandler) moveProxiesToServer(c *gin.Context, proxyIDs []uint, serverID uint, mode string) {
+	switch mode {
+	case "":
+		mode = mappingsRefuse
+	case mappingsRefuse, mappingsMigrate, mappingsDisable:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mappings must be refuse, migrate or disable"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if _, err := h.store.Servers.Get(ctx, serverID, false); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+
+	var deps *dependents
+	var migrated []models.Mapping
+	var stranded []models.MappingSchedule
+	err := commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: proxyIDs})
+		if err != nil {
+			return err
+		}
+		if len(proxies) != len(uniqueIDs(proxyIDs)) {
+			return repository.ErrNotFound
+		}
+
+		// Proxies already on the server are left alone
+		moving := make(map[uint]bool)
+		var moved []models.Proxy
+		for _, proxy := range proxies {
+			if proxy.ServerID == nil || *proxy.ServerID != serverID {
+				moving[proxy.ID] = true
+				moved = append(moved, proxy)
+				affected.add(proxy.ServerID)
+			}
+		}
+		if len(moving) == 0 {
+			deps = &dependents{servers: make(affectedServers)}
+			return nil
+		}
+		movingIDs := keys(moving)
+		affected.addID(serverID)
+
+		if deps, err = findDependents(ctx, tx, movingIDs); err != nil {
+			return err
+		}
+		changes := events.NewChanges(c.GetString("email"))
+		changes.Proxies(moved...)
+
+		switch mode {
+		case mappingsRefuse:
+			if !deps.empty() {
+				return errHasDependents
+			}
+		case mappingsDisable:
+			if err := deps.release(ctx, tx, forceDisable, affected, nil, changes); err != nil {
+				return err
+			}
+		case mappingsMigrate:
+			if migrated, stranded, err = migrateDependents(ctx, tx, deps, moving, serverID); err != nil {
+				return err
+			}
+			changes.Mappings(migrated...)
+			for id := range deps.servers {
+				affected[id] = true
+			}
+		}
+
+		if err := tx.Proxies.UpdateMany(ctx, movingIDs, repository.Fields{"server_id": serverID}); err != nil {
+			return err
+		}
+		return changes.Publish(ctx, tx)
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+	if errors.Is(err, errHasDependents) {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":      "Proxy is used by mappings or schedules. Move it with mappings=migrate or mappings=disable.",
+			"dependents": deps.response(),
+		})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move proxies"})
 		return
 	}
 
-	// Increment config version for all affected servers
-	serverIDs := make(map[uint]bool)
-	for _, proxy := range proxies {
-		if proxy.ServerID != nil && *proxy.ServerID > 0 {
-			serverIDs[*proxy.ServerID] = true
+	proxies, err := h.store.Proxies.List(ctx, repository.ProxyFilter{IDs: proxyIDs})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load proxies"})
+		return
+	}
+
+	resp := ServerMoveResponse{
+		Proxies:  newProxyResponses(proxies),
+		Mappings: mode,
+		Migrated: make([]MappingRef, 0, len(migrated)),
+		Disabled: DependentsResponse{Mappings: []MappingRef{}, Schedules: []ScheduleRef{}},
+	}
+	for _, m := range migrated {
+		resp.Migrated = append(resp.Migrated, MappingRef{ID: m.ID, ServerID: serverID, ClientCIDR: m.ClientCIDR, Enabled: m.Enabled})
+	}
+	switch mode {
+	case mappingsDisable:
+		resp.Disabled = deps.response()
+	case mappingsMigrate:
+		resp.Disabled = (&dependents{schedules: stranded}).response()
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// migrateDependents moves the mappings using the moving proxies to the
+// server. Schedules that would switch a mapping to a proxy on another server
+// afterwards are disabled and returned along with the moved mappings.
+func migrateDependents(ctx context.Context, tx *repository.Store, deps *dependents, moving map[uint]bool, serverID uint) ([]models.Mapping, []models.MappingSchedule, error) {
+	if len(deps.mappings) == 0 && len(deps.schedules) == 0 {
+		return nil, nil, nil
+	}
+
+	movedMappings := make(map[uint]bool, len(deps.mappings))
+	for _, mapping := range deps.mappings {
+		movedMappings[mapping.ID] = true
+	}
+
+	var stranded []models.MappingSchedule
+	// Schedules of mappings that stay behind and switch to a moving proxy
+	for _, schedule := range deps.schedules {
+		if !movedMappings[schedule.MappingID] {
+			stranded = append(stranded, schedule)
 		}
 	}
-	
-	for serverID := range serverIDs {
-		h.db.IncrementConfigVersion(serverID)
+	// Schedules of moving mappings that switch to a proxy that stays behind
+	if len(movedMappings) > 0 {
+		schedules, err := tx.Schedules.List(ctx, repository.ScheduleFilter{MappingIDs: keys(movedMappings)})
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, schedule := range schedules {
+			if schedule.UpstreamProxyID != nil && !moving[*schedule.UpstreamProxyID] {
+				stranded = append(stranded, schedule)
+			}
+		}
+
+		if err := tx.Mappings.UpdateMany(ctx, keys(movedMappings), repository.Fields{"server_id": serverID}); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(stranded) > 0 {
+		ids := make([]uint, 0, len(stranded))
+		for _, schedule := range stranded {
+			ids = append(ids, schedule.ID)
+		}
+		if err := tx.Schedules.UpdateMany(ctx, ids, repository.Fields{"upstream_proxy_id": nil, "enabled": false, "active": false}); err != nil {
+			return nil, nil, err
+		}
+	}
+	return deps.mappings, stranded, nil
+}
+
+// RevealProxyCredentials returns a proxy's username and password in the
+// clear. Every reveal is written to the audit log.
+func (h *ProxyHandler) RevealProxyCredentials(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy ID"})
+		return
+	}
+
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+
+	username, err := proxy.Username.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt credentials"})
+		return
+	}
+	password, err := proxy.Password.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt credentials"})
+		return
+	}
+
+	after, _ := json.Marshal(gin.H{"proxy_id": proxy.ID, "label": proxy.Label})
+	audit := models.AuditLog{
+		Actor:    c.GetString("email"),
+		Action:   "reveal_credentials",
+		Resource: "proxy",
+		After:    string(after),
+	}
+	// Refuse to reveal what cannot be audited
+	if err := h.store.Audit.Record(c.Request.Context(), &audit); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write audit log"})
+		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"message": "Proxies moved successfully",
-		"moved_count": len(proxies),
+		"id":       proxy.ID,
+		"username": username,
+		"password": password,
 	})
 }
+
+// publishProxiesUpdated publishes ProxyUpdated for proxies as they were
+// before a change, reading what they are now in the transaction
+func publishProxiesUpdated(ctx context.Context, tx *repository.Store, before []models.Proxy, actor string) error {
+	changes := events.NewChanges(actor)
+	changes.Proxies(before...)
+	return changes.Publish(ctx, tx)
+}
+
+// respondWithProxy reloads a proxy with its server and group and writes it
+func (h *ProxyHandler) respondWithProxy(c *gin.Context, status int, id uint) {
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+	c.JSON(status, newProxyResponse(*proxy))
+}
+
+// validProvider checks that the provider of a proxy exists, or writes the
+// error
+func (h *ProxyHandler) validProvider(c *gin.Context, providerID *uint) bool {
+	if providerID == nil {
+		return true
+	}
+	if _, err := h.store.Providers.Get(c.Request.Context(), *providerID); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Provider not found"})
+		return false
+	}
+	return true
+}
+
+// maxCost is the largest cost the cost column holds
+const maxCost = 9999999999.99
+
+// validCost checks that a proxy cost is neither negative nor too large, or
+// writes the error, and rounds it to the cent as it is stored
+func validCost(c *gin.Context, cost *float64) bool {
+	if *cost < 0 || *cost > maxCost {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cost must be between 0 and 9999999999.99"})
+		return false
+	}
+	*cost = math.Round(*cost*100) / 100
+	return true
+}
+
+func sealCredentials(username, password string) (secrets.Text, secrets.Secret, error) {
+	sealedUsername, err := secrets.SealText(username)
+	if err != nil {
+		return "", "", err
+	}
+	sealedPassword, err := secrets.SealSecret(password)
+	if err != nil {
+		return "", "", err
+	}
+	return sealedUsername, sealedPassword, nil
+}
+
+// proxyQueryFilter adds the exit_ip, country, city, asn, anonymity,
+// quarantined, provider_id, expires_before and disabled query parameters to
+// filter, or writes the error
+func proxyQueryFilter(c *gin.Context, filter *repository.ProxyFilter) bool {
+	filter.ExitIP = c.Query("exit_ip")
+	filter.Country = c.Query("country")
+	filter.City = c.Query("city")
+	if value := c.Query("asn"); value != "" {
+		asn, err := strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(value), "AS"), 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ASN"})
+			return false
+		}
+		parsed := uint(asn)
+		filter.ASN = &parsed
+	}
+	switch filter.Anonymity = c.Query("anonymity"); filter.Anonymity {
+	case "", healthcheck.AnonymityTransparent, healthcheck.AnonymityAnonymous, healthcheck.AnonymityElite:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "anonymity must be transparent, anonymous or elite"})
+		return false
+	}
+	if value := c.Query("quarantined"); value != "" {
+		quarantined, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "quarantined must be true or false"})
+			return false
+		}
+		filter.Quarantined = &quarantined
+	}
+	var ok bool
+	if filter.ProviderID, ok = queryID(c, "provider_id", "Invalid provider ID"); !ok {
+		return false
+	}
+	if value := c.Query("expires_before"); value != "" {
+		before, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_before must be an RFC 3339 time"})
+			return false
+		}
+		filter.ExpiresBefore = &before
+	}
+	if value := c.Query("disabled"); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "disabled must be true or false"})
+			return false
+		}
+		filter.Disabled = &disabled
+	}
+	return true
+}
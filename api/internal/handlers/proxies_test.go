@@ -0,0 +1,100 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestProxyLifecycleBumpsConfigVersion(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	version := configVersion(t, store, serverID)
+
+	var proxy ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080,"username":"user","password":"secret"}`,
+		http.StatusCreated, &proxy)
+	if proxy.ServerID == nil || *proxy.ServerID != serverID {
+		t.Fatalf("proxy server = %v, want %d", proxy.ServerID, serverID)
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after create = %d, want %d", got, version+1)
+	}
+
+	stored, err := store.Proxies.Get(ctx, proxy.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if password, err := stored.Password.Open(); err != nil || password != "secret" {
+		t.Fatalf("stored password = %q, %v", password, err)
+	}
+
+	serve(t, r, http.MethodPatch, fmt.Sprintf("/proxies/%d", proxy.ID), `{"host":"10.0.0.2","port":3128}`, http.StatusOK, &proxy)
+	if proxy.Host != "10.0.0.2" || proxy.Port != 3128 {
+		t.Fatalf("updated proxy = %s:%d, want 10.0.0.2:3128", proxy.Host, proxy.Port)
+	}
+	if got := configVersion(t, store, serverID); got != version+2 {
+		t.Fatalf("version after update = %d, want %d", got, version+2)
+	}
+
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/proxies/%d", proxy.ID), ``, http.StatusOK, nil)
+	if got := configVersion(t, store, serverID); got != version+3 {
+		t.Fatalf("version after delete = %d, want %d", got, version+3)
+	}
+	if _, err := store.Proxies.Get(ctx, proxy.ID); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("deleted proxy: got %v, want ErrNotFound", err)
+	}
+	if _, err := store.Trash.Find(ctx, models.TrashProxy, proxy.ID); err != nil {
+		t.Fatalf("deleted proxy is not in the trash: %v", err)
+	}
+}
+
+func TestDeleteProxyInUse(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var proxy ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &proxy)
+	var mapping MappingResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80],"upstream_proxy_id":%d}`, serverID, proxy.ID),
+		http.StatusCreated, &mapping)
+	version := configVersion(t, store, serverID)
+
+	// Refused without force, and nothing changes
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/proxies/%d", proxy.ID), ``, http.StatusConflict, nil)
+	if got := configVersion(t, store, serverID); got != version {
+		t.Fatalf("version after refused delete = %d, want %d", got, version)
+	}
+
+	// force=disable keeps the mapping without an upstream, in one version
+	published := len(outboxNames(t, store))
+	serve(t, r, http.MethodDelete, fmt.Sprintf("/proxies/%d?force=disable", proxy.ID), ``, http.StatusOK, nil)
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after forced delete = %d, want %d", got, version+1)
+	}
+	if got := outboxNames(t, store)[published:]; !reflect.DeepEqual(got, []string{events.NameProxyDeleted, events.NameMappingUpdated}) {
+		t.Fatalf("events of the forced delete = %v", got)
+	}
+	stored, err := store.Mappings.Get(ctx, mapping.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Enabled || stored.UpstreamProxyID != nil {
+		t.Fatalf("mapping after force=disable: enabled %v, upstream %v", stored.Enabled, stored.UpstreamProxyID)
+	}
+}
@@ -0,0 +1,335 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/quarantine"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultTransitionLimit and maxTransitionLimit bound the transition list
+const (
+	defaultTransitionLimit = 100
+	maxTransitionLimit     = 500
+)
+
+type QuarantineHandler struct {
+	store *repository.Store
+}
+
+func NewQuarantineHandler(store *repository.Store) *QuarantineHandler {
+	return &QuarantineHandler{store: store}
+}
+
+type CreateQuarantinePolicyRequest struct {
+	Name            string `json:"name" binding:"required"`
+	GroupID         *uint  `json:"group_id"` // the default policy if nil
+	Failures        int    `json:"failures" binding:"required"`
+	Successes       int    `json:"successes" binding:"required"`
+	FallbackProxyID *uint  `json:"fallback_proxy_id"`
+	FallbackGroupID *uint  `json:"fallback_group_id"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+// UpdateQuarantinePolicyRequest changes the fields it sets. An ID of 0
+// clears it.
+type UpdateQuarantinePolicyRequest struct {
+	Name            *string `json:"name"`
+	GroupID         *uint   `json:"group_id"`
+	Failures        *int    `json:"failures"`
+	Successes       *int    `json:"successes"`
+	FallbackProxyID *uint   `json:"fallback_proxy_id"`
+	FallbackGroupID *uint   `json:"fallback_group_id"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+// GetQuarantinePolicies returns all quarantine policies
+func (h *QuarantineHandler) GetQuarantinePolicies(c *gin.Context) {
+	policies, err := h.store.QuarantinePolicies.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quarantine policies"})
+		return
+	}
+	c.JSON(http.StatusOK, append([]models.QuarantinePolicy{}, policies...))
+}
+
+// GetQuarantinePolicy returns a quarantine policy by ID
+func (h *QuarantineHandler) GetQuarantinePolicy(c *gin.Context) {
+	policy, ok := h.findPolicy(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, policy)
+}
+
+// CreateQuarantinePolicy creates a quarantine policy. It applies from the
+// next check of each proxy.
+func (h *QuarantineHandler) CreateQuarantinePolicy(c *gin.Context) {
+	var req CreateQuarantinePolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	policy := models.QuarantinePolicy{
+		Name:            req.Name,
+		GroupID:         req.GroupID,
+		Failures:        req.Failures,
+		Successes:       req.Successes,
+		FallbackProxyID: req.FallbackProxyID,
+		FallbackGroupID: req.FallbackGroupID,
+		Enabled:         req.Enabled == nil || *req.Enabled,
+	}
+	if status, msg := h.validatePolicy(c, policy); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.store.QuarantinePolicies.Create(c.Request.Context(), &policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create quarantine policy"})
+		return
+	}
+	c.JSON(http.StatusCreated, policy)
+}
+
+// UpdateQuarantinePolicy updates a quarantine policy. Proxies it
+// quarantined stay quarantined until its successes restore them, and the
+// servers of those proxies get a new config version for the new fallback.
+func (h *QuarantineHandler) UpdateQuarantinePolicy(c *gin.Context) {
+	policy, ok := h.findPolicy(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateQuarantinePolicyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	next := *policy
+	if req.Name != nil {
+		next.Name = *req.Name
+	}
+	if req.GroupID != nil {
+		next.GroupID = clearableID(*req.GroupID)
+	}
+	if req.Failures != nil {
+		next.Failures = *req.Failures
+	}
+	if req.Successes != nil {
+		next.Successes = *req.Successes
+	}
+	if req.FallbackProxyID != nil {
+		next.FallbackProxyID = clearableID(*req.FallbackProxyID)
+	}
+	if req.FallbackGroupID != nil {
+		next.FallbackGroupID = clearableID(*req.FallbackGroupID)
+	}
+	if req.Enabled != nil {
+		next.Enabled = *req.Enabled
+	}
+	if status, msg := h.validatePolicy(c, next); msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+
+	ctx := c.Request.Context()
+	err := commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		err := tx.QuarantinePolicies.Update(ctx, policy.ID, repository.Fields{
+			"name":              next.Name,
+			"group_id":          next.GroupID,
+			"failures":          next.Failures,
+			"successes":         next.Successes,
+			"fallback_proxy_id": next.FallbackProxyID,
+			"fallback_group_id": next.FallbackGroupID,
+			"enabled":           next.Enabled,
+		})
+		if err != nil {
+			return err
+		}
+		if sameID(policy.FallbackProxyID, next.FallbackProxyID) && sameID(policy.FallbackGroupID, next.FallbackGroupID) {
+			return nil
+		}
+		return h.affectQuarantined(c, tx, policy.ID, affected)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update quarantine policy"})
+		return
+	}
+
+	updated, err := h.store.QuarantinePolicies.Get(ctx, policy.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quarantine policy not found"})
+		return
+	}
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteQuarantinePolicy deletes a quarantine policy. Proxies it
+// quarantined stay quarantined without a fallback until their next ok
+// check restores them.
+func (h *QuarantineHandler) DeleteQuarantinePolicy(c *gin.Context) {
+	policy, ok := h.findPolicy(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+	err := commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		if err := h.affectQuarantined(c, tx, policy.ID, affected); err != nil {
+			return err
+		}
+		return tx.QuarantinePolicies.Delete(ctx, policy.ID)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete quarantine policy"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Quarantine policy deleted successfully"})
+}
+
+// GetQuarantineTransitions returns proxies going into and out of
+// quarantine, newest first, optionally of one proxy
+func (h *QuarantineHandler) GetQuarantineTransitions(c *gin.Context) {
+	var filter repository.TransitionFilter
+	var ok bool
+	if filter.ProxyID, ok = queryID(c, "proxy_id", "Invalid proxy ID"); !ok {
+		return
+	}
+	filter.Limit = defaultTransitionLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxTransitionLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	transitions, err := h.store.QuarantineTransitions.List(c.Request.Context(), filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quarantine transitions"})
+		return
+	}
+	c.JSON(http.StatusOK, append([]models.QuarantineTransition{}, transitions...))
+}
+
+// ReleaseProxy takes a proxy out of quarantine now
+func (h *QuarantineHandler) ReleaseProxy(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	actor := c.GetString("email")
+	var proxy *models.Proxy
+	err = h.store.Transaction(ctx, func(tx *repository.Store) error {
+		var err error
+		if proxy, err = tx.Proxies.Get(ctx, uint(id)); err != nil || proxy.QuarantinedAt == nil {
+			return err
+		}
+		return quarantine.Restore(ctx, tx, *proxy, models.QuarantineReleased, "released by "+actor, actor)
+	})
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to release proxy"})
+		return
+	}
+	if proxy.QuarantinedAt == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Proxy is not quarantined"})
+		return
+	}
+
+	released, err := h.store.Proxies.Get(ctx, proxy.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy"})
+		return
+	}
+	c.JSON(http.StatusOK, newProxyResponse(*released))
+}
+
+// findPolicy loads the policy of the :id parameter, or writes the error
+func (h *QuarantineHandler) findPolicy(c *gin.Context) (*models.QuarantinePolicy, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid quarantine policy ID"})
+		return nil, false
+	}
+	policy, err := h.store.QuarantinePolicies.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Quarantine policy not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch quarantine policy"})
+		return nil, false
+	}
+	return policy, true
+}
+
+// validatePolicy returns the status and message of why a policy is invalid,
+// or an empty message
+func (h *QuarantineHandler) validatePolicy(c *gin.Context, policy models.QuarantinePolicy) (int, string) {
+	if policy.Failures < 1 || policy.Successes < 1 {
+		return http.StatusBadRequest, "failures and successes must be at least 1"
+	}
+
+	ctx := c.Request.Context()
+	for _, id := range []*uint{policy.GroupID, policy.FallbackGroupID} {
+		if id == nil {
+			continue
+		}
+		if _, err := h.store.Groups.Get(ctx, *id); err != nil {
+			return http.StatusBadRequest, "Group not found"
+		}
+	}
+	if policy.FallbackProxyID != nil {
+		if _, err := h.store.Proxies.Get(ctx, *policy.FallbackProxyID); err != nil {
+			return http.StatusBadRequest, "Fallback proxy not found"
+		}
+	}
+
+	policies, err := h.store.QuarantinePolicies.List(ctx)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to fetch quarantine policies"
+	}
+	for _, other := range policies {
+		if other.ID != policy.ID && sameID(other.GroupID, policy.GroupID) {
+			if policy.GroupID == nil {
+				return http.StatusConflict, "A default quarantine policy already exists"
+			}
+			return http.StatusConflict, "The group already has a quarantine policy"
+		}
+	}
+	return 0, ""
+}
+
+// affectQuarantined marks the servers of the proxies a policy quarantined
+// as affected, as their configs route to its fallback
+func (h *QuarantineHandler) affectQuarantined(c *gin.Context, tx *repository.Store, policyID uint, affected affectedServers) error {
+	proxies, err := tx.Proxies.List(c.Request.Context(), repository.ProxyFilter{QuarantinePolicyID: &policyID})
+	if err != nil {
+		return err
+	}
+	for _, proxy := range proxies {
+		affected.add(proxy.ServerID)
+	}
+	return nil
+}
+
+// sameID reports whether two optional IDs are both unset or equal
+func sameID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
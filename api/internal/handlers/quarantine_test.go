@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/quarantine"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestQuarantineFallbackIgnoresHealth(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	var group GroupResponse
+	serve(t, r, http.MethodPost, "/groups", `{"name":"fallback"}`, http.StatusCreated, &group)
+	var upstream, first, second ProxyResponse
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080}`, http.StatusCreated, &upstream)
+	for i, proxy := range []*ProxyResponse{&first, &second} {
+		serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+			fmt.Sprintf(`{"label":"f%d","type":"http","host":"10.0.1.%d","port":8080}`, i+1, i+1),
+			http.StatusCreated, proxy)
+		if err := store.Proxies.Update(ctx, proxy.ID, repository.Fields{"group_id": group.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/mappings", serverID),
+		fmt.Sprintf(`{"server_id":%d,"client_cidr":"192.168.1.0/24","dst_ports":[80],"upstream_proxy_id":%d}`, serverID, upstream.ID),
+		http.StatusCreated, nil)
+
+	// The first fallback fails its checks without being quarantined, which
+	// bumps no version
+	if err := store.Proxies.Update(ctx, first.ID, repository.Fields{"health": healthcheck.HealthFail}); err != nil {
+		t.Fatal(err)
+	}
+	policy := models.QuarantinePolicy{Name: "default", Failures: 3, Successes: 2, FallbackGroupID: &group.ID, Enabled: true}
+	if err := store.QuarantinePolicies.Create(ctx, &policy); err != nil {
+		t.Fatal(err)
+	}
+	proxy, err := store.Proxies.Get(ctx, upstream.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quarantine.Quarantine(ctx, store, *proxy, policy, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The failing fallback is still chosen, as it was before it failed
+	version := configVersion(t, store, serverID)
+	config, err := store.Snapshots.AgentConfig(ctx, serverID, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Mappings) != 1 || config.Mappings[0].UpstreamProxyID == nil || *config.Mappings[0].UpstreamProxyID != first.ID {
+		t.Fatalf("quarantined upstream routed to %+v, want proxy %d", config.Mappings, first.ID)
+	}
+
+	// Quarantining it bumps the version and passes it over
+	fallback, err := store.Proxies.Get(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := quarantine.Quarantine(ctx, store, *fallback, policy, "test"); err != nil {
+		t.Fatal(err)
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after quarantining the fallback = %d, want %d", got, version+1)
+	}
+	config, err = store.Snapshots.AgentConfig(ctx, serverID, version+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Mappings) != 1 || config.Mappings[0].UpstreamProxyID == nil || *config.Mappings[0].UpstreamProxyID != second.ID {
+		t.Fatalf("quarantined upstream routed to %+v, want proxy %d", config.Mappings, second.ID)
+	}
+}
@@ -0,0 +1,325 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+)
+
+// Response DTOs. Handlers never serialize GORM models with relations
+// directly: credentials are redacted here and relations that were not loaded
+// are omitted instead of showing up as zero-valued objects.
+
+// ServerRef is a server nested in another resource
+type ServerRef struct {
+	ID            uint   `json:"id"`
+	Name          string `json:"name"`
+	Status        string `json:"status"`
+	ServiceState  string `json:"service_state"`
+	ConfigVersion int    `json:"config_version"`
+}
+
+// ProxyRef is a proxy nested in another resource. It carries no credentials.
+type ProxyRef struct {
+	ID     uint   `json:"id"`
+	Label  string `json:"label"`
+	Type   string `json:"type"`
+	Host   string `json:"host"`
+	Port   int    `json:"port"`
+	Health string `json:"health"`
+}
+
+// GroupRef is a proxy group nested in another resource
+type GroupRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+// MappingRef is a mapping listed by another resource that it depends on
+type MappingRef struct {
+	ID         uint   `json:"id"`
+	ServerID   uint   `json:"server_id"`
+	ClientCIDR string `json:"client_cidr"`
+	Enabled    bool   `json:"enabled"`
+}
+
+// ScheduleRef is a mapping schedule listed by another resource that it
+// depends on
+type ScheduleRef struct {
+	ID        uint   `json:"id"`
+	MappingID uint   `json:"mapping_id"`
+	Name      string `json:"name"`
+	Action    string `json:"action"`
+}
+
+// RuleRef is a quarantine policy or alert rule listed by a group it names
+type RuleRef struct {
+	ID   uint   `json:"id"`
+	Name string `json:"name"`
+}
+
+type ServerResponse struct {
+	ID                uint       `json:"id"`
+	Name              string     `json:"name"`
+	Tags              string     `json:"tags"`
+	WANIface          string     `json:"wan_iface"`
+	LANIface          string     `json:"lan_iface"`
+	LastSeenAt        *time.Time `json:"last_seen_at"`
+	Status            string     `json:"status"`
+	ConfigVersion     int        `json:"config_version"`
+	AppliedVersion    int        `json:"applied_version"`
+	AppliedStatus     string     `json:"applied_status"`
+	AppliedAt         *time.Time `json:"applied_at"`
+	ServiceState      string     `json:"service_state"`
+	MaintenanceReason string     `json:"maintenance_reason"`
+	MaintenanceSince  *time.Time `json:"maintenance_since"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Proxies  *[]ProxyResponse   `json:"proxies,omitempty"`
+	Mappings *[]MappingResponse `json:"mappings,omitempty"`
+}
+
+type ProxyResponse struct {
+	ID        uint      `json:"id"`
+	ServerID  *uint     `json:"server_id"`
+	GroupID   *uint     `json:"group_id"`
+	Label     string    `json:"label"`
+	Type      string    `json:"type"`
+	Host      string    `json:"host"`
+	Port      int       `json:"port"`
+	Username  string    `json:"username"` // masked if sealed
+	Password  string    `json:"password"` // always masked
+	Health    string    `json:"health"`
+	Tags      string    `json:"tags"`    // JSON array as string
+	ExitIP    string    `json:"exit_ip"` // empty if not discovered
+	Country   string    `json:"country"`
+	City      string    `json:"city"`
+	ASN       uint      `json:"asn"`
+	ASOrg     string    `json:"as_org"`
+	Anonymity string    `json:"anonymity"` // empty if not classified
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	ConsecutiveFailures  int        `json:"consecutive_failures"`
+	ConsecutiveSuccesses int        `json:"consecutive_successes"`
+	QuarantinedAt        *time.Time `json:"quarantined_at"` // nil if not quarantined
+	QuarantinePolicyID   *uint      `json:"quarantine_policy_id"`
+
+	ProviderID     *uint      `json:"provider_id"`
+	ExpiresAt      *time.Time `json:"expires_at"` // nil if it does not expire
+	Cost           float64    `json:"cost"`       // per month
+	OrderRef       string     `json:"order_ref"`
+	ExpiryWarnedAt *time.Time `json:"expiry_warned_at"`
+	DisabledAt     *time.Time `json:"disabled_at"` // nil unless disabled in bulk or once expired
+
+	Server *ServerRef `json:"server,omitempty"`
+	Group  *GroupRef  `json:"group,omitempty"`
+}
+
+type MappingResponse struct {
+	ID              uint      `json:"id"`
+	ServerID        uint      `json:"server_id"`
+	ClientCIDR      string    `json:"client_cidr"`
+	DstPorts        string    `json:"dst_ports"` // JSON array as string
+	UpstreamProxyID *uint     `json:"upstream_proxy_id"`
+	Enabled         bool      `json:"enabled"`
+	Notes           string    `json:"notes"`
+	Tags            string    `json:"tags"` // JSON array as string
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+
+	Server        *ServerRef `json:"server,omitempty"`
+	UpstreamProxy *ProxyRef  `json:"upstream_proxy,omitempty"`
+}
+
+type GroupResponse struct {
+	ID          uint      `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	Proxies *[]ProxyResponse `json:"proxies,omitempty"`
+}
+
+// newServerResponse converts a server. withRelations says whether its
+// proxies and mappings were preloaded.
+func newServerResponse(s models.Server, withRelations bool) ServerResponse {
+	resp := ServerResponse{
+		ID:                s.ID,
+		Name:              s.Name,
+		Tags:              s.Tags,
+		WANIface:          s.WANIface,
+		LANIface:          s.LANIface,
+		LastSeenAt:        s.LastSeenAt,
+		Status:            s.Status,
+		ConfigVersion:     s.ConfigVersion,
+		AppliedVersion:    s.AppliedVersion,
+		AppliedStatus:     s.AppliedStatus,
+		AppliedAt:         s.AppliedAt,
+		ServiceState:      s.ServiceState,
+		MaintenanceReason: s.MaintenanceReason,
+		MaintenanceSince:  s.MaintenanceSince,
+		CreatedAt:         s.CreatedAt,
+		UpdatedAt:         s.UpdatedAt,
+	}
+	if withRelations {
+		proxies := newProxyResponses(s.Proxies)
+		mappings := newMappingResponses(s.Mappings)
+		resp.Proxies = &proxies
+		resp.Mappings = &mappings
+	}
+	return resp
+}
+
+func newServerResponses(servers []models.Server, withRelations bool) []ServerResponse {
+	resp := make([]ServerResponse, 0, len(servers))
+	for _, s := range servers {
+		resp = append(resp, newServerResponse(s, withRelations))
+	}
+	return resp
+}
+
+func newProxyResponse(p models.Proxy) ProxyResponse {
+	resp := ProxyResponse{
+		ID:        p.ID,
+		ServerID:  p.ServerID,
+		GroupID:   p.GroupID,
+		Label:     p.Label,
+		Type:      p.Type,
+		Host:      p.Host,
+		Port:      p.Port,
+		Username:  redactText(p.Username),
+		Password:  redactSecret(p.Password),
+		Health:    p.Health,
+		Tags:      p.Tags,
+		ExitIP:    p.ExitIP,
+		Country:   p.Country,
+		City:      p.City,
+		ASN:       p.ASN,
+		ASOrg:     p.ASOrg,
+		Anonymity: p.Anonymity,
+		CreatedAt: p.CreatedAt,
+		UpdatedAt: p.UpdatedAt,
+
+		ConsecutiveFailures:  p.ConsecutiveFailures,
+		ConsecutiveSuccesses: p.ConsecutiveSuccesses,
+		QuarantinedAt:        p.QuarantinedAt,
+		QuarantinePolicyID:   p.QuarantinePolicyID,
+
+		ProviderID:     p.ProviderID,
+		ExpiresAt:      p.ExpiresAt,
+		Cost:           p.Cost,
+		OrderRef:       p.OrderRef,
+		ExpiryWarnedAt: p.ExpiryWarnedAt,
+		DisabledAt:     p.DisabledAt,
+	}
+	if p.Server.ID != 0 {
+		resp.Server = newServerRef(p.Server)
+	}
+	if p.Group.ID != 0 {
+		resp.Group = &GroupRef{ID: p.Group.ID, Name: p.Group.Name}
+	}
+	return resp
+}
+
+func newProxyResponses(proxies []models.Proxy) []ProxyResponse {
+	resp := make([]ProxyResponse, 0, len(proxies))
+	for _, p := range proxies {
+		resp = append(resp, newProxyResponse(p))
+	}
+	return resp
+}
+
+func newMappingResponse(m models.Mapping) MappingResponse {
+	resp := MappingResponse{
+		ID:              m.ID,
+		ServerID:        m.ServerID,
+		ClientCIDR:      m.ClientCIDR,
+		DstPorts:        m.DstPorts,
+		UpstreamProxyID: m.UpstreamProxyID,
+		Enabled:         m.Enabled,
+		Notes:           m.Notes,
+		Tags:            m.Tags,
+		CreatedAt:       m.CreatedAt,
+		UpdatedAt:       m.UpdatedAt,
+	}
+	if m.Server.ID != 0 {
+		resp.Server = newServerRef(m.Server)
+	}
+	if m.UpstreamProxy.ID != 0 {
+		resp.UpstreamProxy = newProxyRef(m.UpstreamProxy)
+	}
+	return resp
+}
+
+func newMappingResponses(mappings []models.Mapping) []MappingResponse {
+	resp := make([]MappingResponse, 0, len(mappings))
+	for _, m := range mappings {
+		resp = append(resp, newMappingResponse(m))
+	}
+	return resp
+}
+
+// newGroupResponse converts a group. withProxies says whether its proxies
+// were preloaded.
+func newGroupResponse(g models.ProxyGroup, withProxies bool) GroupResponse {
+	resp := GroupResponse{
+		ID:          g.ID,
+		Name:        g.Name,
+		Description: g.Description,
+		CreatedAt:   g.CreatedAt,
+		UpdatedAt:   g.UpdatedAt,
+	}
+	if withProxies {
+		proxies := newProxyResponses(g.Proxies)
+		resp.Proxies = &proxies
+	}
+	return resp
+}
+
+func newGroupResponses(groups []models.ProxyGroup, withProxies bool) []GroupResponse {
+	resp := make([]GroupResponse, 0, len(groups))
+	for _, g := range groups {
+		resp = append(resp, newGroupResponse(g, withProxies))
+	}
+	return resp
+}
+
+func newProxyRef(p models.Proxy) *ProxyRef {
+	return &ProxyRef{ID: p.ID, Label: p.Label, Type: p.Type, Host: p.Host, Port: p.Port, Health: p.Health}
+}
+
+func newProxyRefs(proxies []models.Proxy) []ProxyRef {
+	refs := make([]ProxyRef, 0, len(proxies))
+	for _, p := range proxies {
+		refs = append(refs, *newProxyRef(p))
+	}
+	return refs
+}
+
+func newServerRef(s models.Server) *ServerRef {
+	return &ServerRef{
+		ID:            s.ID,
+		Name:          s.Name,
+		Status:        s.Status,
+		ServiceState:  s.ServiceState,
+		ConfigVersion: s.ConfigVersion,
+	}
+}
+
+func redactText(t secrets.Text) string {
+	if secrets.IsSealed(string(t)) {
+		return secrets.Mask
+	}
+	return string(t)
+}
+
+func redactSecret(s secrets.Secret) string {
+	if s == "" {
+		return ""
+	}
+	return secrets.Mask
+}
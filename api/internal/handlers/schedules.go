@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/scheduler"
+	"github.com/gin-gonic/gin"
+)
+
+type ScheduleHandler struct {
+	store     *repository.Store
+	scheduler *scheduler.Scheduler
+}
+
+func NewScheduleHandler(store *repository.Store, sched *scheduler.Scheduler) *ScheduleHandler {
+	return &ScheduleHandler{store: store, scheduler: sched}
+}
+
+type CreateScheduleRequest struct {
+	Name            string `json:"name"`
+	Kind            string `json:"kind" binding:"required"`
+	CronExpr        string `json:"cron_expr"`
+	DurationMinutes int    `json:"duration_minutes"`
+	Weekdays        string `json:"weekdays"`
+	StartTime       string `json:"start_time"`
+	EndTime         string `json:"end_time"`
+	Timezone        string `json:"timezone"`
+	Action          string `json:"action" binding:"required"`
+	UpstreamProxyID *uint  `json:"upstream_proxy_id"`
+	Enabled         *bool  `json:"enabled"`
+}
+
+type UpdateScheduleRequest struct {
+	Name            *string `json:"name"`
+	Kind            *string `json:"kind"`
+	CronExpr        *string `json:"cron_expr"`
+	DurationMinutes *int    `json:"duration_minutes"`
+	Weekdays        *string `json:"weekdays"`
+	StartTime       *string `json:"start_time"`
+	EndTime         *string `json:"end_time"`
+	Timezone        *string `json:"timezone"`
+	Action          *string `json:"action"`
+	UpstreamProxyID *uint   `json:"upstream_proxy_id"`
+	Enabled         *bool   `json:"enabled"`
+}
+
+// GetMappingSchedules returns all schedules of a mapping
+func (h *ScheduleHandler) GetMappingSchedules(c *gin.Context) {
+	mapping, ok := h.findMapping(c)
+	if !ok {
+		return
+	}
+
+	schedules, err := h.store.Schedules.List(c.Request.Context(), repository.ScheduleFilter{MappingID: &mapping.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch schedules"})
+		return
+	}
+
+	now := time.Now()
+	for i := range schedules {
+		setNextTransition(&schedules[i], now)
+	}
+
+	c.JSON(http.StatusOK, schedules)
+}
+
+// CreateMappingSchedule adds a schedule to a mapping
+func (h *ScheduleHandler) CreateMappingSchedule(c *gin.Context) {
+	mapping, ok := h.findMapping(c)
+	if !ok {
+		return
+	}
+
+	var req CreateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	// Default enabled to true if not provided
+	enabled := true
+	if req.Enabled != nil {
+		enabled = *req.Enabled
+	}
+
+	timezone := req.Timezone
+	if timezone == "" {
+		timezone = "UTC"
+	}
+
+	schedule := models.MappingSchedule{
+		MappingID:       mapping.ID,
+		Name:            req.Name,
+		Kind:            req.Kind,
+		CronExpr:        req.CronExpr,
+		DurationMinutes: req.DurationMinutes,
+		Weekdays:        req.Weekdays,
+		StartTime:       req.StartTime,
+		EndTime:         req.EndTime,
+		Timezone:        timezone,
+		Action:          req.Action,
+		UpstreamProxyID: req.UpstreamProxyID,
+		Enabled:         enabled,
+	}
+
+	if err := h.validateSchedule(c, &schedule, mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A mapping's schedules are part of it, so the mapping is reported as
+	// updated
+	err := h.store.Transaction(c.Request.Context(), func(tx *repository.Store) error {
+		if err := tx.Schedules.Create(c.Request.Context(), &schedule); err != nil {
+			return err
+		}
+		return publishMappingUpdated(c.Request.Context(), tx, *mapping, c.GetString("email"))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule"})
+		return
+	}
+
+	// Let the scheduler open the window right away if it is due
+	h.scheduler.Wake()
+
+	setNextTransition(&schedule, time.Now())
+	c.JSON(http.StatusCreated, schedule)
+}
+
+// UpdateMappingSchedule updates a schedule of a mapping
+func (h *ScheduleHandler) UpdateMappingSchedule(c *gin.Context) {
+	mapping, ok := h.findMapping(c)
+	if !ok {
+		return
+	}
+
+	schedule, ok := h.findSchedule(c, mapping)
+	if !ok {
+		return
+	}
+
+	var req UpdateScheduleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	if req.Name != nil {
+		schedule.Name = *req.Name
+	}
+	if req.Kind != nil {
+		schedule.Kind = *req.Kind
+	}
+	if req.CronExpr != nil {
+		schedule.CronExpr = *req.CronExpr
+	}
+	if req.DurationMinutes != nil {
+		schedule.DurationMinutes = *req.DurationMinutes
+	}
+	if req.Weekdays != nil {
+		schedule.Weekdays = *req.Weekdays
+	}
+	if req.StartTime != nil {
+		schedule.StartTime = *req.StartTime
+	}
+	if req.EndTime != nil {
+		schedule.EndTime = *req.EndTime
+	}
+	if req.Timezone != nil {
+		schedule.Timezone = *req.Timezone
+	}
+	if req.Action != nil {
+		schedule.Action = *req.Action
+	}
+	if req.UpstreamProxyID != nil {
+		schedule.UpstreamProxyID = req.UpstreamProxyID
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+
+	if err := h.validateSchedule(c, &schedule, mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		// An open window may now apply a different action; windows closed
+		// by disabling the schedule are handled by the scheduler
+		if schedule.Active && schedule.Enabled {
+			affected.addID(mapping.ServerID)
+		}
+		if err := tx.Schedules.Save(c.Request.Context(), &schedule); err != nil {
+			return err
+		}
+		return publishMappingUpdated(c.Request.Context(), tx, *mapping, c.GetString("email"))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+	h.scheduler.Wake()
+
+	setNextTransition(&schedule, time.Now())
+	c.JSON(http.StatusOK, schedule)
+}
+
+// DeleteMappingSchedule deletes a schedule of a mapping
+func (h *ScheduleHandler) DeleteMappingSchedule(c *gin.Context) {
+	mapping, ok := h.findMapping(c)
+	if !ok {
+		return
+	}
+
+	schedule, ok := h.findSchedule(c, mapping)
+	if !ok {
+		return
+	}
+
+	err := commitChange(c.Request.Context(), h.store, func(tx *repository.Store, affected affectedServers) error {
+		// Closing an open window changes the effective config
+		if schedule.Active {
+			affected.addID(mapping.ServerID)
+		}
+		if err := tx.Schedules.Delete(c.Request.Context(), schedule.ID); err != nil {
+			return err
+		}
+		return publishMappingUpdated(c.Request.Context(), tx, *mapping, c.GetString("email"))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+	h.scheduler.Wake()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Schedule deleted successfully"})
+}
+
+func (h *ScheduleHandler) findMapping(c *gin.Context) (*models.Mapping, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping ID"})
+		return nil, false
+	}
+
+	mapping, err := h.store.Mappings.Get(c.Request.Context(), uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found"})
+		return nil, false
+	}
+
+	return mapping, true
+}
+
+func (h *ScheduleHandler) findSchedule(c *gin.Context, mapping *models.Mapping) (models.MappingSchedule, bool) {
+	var schedule models.MappingSchedule
+
+	id, err := strconv.ParseUint(c.Param("schedule_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule ID"})
+		return schedule, false
+	}
+
+	found, err := h.store.Schedules.Get(c.Request.Context(), uint(id))
+	if err != nil || found.MappingID != mapping.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Schedule not found"})
+		return schedule, false
+	}
+
+	return *found, true
+}
+
+// validateSchedule checks the timing fields and the action of a schedule
+func (h *ScheduleHandler) validateSchedule(c *gin.Context, schedule *models.MappingSchedule, mapping *models.Mapping) error {
+	if _, err := scheduler.Compile(schedule); err != nil {
+		return err
+	}
+
+	switch schedule.Action {
+	case models.ScheduleActionEnable, models.ScheduleActionDisable:
+		schedule.UpstreamProxyID = nil
+	case models.ScheduleActionSwitchUpstream:
+		if schedule.UpstreamProxyID == nil {
+			return errors.New("upstream_proxy_id is required for switch_upstream")
+		}
+		// Verify upstream proxy exists and belongs to the same server
+		if !proxyOnServer(c, h.store, *schedule.UpstreamProxyID, mapping.ServerID) {
+			return errors.New("Upstream proxy not found or belongs to different server")
+		}
+	default:
+		return errors.New("Invalid action. Must be: enable, disable, switch_upstream")
+	}
+
+	return nil
+}
+
+// setNextTransition fills in when the schedule's window next opens or closes
+func setNextTransition(schedule *models.MappingSchedule, now time.Time) {
+	if !schedule.Enabled {
+		return
+	}
+	window, err := scheduler.Compile(schedule)
+	if err != nil {
+		return
+	}
+	if next := window.NextTransition(now); !next.IsZero() {
+		schedule.NextTransitionAt = &next
+	}
+}
+
+// publishMappingUpdated publishes MappingUpdated for a mapping whose
+// schedules changed
+func publishMappingUpdated(ctx context.Context, tx *repository.Store, mapping models.Mapping, actor string) error {
+	changes := events.NewChanges(actor)
+	changes.Mappings(mapping)
+	return changes.Publish(ctx, tx)
+}
@@ -1,23 +1,27 @@
 package handlers
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
 
-	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
 	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
 	"github.com/gin-gonic/gin"
 )
 
 type ServerHandler struct {
-	db *database.DB
+	store *repository.Store
 }
 
-func NewServerHandler(db *database.DB) *ServerHandler {
-	return &ServerHandler{db: db}
+func NewServerHandler(store *repository.Store) *ServerHandler {
+	return &ServerHandler{store: store}
 }
 
 type CreateServerRequest struct {
@@ -37,18 +41,15 @@ type UpdateServerRequest struct {
 
 // GetServers returns all servers
 func (h *ServerHandler) GetServers(c *gin.Context) {
-	var servers []models.Server
-	
-	result := h.db.Preload("Proxies").Preload("Mappings").Find(&servers)
-	if result.Error != nil {
+	servers, err := h.store.Servers.List(c.Request.Context(), true)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch servers"})
 		return
 	}
 
 
 	// Get unassigned proxies
-	var unassignedProxies []models.Proxy
-	h.db.Where("server_id IS NULL").Find(&unassignedProxies)
+	unassignedProxies, _ := h.store.Proxies.List(c.Request.Context(), repository.ProxyFilter{Unassigned: true})
 
 
 	// Add virtual "Unassigned" server if there are unassigned proxies
@@ -64,7 +65,7 @@ func (h *ServerHandler) GetServers(c *gin.Context) {
 	}
 
 
-	c.JSON(http.StatusOK, servers)
+	c.JSON(http.StatusOK, newServerResponses(servers, true))
 }
 
 // GetServer returns a single server by ID
@@ -75,14 +76,13 @@ func (h *ServerHandler) GetServer(c *gin.Context) {
 		return
 	}
 
-	var server models.Server
-	result := h.db.Preload("Proxies").Preload("Mappings").First(&server, id)
-	if result.Error != nil {
+	server, err := h.store.Servers.Get(c.Request.Context(), uint(id), true)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	c.JSON(http.StatusOK, server)
+	c.JSON(http.StatusOK, newServerResponse(*server, true))
 }
 
 // CreateServer creates a new server
@@ -121,12 +121,19 @@ func (h *ServerHandler) CreateServer(c *gin.Context) {
 		ConfigVersion: 0,
 	}
 
-	if err := h.db.Create(&server).Error; err != nil {
+	ctx := c.Request.Context()
+	err = h.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := tx.Servers.Create(ctx, &server); err != nil {
+			return err
+		}
+		return events.Publish(ctx, tx, events.ServerCreated{Server: events.NewServerState(server), Actor: c.GetString("email")})
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create server"})
 		return
 	}
 
-	c.JSON(http.StatusCreated, server)
+	c.JSON(http.StatusCreated, newServerResponse(server, false))
 }
 
 // UpdateServer updates an existing server
@@ -143,14 +150,15 @@ func (h *ServerHandler) UpdateServer(c *gin.Context) {
 		return
 	}
 
-	var server models.Server
-	if err := h.db.First(&server, id).Error; err != nil {
+	ctx := c.Request.Context()
+	before, err := h.store.Servers.Get(ctx, uint(id), false)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
 	// Update fields if provided
-	updates := make(map[string]interface{})
+	updates := make(repository.Fields)
 	if req.Name != nil {
 		updates["name"] = *req.Name
 	}
@@ -180,14 +188,24 @@ func (h *ServerHandler) UpdateServer(c *gin.Context) {
 		}
 	}
 
-	if err := h.db.Model(&server).Updates(updates).Error; err != nil {
+	err = h.store.Transaction(ctx, func(tx *repository.Store) error {
+		if err := tx.Servers.Update(ctx, before.ID, updates); err != nil {
+			return err
+		}
+		return publishServerUpdated(ctx, tx, *before, c.GetString("email"))
+	})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
 		return
 	}
 
 	// Reload server with updated data
-	h.db.Preload("Proxies").Preload("Mappings").First(&server, id)
-	c.JSON(http.StatusOK, server)
+	server, err := h.store.Servers.Get(c.Request.Context(), uint(id), true)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newServerResponse(*server, true))
 }
 
 // DeleteServer deletes a server
@@ -199,58 +217,141 @@ func (h *ServerHandler) DeleteServer(c *gin.Context) {
 	}
 
 	// Check if server exists
-	var server models.Server
-	if err := h.db.First(&server, id).Error; err != nil {
+	ctx := c.Request.Context()
+	server, err := h.store.Servers.Get(ctx, uint(id), false)
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	// Start transaction
-	tx := h.db.Begin()
-	if tx.Error != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start transaction"})
+	// Moves the server with its mappings and their schedules to the trash
+	// and unassigns its proxies in one transaction. The trash entry records
+	// them, so a restore brings them back.
+	err = h.store.Transaction(ctx, func(tx *repository.Store) error {
+		var cascade trash.Cascade
+		mappings, err := tx.Mappings.List(ctx, repository.MappingFilter{ServerID: &server.ID})
+		if err != nil {
+			return err
+		}
+		for _, mapping := range mappings {
+			cascade.Mappings = append(cascade.Mappings, mapping.ID)
+		}
+		if len(cascade.Mappings) > 0 {
+			schedules, err := tx.Schedules.List(ctx, repository.ScheduleFilter{MappingIDs: cascade.Mappings})
+			if err != nil {
+				return err
+			}
+			for _, schedule := range schedules {
+				cascade.Schedules = append(cascade.Schedules, schedule.ID)
+			}
+		}
+		proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{ServerID: &server.ID})
+		if err != nil {
+			return err
+		}
+		for _, proxy := range proxies {
+			cascade.Unassigned = append(cascade.Unassigned, proxy.ID)
+		}
+
+		if err := tx.Servers.Delete(ctx, server.ID); err != nil {
+			return err
+		}
+		entry := models.TrashEntry{ResourceType: models.TrashServer, ResourceID: server.ID, Label: server.Name, DeletedBy: c.GetString("email")}
+		if err := trash.Record(ctx, tx, entry, &cascade); err != nil {
+			return err
+		}
+		return events.Publish(ctx, tx, events.ServerDeleted{Server: events.NewServerState(*server), Actor: c.GetString("email")})
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete server"})
 		return
 	}
 
-	// Ensure we rollback on any panic
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Transaction failed"})
-		}
-	}()
+	c.JSON(http.StatusOK, gin.H{"message": "Server deleted successfully"})
+}
 
-	// 1. Delete all mappings associated with this server
-	result := tx.Where("server_id = ?", id).Delete(&models.Mapping{})
-	if result.Error != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete server mappings"})
+type MaintenanceRequest struct {
+	Reason string `json:"reason"`
+}
+
+// EnterMaintenance takes a server out of service. Its agent is told to drain
+// and its mappings are rendered disabled; the stored mappings are kept.
+func (h *ServerHandler) EnterMaintenance(c *gin.Context) {
+	var req MaintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil && err != io.EOF {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
 		return
 	}
 
-	// 2. Update proxies to remove server association - force explicit SQL
-	result = tx.Exec("UPDATE proxies SET server_id = NULL WHERE server_id = $1", id)
-	if result.Error != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unassign proxies"})
+	now := time.Now()
+	h.setServiceState(c, models.ServiceMaintenance, "Server is already in maintenance", repository.Fields{
+		"service_state":      models.ServiceMaintenance,
+		"maintenance_reason": req.Reason,
+		"maintenance_since":  &now,
+	})
+}
+
+// ResumeService returns a server in maintenance to service
+func (h *ServerHandler) ResumeService(c *gin.Context) {
+	h.setServiceState(c, models.ServiceActive, "Server is not in maintenance", repository.Fields{
+		"service_state":      models.ServiceActive,
+		"maintenance_reason": "",
+		"maintenance_since":  nil,
+	})
+}
+
+func (h *ServerHandler) setServiceState(c *gin.Context, state, conflict string, updates repository.Fields) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
 		return
 	}
 
-	// 3. Delete the server itself
-	result = tx.Delete(&server)
-	if result.Error != nil {
-		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete server"})
+	ctx := c.Request.Context()
+	server, err := h.store.Servers.Get(ctx, uint(id), false)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
 		return
 	}
 
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to commit transaction"})
+	if server.ServiceState == state {
+		c.JSON(http.StatusConflict, gin.H{"error": conflict})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Server deleted successfully"})
+	// The agent picks up the drain or resume with the next config version
+	err = commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		affected.addID(server.ID)
+		if err := tx.Servers.Update(ctx, server.ID, updates); err != nil {
+			return err
+		}
+		return publishServerUpdated(ctx, tx, *server, c.GetString("email"))
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update server"})
+		return
+	}
+
+	server, err = h.store.Servers.Get(ctx, server.ID, false)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newServerResponse(*server, false))
+}
+
+// publishServerUpdated publishes ServerUpdated for a server as it was
+// before a change, reading what it is now in the transaction
+func publishServerUpdated(ctx context.Context, tx *repository.Store, before models.Server, actor string) error {
+	after, err := tx.Servers.Get(ctx, before.ID, false)
+	if err != nil {
+		return err
+	}
+	return events.Publish(ctx, tx, events.ServerUpdated{
+		Before: events.NewServerState(before),
+		After:  events.NewServerState(*after),
+		Actor:  actor,
+	})
 }
 
 // generateAgentToken generates a random token for agent authentication
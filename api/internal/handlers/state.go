@@ -0,0 +1,148 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type StateHandler struct {
+	store *repository.Store
+}
+
+func NewStateHandler(store *repository.Store) *StateHandler {
+	return &StateHandler{store: store}
+}
+
+// GetServerState exports a server's proxies and mappings as a desired state
+// document (YAML with ?format=yaml). Credentials are masked unless an admin
+// asks for them with ?reveal=true, which is written to the audit log like
+// RevealProxyCredentials.
+func (h *StateHandler) GetServerState(c *gin.Context) {
+	serverID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return
+	}
+
+	reveal := c.Query("reveal") == "true"
+	if reveal && c.GetString("role") != "admin" {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	doc, _, err := configstate.Load(ctx, h.store, uint(serverID))
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	if reveal {
+		after, _ := json.Marshal(gin.H{"server_id": serverID, "proxies": len(doc.Proxies)})
+		audit := models.AuditLog{
+			Actor:    c.GetString("email"),
+			Action:   "reveal_credentials",
+			Resource: "server",
+			After:    string(after),
+		}
+		// Refuse to reveal what cannot be audited
+		if err := h.store.Audit.Record(ctx, &audit); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write audit log"})
+			return
+		}
+	} else {
+		doc.Mask()
+	}
+
+	if c.Query("format") == "yaml" {
+		c.YAML(http.StatusOK, doc)
+		return
+	}
+	c.JSON(http.StatusOK, doc)
+}
+
+// PlanServerState shows the changes that applying a document would make
+func (h *StateHandler) PlanServerState(c *gin.Context) {
+	serverID, doc, ok := h.bindDocument(c)
+	if !ok {
+		return
+	}
+
+	result, err := configstate.PlanServer(c.Request.Context(), h.store, serverID, doc)
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// ApplyServerState makes a server match a document in one transaction.
+// ?expected_version=N refuses the apply if the server changed since planning.
+func (h *StateHandler) ApplyServerState(c *gin.Context) {
+	serverID, doc, ok := h.bindDocument(c)
+	if !ok {
+		return
+	}
+
+	var expectedVersion *int
+	if v := c.Query("expected_version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid expected_version"})
+			return
+		}
+		expectedVersion = &version
+	}
+
+	result, err := configstate.ApplyServer(c.Request.Context(), h.store, serverID, doc, expectedVersion, c.GetString("email"))
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+func (h *StateHandler) bindDocument(c *gin.Context) (uint, *configstate.Document, bool) {
+	serverID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return 0, nil, false
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return 0, nil, false
+	}
+
+	doc, err := configstate.Parse(body, c.ContentType())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document", "details": err.Error()})
+		return 0, nil, false
+	}
+
+	return uint(serverID), doc, true
+}
+
+func respondStateError(c *gin.Context, err error) {
+	var stateErr *configstate.StateError
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+	case errors.Is(err, configstate.ErrVersionConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.As(err, &stateErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": stateErr.Message})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process server state", "details": err.Error()})
+	}
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/gin-gonic/gin"
+)
+
+func TestApplyServerStateBumpsConfigVersionOnce(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+
+	serverID := createServer(t, r)
+	version := configVersion(t, store, serverID)
+
+	doc := `{
+		"proxies": [
+			{"label": "p1", "type": "http", "host": "10.0.0.1", "port": 8080, "username": "user", "password": "secret"},
+			{"label": "p2", "type": "socks5", "host": "10.0.0.2", "port": 1080}
+		],
+		"mappings": [
+			{"client_cidr": "192.168.1.0/24", "dst_ports": [80, 443], "upstream": "p1"}
+		]
+	}`
+
+	var result configstate.Result
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/apply?expected_version=%d", serverID, version), doc, http.StatusOK, &result)
+	if !result.Applied || result.ConfigVersion != version+1 || len(result.Plan.Changes) != 3 {
+		t.Fatalf("apply: applied %v, version %d, %d changes", result.Applied, result.ConfigVersion, len(result.Plan.Changes))
+	}
+	if got := configVersion(t, store, serverID); got != version+1 {
+		t.Fatalf("version after apply = %d, want %d", got, version+1)
+	}
+
+	// Applying the same document again changes nothing
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/apply", serverID), doc, http.StatusOK, &result)
+	if result.Applied || !result.Plan.Empty() {
+		t.Fatalf("second apply: applied %v, %d changes", result.Applied, len(result.Plan.Changes))
+	}
+
+	// A stale expected version is refused
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/apply?expected_version=%d", serverID, version), doc, http.StatusConflict, nil)
+
+	var exported configstate.Document
+	serve(t, r, http.MethodGet, fmt.Sprintf("/servers/%d/state", serverID), ``, http.StatusOK, &exported)
+	if len(exported.Proxies) != 2 || len(exported.Mappings) != 1 || exported.Mappings[0].Upstream != "p1" {
+		t.Fatalf("exported state: %+v", exported)
+	}
+}
+
+func TestApplyServerStateDeletesToTrash(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/apply", serverID), `{
+		"proxies": [
+			{"label": "p1", "type": "http", "host": "10.0.0.1", "port": 8080},
+			{"label": "p2", "type": "http", "host": "10.0.0.2", "port": 8080}
+		],
+		"mappings": [{"client_cidr": "192.168.1.0/24", "dst_ports": [80], "upstream": "p1"}]
+	}`, http.StatusOK, nil)
+	proxies, err := store.Proxies.List(ctx, repository.ProxyFilter{ServerID: &serverID})
+	if err != nil || len(proxies) != 2 {
+		t.Fatalf("proxies after apply: %d, %v", len(proxies), err)
+	}
+	mappings, err := store.Mappings.List(ctx, repository.MappingFilter{ServerID: &serverID})
+	if err != nil || len(mappings) != 1 {
+		t.Fatalf("mappings after apply: %d, %v", len(mappings), err)
+	}
+
+	// Dropping p1 and its mapping moves both to the trash
+	published := len(outboxNames(t, store))
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/apply", serverID), `{
+		"proxies": [{"label": "p2", "type": "http", "host": "10.0.0.2", "port": 8080}],
+		"mappings": []
+	}`, http.StatusOK, nil)
+	version := configVersion(t, store, serverID)
+	if got := outboxNames(t, store)[published:]; !reflect.DeepEqual(got, []string{events.NameProxyDeleted, events.NameMappingDeleted}) {
+		t.Fatalf("events of the apply = %v", got)
+	}
+
+	proxyEntry, err := store.Trash.Find(ctx, models.TrashProxy, proxies[0].ID)
+	if err != nil {
+		t.Fatalf("deleted proxy is not in the trash: %v", err)
+	}
+	if proxyEntry.Label != "p1" || proxyEntry.ServerID == nil || *proxyEntry.ServerID != serverID {
+		t.Fatalf("proxy trash entry: %+v", proxyEntry)
+	}
+	if _, err := store.Trash.Find(ctx, models.TrashMapping, mappings[0].ID); err != nil {
+		t.Fatalf("deleted mapping is not in the trash: %v", err)
+	}
+
+	// Both come back, in one new version each
+	published = len(outboxNames(t, store))
+	serve(t, r, http.MethodPost, fmt.Sprintf("/trash/proxy/%d/restore", proxies[0].ID), ``, http.StatusOK, nil)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/trash/mapping/%d/restore", mappings[0].ID), ``, http.StatusOK, nil)
+	if got := configVersion(t, store, serverID); got != version+2 {
+		t.Fatalf("version after restores = %d, want %d", got, version+2)
+	}
+	if got := outboxNames(t, store)[published:]; !reflect.DeepEqual(got, []string{events.NameProxyCreated, events.NameMappingCreated}) {
+		t.Fatalf("events of the restores = %v", got)
+	}
+	mapping, err := store.Mappings.Get(ctx, mappings[0].ID)
+	if err != nil || mapping.UpstreamProxyID == nil || *mapping.UpstreamProxyID != proxies[0].ID {
+		t.Fatalf("restored mapping: %+v, %v", mapping, err)
+	}
+}
+
+func TestGetServerStateMasksCredentials(t *testing.T) {
+	store := newTestStore(t)
+	r := newTestRouter(store)
+	ctx := context.Background()
+
+	serverID := createServer(t, r)
+	serve(t, r, http.MethodPost, fmt.Sprintf("/servers/%d/proxies", serverID),
+		`{"label":"p1","type":"http","host":"10.0.0.1","port":8080,"username":"user","password":"secret"}`, http.StatusCreated, nil)
+	path := fmt.Sprintf("/servers/%d/state", serverID)
+
+	var exported configstate.Document
+	serve(t, r, http.MethodGet, path, ``, http.StatusOK, &exported)
+	if password := exported.Proxies[0].Password; password == nil || *password != secrets.Mask {
+		t.Fatalf("exported password = %v, want the mask", password)
+	}
+
+	// Only admins can reveal them
+	serve(t, r, http.MethodGet, path+"?reveal=true", ``, http.StatusForbidden, nil)
+
+	admin := gin.New()
+	admin.Use(func(c *gin.Context) {
+		c.Set("email", "admin@example.com")
+		c.Set("role", "admin")
+	})
+	admin.GET("/servers/:id/state", NewStateHandler(store).GetServerState)
+	serve(t, admin, http.MethodGet, path+"?reveal=true", ``, http.StatusOK, &exported)
+	if password := exported.Proxies[0].Password; password == nil || *password != "secret" {
+		t.Fatalf("revealed password = %v, want secret", password)
+	}
+
+	entries, err := store.Audit.List(ctx, 1)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("audit log: %d entries, %v", len(entries), err)
+	}
+	if entries[0].Action != "reveal_credentials" || entries[0].Actor != "admin@example.com" {
+		t.Fatalf("audit entry: %+v", entries[0])
+	}
+}
@@ -0,0 +1,311 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/checkhistory"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultStatsWindow is how far back stats look without a window
+const defaultStatsWindow = 24 * time.Hour
+
+// Orders of GetProxiesStats, best first
+const (
+	statsSortUptime = "uptime"
+	statsSortP50    = "p50"
+	statsSortP95    = "p95"
+	statsSortStreak = "streak"
+)
+
+type StatsHandler struct {
+	store *repository.Store
+}
+
+func NewStatsHandler(store *repository.Store) *StatsHandler {
+	return &StatsHandler{store: store}
+}
+
+type ProxyStatsResponse struct {
+	ProxyID       uint   `json:"proxy_id"`
+	Label         string `json:"label"`
+	ServerID      *uint  `json:"server_id"`
+	GroupID       *uint  `json:"group_id"`
+	Health        string `json:"health"`
+	WindowSeconds int64  `json:"window_seconds"`
+	checkhistory.Stats
+}
+
+type GroupStatsResponse struct {
+	GroupID       uint   `json:"group_id"`
+	Name          string `json:"name"`
+	Proxies       int    `json:"proxies"`
+	WindowSeconds int64  `json:"window_seconds"`
+	checkhistory.Stats
+}
+
+// ProxyChecksResponse is the history of a proxy within a window: hourly
+// rollups for the part that was downsampled, then the checks
+type ProxyChecksResponse struct {
+	ProxyID       uint                      `json:"proxy_id"`
+	WindowSeconds int64                     `json:"window_seconds"`
+	Rollups       []models.ProxyCheckRollup `json:"rollups"`
+	Checks        []models.ProxyCheck       `json:"checks"`
+}
+
+// GetProxiesStats returns the stats of every proxy, or of those of
+// group_id or server_id, ordered best first by sort
+func (h *StatsHandler) GetProxiesStats(c *gin.Context) {
+	window, ok := statsWindow(c)
+	if !ok {
+		return
+	}
+	var filter repository.ProxyFilter
+	if filter.GroupID, ok = queryID(c, "group_id", "Invalid group ID"); !ok {
+		return
+	}
+	if filter.ServerID, ok = queryID(c, "server_id", "Invalid server ID"); !ok {
+		return
+	}
+	order := c.DefaultQuery("sort", statsSortUptime)
+	switch order {
+	case statsSortUptime, statsSortP50, statsSortP95, statsSortStreak:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be uptime, p50, p95 or streak"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	proxies, err := h.store.Proxies.List(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
+		return
+	}
+	stats, _, err := checkhistory.Summarize(ctx, h.store, proxyIDs(proxies), time.Now().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy stats"})
+		return
+	}
+
+	resp := make([]ProxyStatsResponse, 0, len(proxies))
+	for _, proxy := range proxies {
+		resp = append(resp, newProxyStatsResponse(proxy, window, stats[proxy.ID]))
+	}
+	sortProxyStats(resp, order)
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetProxyStats returns the stats of a proxy
+func (h *StatsHandler) GetProxyStats(c *gin.Context) {
+	proxy, ok := h.findProxy(c)
+	if !ok {
+		return
+	}
+	window, ok := statsWindow(c)
+	if !ok {
+		return
+	}
+
+	_, stats, err := checkhistory.Summarize(c.Request.Context(), h.store, []uint{proxy.ID}, time.Now().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy stats"})
+		return
+	}
+	c.JSON(http.StatusOK, newProxyStatsResponse(*proxy, window, stats))
+}
+
+// GetProxyChecks returns the check history of a proxy
+func (h *StatsHandler) GetProxyChecks(c *gin.Context) {
+	proxy, ok := h.findProxy(c)
+	if !ok {
+		return
+	}
+	window, ok := statsWindow(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	filter := repository.CheckFilter{ProxyIDs: []uint{proxy.ID}, Since: time.Now().Add(-window)}
+	rollups, err := h.store.Checks.Rollups(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy checks"})
+		return
+	}
+	checks, err := h.store.Checks.List(ctx, filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy checks"})
+		return
+	}
+	c.JSON(http.StatusOK, ProxyChecksResponse{
+		ProxyID:       proxy.ID,
+		WindowSeconds: int64(window / time.Second),
+		Rollups:       append([]models.ProxyCheckRollup{}, rollups...),
+		Checks:        append([]models.ProxyCheck{}, checks...),
+	})
+}
+
+// GetGroupStats returns the stats of the proxies of a group together
+func (h *StatsHandler) GetGroupStats(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group ID"})
+		return
+	}
+	window, ok := statsWindow(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	group, err := h.store.Groups.Get(ctx, uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Group not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group"})
+		return
+	}
+	groupID := group.ID
+	proxies, err := h.store.Proxies.List(ctx, repository.ProxyFilter{GroupID: &groupID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxies"})
+		return
+	}
+	_, stats, err := checkhistory.Summarize(ctx, h.store, proxyIDs(proxies), time.Now().Add(-window))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch group stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, GroupStatsResponse{
+		GroupID:       group.ID,
+		Name:          group.Name,
+		Proxies:       len(proxies),
+		WindowSeconds: int64(window / time.Second),
+		Stats:         stats,
+	})
+}
+
+// findProxy loads the proxy of the :id parameter, or writes the error
+func (h *StatsHandler) findProxy(c *gin.Context) (*models.Proxy, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid proxy ID"})
+		return nil, false
+	}
+	proxy, err := h.store.Proxies.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Proxy not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch proxy"})
+		return nil, false
+	}
+	return proxy, true
+}
+
+func newProxyStatsResponse(proxy models.Proxy, window time.Duration, stats checkhistory.Stats) ProxyStatsResponse {
+	return ProxyStatsResponse{
+		ProxyID:       proxy.ID,
+		Label:         proxy.Label,
+		ServerID:      proxy.ServerID,
+		GroupID:       proxy.GroupID,
+		Health:        proxy.Health,
+		WindowSeconds: int64(window / time.Second),
+		Stats:         stats,
+	}
+}
+
+// queryID parses an optional ID query parameter, or writes the error
+func queryID(c *gin.Context, param, invalid string) (*uint, bool) {
+	value := c.Query(param)
+	if value == "" {
+		return nil, true
+	}
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": invalid})
+		return nil, false
+	}
+	parsed := uint(id)
+	return &parsed, true
+}
+
+// statsWindow parses the window parameter, a duration such as 90m, 24h or
+// 7d, or writes the error
+func statsWindow(c *gin.Context) (time.Duration, bool) {
+	value := c.Query("window")
+	if value == "" {
+		return defaultStatsWindow, true
+	}
+	var window time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		window = time.Duration(n) * 24 * time.Hour
+	} else {
+		window, err = time.ParseDuration(value)
+	}
+	if err != nil || window <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "window must be a positive duration such as 90m, 24h or 7d"})
+		return 0, false
+	}
+	return window, true
+}
+
+// sortProxyStats orders proxy stats best first by order. Proxies without
+// the value to order by come last, and ties go by ID.
+func sortProxyStats(stats []ProxyStatsResponse, order string) {
+	sort.SliceStable(stats, func(i, j int) bool {
+		a, aOK := statsSortKey(stats[i], order)
+		b, bOK := statsSortKey(stats[j], order)
+		if aOK != bOK {
+			return aOK
+		}
+		if aOK && a != b {
+			return a < b
+		}
+		return stats[i].ProxyID < stats[j].ProxyID
+	})
+}
+
+// statsSortKey returns the value to order proxy stats by, lowest first, and
+// false if the stats have none
+func statsSortKey(stats ProxyStatsResponse, order string) (float64, bool) {
+	switch order {
+	case statsSortUptime:
+		if stats.UptimePercent != nil {
+			return -*stats.UptimePercent, true
+		}
+	case statsSortP50:
+		if stats.LatencyP50MS != nil {
+			return float64(*stats.LatencyP50MS), true
+		}
+	case statsSortP95:
+		if stats.LatencyP95MS != nil {
+			return float64(*stats.LatencyP95MS), true
+		}
+	case statsSortStreak:
+		return float64(stats.FailureStreak), stats.Checks > 0
+	}
+	return 0, false
+}
+
+func proxyIDs(proxies []models.Proxy) []uint {
+	ids := make([]uint, 0, len(proxies))
+	for _, proxy := range proxies {
+		ids = append(ids, proxy.ID)
+	}
+	return ids
+}
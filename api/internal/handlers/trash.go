@@ -0,0 +1,422 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/trash"
+	"github.com/gin-gonic/gin"
+)
+
+// restoreConflict is a restore that cannot go ahead, with the reason
+type restoreConflict string
+
+func (e restoreConflict) Error() string { return string(e) }
+
+type TrashHandler struct {
+	store     *repository.Store
+	retention time.Duration
+}
+
+// NewTrashHandler returns a handler for the trash. Entries are purged after
+// retention; zero keeps them until restored.
+func NewTrashHandler(store *repository.Store, retention time.Duration) *TrashHandler {
+	return &TrashHandler{store: store, retention: retention}
+}
+
+// TrashCascadeResponse counts what an entry took along to the trash
+type TrashCascadeResponse struct {
+	Proxies   int `json:"proxies"`
+	Mappings  int `json:"mappings"`
+	Schedules int `json:"schedules"`
+	Detached  int `json:"detached"` // proxies unassigned, mappings and schedules that lost their upstream
+}
+
+type TrashEntryResponse struct {
+	ID           uint                 `json:"id"`
+	ResourceType string               `json:"resource_type"`
+	ResourceID   uint                 `json:"resource_id"`
+	Label        string               `json:"label"`
+	ServerID     *uint                `json:"server_id"`
+	DeletedBy    string               `json:"deleted_by"`
+	DeletedAt    time.Time            `json:"deleted_at"`
+	PurgeAt      *time.Time           `json:"purge_at"` // null if the trash is kept
+	Cascade      TrashCascadeResponse `json:"cascade"`
+}
+
+type RestoreResponse struct {
+	Message  string               `json:"message"`
+	Entry    TrashEntryResponse   `json:"entry"`
+	Restored TrashCascadeResponse `json:"restored"` // what came back along with the item
+	Notes    []string             `json:"notes"`    // what could not be restored as it was
+}
+
+// GetTrash lists the trash, newest first, optionally of one type
+func (h *TrashHandler) GetTrash(c *gin.Context) {
+	resourceType := c.Query("type")
+	if resourceType != "" && !validTrashType(resourceType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be server, proxy, mapping or group"})
+		return
+	}
+
+	entries, err := h.store.Trash.List(c.Request.Context(), resourceType)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch trash"})
+		return
+	}
+
+	resp := make([]TrashEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		resp = append(resp, h.newTrashEntryResponse(entry))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RestoreTrashItem takes an item out of the trash with everything deleted
+// along with it, re-links what was detached from it, and bumps the config
+// version of every server the restore changes
+func (h *TrashHandler) RestoreTrashItem(c *gin.Context) {
+	resourceType := c.Param("type")
+	if !validTrashType(resourceType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be server, proxy, mapping or group"})
+		return
+	}
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	entry, err := h.store.Trash.Find(ctx, resourceType, uint(id))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not in the trash"})
+		return
+	}
+
+	var restored TrashCascadeResponse
+	var notes []string
+	err = commitChange(ctx, h.store, func(tx *repository.Store, affected affectedServers) error {
+		changes := events.NewChanges(c.GetString("email"))
+		var err error
+		if restored, notes, err = restoreEntry(ctx, tx, entry, affected, changes); err != nil {
+			return err
+		}
+		return changes.Publish(ctx, tx)
+	})
+	var conflict restoreConflict
+	if errors.As(err, &conflict) {
+		c.JSON(http.StatusConflict, gin.H{"error": string(conflict)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore"})
+		return
+	}
+
+	if notes == nil {
+		notes = []string{}
+	}
+	c.JSON(http.StatusOK, RestoreResponse{
+		Message:  "Restored " + resourceType + " " + strconv.FormatUint(id, 10),
+		Entry:    h.newTrashEntryResponse(*entry),
+		Restored: restored,
+		Notes:    notes,
+	})
+}
+
+func validTrashType(resourceType string) bool {
+	switch resourceType {
+	case models.TrashServer, models.TrashProxy, models.TrashMapping, models.TrashGroup:
+		return true
+	}
+	return false
+}
+
+func (h *TrashHandler) newTrashEntryResponse(entry models.TrashEntry) TrashEntryResponse {
+	resp := TrashEntryResponse{
+		ID:           entry.ID,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		Label:        entry.Label,
+		ServerID:     entry.ServerID,
+		DeletedBy:    entry.DeletedBy,
+		DeletedAt:    entry.DeletedAt,
+	}
+	if h.retention > 0 {
+		purgeAt := entry.DeletedAt.Add(h.retention)
+		resp.PurgeAt = &purgeAt
+	}
+	cascade := trash.DecodeCascade(entry.Cascaded)
+	resp.Cascade = TrashCascadeResponse{
+		Proxies:   len(cascade.Proxies),
+		Mappings:  len(cascade.Mappings),
+		Schedules: len(cascade.Schedules),
+		Detached:  len(cascade.Unassigned) + len(cascade.Ungrouped) + len(cascade.Released) + len(cascade.ReleasedSchedules),
+	}
+	return resp
+}
+
+// restoreEntry takes the entry's item and cascade out of the trash. Rows
+// that would point at something no longer available are restored without
+// that relation, or left in the trash if they cannot exist without it; the
+// notes say which. Restored proxies and mappings are recorded in changes as
+// created, re-linked ones as updated.
+func restoreEntry(ctx context.Context, tx *repository.Store, entry *models.TrashEntry, affected affectedServers, changes *events.Changes) (TrashCascadeResponse, []string, error) {
+	var restored TrashCascadeResponse
+	var notes []string
+	note := func(format string, args ...interface{}) {
+		notes = append(notes, fmt.Sprintf(format, args...))
+	}
+	gone := restoreConflict(fmt.Sprintf("The %s no longer exists", entry.ResourceType))
+
+	cascade := trash.DecodeCascade(entry.Cascaded)
+	switch entry.ResourceType {
+	case models.TrashServer:
+		if err := tx.Servers.Restore(ctx, []uint{entry.ResourceID}); err != nil {
+			return restored, nil, err
+		}
+		if _, err := tx.Servers.Get(ctx, entry.ResourceID, false); err != nil {
+			return restored, nil, gone
+		}
+		affected.addID(entry.ResourceID)
+
+	case models.TrashGroup:
+		groups, err := tx.Groups.List(ctx, false)
+		if err != nil {
+			return restored, nil, err
+		}
+		for _, group := range groups {
+			if group.Name == entry.Label {
+				return restored, nil, restoreConflict(fmt.Sprintf("Group %q already exists. Rename it before restoring this one.", entry.Label))
+			}
+		}
+		if err := tx.Groups.Restore(ctx, []uint{entry.ResourceID}); err != nil {
+			return restored, nil, err
+		}
+		if _, err := tx.Groups.Get(ctx, entry.ResourceID); err != nil {
+			return restored, nil, gone
+		}
+
+	case models.TrashProxy:
+		cascade.Proxies = append([]uint{entry.ResourceID}, cascade.Proxies...)
+
+	case models.TrashMapping:
+		if entry.ServerID == nil {
+			return restored, nil, gone
+		}
+		if _, err := tx.Servers.Get(ctx, *entry.ServerID, false); err != nil {
+			return restored, nil, restoreConflict(fmt.Sprintf("Server %d of the mapping is not available. Restore it first.", *entry.ServerID))
+		}
+		cascade.Mappings = append([]uint{entry.ResourceID}, cascade.Mappings...)
+	}
+
+	if len(cascade.Proxies) > 0 {
+		if err := tx.Proxies.Restore(ctx, cascade.Proxies); err != nil {
+			return restored, nil, err
+		}
+		proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: cascade.Proxies})
+		if err != nil {
+			return restored, nil, err
+		}
+		primary := false
+		for _, proxy := range proxies {
+			primary = primary || proxy.ID == entry.ResourceID
+			changes.ProxyCreated(proxy.ID)
+			// Relations that were not loaded are in the trash or gone
+			updates := make(repository.Fields)
+			if proxy.ServerID != nil && proxy.Server.ID == 0 {
+				updates["server_id"] = nil
+				note("proxy %d: server %d is not available, restored unassigned", proxy.ID, *proxy.ServerID)
+			} else {
+				affected.add(proxy.ServerID)
+			}
+			if proxy.GroupID != nil && proxy.Group.ID == 0 {
+				updates["group_id"] = nil
+				note("proxy %d: group %d is not available, restored without a group", proxy.ID, *proxy.GroupID)
+			}
+			if len(updates) > 0 {
+				if err := tx.Proxies.Update(ctx, proxy.ID, updates); err != nil {
+					return restored, nil, err
+				}
+			}
+		}
+		restored.Proxies = len(proxies)
+		if entry.ResourceType == models.TrashProxy {
+			if !primary {
+				return restored, nil, gone
+			}
+			restored.Proxies--
+		}
+	}
+
+	if len(cascade.Mappings) > 0 {
+		if err := tx.Mappings.Restore(ctx, cascade.Mappings); err != nil {
+			return restored, nil, err
+		}
+		mappings, err := tx.Mappings.List(ctx, repository.MappingFilter{IDs: cascade.Mappings})
+		if err != nil {
+			return restored, nil, err
+		}
+		primary := false
+		for _, mapping := range mappings {
+			if mapping.Server.ID == 0 {
+				if err := tx.Mappings.Delete(ctx, mapping.ID); err != nil {
+					return restored, nil, err
+				}
+				note("mapping %d: server %d is not available, left in the trash", mapping.ID, mapping.ServerID)
+				continue
+			}
+			changes.MappingCreated(mapping.ID)
+			if mapping.UpstreamProxyID != nil && mapping.UpstreamProxy.ID == 0 {
+				if err := tx.Mappings.Update(ctx, mapping.ID, repository.Fields{"upstream_proxy_id": nil, "enabled": false}); err != nil {
+					return restored, nil, err
+				}
+				note("mapping %d: upstream proxy %d is not available, restored disabled without an upstream", mapping.ID, *mapping.UpstreamProxyID)
+			}
+			affected.addID(mapping.ServerID)
+			if entry.ResourceType == models.TrashMapping && mapping.ID == entry.ResourceID {
+				primary = true
+			} else {
+				restored.Mappings++
+			}
+		}
+		if entry.ResourceType == models.TrashMapping && !primary {
+			return restored, nil, gone
+		}
+	}
+
+	if len(cascade.Schedules) > 0 {
+		if err := tx.Schedules.Restore(ctx, cascade.Schedules); err != nil {
+			return restored, nil, err
+		}
+		schedules, err := tx.Schedules.List(ctx, repository.ScheduleFilter{IDs: cascade.Schedules})
+		if err != nil {
+			return restored, nil, err
+		}
+		for _, schedule := range schedules {
+			mapping, err := tx.Mappings.Get(ctx, schedule.MappingID)
+			if err != nil {
+				if err := tx.Schedules.Delete(ctx, schedule.ID); err != nil {
+					return restored, nil, err
+				}
+				note("schedule %d: mapping %d is not available, left in the trash", schedule.ID, schedule.MappingID)
+				continue
+			}
+			if schedule.UpstreamProxyID != nil {
+				if _, err := tx.Proxies.Get(ctx, *schedule.UpstreamProxyID); err != nil {
+					if err := tx.Schedules.UpdateMany(ctx, []uint{schedule.ID}, repository.Fields{"upstream_proxy_id": nil, "enabled": false, "active": false}); err != nil {
+						return restored, nil, err
+					}
+					note("schedule %d: upstream proxy %d is not available, restored disabled without an upstream", schedule.ID, *schedule.UpstreamProxyID)
+				}
+			}
+			affected.addID(mapping.ServerID)
+			restored.Schedules++
+		}
+	}
+
+	for _, released := range cascade.Released {
+		mapping, err := tx.Mappings.Get(ctx, released.ID)
+		if err != nil {
+			note("mapping %d: no longer exists, not re-linked", released.ID)
+			continue
+		}
+		if mapping.UpstreamProxyID != nil {
+			note("mapping %d: has upstream proxy %d now, not re-linked", mapping.ID, *mapping.UpstreamProxyID)
+			continue
+		}
+		proxy, err := tx.Proxies.Get(ctx, released.UpstreamProxyID)
+		if err != nil || proxy.ServerID == nil || *proxy.ServerID != mapping.ServerID {
+			note("mapping %d: proxy %d is not available on server %d, not re-linked", mapping.ID, released.UpstreamProxyID, mapping.ServerID)
+			continue
+		}
+		changes.Mappings(*mapping)
+		if err := tx.Mappings.Update(ctx, mapping.ID, repository.Fields{"upstream_proxy_id": released.UpstreamProxyID, "enabled": released.Enabled}); err != nil {
+			return restored, nil, err
+		}
+		affected.addID(mapping.ServerID)
+		restored.Detached++
+	}
+
+	for _, released := range cascade.ReleasedSchedules {
+		schedule, err := tx.Schedules.Get(ctx, released.ID)
+		if err != nil {
+			note("schedule %d: no longer exists, not re-linked", released.ID)
+			continue
+		}
+		if schedule.UpstreamProxyID != nil {
+			note("schedule %d: has upstream proxy %d now, not re-linked", schedule.ID, *schedule.UpstreamProxyID)
+			continue
+		}
+		mapping, err := tx.Mappings.Get(ctx, schedule.MappingID)
+		if err != nil {
+			note("schedule %d: mapping %d is not available, not re-linked", schedule.ID, schedule.MappingID)
+			continue
+		}
+		proxy, err := tx.Proxies.Get(ctx, released.UpstreamProxyID)
+		if err != nil || proxy.ServerID == nil || *proxy.ServerID != mapping.ServerID {
+			note("schedule %d: proxy %d is not available on server %d, not re-linked", schedule.ID, released.UpstreamProxyID, mapping.ServerID)
+			continue
+		}
+		if err := tx.Schedules.UpdateMany(ctx, []uint{schedule.ID}, repository.Fields{"upstream_proxy_id": released.UpstreamProxyID, "enabled": released.Enabled}); err != nil {
+			return restored, nil, err
+		}
+		affected.addID(mapping.ServerID)
+		restored.Detached++
+	}
+
+	if len(cascade.Unassigned) > 0 {
+		proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: cascade.Unassigned})
+		if err != nil {
+			return restored, nil, err
+		}
+		var reassign []uint
+		for _, proxy := range proxies {
+			if proxy.ServerID != nil {
+				note("proxy %d: is on server %d now, left there", proxy.ID, *proxy.ServerID)
+				continue
+			}
+			reassign = append(reassign, proxy.ID)
+			changes.Proxies(proxy)
+		}
+		if len(reassign) > 0 {
+			if err := tx.Proxies.UpdateMany(ctx, reassign, repository.Fields{"server_id": entry.ResourceID}); err != nil {
+				return restored, nil, err
+			}
+			restored.Detached += len(reassign)
+		}
+	}
+
+	if len(cascade.Ungrouped) > 0 {
+		proxies, err := tx.Proxies.List(ctx, repository.ProxyFilter{IDs: cascade.Ungrouped})
+		if err != nil {
+			return restored, nil, err
+		}
+		var regroup []uint
+		for _, proxy := range proxies {
+			if proxy.GroupID != nil {
+				note("proxy %d: is in group %d now, left there", proxy.ID, *proxy.GroupID)
+				continue
+			}
+			regroup = append(regroup, proxy.ID)
+			changes.Proxies(proxy)
+			affected.add(proxy.ServerID)
+		}
+		if len(regroup) > 0 {
+			if err := tx.Proxies.UpdateMany(ctx, regroup, repository.Fields{"group_id": entry.ResourceID}); err != nil {
+				return restored, nil, err
+			}
+			restored.Detached += len(regroup)
+		}
+	}
+
+	return restored, notes, tx.Trash.Delete(ctx, entry.ID)
+}
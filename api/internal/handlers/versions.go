@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultVersionLimit and maxVersionLimit bound how many snapshots a list
+// of versions returns
+const (
+	defaultVersionLimit = 50
+	maxVersionLimit     = 200
+)
+
+type VersionHandler struct {
+	store *repository.Store
+}
+
+func NewVersionHandler(store *repository.Store) *VersionHandler {
+	return &VersionHandler{store: store}
+}
+
+// GetServerVersions lists the config snapshots of a server, newest first
+func (h *VersionHandler) GetServerVersions(c *gin.Context) {
+	serverID, ok := h.findServer(c)
+	if !ok {
+		return
+	}
+
+	limit := defaultVersionLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxVersionLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 200"})
+			return
+		}
+		limit = n
+	}
+
+	snapshots, err := h.store.Snapshots.List(c.Request.Context(), serverID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, snapshots)
+}
+
+// GetServerVersion returns the agent config of one version of a server
+func (h *VersionHandler) GetServerVersion(c *gin.Context) {
+	serverID, ok := h.findServer(c)
+	if !ok {
+		return
+	}
+
+	snapshot, ok := h.findSnapshot(c, serverID, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	var config models.AgentPullResponse
+	if err := json.Unmarshal([]byte(snapshot.Config), &config); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid snapshot config"})
+		return
+	}
+	config.MaskCredentials()
+
+	c.JSON(http.StatusOK, gin.H{
+		"server_id":  snapshot.ServerID,
+		"version":    snapshot.Version,
+		"created_at": snapshot.CreatedAt,
+		"config":     config,
+	})
+}
+
+// DiffServerVersions shows what changed between two versions of a server.
+// ?from defaults to the version before.
+func (h *VersionHandler) DiffServerVersions(c *gin.Context) {
+	serverID, ok := h.findServer(c)
+	if !ok {
+		return
+	}
+
+	to, ok := h.findSnapshot(c, serverID, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	fromParam := c.Query("from")
+	if fromParam == "" {
+		fromParam = strconv.Itoa(to.Version - 1)
+	}
+	from, ok := h.findSnapshot(c, serverID, fromParam)
+	if !ok {
+		return
+	}
+
+	fromDoc, err := configstate.FromSnapshot(from)
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+	toDoc, err := configstate.FromSnapshot(to)
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from": from.Version,
+		"to":   to.Version,
+		"plan": configstate.Diff(fromDoc, toDoc),
+	})
+}
+
+// RollbackServer restores a server's proxies and mappings to an earlier
+// version. The restore is applied as a new version.
+func (h *VersionHandler) RollbackServer(c *gin.Context) {
+	serverID, ok := h.findServer(c)
+	if !ok {
+		return
+	}
+
+	snapshot, ok := h.findSnapshot(c, serverID, c.Param("version"))
+	if !ok {
+		return
+	}
+
+	doc, err := configstate.FromSnapshot(snapshot)
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	result, err := configstate.ApplyServer(c.Request.Context(), h.store, serverID, doc, nil, c.GetString("email"))
+	if err != nil {
+		respondStateError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rolled_back_to": snapshot.Version,
+		"plan":           result.Plan,
+		"config_version": result.ConfigVersion,
+		"applied":        result.Applied,
+	})
+}
+
+func (h *VersionHandler) findServer(c *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid server ID"})
+		return 0, false
+	}
+
+	server, err := h.store.Servers.Get(c.Request.Context(), uint(id), false)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Server not found"})
+		return 0, false
+	}
+
+	return server.ID, true
+}
+
+func (h *VersionHandler) findSnapshot(c *gin.Context, serverID uint, versionParam string) (*models.ConfigSnapshot, bool) {
+	version, err := strconv.Atoi(versionParam)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return nil, false
+	}
+
+	snapshot, err := h.store.Snapshots.Get(c.Request.Context(), serverID, version)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+		return nil, false
+	}
+
+	return snapshot, true
+}
@@ -0,0 +1,415 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
+	"github.com/Chinsusu/proxy-manager/api/internal/webhooks"
+	"github.com/gin-gonic/gin"
+)
+
+// defaultDeliveryLimit and maxDeliveryLimit bound the delivery log pages
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 500
+)
+
+type WebhookHandler struct {
+	store *repository.Store
+}
+
+func NewWebhookHandler(store *repository.Store) *WebhookHandler {
+	return &WebhookHandler{store: store}
+}
+
+type CreateWebhookRequest struct {
+	Name    string   `json:"name" binding:"required"`
+	URL     string   `json:"url" binding:"required"`
+	Secret  string   `json:"secret"` // generated if empty
+	Events  []string `json:"events" binding:"required"`
+	Enabled *bool    `json:"enabled"`
+}
+
+type UpdateWebhookRequest struct {
+	Name    *string   `json:"name"`
+	URL     *string   `json:"url"`
+	Secret  *string   `json:"secret"`
+	Events  *[]string `json:"events"`
+	Enabled *bool     `json:"enabled"`
+}
+
+// WebhookResponse is a webhook. Its secret is masked, except in the
+// response to its creation.
+type WebhookResponse struct {
+	ID        uint      `json:"id"`
+	Name      string    `json:"name"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret"`
+	Events    []string  `json:"events"`
+	Enabled   bool      `json:"enabled"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type DeliveryResponse struct {
+	ID             uint            `json:"id"`
+	WebhookID      uint            `json:"webhook_id"`
+	EventID        string          `json:"event_id"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"next_attempt_at"` // pending deliveries only
+	LastAttemptAt  *time.Time      `json:"last_attempt_at"`
+	ResponseStatus int             `json:"response_status"`
+	ResponseBody   string          `json:"response_body"`
+	Error          string          `json:"error"`
+	Payload        json.RawMessage `json:"payload"`
+	CreatedAt      time.Time       `json:"created_at"`
+}
+
+func newWebhookResponse(w models.Webhook) WebhookResponse {
+	events := []string{}
+	json.Unmarshal([]byte(w.Events), &events)
+	return WebhookResponse{
+		ID:        w.ID,
+		Name:      w.Name,
+		URL:       w.URL,
+		Secret:    secrets.Mask,
+		Events:    events,
+		Enabled:   w.Enabled,
+		CreatedAt: w.CreatedAt,
+		UpdatedAt: w.UpdatedAt,
+	}
+}
+
+func newDeliveryResponse(d models.WebhookDelivery) DeliveryResponse {
+	resp := DeliveryResponse{
+		ID:             d.ID,
+		WebhookID:      d.WebhookID,
+		EventID:        d.EventID,
+		Event:          d.Event,
+		Status:         d.Status,
+		Attempts:       d.Attempts,
+		LastAttemptAt:  d.LastAttemptAt,
+		ResponseStatus: d.ResponseStatus,
+		ResponseBody:   d.ResponseBody,
+		Error:          d.Error,
+		Payload:        json.RawMessage(d.Payload),
+		CreatedAt:      d.CreatedAt,
+	}
+	if d.Status == models.DeliveryPending {
+		resp.NextAttemptAt = &d.NextAttemptAt
+	}
+	return resp
+}
+
+// GetWebhooks returns all webhooks
+func (h *WebhookHandler) GetWebhooks(c *gin.Context) {
+	list, err := h.store.Webhooks.List(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhooks"})
+		return
+	}
+
+	resp := make([]WebhookResponse, 0, len(list))
+	for _, webhook := range list {
+		resp = append(resp, newWebhookResponse(webhook))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// GetWebhook returns a webhook by ID
+func (h *WebhookHandler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, newWebhookResponse(*webhook))
+}
+
+// CreateWebhook creates a webhook. The response is the only one that shows
+// its secret.
+func (h *WebhookHandler) CreateWebhook(c *gin.Context) {
+	var req CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+	if msg := validateWebhookURL(req.URL); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	events, msg := encodeWebhookEvents(req.Events)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		var err error
+		if secret, err = webhooks.NewSecret(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+			return
+		}
+	}
+	sealed, err := secrets.SealSecret(secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret"})
+		return
+	}
+
+	webhook := models.Webhook{
+		Name:    req.Name,
+		URL:     req.URL,
+		Secret:  sealed,
+		Events:  events,
+		Enabled: req.Enabled == nil || *req.Enabled,
+	}
+	if err := h.store.Webhooks.Create(c.Request.Context(), &webhook); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create webhook"})
+		return
+	}
+
+	resp := newWebhookResponse(webhook)
+	resp.Secret = secret
+	c.JSON(http.StatusCreated, resp)
+}
+
+// UpdateWebhook updates a webhook. Deliveries already queued keep their
+// payload but are sent to the new URL with the new secret.
+func (h *WebhookHandler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	var req UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "details": err.Error()})
+		return
+	}
+
+	updates := make(repository.Fields)
+	if req.Name != nil {
+		updates["name"] = *req.Name
+	}
+	if req.URL != nil {
+		if msg := validateWebhookURL(*req.URL); msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updates["url"] = *req.URL
+	}
+	secret, changed, err := secrets.Update(req.Secret)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret"})
+		return
+	}
+	if changed {
+		if secret == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "secret cannot be empty"})
+			return
+		}
+		updates["secret"] = secret
+	}
+	if req.Events != nil {
+		events, msg := encodeWebhookEvents(*req.Events)
+		if msg != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+			return
+		}
+		updates["events"] = events
+	}
+	if req.Enabled != nil {
+		updates["enabled"] = *req.Enabled
+	}
+
+	if len(updates) > 0 {
+		if err := h.store.Webhooks.Update(c.Request.Context(), webhook.ID, updates); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update webhook"})
+			return
+		}
+	}
+
+	updated, err := h.store.Webhooks.Get(c.Request.Context(), webhook.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return
+	}
+	c.JSON(http.StatusOK, newWebhookResponse(*updated))
+}
+
+// DeleteWebhook deletes a webhook with its delivery log and the deliveries
+// it still had queued
+func (h *WebhookHandler) DeleteWebhook(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+	if err := h.store.Webhooks.Delete(c.Request.Context(), webhook.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete webhook"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// TestWebhook queues a ping event for the webhook, even if it is disabled
+func (h *WebhookHandler) TestWebhook(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+	delivery, err := webhooks.Ping(c.Request.Context(), h.store, *webhook)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue test delivery"})
+		return
+	}
+	c.JSON(http.StatusAccepted, newDeliveryResponse(*delivery))
+}
+
+// GetDeliveries returns the delivery log of a webhook, newest first
+func (h *WebhookHandler) GetDeliveries(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+
+	status := c.Query("status")
+	switch status {
+	case "", models.DeliveryPending, models.DeliverySucceeded, models.DeliveryFailed:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status must be pending, succeeded or failed"})
+		return
+	}
+	limit := defaultDeliveryLimit
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxDeliveryLimit {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be between 1 and 500"})
+			return
+		}
+		limit = n
+	}
+
+	deliveries, err := h.store.Deliveries.List(c.Request.Context(), repository.DeliveryFilter{WebhookID: &webhook.ID, Status: status, Limit: limit})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch deliveries"})
+		return
+	}
+
+	resp := make([]DeliveryResponse, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		resp = append(resp, newDeliveryResponse(delivery))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// RetryDelivery queues a failed or succeeded delivery again with a fresh
+// set of attempts
+func (h *WebhookHandler) RetryDelivery(c *gin.Context) {
+	webhook, ok := h.find(c)
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseUint(c.Param("delivery_id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	delivery, err := h.store.Deliveries.Get(ctx, uint(deliveryID))
+	if err != nil || delivery.WebhookID != webhook.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	if delivery.Status == models.DeliveryPending {
+		c.JSON(http.StatusConflict, gin.H{"error": "Delivery is still pending"})
+		return
+	}
+
+	err = h.store.Deliveries.Update(ctx, delivery.ID, repository.Fields{
+		"status":          models.DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now(),
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry delivery"})
+		return
+	}
+
+	retried, err := h.store.Deliveries.Get(ctx, delivery.ID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Delivery not found"})
+		return
+	}
+	c.JSON(http.StatusAccepted, newDeliveryResponse(*retried))
+}
+
+// find loads the webhook of the :id parameter, or writes the error
+func (h *WebhookHandler) find(c *gin.Context) (*models.Webhook, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook ID"})
+		return nil, false
+	}
+	webhook, err := h.store.Webhooks.Get(c.Request.Context(), uint(id))
+	if errors.Is(err, repository.ErrNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch webhook"})
+		return nil, false
+	}
+	return webhook, true
+}
+
+// validateWebhookURL returns why a webhook URL is unusable, or ""
+func validateWebhookURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "url must be an absolute http or https URL"
+	}
+	return ""
+}
+
+// encodeWebhookEvents checks event types and stores them as a JSON array.
+// It returns why they are invalid, or "".
+func encodeWebhookEvents(events []string) (string, string) {
+	if len(events) == 0 {
+		return "", "events cannot be empty; use [\"*\"] for all events"
+	}
+	known := map[string]bool{"*": true}
+	for _, event := range webhooks.Events {
+		known[event] = true
+	}
+	for _, event := range events {
+		if !known[event] {
+			return "", "Unknown event " + strconv.Quote(event)
+		}
+	}
+	encoded, _ := json.Marshal(uniqueStrings(events))
+	return string(encoded), ""
+}
+
+// uniqueStrings drops repeated values, keeping the first of each
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	unique := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,198 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// Anonymity classes stored on models.Proxy, from worst to best
+const (
+	AnonymityTransparent = "transparent" // passes on the address of the client
+	AnonymityAnonymous   = "anonymous"   // hides the client but says it is a proxy
+	AnonymityElite       = "elite"       // looks like a direct request
+)
+
+// ProxyHeaders are the request headers by which proxies give themselves or
+// their clients away
+var ProxyHeaders = []string{
+	"Via",
+	"Forwarded",
+	"X-Forwarded-For",
+	"X-Forwarded-Host",
+	"X-Real-Ip",
+	"X-Client-Ip",
+	"Client-Ip",
+	"X-Proxy-Id",
+	"X-Bluecoat-Via",
+}
+
+// selfTTL is how long the checker's own addresses are kept before the judge
+// is asked again
+const selfTTL = 10 * time.Minute
+
+// maxJudgeBody limits how much of a judge response is read
+const maxJudgeBody = 64 << 10
+
+// JudgeResponse is what a judge answers with: the address a request came
+// from and which of ProxyHeaders it had
+type JudgeResponse struct {
+	RemoteAddr string              `json:"remote_addr"`
+	Headers    map[string][]string `json:"headers"`
+}
+
+// Judge serves requests with their JudgeResponse. It must be reached
+// without a reverse proxy in between, which would add headers of its own.
+func Judge() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := JudgeResponse{RemoteAddr: r.RemoteAddr, Headers: map[string][]string{}}
+		if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			resp.RemoteAddr = host
+		}
+		for _, name := range ProxyHeaders {
+			if values := r.Header.Values(name); len(values) > 0 {
+				resp.Headers[name] = values
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// Anonymity classifies how much a judge learned from a request through a
+// proxy, given the addresses of the client. It returns the class and the
+// headers that gave the proxy or client away. A request the judge sees
+// coming from the client itself is transparent, whatever its headers.
+func Anonymity(judged JudgeResponse, self []netip.Addr) (string, []string) {
+	var leaks []string
+	transparent := containsAddr(judged.RemoteAddr, self)
+	for _, name := range ProxyHeaders {
+		values, ok := judged.Headers[name]
+		if !ok {
+			continue
+		}
+		leaks = append(leaks, name)
+		for _, value := range values {
+			if containsAddr(value, self) {
+				transparent = true
+			}
+		}
+	}
+	switch {
+	case transparent:
+		return AnonymityTransparent, leaks
+	case len(leaks) > 0:
+		return AnonymityAnonymous, leaks
+	}
+	return AnonymityElite, nil
+}
+
+// containsAddr reports whether a header value mentions one of addrs, as in
+// `203.0.113.7, 10.0.0.1` or `for="[2001:db8::1]:4711";proto=http`
+func containsAddr(value string, addrs []netip.Addr) bool {
+	fields := strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\t'
+	})
+	for _, field := range fields {
+		if _, after, ok := strings.Cut(field, "="); ok {
+			field = after
+		}
+		field = strings.Trim(field, `"`)
+		if host, _, err := net.SplitHostPort(field); err == nil {
+			field = host
+		}
+		field = strings.Trim(field, "[]")
+		if addr, err := netip.ParseAddr(field); err == nil && slices.Contains(addrs, addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymity requests the judge URL through proxy and classifies the answer.
+// The judge has answered the checker directly, so a request through proxy
+// that fails in any way is the proxy's failure.
+func (c *Checker) anonymity(ctx context.Context, proxy models.Proxy) (string, []string, error) {
+	self, err := c.self(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := c.client(proxy)
+	if err != nil {
+		return "", nil, err
+	}
+	judged, err := c.judge(ctx, client)
+	if err != nil {
+		return "", nil, &requestError{fmt.Errorf("judge request: %w", err)}
+	}
+	class, leaks := Anonymity(judged, self)
+	return class, leaks, nil
+}
+
+// self returns the addresses the checker has: those of its interfaces and
+// the one the judge sees a direct request from
+func (c *Checker) self(ctx context.Context) ([]netip.Addr, error) {
+	c.selfMu.Lock()
+	defer c.selfMu.Unlock()
+	if c.selfAddrs != nil && time.Since(c.selfAt) < selfTTL {
+		return c.selfAddrs, nil
+	}
+
+	direct := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	judged, err := c.judge(ctx, direct)
+	if err != nil {
+		return nil, fmt.Errorf("direct judge request: %w", err)
+	}
+	addr, err := netip.ParseAddr(judged.RemoteAddr)
+	if err != nil {
+		return nil, fmt.Errorf("judge sees no address: %w", err)
+	}
+	addrs := []netip.Addr{addr.Unmap()}
+	if local, err := net.InterfaceAddrs(); err == nil {
+		for _, a := range local {
+			if prefix, err := netip.ParsePrefix(a.String()); err == nil {
+				addrs = append(addrs, prefix.Addr().Unmap())
+			}
+		}
+	}
+	c.selfAddrs, c.selfAt = addrs, time.Now()
+	return addrs, nil
+}
+
+// judge requests the judge URL with client
+func (c *Checker) judge(ctx context.Context, client *http.Client) (JudgeResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var judged JudgeResponse
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.JudgeURL, nil)
+	if err != nil {
+		return judged, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return judged, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return judged, fmt.Errorf("judge returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxJudgeBody))
+	if err != nil {
+		return judged, err
+	}
+	if err := json.Unmarshal(body, &judged); err != nil {
+		return judged, fmt.Errorf("judge response: %w", err)
+	}
+	return judged, nil
+}
@@ -0,0 +1,254 @@
+package healthcheck
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// exitHeader is set by the test proxy on requests it forwards, for the test
+// judge to report as their remote address: everything here runs on
+// loopback, so the judge would otherwise see the checker's own address.
+const exitHeader = "X-Test-Exit"
+
+// exitAddr is where the test proxy pretends to forward requests from
+const exitAddr = "198.51.100.7"
+
+func TestContainsAddr(t *testing.T) {
+	self := []netip.Addr{netip.MustParseAddr("203.0.113.7"), netip.MustParseAddr("2001:db8::1")}
+
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"203.0.113.7", true},
+		{"203.0.113.7:51234", true},
+		{"10.0.0.1, 203.0.113.7", true},
+		{"10.0.0.1,203.0.113.7", true},
+		{"::ffff:203.0.113.7", true},
+		{`for="[2001:db8::1]:4711";proto=http`, true},
+		{"for=203.0.113.7;by=10.0.0.1", true},
+		{"2001:db8::1", true},
+		{"10.0.0.1, 10.0.0.2", false},
+		{"1.1 squid (squid/5.7)", false},
+		{"203.0.113.70", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := containsAddr(tt.value, self); got != tt.want {
+			t.Errorf("containsAddr(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAnonymity(t *testing.T) {
+	self := []netip.Addr{netip.MustParseAddr("203.0.113.7")}
+
+	tests := []struct {
+		name      string
+		judged    JudgeResponse
+		wantClass string
+		wantLeaks []string
+	}{
+		{
+			name:      "no headers",
+			judged:    JudgeResponse{RemoteAddr: exitAddr},
+			wantClass: AnonymityElite,
+		},
+		{
+			name: "client address forwarded",
+			judged: JudgeResponse{RemoteAddr: exitAddr, Headers: map[string][]string{
+				"Via":             {"1.1 proxy"},
+				"X-Forwarded-For": {"203.0.113.7"},
+			}},
+			wantClass: AnonymityTransparent,
+			wantLeaks: []string{"Via", "X-Forwarded-For"},
+		},
+		{
+			name: "other address forwarded",
+			judged: JudgeResponse{RemoteAddr: exitAddr, Headers: map[string][]string{
+				"X-Forwarded-For": {"10.0.0.1"},
+			}},
+			wantClass: AnonymityAnonymous,
+			wantLeaks: []string{"X-Forwarded-For"},
+		},
+		{
+			name: "proxy named",
+			judged: JudgeResponse{RemoteAddr: exitAddr, Headers: map[string][]string{
+				"Via": {"1.1 proxy"},
+			}},
+			wantClass: AnonymityAnonymous,
+			wantLeaks: []string{"Via"},
+		},
+		{
+			name:      "request from the client itself",
+			judged:    JudgeResponse{RemoteAddr: "203.0.113.7"},
+			wantClass: AnonymityTransparent,
+		},
+		{
+			name:      "request from the client itself over IPv6",
+			judged:    JudgeResponse{RemoteAddr: "::ffff:203.0.113.7"},
+			wantClass: AnonymityTransparent,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			class, leaks := Anonymity(tt.judged, self)
+			if class != tt.wantClass || !reflect.DeepEqual(leaks, tt.wantLeaks) {
+				t.Fatalf("Anonymity() = %s, %v; want %s, %v", class, leaks, tt.wantClass, tt.wantLeaks)
+			}
+		})
+	}
+}
+
+func TestCheckClassifiesAnonymity(t *testing.T) {
+	judge := newTestJudge(t)
+
+	tests := []struct {
+		mode      string
+		wantClass string
+		wantLeaks []string
+	}{
+		{"transparent", AnonymityTransparent, []string{"X-Forwarded-For"}},
+		{"anonymous", AnonymityAnonymous, []string{"Via"}},
+		{"elite", AnonymityElite, nil},
+		// A proxy that connects to the judge from the checker's own
+		// address hides nothing
+		{"direct", AnonymityTransparent, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			checker := New()
+			checker.Timeout = 5 * time.Second
+			checker.JudgeURL = judge.URL
+
+			result := checker.Check(context.Background(), newTestProxy(t, tt.mode))
+			if result.Health != HealthOK || result.AnonymityError != "" {
+				t.Fatalf("health %s, error %q, anonymity error %q", result.Health, result.Error, result.AnonymityError)
+			}
+			if result.Anonymity != tt.wantClass || !reflect.DeepEqual(result.AnonymityLeaks, tt.wantLeaks) {
+				t.Fatalf("anonymity = %s, %v; want %s, %v", result.Anonymity, result.AnonymityLeaks, tt.wantClass, tt.wantLeaks)
+			}
+		})
+	}
+}
+
+func TestCheckFailsProxyFailingRequests(t *testing.T) {
+	judge := newTestJudge(t)
+	proxy := newTestProxy(t, "broken")
+
+	tests := []struct {
+		name    string
+		checker *Checker
+	}{
+		{"echo", &Checker{Timeout: 5 * time.Second, EchoURL: judge.URL}},
+		{"judge", &Checker{Timeout: 5 * time.Second, JudgeURL: judge.URL}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.checker.Check(context.Background(), proxy)
+			if result.Health != HealthFail || result.ErrorClass != ErrorOther || result.Error == "" {
+				t.Fatalf("health %s, error %q (%s); want a failure", result.Health, result.Error, result.ErrorClass)
+			}
+		})
+	}
+}
+
+func TestCheckKeepsHealthWithoutJudge(t *testing.T) {
+	judge := newTestJudge(t)
+	judge.Close()
+
+	checker := &Checker{Timeout: 5 * time.Second, JudgeURL: judge.URL}
+	result := checker.Check(context.Background(), newTestProxy(t, "elite"))
+	if result.Health != HealthOK || result.Anonymity != "" || result.AnonymityError == "" {
+		t.Fatalf("health %s, anonymity %q, anonymity error %q", result.Health, result.Anonymity, result.AnonymityError)
+	}
+}
+
+// newTestJudge starts a Judge that reports requests forwarded by the test
+// proxy as coming from exitAddr
+func newTestJudge(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	judge := Judge()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if exit := r.Header.Get(exitHeader); exit != "" {
+			r.Header.Del(exitHeader)
+			r.RemoteAddr = net.JoinHostPort(exit, "40000")
+		}
+		judge.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// newTestProxy starts a forwarding HTTP proxy and returns it as a
+// models.Proxy. By mode, it passes on the client's address (transparent),
+// names itself (anonymous), adds nothing (elite), does not even hide where
+// the request comes from (direct) or fails every request (broken).
+func newTestProxy(t *testing.T, mode string) models.Proxy {
+	t.Helper()
+
+	transport := &http.Transport{Proxy: nil, DisableKeepAlives: true}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if mode == "broken" {
+			http.Error(w, "upstream unreachable", http.StatusBadGateway)
+			return
+		}
+		if !r.URL.IsAbs() {
+			http.Error(w, "not a proxy request", http.StatusBadRequest)
+			return
+		}
+		out, err := http.NewRequestWithContext(r.Context(), r.Method, r.URL.String(), r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		out.Header = r.Header.Clone()
+		out.Header.Del("Proxy-Connection")
+		out.Header.Del("Proxy-Authorization")
+		switch mode {
+		case "transparent":
+			client, _, _ := net.SplitHostPort(r.RemoteAddr)
+			out.Header.Set("X-Forwarded-For", client)
+		case "anonymous":
+			out.Header.Set("Via", "1.1 test-proxy")
+		}
+		if mode != "direct" {
+			out.Header.Set(exitHeader, exitAddr)
+		}
+
+		resp, err := transport.RoundTrip(out)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer resp.Body.Close()
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(server.Close)
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return models.Proxy{Label: mode, Type: "http", Host: u.Hostname(), Port: port}
+}
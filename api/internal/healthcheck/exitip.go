@@ -0,0 +1,158 @@
+package healthcheck
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// maxEchoBody limits how much of an echo response is read
+const maxEchoBody = 4096
+
+// exitIP requests the echo URL through proxy and returns the IP address it
+// answers with
+func (c *Checker) exitIP(ctx context.Context, proxy models.Proxy) (string, error) {
+	client, err := c.client(proxy)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.EchoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", &requestError{fmt.Errorf("echo request: %w", err)}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &requestError{fmt.Errorf("echo endpoint returned %s", resp.Status)}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxEchoBody))
+	if err != nil {
+		return "", &requestError{fmt.Errorf("echo response: %w", err)}
+	}
+	return parseEcho(body)
+}
+
+// parseEcho reads an IP address from a plain text body, or from the ip or
+// origin field of a JSON body
+func parseEcho(body []byte) (string, error) {
+	if addr, err := netip.ParseAddr(strings.TrimSpace(string(body))); err == nil {
+		return addr.Unmap().String(), nil
+	}
+	var fields struct {
+		IP     string `json:"ip"`
+		Origin string `json:"origin"` // may list proxies after the client
+	}
+	if json.Unmarshal(body, &fields) == nil {
+		for _, value := range []string{fields.IP, fields.Origin} {
+			first, _, _ := strings.Cut(value, ",")
+			if addr, err := netip.ParseAddr(strings.TrimSpace(first)); err == nil {
+				return addr.Unmap().String(), nil
+			}
+		}
+	}
+	return "", errors.New("echo response has no IP address")
+}
+
+// client returns an HTTP client sending every request through proxy
+func (c *Checker) client(proxy models.Proxy) (*http.Client, error) {
+	username, err := proxy.Username.Open()
+	if err != nil {
+		return nil, err
+	}
+	password, err := proxy.Password.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	address := net.JoinHostPort(proxy.Host, strconv.Itoa(proxy.Port))
+	transport := &http.Transport{
+		DisableKeepAlives:     true,
+		TLSHandshakeTimeout:   c.Timeout,
+		ResponseHeaderTimeout: c.Timeout,
+	}
+	switch proxy.Type {
+	case "http", "https", "socks5":
+		proxyURL := &url.URL{Scheme: proxy.Type, Host: address}
+		if username != "" || password != "" {
+			proxyURL.User = url.UserPassword(username, password)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	case "socks4":
+		transport.DialContext = func(ctx context.Context, network, target string) (net.Conn, error) {
+			return c.dialSOCKS4(ctx, address, username, target)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy type %q", proxy.Type)
+	}
+	return &http.Client{
+		Transport: transport,
+		// Redirects would be followed through the proxy too, but echo
+		// endpoints answer directly
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}, nil
+}
+
+// dialSOCKS4 opens a connection to target through the SOCKS4a proxy at
+// address
+func (c *Checker) dialSOCKS4(ctx context.Context, address, userID, target string) (net.Conn, error) {
+	host, portText, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portText, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := c.dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Version 4, connect, port and IPv4 address, or 0.0.0.1 and the host
+	// name after the user ID for the proxy to resolve
+	req := []byte{4, 1, byte(port >> 8), byte(port)}
+	ip := net.ParseIP(host).To4()
+	if ip != nil {
+		req = append(req, ip...)
+	} else {
+		req = append(req, 0, 0, 0, 1)
+	}
+	req = append(append(req, userID...), 0)
+	if ip == nil {
+		req = append(append(req, host...), 0)
+	}
+	reply := make([]byte, 8)
+	if _, err = conn.Write(req); err == nil {
+		_, err = io.ReadFull(conn, reply)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks4: %w", err)
+	}
+	if reply[1] != 0x5a {
+		conn.Close()
+		return nil, fmt.Errorf("socks4: request rejected with code %d", reply[1])
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
@@ -0,0 +1,183 @@
+// Package healthcheck probes upstream proxies on demand. A proxy is healthy
+// if a TCP connection to it can be opened within the timeout. With an echo
+// URL set, the check also discovers the proxy's exit IP by requesting it
+// through the proxy, and with a judge URL its anonymity by what a judge
+// sees of a request through it; a proxy those requests fail through is not
+// healthy either.
+package healthcheck
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/geoip"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// Defaults for New
+const (
+	DefaultTimeout = 5 * time.Second
+	DefaultWorkers = 16
+)
+
+// Health values stored on models.Proxy
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// Error classes of failed checks
+const (
+	ErrorTimeout     = "timeout"
+	ErrorRefused     = "refused"
+	ErrorDNS         = "dns" // the proxy's host name did not resolve
+	ErrorUnreachable = "unreachable"
+	ErrorReset       = "reset"
+	ErrorOther       = "other"
+)
+
+// Result of checking one proxy
+type Result struct {
+	Health      string
+	Latency     time.Duration // time to connect, zero if it failed
+	Error       string
+	ErrorClass  string // empty if it succeeded
+	CheckedAt   time.Time
+	ExitIP      string // empty if not discovered
+	ExitIPError string // why the exit IP was not discovered, for healthy proxies
+	Location    geoip.Location
+
+	Anonymity      string   // empty if not classified
+	AnonymityLeaks []string // headers that gave the proxy away
+	AnonymityError string   // why it was not classified, for healthy proxies
+}
+
+// Checker checks proxies with a timeout each and a bounded number of checks
+// in flight
+type Checker struct {
+	Timeout time.Duration
+	Workers int
+	EchoURL string         // answers with the caller's IP address; empty skips exit IP discovery
+	Geo     *geoip.Locator // locates exit IPs
+	// JudgeURL is a Judge requested through proxies to classify their
+	// anonymity; empty skips it
+	JudgeURL string
+
+	dialer net.Dialer
+
+	// Addresses of the checker itself, looked for in what proxies pass on
+	selfMu    sync.Mutex
+	selfAddrs []netip.Addr
+	selfAt    time.Time
+}
+
+func New() *Checker {
+	return &Checker{Timeout: DefaultTimeout, Workers: DefaultWorkers}
+}
+
+// Check checks a single proxy
+func (c *Checker) Check(ctx context.Context, proxy models.Proxy) Result {
+	dialCtx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	conn, err := c.dialer.DialContext(dialCtx, "tcp", net.JoinHostPort(proxy.Host, strconv.Itoa(proxy.Port)))
+	if err != nil {
+		return Result{Health: HealthFail, Error: err.Error(), ErrorClass: Classify(err), CheckedAt: start}
+	}
+	latency := time.Since(start)
+	conn.Close()
+	result := Result{Health: HealthOK, Latency: latency, CheckedAt: start}
+
+	// A proxy that accepts connections but fails the echo or judge request
+	// through it is as unusable as one refusing them. It stays healthy if
+	// only the answer is unusable or the judge cannot be reached directly,
+	// since the endpoint is what is wrong then.
+	if c.EchoURL != "" {
+		if result.ExitIP, err = c.exitIP(ctx, proxy); err != nil {
+			if requestFailed(err) {
+				return Result{Health: HealthFail, Error: err.Error(), ErrorClass: Classify(err), CheckedAt: start}
+			}
+			result.ExitIPError = err.Error()
+		} else {
+			result.Location = c.Geo.Lookup(result.ExitIP)
+		}
+	}
+	if c.JudgeURL != "" {
+		if result.Anonymity, result.AnonymityLeaks, err = c.anonymity(ctx, proxy); err != nil {
+			if requestFailed(err) {
+				return Result{Health: HealthFail, Error: err.Error(), ErrorClass: Classify(err), CheckedAt: start}
+			}
+			result.AnonymityError = err.Error()
+		}
+	}
+	return result
+}
+
+// requestError is a request through a proxy that failed or was answered
+// with an error
+type requestError struct {
+	err error
+}
+
+func (e *requestError) Error() string { return e.err.Error() }
+func (e *requestError) Unwrap() error { return e.err }
+
+// requestFailed reports whether err is a failed request through a proxy
+func requestFailed(err error) bool {
+	var reqErr *requestError
+	return errors.As(err, &reqErr)
+}
+
+// Classify returns the error class of a failed connection
+func Classify(err error) string {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, context.DeadlineExceeded), os.IsTimeout(err):
+		return ErrorTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrorRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return ErrorUnreachable
+	case errors.Is(err, syscall.ECONNRESET):
+		return ErrorReset
+	}
+	return ErrorOther
+}
+
+// CheckAll checks proxies concurrently and returns the results by proxy ID
+func (c *Checker) CheckAll(ctx context.Context, proxies []models.Proxy) map[uint]Result {
+	workers := c.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make(map[uint]Result, len(proxies))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, workers)
+	for _, proxy := range proxies {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(proxy models.Proxy) {
+			defer wg.Done()
+			defer func() { <-slots }()
+
+			result := c.Check(ctx, proxy)
+			mu.Lock()
+			results[proxy.ID] = result
+			mu.Unlock()
+		}(proxy)
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,85 @@
+// Package liveness marks servers offline once their agent stops reporting
+// in. Agents set their server online on every pull and ack.
+package liveness
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// checkInterval is how often servers are checked
+const checkInterval = 30 * time.Second
+
+// Monitor sets servers offline whose agent was not seen for longer than a
+// timeout
+type Monitor struct {
+	store   *repository.Store
+	timeout time.Duration
+}
+
+// New returns a monitor. A timeout of zero never marks servers offline, and
+// Run returns at once.
+func New(store *repository.Store, timeout time.Duration) *Monitor {
+	return &Monitor{store: store, timeout: timeout}
+}
+
+// Run checks servers every checkInterval until ctx is cancelled
+func (m *Monitor) Run(ctx context.Context) {
+	if m.timeout <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		if marked, err := m.Check(ctx, time.Now()); err != nil {
+			slog.Error("Server liveness check failed", "component", "liveness", "error", err)
+		} else if marked > 0 {
+			slog.Info("Marked servers offline", "component", "liveness", "servers", marked)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check sets every online server offline whose agent was last seen more
+// than the timeout before now, and returns their number. Each transition
+// is published as ServerOffline.
+func (m *Monitor) Check(ctx context.Context, now time.Time) (int, error) {
+	servers, err := m.store.Servers.List(ctx, false)
+	if err != nil {
+		return 0, err
+	}
+
+	cutoff := now.Add(-m.timeout)
+	marked := 0
+	for _, server := range servers {
+		if server.Status != "online" || (server.LastSeenAt != nil && !server.LastSeenAt.Before(cutoff)) {
+			continue
+		}
+		var changed bool
+		err := m.store.Transaction(ctx, func(tx *repository.Store) error {
+			// Skipped if the agent reported in meanwhile
+			var err error
+			if changed, err = tx.Servers.MarkOffline(ctx, server.ID, cutoff); err != nil || !changed {
+				return err
+			}
+			return events.Publish(ctx, tx, events.ServerOffline{Server: events.NewServerState(server)})
+		})
+		if err != nil {
+			return marked, err
+		}
+		if changed {
+			marked++
+		}
+	}
+	return marked, nil
+}
@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// gormLogger logs SQL through slog, with the request attributes of the
+// statement's context. Statements are logged with placeholders instead of
+// their parameters, so credentials and tokens never reach the log.
+type gormLogger struct {
+	level logger.LogLevel
+	all   bool          // log every statement, not only failed and slow ones
+	slow  time.Duration // statements taking longer are logged as slow; zero disables
+}
+
+// NewGormLogger returns a GORM logger that logs failed statements, those
+// slower than slow, and every statement if all is set
+func NewGormLogger(all bool, slow time.Duration) logger.Interface {
+	return &gormLogger{level: logger.Info, all: all, slow: slow}
+}
+
+func (l *gormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.level = level
+	return &copied
+}
+
+func (l *gormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Info {
+		slog.InfoContext(ctx, fmt.Sprintf(msg, data...), "component", "gorm")
+	}
+}
+
+func (l *gormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Warn {
+		slog.WarnContext(ctx, fmt.Sprintf(msg, data...), "component", "gorm")
+	}
+}
+
+func (l *gormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.level >= logger.Error {
+		slog.ErrorContext(ctx, fmt.Sprintf(msg, data...), "component", "gorm")
+	}
+}
+
+func (l *gormLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	if l.level <= logger.Silent {
+		return
+	}
+
+	elapsed := time.Since(begin)
+	var level slog.Level
+	var msg string
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound) && l.level >= logger.Error:
+		level, msg = slog.LevelError, "SQL failed"
+	case l.slow > 0 && elapsed > l.slow && l.level >= logger.Warn:
+		level, msg = slog.LevelWarn, "Slow SQL"
+	case l.all && l.level >= logger.Info:
+		level, msg = slog.LevelInfo, "SQL"
+	default:
+		return
+	}
+
+	sql, rows := fc()
+	attrs := []any{"component", "gorm", "sql", sql, "rows", rows, "duration_ms", elapsed.Milliseconds()}
+	if err != nil {
+		attrs = append(attrs, "error", err)
+	}
+	slog.Log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter drops the parameters of statements before they are logged
+func (l *gormLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	return sql, nil
+}
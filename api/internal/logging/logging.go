@@ -0,0 +1,72 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// redacted replaces the value of sensitive attributes
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are parts of attribute keys whose values are never logged
+var sensitiveKeys = []string{"password", "passwd", "secret", "token", "authorization", "cookie", "credential", "api_key"}
+
+// Setup installs a JSON logger writing to stdout at level as the default
+// for slog and the log package. Request attributes and the trace of the
+// context are added to every record, and sensitive attributes are redacted.
+func Setup(level slog.Level) *slog.Logger {
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level:       level,
+		ReplaceAttr: redact,
+	})
+	logger := slog.New(contextHandler{handler})
+	slog.SetDefault(logger)
+	return logger
+}
+
+// ParseLevel reads debug, info, warn or error
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(s))
+	return level, err
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	for _, sensitive := range sensitiveKeys {
+		if strings.Contains(key, sensitive) {
+			return slog.String(a.Key, redacted)
+		}
+	}
+	return a
+}
+
+// contextHandler adds the request attributes and the trace of the context
+// to records
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if info := requestFrom(ctx); info != nil {
+		r.AddAttrs(info.attrs()...)
+	}
+	if ctx != nil {
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			r.AddAttrs(slog.String("trace_id", sc.TraceID().String()), slog.String("span_id", sc.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,146 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID. An ID sent by the client or a
+// proxy in front of the API is kept; otherwise one is generated.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs taken from clients
+const maxRequestIDLength = 64
+
+// quietRoutes are polled often and logged at debug level only
+var quietRoutes = map[string]bool{
+	"/metrics":             true,
+	"/api/v1/admin/health": true,
+}
+
+type requestKey struct{}
+
+// requestInfo is what is known about a request. The user is only known
+// once authentication ran, so it is set later on the same value.
+type requestInfo struct {
+	mu      sync.Mutex
+	id      string
+	route   string
+	agentID string
+	user    string
+}
+
+func requestFrom(ctx context.Context) *requestInfo {
+	if ctx == nil {
+		return nil
+	}
+	info, _ := ctx.Value(requestKey{}).(*requestInfo)
+	return info
+}
+
+func (r *requestInfo) attrs() []slog.Attr {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	attrs := []slog.Attr{slog.String("request_id", r.id), slog.String("route", r.route)}
+	if r.user != "" {
+		attrs = append(attrs, slog.String("user", r.user))
+	}
+	if r.agentID != "" {
+		attrs = append(attrs, slog.String("agent_id", r.agentID))
+	}
+	return attrs
+}
+
+// SetUser records who made the request, for the records logged after
+// authentication
+func SetUser(ctx context.Context, email string) {
+	if info := requestFrom(ctx); info != nil {
+		info.mu.Lock()
+		info.user = email
+		info.mu.Unlock()
+	}
+}
+
+// RequestID returns the ID of the request of ctx, or "" outside a request
+func RequestID(ctx context.Context) string {
+	if info := requestFrom(ctx); info != nil {
+		return info.id
+	}
+	return ""
+}
+
+// Middleware gives each request an ID, logs the request when it completes
+// and turns panics into a logged 500. It replaces gin's logger and
+// recovery.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if id == "" || len(id) > maxRequestIDLength {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		info := &requestInfo{id: id, route: route, agentID: c.Param("agent_id")}
+		ctx := context.WithValue(c.Request.Context(), requestKey{}, info)
+		c.Request = c.Request.WithContext(ctx)
+
+		defer func() {
+			if err := recover(); err != nil {
+				// A handler aborting a response on purpose is not a bug
+				if e, ok := err.(error); ok && errors.Is(e, http.ErrAbortHandler) {
+					panic(err)
+				}
+				slog.ErrorContext(ctx, "Panic while handling request", "panic", fmt.Sprint(err), "stack", string(debug.Stack()))
+				if !c.Writer.Written() {
+					c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+				} else {
+					c.Abort()
+				}
+			}
+
+			status := c.Writer.Status()
+			level := slog.LevelInfo
+			switch {
+			case status >= http.StatusInternalServerError:
+				level = slog.LevelError
+			case status >= http.StatusBadRequest:
+				level = slog.LevelWarn
+			case quietRoutes[route]:
+				level = slog.LevelDebug
+			}
+			slog.Log(ctx, level, "Request handled",
+				"method", c.Request.Method,
+				"path", c.Request.URL.Path,
+				"status", status,
+				"duration_ms", time.Since(start).Milliseconds(),
+				"bytes", max(c.Writer.Size(), 0),
+				"client_ip", c.ClientIP(),
+			)
+		}()
+
+		c.Next()
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
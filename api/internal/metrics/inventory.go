@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queryTimeout bounds the inventory queries of one scrape
+const queryTimeout = 5 * time.Second
+
+var (
+	serversDesc = prometheus.NewDesc(namespace+"_servers",
+		"Servers by agent status and service state.",
+		[]string{"status", "service_state"}, nil)
+	proxiesDesc = prometheus.NewDesc(namespace+"_proxies",
+		"Proxies by health and type.",
+		[]string{"health", "type"}, nil)
+	mappingsDesc = prometheus.NewDesc(namespace+"_mappings",
+		"Mappings by whether they are enabled.",
+		[]string{"enabled"}, nil)
+	configVersionDesc = prometheus.NewDesc(namespace+"_server_config_version",
+		"Current config version of a server.",
+		[]string{"server_id", "server"}, nil)
+	appliedVersionDesc = prometheus.NewDesc(namespace+"_server_applied_version",
+		"Config version the server's agent last acked.",
+		[]string{"server_id", "server"}, nil)
+	versionDriftDesc = prometheus.NewDesc(namespace+"_server_config_version_drift",
+		"Config versions the server's agent is behind.",
+		[]string{"server_id", "server"}, nil)
+	lastPullDesc = prometheus.NewDesc(namespace+"_server_last_pull_age_seconds",
+		"Seconds since the server's agent last pulled or acked. Not set for agents that never did.",
+		[]string{"server_id", "server"}, nil)
+)
+
+// inventoryCollector reads servers, proxies and mappings from the database
+// on each scrape. Deleted items are not counted.
+type inventoryCollector struct {
+	db *database.DB
+}
+
+func newInventoryCollector(db *database.DB) *inventoryCollector {
+	return &inventoryCollector{db: db}
+}
+
+func (c *inventoryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- serversDesc
+	ch <- proxiesDesc
+	ch <- mappingsDesc
+	ch <- configVersionDesc
+	ch <- appliedVersionDesc
+	ch <- versionDriftDesc
+	ch <- lastPullDesc
+}
+
+func (c *inventoryCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
+	defer cancel()
+	db := c.db.WithContext(ctx)
+
+	var servers []models.Server
+	if err := db.Select("id", "name", "status", "service_state", "config_version", "applied_version", "last_seen_at").
+		Order("id").Find(&servers).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(serversDesc, err)
+	} else {
+		now := time.Now()
+		type state struct{ status, serviceState string }
+		counts := make(map[state]int)
+		for _, server := range servers {
+			counts[state{server.Status, server.ServiceState}]++
+
+			id := strconv.FormatUint(uint64(server.ID), 10)
+			ch <- prometheus.MustNewConstMetric(configVersionDesc, prometheus.GaugeValue, float64(server.ConfigVersion), id, server.Name)
+			ch <- prometheus.MustNewConstMetric(appliedVersionDesc, prometheus.GaugeValue, float64(server.AppliedVersion), id, server.Name)
+			ch <- prometheus.MustNewConstMetric(versionDriftDesc, prometheus.GaugeValue, float64(server.ConfigVersion-server.AppliedVersion), id, server.Name)
+			if server.LastSeenAt != nil {
+				ch <- prometheus.MustNewConstMetric(lastPullDesc, prometheus.GaugeValue, now.Sub(*server.LastSeenAt).Seconds(), id, server.Name)
+			}
+		}
+		for s, n := range counts {
+			ch <- prometheus.MustNewConstMetric(serversDesc, prometheus.GaugeValue, float64(n), s.status, s.serviceState)
+		}
+	}
+
+	var proxies []struct {
+		Health string
+		Type   string
+		Count  int64
+	}
+	if err := db.Model(&models.Proxy{}).Select("health, type, COUNT(*) AS count").
+		Group("health, type").Scan(&proxies).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(proxiesDesc, err)
+	} else {
+		for _, row := range proxies {
+			ch <- prometheus.MustNewConstMetric(proxiesDesc, prometheus.GaugeValue, float64(row.Count), row.Health, row.Type)
+		}
+	}
+
+	var mappings []struct {
+		Enabled bool
+		Count   int64
+	}
+	if err := db.Model(&models.Mapping{}).Select("enabled, COUNT(*) AS count").
+		Group("enabled").Scan(&mappings).Error; err != nil {
+		ch <- prometheus.NewInvalidMetric(mappingsDesc, err)
+	} else {
+		// Both series are always there, so alerts on either see a zero
+		counts := map[bool]int64{true: 0, false: 0}
+		for _, row := range mappings {
+			counts[row.Enabled] += row.Count
+		}
+		for enabled, n := range counts {
+			ch <- prometheus.MustNewConstMetric(mappingsDesc, prometheus.GaugeValue, float64(n), strconv.FormatBool(enabled))
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the manager
+const namespace = "pgm"
+
+// Metrics is the Prometheus registry of the API: HTTP requests, the
+// database pool, and the inventory read from the database on each scrape
+type Metrics struct {
+	registry *prometheus.Registry
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	events   *prometheus.CounterVec
+}
+
+func New(db *database.DB) (*Metrics, error) {
+	sqlDB, err := db.DB.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests handled, by route and status code.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests, by route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		events: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_total",
+			Help:      "Domain events handled by the event bus, by event.",
+		}, []string{"event"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		collectors.NewDBStatsCollector(sqlDB, db.DB.Dialector.Name()),
+		m.requests,
+		m.duration,
+		m.events,
+		newInventoryCollector(db),
+	)
+	return m, nil
+}
+
+// Middleware counts and times requests by route template, so /proxies/1
+// and /proxies/2 are the same series
+func (m *Metrics) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requests.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).Inc()
+		m.duration.WithLabelValues(c.Request.Method, route).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format. If token is
+// set, scrapes must send it as a bearer token.
+func (m *Metrics) Handler(token string) gin.HandlerFunc {
+	serve := promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+	return func(c *gin.Context) {
+		if token != "" {
+			given := []byte(c.GetHeader("Authorization"))
+			if subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid metrics token"})
+				return
+			}
+		}
+		serve.ServeHTTP(c.Writer, c.Request)
+	}
+}
+
+// Subscriber counts domain events, as an event bus handler. An event
+// retried after a crash may be counted twice.
+func (m *Metrics) Subscriber(ctx context.Context, tx *repository.Store, record events.Record) error {
+	m.events.WithLabelValues(record.Event.Name()).Inc()
+	return nil
+}
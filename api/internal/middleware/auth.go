@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/Chinsusu/proxy-manager/api/internal/logging"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 )
@@ -45,12 +46,30 @@ func JWTAuth(jwtSecret string) gin.HandlerFunc {
 			c.Set("user_id", claims["user_id"])
 			c.Set("email", claims["email"])
 			c.Set("role", claims["role"])
+			logging.SetUser(c.Request.Context(), c.GetString("email"))
 		}
 
 		c.Next()
 	}
 }
 
+// RequireRole rejects requests whose token role is not one of roles.
+// Must run after JWTAuth.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role := c.GetString("role")
+		for _, allowed := range roles {
+			if role == allowed {
+				c.Next()
+				return
+			}
+		}
+
+		c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+		c.Abort()
+	}
+}
+
 // AgentAuth middleware for agent authentication
 func AgentAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -72,8 +91,8 @@ func CORS() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Header("Access-Control-Allow-Origin", "*")
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Agent-Token")
-		c.Header("Access-Control-Expose-Headers", "Content-Length")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Agent-Token, X-Request-ID, traceparent, tracestate, baggage")
+		c.Header("Access-Control-Expose-Headers", "Content-Length, X-Request-ID")
 		c.Header("Access-Control-Allow-Credentials", "true")
 
 		if c.Request.Method == "OPTIONS" {
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/secrets"
 	"gorm.io/gorm"
 )
 
@@ -22,11 +25,19 @@ type ProxyGroup struct {
 	Description string    `json:"description"`
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+	DeletedAt   gorm.DeletedAt `json:"-" gorm:"index"` // in the trash
 	
 	// Relationships
-	Proxies []Proxy `json:"proxies,omitempty" gorm:"foreignKey:GroupID"`
+	Proxies []Proxy `json:"-" gorm:"foreignKey:GroupID"`
 }
 
+// Server service states. A server in maintenance keeps its mappings but
+// agents are told to drain and all mappings are rendered disabled.
+const (
+	ServiceActive      = "active"
+	ServiceMaintenance = "maintenance"
+)
+
 // Server represents a proxy server node/agent
 type Server struct {
 	ID           uint      `json:"id" gorm:"primarykey"`
@@ -38,12 +49,19 @@ type Server struct {
 	Status       string    `json:"status" gorm:"default:offline"` // online/offline
 	AgentToken   string    `json:"-" gorm:"uniqueIndex;not null"` // for agent auth
 	ConfigVersion int      `json:"config_version" gorm:"default:0"`
+	AppliedVersion int     `json:"applied_version" gorm:"default:0"` // last version the agent acked
+	AppliedStatus string   `json:"applied_status"`
+	AppliedAt    *time.Time `json:"applied_at"`
+	ServiceState string    `json:"service_state" gorm:"default:active"` // active, maintenance
+	MaintenanceReason string `json:"maintenance_reason"`
+	MaintenanceSince *time.Time `json:"maintenance_since"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+	DeletedAt    gorm.DeletedAt `json:"-" gorm:"index"` // in the trash
 	
 	// Relationships
-	Proxies  []Proxy   `json:"proxies,omitempty"`
-	Mappings []Mapping `json:"mappings,omitempty"`
+	Proxies  []Proxy   `json:"-"`
+	Mappings []Mapping `json:"-"`
 }
 
 // Proxy represents upstream proxy server
@@ -55,61 +73,530 @@ type Proxy struct {
 	Type       string    `json:"type" gorm:"not null"` // http, socks5, etc.
 	Host       string    `json:"host" gorm:"not null"`
 	Port       int       `json:"port" gorm:"not null"`
-	Username   string    `json:"username"`
-	Password   string    `json:"password"`
+	Username   secrets.Text   `json:"username"` // sealed if ENCRYPT_PROXY_USERNAMES is set
+	Password   secrets.Secret `json:"password"` // sealed, masked in JSON
 	Health     string    `json:"health" gorm:"default:unknown"` // ok, fail, unknown
+	Tags       string    `json:"tags" gorm:"default:'[]'"` // JSON array as string
+	ExitIP     string    `json:"exit_ip"` // as the echo endpoint sees it, empty if not discovered
+	Country    string    `json:"country"` // ISO code of the exit IP's country
+	City       string    `json:"city"`
+	ASN        uint      `json:"asn"`
+	ASOrg      string    `json:"as_org"`
+	Anonymity  string    `json:"anonymity"` // transparent, anonymous, elite; empty if not classified
+	ConsecutiveFailures  int        `json:"consecutive_failures"`  // failed checks in a row up to the last one
+	ConsecutiveSuccesses int        `json:"consecutive_successes"` // ok checks in a row up to the last one
+	QuarantinedAt        *time.Time `json:"quarantined_at"`        // left out of agent configs since then
+	QuarantinePolicyID   *uint      `json:"quarantine_policy_id"`  // policy that quarantined it
+	ProviderID     *uint      `json:"provider_id"`
+	ExpiresAt      *time.Time `json:"expires_at"`       // nil if it does not expire
+	Cost           float64    `json:"cost"`             // per month
+	OrderRef       string     `json:"order_ref"`        // the provider's order or subscription reference
+	ExpiryWarnedAt *time.Time `json:"expiry_warned_at"` // when its coming expiry was warned about
+	DisabledAt     *time.Time `json:"disabled_at"`      // left out of agent configs since it expired or was disabled in bulk
 	CreatedAt  time.Time `json:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at"`
+	DeletedAt  gorm.DeletedAt `json:"-" gorm:"index"` // in the trash
 	
 	// Relationships
-	Server   Server     `json:"server,omitempty"`
-	Group    ProxyGroup `json:"group,omitempty"`
-	Mappings []Mapping  `json:"mappings,omitempty" gorm:"foreignKey:UpstreamProxyID"`
+	Server   Server     `json:"-"`
+	Group    ProxyGroup `json:"-"`
+	Mappings []Mapping  `json:"-" gorm:"foreignKey:UpstreamProxyID"`
 }
 
 // Mapping represents client to proxy mapping rules
 type Mapping struct {
 	ID               uint      `json:"id" gorm:"primarykey"`
 	ServerID         uint      `json:"server_id" gorm:"not null"`
-	ClientCIDR       string    `json:"client_cidr" gorm:"not null"`
+	ClientCIDR       string    `json:"client_cidr" gorm:"column:client_cidr;not null"`
 	DstPorts         string    `json:"dst_ports"` // JSON array as string
-	UpstreamProxyID  uint      `json:"upstream_proxy_id" gorm:"not null"`
+	UpstreamProxyID  *uint     `json:"upstream_proxy_id"` // nil once the proxy was deleted with force=disable
 	Enabled          bool      `json:"enabled" gorm:"default:true"`
 	Notes            string    `json:"notes"`
+	Tags             string    `json:"tags" gorm:"default:'[]'"` // JSON array as string
 	CreatedAt        time.Time `json:"created_at"`
 	UpdatedAt        time.Time `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"` // in the trash
 	
 	// Relationships
-	Server        Server `json:"server,omitempty"`
-	UpstreamProxy Proxy  `json:"upstream_proxy,omitempty"`
+	Server        Server `json:"-"`
+	UpstreamProxy Proxy  `json:"-"`
+}
+
+// Mapping schedule actions
+const (
+	ScheduleActionEnable         = "enable"
+	ScheduleActionDisable        = "disable"
+	ScheduleActionSwitchUpstream = "switch_upstream"
+)
+
+// MappingSchedule overrides a mapping while one of its time windows is open
+type MappingSchedule struct {
+	ID               uint       `json:"id" gorm:"primarykey"`
+	MappingID        uint       `json:"mapping_id" gorm:"not null;index"`
+	Name             string     `json:"name"`
+	Kind             string     `json:"kind" gorm:"not null"` // cron, weekly
+	CronExpr         string     `json:"cron_expr"`            // cron: window opens on each fire time
+	DurationMinutes  int        `json:"duration_minutes"`     // cron: how long each window stays open
+	Weekdays         string     `json:"weekdays"`             // weekly: e.g. "mon,tue,wed"
+	StartTime        string     `json:"start_time"`           // weekly: HH:MM
+	EndTime          string     `json:"end_time"`             // weekly: HH:MM, may wrap past midnight
+	Timezone         string     `json:"timezone" gorm:"not null"`
+	Action           string     `json:"action" gorm:"not null"` // enable, disable, switch_upstream
+	UpstreamProxyID  *uint      `json:"upstream_proxy_id"`      // switch_upstream target
+	Enabled          bool       `json:"enabled"`
+	Active           bool       `json:"active"` // window open as last applied by the scheduler
+	LastTransitionAt *time.Time `json:"last_transition_at"`
+	CreatedAt        time.Time  `json:"created_at"`
+	UpdatedAt        time.Time  `json:"updated_at"`
+	DeletedAt        gorm.DeletedAt `json:"-" gorm:"index"` // deleted with its mapping or upstream proxy
+
+	NextTransitionAt *time.Time `json:"next_transition_at,omitempty" gorm:"-"`
 }
 
 // AuditLog represents system audit trail
 type AuditLog struct {
 	ID        uint      `json:"id" gorm:"primarykey"`
 	Actor     string    `json:"actor" gorm:"not null"` // user email or "system"
-	Action    string    `json:"action" gorm:"not null"` // create, update, delete
+	Action    string    `json:"action" gorm:"not null"` // create, update, delete; quarantine, restore, release; disable
 	Resource  string    `json:"resource" gorm:"not null"` // server, proxy, mapping
 	Before    string    `json:"before"` // JSON
 	After     string    `json:"after"` // JSON
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// Trash resource types
+const (
+	TrashServer  = "server"
+	TrashProxy   = "proxy"
+	TrashMapping = "mapping"
+	TrashGroup   = "group"
+)
+
+// TrashEntry records a soft-deleted server, proxy, mapping or group. Cascade
+// lists the rows deleted or detached along with it, so a restore can put
+// them back.
+type TrashEntry struct {
+	ID           uint      `json:"id" gorm:"primarykey"`
+	ResourceType string    `json:"resource_type" gorm:"not null"`
+	ResourceID   uint      `json:"resource_id" gorm:"not null"`
+	Label        string    `json:"label"`     // name of the item when it was deleted
+	ServerID     *uint     `json:"server_id"` // server a proxy or mapping was on
+	DeletedBy    string    `json:"deleted_by"`
+	Cascaded     string    `json:"-"` // JSON
+	DeletedAt    time.Time `json:"deleted_at"`
+}
+
+// ConfigSnapshot is the immutable config of a server at one config version
+type ConfigSnapshot struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	ServerID  uint      `json:"server_id" gorm:"not null;uniqueIndex:idx_config_snapshots_server_version"`
+	Version   int       `json:"version" gorm:"not null;uniqueIndex:idx_config_snapshots_server_version"`
+	Config    string    `json:"-" gorm:"type:text;not null"` // rendered AgentPullResponse, JSON
+	State     string    `json:"-" gorm:"type:text;not null"` // proxy and mapping rows, JSON
+	Proxies   int       `json:"proxies"`
+	Mappings  int       `json:"mappings"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Change set statuses
+const (
+	ChangeSetDraft      = "draft"
+	ChangeSetRunning    = "running"
+	ChangeSetCompleted  = "completed"
+	ChangeSetHalted     = "halted"
+	ChangeSetRolledBack = "rolled_back"
+)
+
+// Change set target statuses
+const (
+	TargetPending    = "pending"
+	TargetApplied    = "applied"   // waiting for the agent to ack
+	TargetSucceeded  = "succeeded" // acked and proxies stayed healthy
+	TargetFailed     = "failed"
+	TargetRolledBack = "rolled_back"
+	TargetSkipped    = "skipped" // server was in maintenance
+)
+
+// ChangeSet is a drafted set of server config changes rolled out in waves.
+// Wave 0 is the canary; each wave must succeed before the next one starts.
+type ChangeSet struct {
+	ID                uint       `json:"id" gorm:"primarykey"`
+	Name              string     `json:"name" gorm:"not null"`
+	Description       string     `json:"description"`
+	Status            string     `json:"status" gorm:"not null;index"`
+	CurrentWave       int        `json:"current_wave"`
+	AckTimeoutSeconds int        `json:"ack_timeout_seconds"` // how long agents have to ack a wave
+	HealthWaitSeconds int        `json:"health_wait_seconds"` // how long proxies must stay healthy after the ack
+	Error             string     `json:"error"`
+	StartedAt         *time.Time `json:"started_at"`
+	FinishedAt        *time.Time `json:"finished_at"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+
+	Targets []ChangeSetTarget `json:"targets,omitempty"`
+}
+
+// ChangeSetTarget is the desired state document for one server of a change set
+type ChangeSetTarget struct {
+	ID             uint       `json:"id" gorm:"primarykey"`
+	ChangeSetID    uint       `json:"change_set_id" gorm:"not null;index"`
+	ServerID       uint       `json:"server_id" gorm:"not null"`
+	Wave           int        `json:"wave"`
+	Document       string     `json:"-" gorm:"type:text;not null"` // configstate.Document, JSON
+	Status         string     `json:"status" gorm:"not null"`
+	BaseVersion    int        `json:"base_version"`    // server version before the apply, restored on rollback
+	AppliedVersion int        `json:"applied_version"` // server version the apply produced
+	FailingBefore  int        `json:"failing_before"`  // proxies with health "fail" before the apply
+	Error          string     `json:"error"`
+	AppliedAt      *time.Time `json:"applied_at"`
+	AckedAt        *time.Time `json:"acked_at"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+
+	DesiredState json.RawMessage `json:"document,omitempty" gorm:"-"`
+}
+
+// Webhook event types
+const (
+	EventProxyHealthChanged = "proxy.health_changed"
+	EventProxyQuarantine    = "proxy.quarantine" // quarantined, restored or released
+	EventProxyExpiring      = "proxy.expiring"
+	EventProxyExpired       = "proxy.expired"
+	EventServerOffline      = "server.offline"
+	EventServerOnline       = "server.online"
+	EventAgentApplyFailed   = "agent.apply_failed"
+	EventMappingChanged     = "mapping.changed"
+	EventBulkFinished       = "bulk.finished"
+	EventPing               = "ping" // sent by the test endpoint only
+)
+
+// Webhook is a subscription that receives the events it lists as signed
+// HTTP POSTs
+type Webhook struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	Name      string         `json:"name" gorm:"not null"`
+	URL       string         `json:"url" gorm:"not null"`
+	Secret    secrets.Secret `json:"secret"` // sealed, signs the deliveries
+	Events    string         `json:"events" gorm:"not null"` // JSON array of event types, "*" for all
+	Enabled   bool           `json:"enabled"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed" // gave up after the last attempt
+)
+
+// WebhookDelivery is one event queued for one webhook. Deliveries are
+// written in the transaction of the change they report, and sent from this
+// table until they succeed or run out of attempts.
+type WebhookDelivery struct {
+	ID             uint       `json:"id" gorm:"primarykey"`
+	WebhookID      uint       `json:"webhook_id" gorm:"not null;index"`
+	EventID        string     `json:"event_id" gorm:"not null"`
+	Event          string     `json:"event" gorm:"not null"`
+	Payload        string     `json:"-" gorm:"type:text;not null"` // body as sent, JSON
+	Status         string     `json:"status" gorm:"not null"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  time.Time  `json:"next_attempt_at"`
+	LastAttemptAt  *time.Time `json:"last_attempt_at"`
+	ResponseStatus int        `json:"response_status"`
+	ResponseBody   string     `json:"response_body"` // truncated
+	Error          string     `json:"error"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// OutboxEvent is a domain event written in the transaction of the change
+// it describes. The event bus hands it to every subscriber after commit and
+// records who handled it, so no subscriber misses or repeats it.
+type OutboxEvent struct {
+	ID            uint       `json:"id" gorm:"primarykey"`
+	Name          string     `json:"name" gorm:"not null"`
+	Payload       string     `json:"payload" gorm:"type:text;not null"` // JSON
+	Handled       string     `json:"handled" gorm:"not null"`           // JSON array of subscribers done with it
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastError     string     `json:"last_error"`
+	ProcessedAt   *time.Time `json:"processed_at"` // set once every subscriber handled it
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+// Alert rule kinds
+const (
+	AlertGroupFailing  = "group_failing"  // share of a group's proxies failing, in percent
+	AlertServerOffline = "server_offline" // a server is offline
+	AlertVersionLag    = "version_lag"    // a server's agent has not applied its config version
+	AlertLatencyP95    = "latency_p95"    // p95 check latency of a proxy, in milliseconds
+	AlertProxyExpiring = "proxy_expiring" // a proxy expires within a number of days
+)
+
+// AlertRule is a condition evaluated every interval. It raises an alert for
+// each subject it holds for, such as each offline server of a rule on all
+// servers, which fires once it held for ForSeconds.
+type AlertRule struct {
+	ID              uint       `json:"id" gorm:"primarykey"`
+	Name            string     `json:"name" gorm:"not null"`
+	Kind            string     `json:"kind" gorm:"not null"`
+	GroupID         *uint      `json:"group_id"`  // group_failing, latency_p95 and proxy_expiring: proxies of this group, all if nil
+	ServerID        *uint      `json:"server_id"` // server_offline and version_lag: this server, all if nil
+	Threshold       float64    `json:"threshold"` // group_failing: percent; latency_p95: milliseconds; proxy_expiring: days
+	ForSeconds      int        `json:"for_seconds"`
+	IntervalSeconds int        `json:"interval_seconds" gorm:"not null"`
+	WindowSeconds   int        `json:"window_seconds"`           // latency_p95: checks this recent count
+	Channels        string     `json:"channels" gorm:"not null"` // JSON array of alert channel IDs
+	Enabled         bool       `json:"enabled"`
+	EvaluatedAt     *time.Time `json:"evaluated_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// Alert states
+const (
+	AlertPending  = "pending" // the condition holds, but not for long enough yet
+	AlertFiring   = "firing"
+	AlertResolved = "resolved"
+)
+
+// Alert is a rule's condition on one subject, from the evaluation it was
+// first seen until it no longer holds. Pending alerts whose condition
+// clears are dropped; firing ones are resolved and kept.
+type Alert struct {
+	ID         uint       `json:"id" gorm:"primarykey"`
+	RuleID     uint       `json:"rule_id" gorm:"not null"`
+	Subject    string     `json:"subject" gorm:"not null"` // e.g. "server:3", "group:2", "proxies" for all proxies
+	Summary    string     `json:"summary"`
+	State      string     `json:"state" gorm:"not null"`
+	Value      float64    `json:"value"` // at the last evaluation that saw the condition
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at"`
+	ResolvedAt *time.Time `json:"resolved_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// AlertSilence mutes the notifications of alerts that fire or resolve
+// between StartsAt and EndsAt. Alerts are still tracked.
+type AlertSilence struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	RuleID    *uint     `json:"rule_id"` // nil silences every rule
+	Subject   string    `json:"subject"` // empty silences every subject
+	Comment   string    `json:"comment"`
+	CreatedBy string    `json:"created_by"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Alert channel types
+const (
+	ChannelEmail    = "email"    // sent over the SMTP server of the API config
+	ChannelWebhook  = "webhook"  // queued for a webhook subscription
+	ChannelTelegram = "telegram" // sent with the sendMessage method of a Telegram-style bot API
+)
+
+// AlertChannel is where the notifications of the rules listing it go
+type AlertChannel struct {
+	ID        uint           `json:"id" gorm:"primarykey"`
+	Name      string         `json:"name" gorm:"not null"`
+	Type      string         `json:"type" gorm:"not null"`
+	Target    string         `json:"target"`     // email: comma-separated recipients; telegram: chat ID
+	URL       string         `json:"url"`        // telegram: bot API base URL
+	Token     secrets.Secret `json:"token"`      // sealed; telegram: bot token
+	WebhookID *uint          `json:"webhook_id"` // webhook: the webhook to send through
+	Enabled   bool           `json:"enabled"`
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Alert notification statuses
+const (
+	NotificationPending  = "pending"
+	NotificationSent     = "sent"   // for webhook channels, queued as a delivery
+	NotificationFailed   = "failed" // gave up after the last attempt
+	NotificationSilenced = "silenced"
+)
+
+// AlertNotification is an alert firing or resolving, queued for one
+// channel
+type AlertNotification struct {
+	ID            uint       `json:"id" gorm:"primarykey"`
+	AlertID       uint       `json:"alert_id" gorm:"not null;index"`
+	ChannelID     uint       `json:"channel_id" gorm:"not null"`
+	Event         string     `json:"event" gorm:"not null"`       // alert.firing or alert.resolved
+	Payload       string     `json:"-" gorm:"type:text;not null"` // the alert as it fired or resolved, JSON
+	Status        string     `json:"status" gorm:"not null"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"next_attempt_at"`
+	LastAttemptAt *time.Time `json:"last_attempt_at"`
+	Error         string     `json:"error"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// ProxyCheck is the result of one health check of a proxy. Checks older
+// than the raw retention are downsampled into ProxyCheckRollups.
+type ProxyCheck struct {
+	ID         uint      `json:"id" gorm:"primarykey"`
+	ProxyID    uint      `json:"proxy_id" gorm:"not null"`
+	CheckedAt  time.Time `json:"checked_at"`
+	OK         bool      `json:"ok"`
+	LatencyMS  int       `json:"latency_ms"` // ok checks only
+	Error      string    `json:"error"`
+	ErrorClass string    `json:"error_class"` // failed checks only: timeout, refused, dns, unreachable, reset or other
+	ExitIP     string    `json:"exit_ip"`     // empty if not discovered
+}
+
+// ProxyCheckRollup sums up the checks of a proxy within one hour
+type ProxyCheckRollup struct {
+	ID          uint      `json:"-" gorm:"primarykey"`
+	ProxyID     uint      `json:"proxy_id" gorm:"not null"`
+	BucketStart time.Time `json:"bucket_start" gorm:"not null"`
+	Checks      int       `json:"checks"`
+	Failures    int       `json:"failures"`
+	LatencyP50  int       `json:"latency_p50_ms"` // of the ok checks, zero without any
+	LatencyP95  int       `json:"latency_p95_ms"`
+	LatencyMax  int       `json:"latency_max_ms"`
+}
+
+// QuarantinePolicy quarantines the proxies of a group, or with a nil
+// GroupID those of groups without a policy and of no group, after Failures
+// failed checks in a row, and restores them after Successes ok checks in a
+// row. Mappings of a quarantined proxy are routed to the fallback proxy,
+// else to a healthy proxy of the fallback group on their server, else left
+// out of the agent config.
+type QuarantinePolicy struct {
+	ID              uint      `json:"id" gorm:"primarykey"`
+	Name            string    `json:"name" gorm:"not null"`
+	GroupID         *uint     `json:"group_id"`
+	Failures        int       `json:"failures" gorm:"not null"`
+	Successes       int       `json:"successes" gorm:"not null"`
+	FallbackProxyID *uint     `json:"fallback_proxy_id"`
+	FallbackGroupID *uint     `json:"fallback_group_id"`
+	Enabled         bool      `json:"enabled"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Quarantine transitions
+const (
+	QuarantineEntered  = "quarantined"
+	QuarantineRestored = "restored" // by ok checks
+	QuarantineReleased = "released" // by a user
+)
+
+// QuarantineTransition records a proxy going into or out of quarantine
+type QuarantineTransition struct {
+	ID            uint      `json:"id" gorm:"primarykey"`
+	ProxyID       uint      `json:"proxy_id" gorm:"not null"`
+	PolicyID      *uint     `json:"policy_id"`
+	ServerID      *uint     `json:"server_id"`
+	Action        string    `json:"action" gorm:"not null"`
+	Reason        string    `json:"reason"`
+	Actor         string    `json:"actor"`          // user email, "system" for checks
+	ConfigVersion int       `json:"config_version"` // of the server after the transition, zero without one
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// Provider is who proxies were bought from
+type Provider struct {
+	ID        uint      `json:"id" gorm:"primarykey"`
+	Name      string    `json:"name" gorm:"not null"`
+	Website   string    `json:"website"`
+	Notes     string    `json:"notes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Agent modes
+const (
+	AgentModeNormal = "normal"
+	AgentModeDrain  = "drain" // stop accepting new client connections, let existing ones finish
+)
+
+// AgentProxy is a proxy as sent to agents. Its credentials are the stored
+// (sealed) values in snapshots and plain text once opened for a pull.
+type AgentProxy struct {
+	Proxy
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// NewAgentProxy copies a proxy with its credentials as stored
+func NewAgentProxy(p Proxy) AgentProxy {
+	return AgentProxy{Proxy: p, Username: string(p.Username), Password: string(p.Password)}
+}
+
+// Stored returns the proxy row with the credentials as stored
+func (p AgentProxy) Stored() Proxy {
+	proxy := p.Proxy
+	proxy.Username = secrets.Text(p.Username)
+	proxy.Password = secrets.Secret(p.Password)
+	return proxy
+}
+
+// AgentMapping is a mapping as sent to agents
+type AgentMapping struct {
+	Mapping
+	UpstreamProxy AgentProxy `json:"upstream_proxy,omitempty"`
+}
+
 // AgentPullResponse represents response for agent pull
 type AgentPullResponse struct {
-	Version  int       `json:"version"`
-	Proxies  []Proxy   `json:"proxies"`
-	Mappings []Mapping `json:"mappings"`
-}
-
-// AutoMigrate runs database migrations
-func AutoMigrate(db *gorm.DB) error {
-	return db.AutoMigrate(
-		&User{},
-		&ProxyGroup{},
-		&Server{},
-		&Proxy{},
-		&Mapping{},
-		&AuditLog{},
-	)
+	Version  int            `json:"version"`
+	Mode     string         `json:"mode"`
+	Proxies  []AgentProxy   `json:"proxies"`
+	Mappings []AgentMapping `json:"mappings"`
+}
+
+// OpenCredentials decrypts every proxy credential in place, for sending the
+// config to its agent.
+func (r *AgentPullResponse) OpenCredentials() error {
+	for i := range r.Proxies {
+		if err := r.Proxies[i].openCredentials(); err != nil {
+			return err
+		}
+	}
+	for i := range r.Mappings {
+		if err := r.Mappings[i].UpstreamProxy.openCredentials(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MaskCredentials hides every proxy credential in place, for showing the
+// config in the admin API.
+func (r *AgentPullResponse) MaskCredentials() {
+	for i := range r.Proxies {
+		r.Proxies[i].maskCredentials()
+	}
+	for i := range r.Mappings {
+		r.Mappings[i].UpstreamProxy.maskCredentials()
+	}
+}
+
+func (p *AgentProxy) openCredentials() error {
+	username, err := secrets.Text(p.Username).Open()
+	if err != nil {
+		return err
+	}
+	password, err := secrets.Secret(p.Password).Open()
+	if err != nil {
+		return err
+	}
+	p.Username, p.Password = username, password
+	return nil
+}
+
+func (p *AgentProxy) maskCredentials() {
+	if secrets.IsSealed(p.Username) {
+		p.Username = secrets.Mask
+	}
+	if p.Password != "" {
+		p.Password = secrets.Mask
+	}
 }
@@ -0,0 +1,141 @@
+// Package quarantine takes failing proxies out of agent configs and puts
+// them back once they recover. Every check result extends a proxy's streak
+// of failed or ok checks; the quarantine policy of its group quarantines it
+// after enough failures and restores it after enough successes. Each
+// transition bumps the config version of the proxy's server, whose config
+// then leaves the proxy out and routes its mappings to the policy's
+// fallback, and is recorded.
+package quarantine
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// System is the actor of transitions made by checks
+const System = "system"
+
+// Observe counts the result of a check of a proxy in its streaks and
+// quarantines or restores it as its policy says. proxy is its state before
+// the check, and reason why a failed check failed.
+func Observe(ctx context.Context, tx *repository.Store, proxy models.Proxy, ok bool, reason string) error {
+	failures, successes := 0, 0
+	if ok {
+		successes = proxy.ConsecutiveSuccesses + 1
+	} else {
+		failures = proxy.ConsecutiveFailures + 1
+	}
+	err := tx.Proxies.Update(ctx, proxy.ID, repository.Fields{
+		"consecutive_failures":  failures,
+		"consecutive_successes": successes,
+	})
+	if err != nil {
+		return err
+	}
+
+	if proxy.QuarantinedAt == nil {
+		if ok {
+			return nil
+		}
+		policy, err := tx.QuarantinePolicies.For(ctx, proxy.GroupID)
+		if err != nil || policy == nil || failures < policy.Failures {
+			return err
+		}
+		return Quarantine(ctx, tx, proxy, *policy, fmt.Sprintf("%d failed checks in a row, the last: %s", failures, reason))
+	}
+
+	if !ok {
+		return nil
+	}
+	// Without its policy any longer, one ok check restores it
+	needed := 1
+	if proxy.QuarantinePolicyID != nil {
+		policy, err := tx.QuarantinePolicies.Get(ctx, *proxy.QuarantinePolicyID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			return err
+		}
+		if policy != nil {
+			needed = policy.Successes
+		}
+	}
+	if successes < needed {
+		return nil
+	}
+	return Restore(ctx, tx, proxy, models.QuarantineRestored, fmt.Sprintf("%d ok checks in a row", successes), System)
+}
+
+// Quarantine quarantines a proxy under policy
+func Quarantine(ctx context.Context, tx *repository.Store, proxy models.Proxy, policy models.QuarantinePolicy, reason string) error {
+	now := time.Now()
+	policyID := policy.ID
+	transition := models.QuarantineTransition{
+		ProxyID:  proxy.ID,
+		PolicyID: &policyID,
+		ServerID: proxy.ServerID,
+		Action:   models.QuarantineEntered,
+		Reason:   reason,
+		Actor:    System,
+	}
+	fields := repository.Fields{"quarantined_at": &now, "quarantine_policy_id": &policyID}
+	version, err := transit(ctx, tx, proxy, fields, &transition)
+	if err != nil {
+		return err
+	}
+	return events.Publish(ctx, tx, events.ProxyQuarantined{
+		Proxy:         events.NewProxyState(proxy),
+		PolicyID:      &policyID,
+		Reason:        reason,
+		ConfigVersion: version,
+	})
+}
+
+// Restore takes a proxy out of quarantine, as restored by checks or
+// released by actor. Its failure streak starts over, so it gets as many
+// failed checks as any other proxy before it is quarantined again.
+func Restore(ctx context.Context, tx *repository.Store, proxy models.Proxy, action, reason, actor string) error {
+	transition := models.QuarantineTransition{
+		ProxyID:  proxy.ID,
+		PolicyID: proxy.QuarantinePolicyID,
+		ServerID: proxy.ServerID,
+		Action:   action,
+		Reason:   reason,
+		Actor:    actor,
+	}
+	fields := repository.Fields{"quarantined_at": nil, "quarantine_policy_id": nil, "consecutive_failures": 0}
+	version, err := transit(ctx, tx, proxy, fields, &transition)
+	if err != nil {
+		return err
+	}
+	return events.Publish(ctx, tx, events.ProxyRestored{
+		Proxy:         events.NewProxyState(proxy),
+		Action:        action,
+		Reason:        reason,
+		Actor:         actor,
+		ConfigVersion: version,
+	})
+}
+
+// transit updates a proxy with fields, bumps the config version of its
+// server and records transition with the new version, which it returns
+func transit(ctx context.Context, tx *repository.Store, proxy models.Proxy, fields repository.Fields, transition *models.QuarantineTransition) (int, error) {
+	if err := tx.Proxies.Update(ctx, proxy.ID, fields); err != nil {
+		return 0, err
+	}
+	if proxy.ServerID != nil {
+		if err := tx.Servers.BumpConfigVersion(ctx, *proxy.ServerID); err != nil {
+			return 0, err
+		}
+		server, err := tx.Servers.Get(ctx, *proxy.ServerID, false)
+		if err != nil {
+			return 0, err
+		}
+		transition.ConfigVersion = server.ConfigVersion
+	}
+	return transition.ConfigVersion, tx.QuarantineTransitions.Record(ctx, transition)
+}
@@ -0,0 +1,1214 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/database"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"gorm.io/gorm"
+)
+
+// New returns a store backed by db. The same implementation serves every
+// backend database.Open supports; the schema differences between them live
+// in the migrations.
+func New(db *database.DB) *Store {
+	store := newStore(db.DB)
+	store.ping = func(ctx context.Context) error {
+		sqlDB, err := db.DB.DB()
+		if err != nil {
+			return err
+		}
+		return sqlDB.PingContext(ctx)
+	}
+	store.transaction = func(ctx context.Context, fn func(tx *Store) error) error {
+		return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			return fn(newStore(tx))
+		})
+	}
+	return store
+}
+
+func newStore(db *gorm.DB) *Store {
+	return &Store{
+		Servers:    gormServers{db},
+		Proxies:    gormProxies{db},
+		Mappings:   gormMappings{db},
+		Schedules:  gormSchedules{db},
+		Groups:     gormGroups{db},
+		Users:      gormUsers{db},
+		Audit:      gormAudit{db},
+		Trash:      gormTrash{db},
+		Webhooks:   gormWebhooks{db},
+		Deliveries: gormDeliveries{db},
+		Outbox:     gormOutbox{db},
+
+		AlertRules:         gormAlertRules{db},
+		Alerts:             gormAlerts{db},
+		AlertSilences:      gormAlertSilences{db},
+		AlertChannels:      gormAlertChannels{db},
+		AlertNotifications: gormAlertNotifications{db},
+		Checks:             gormChecks{db},
+
+		QuarantinePolicies:    gormQuarantinePolicies{db},
+		QuarantineTransitions: gormQuarantineTransitions{db},
+		Providers:             gormProviders{db},
+
+		Snapshots:  gormSnapshots{db},
+		ChangeSets: gormChangeSets{db},
+	}
+}
+
+// notFound maps GORM's missing record error to ErrNotFound
+func notFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// updated returns ErrNotFound if an update or delete matched no rows
+func updated(result *gorm.DB) error {
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// restore clears deleted_at of the given rows of model's table. Deletes of
+// models with a gorm.DeletedAt field only set it.
+func restore(db *gorm.DB, model interface{}, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return db.Unscoped().Model(model).Where("id IN ? AND deleted_at IS NOT NULL", ids).Update("deleted_at", nil).Error
+}
+
+type gormServers struct{ db *gorm.DB }
+
+func (r gormServers) query(ctx context.Context, withRelations bool) *gorm.DB {
+	query := r.db.WithContext(ctx)
+	if withRelations {
+		query = query.Preload("Proxies").Preload("Mappings")
+	}
+	return query
+}
+
+func (r gormServers) List(ctx context.Context, withRelations bool) ([]models.Server, error) {
+	var servers []models.Server
+	err := r.query(ctx, withRelations).Order("id").Find(&servers).Error
+	return servers, err
+}
+
+func (r gormServers) Get(ctx context.Context, id uint, withRelations bool) (*models.Server, error) {
+	var server models.Server
+	if err := r.query(ctx, withRelations).First(&server, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &server, nil
+}
+
+func (r gormServers) Create(ctx context.Context, server *models.Server) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(server).Error; err != nil {
+			return err
+		}
+		// Record the empty initial config so the server can be rolled back to it
+		return (&database.DB{DB: tx}).RecordConfigSnapshot(server.ID)
+	})
+}
+
+func (r gormServers) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Server{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormServers) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		mappings := tx.Model(&models.Mapping{}).Select("id").Where("server_id = ?", id)
+		if err := tx.Where("mapping_id IN (?)", mappings).Delete(&models.MappingSchedule{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("server_id = ?", id).Delete(&models.Mapping{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Proxy{}).Where("server_id = ?", id).Update("server_id", nil).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.Server{}, id))
+	})
+}
+
+func (r gormServers) Restore(ctx context.Context, ids []uint) error {
+	return restore(r.db.WithContext(ctx), &models.Server{}, ids)
+}
+
+func (r gormServers) BumpConfigVersion(ctx context.Context, id uint) error {
+	return (&database.DB{DB: r.db.WithContext(ctx)}).IncrementConfigVersion(id)
+}
+
+func (r gormServers) MarkOffline(ctx context.Context, id uint, seenBefore time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.Server{}).
+		Where("id = ? AND status = ? AND (last_seen_at IS NULL OR last_seen_at < ?)", id, "online", seenBefore).
+		Update("status", "offline")
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormServers) GetByAgentToken(ctx context.Context, id uint, token string) (*models.Server, error) {
+	var server models.Server
+	if err := r.db.WithContext(ctx).Where("id = ? AND agent_token = ?", id, token).First(&server).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &server, nil
+}
+
+func (r gormServers) Summary(ctx context.Context, activeSince time.Time) (*Summary, error) {
+	// A session, so each count starts from the same base
+	db := r.db.WithContext(ctx).Session(&gorm.Session{})
+	maintenance := db.Model(&models.Server{}).Select("id").Where("service_state = ?", models.ServiceMaintenance)
+
+	var summary Summary
+	counts := []struct {
+		query *gorm.DB
+		count *int64
+	}{
+		{db.Model(&models.Server{}).Where("service_state <> ?", models.ServiceMaintenance), &summary.Servers},
+		{db.Model(&models.Proxy{}).Where("server_id IS NULL OR server_id NOT IN (?)", maintenance), &summary.Proxies},
+		{db.Model(&models.Mapping{}).Where("server_id NOT IN (?)", maintenance), &summary.Mappings},
+		{db.Model(&models.Server{}).
+			Where("last_seen_at > ? OR status = ?", activeSince, "online").
+			Where("service_state <> ?", models.ServiceMaintenance), &summary.ActiveServers},
+		{db.Model(&models.Server{}).Where("service_state = ?", models.ServiceMaintenance), &summary.MaintenanceServers},
+	}
+	for _, c := range counts {
+		if err := c.query.Count(c.count).Error; err != nil {
+			return nil, err
+		}
+	}
+	return &summary, nil
+}
+
+type gormProxies struct{ db *gorm.DB }
+
+func (r gormProxies) List(ctx context.Context, filter ProxyFilter) ([]models.Proxy, error) {
+	query := r.db.WithContext(ctx).Preload("Server").Preload("Group")
+	if filter.ServerID != nil {
+		query = query.Where("server_id = ?", *filter.ServerID)
+	}
+	if filter.GroupID != nil {
+		query = query.Where("group_id = ?", *filter.GroupID)
+	}
+	if filter.Unassigned {
+		query = query.Where("server_id IS NULL")
+	}
+	if filter.IDs != nil {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if filter.ExitIP != "" {
+		query = query.Where("exit_ip = ?", filter.ExitIP)
+	}
+	if filter.Country != "" {
+		query = query.Where("UPPER(country) = UPPER(?)", filter.Country)
+	}
+	if filter.City != "" {
+		query = query.Where("LOWER(city) = LOWER(?)", filter.City)
+	}
+	if filter.ASN != nil {
+		query = query.Where("asn = ?", *filter.ASN)
+	}
+	if filter.Anonymity != "" {
+		query = query.Where("anonymity = ?", filter.Anonymity)
+	}
+	if filter.Quarantined != nil && *filter.Quarantined {
+		query = query.Where("quarantined_at IS NOT NULL")
+	}
+	if filter.Quarantined != nil && !*filter.Quarantined {
+		query = query.Where("quarantined_at IS NULL")
+	}
+	if filter.QuarantinePolicyID != nil {
+		query = query.Where("quarantine_policy_id = ?", *filter.QuarantinePolicyID)
+	}
+	if filter.ProviderID != nil {
+		query = query.Where("provider_id = ?", *filter.ProviderID)
+	}
+	if filter.ExpiresBefore != nil {
+		query = query.Where("expires_at < ?", *filter.ExpiresBefore)
+	}
+	if filter.Disabled != nil && *filter.Disabled {
+		query = query.Where("disabled_at IS NOT NULL")
+	}
+	if filter.Disabled != nil && !*filter.Disabled {
+		query = query.Where("disabled_at IS NULL")
+	}
+	if filter.Health != "" {
+		query = query.Where("health = ?", filter.Health)
+	}
+
+	var proxies []models.Proxy
+	err := query.Order("id").Find(&proxies).Error
+	return proxies, err
+}
+
+func (r gormProxies) Get(ctx context.Context, id uint) (*models.Proxy, error) {
+	var proxy models.Proxy
+	if err := r.db.WithContext(ctx).Preload("Server").Preload("Group").First(&proxy, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &proxy, nil
+}
+
+func (r gormProxies) SharedExitIPs(ctx context.Context) (map[string][]models.Proxy, error) {
+	shared := r.db.Model(&models.Proxy{}).
+		Select("exit_ip").
+		Where("exit_ip <> ''").
+		Group("exit_ip").
+		Having("COUNT(*) > 1")
+	var proxies []models.Proxy
+	err := r.db.WithContext(ctx).Preload("Server").Preload("Group").
+		Where("exit_ip IN (?)", shared).
+		Order("exit_ip, id").
+		Find(&proxies).Error
+	byIP := make(map[string][]models.Proxy)
+	for _, proxy := range proxies {
+		byIP[proxy.ExitIP] = append(byIP[proxy.ExitIP], proxy)
+	}
+	return byIP, err
+}
+
+func (r gormProxies) Create(ctx context.Context, proxy *models.Proxy) error {
+	return r.db.WithContext(ctx).Omit("Server", "Group", "Mappings").Create(proxy).Error
+}
+
+func (r gormProxies) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Proxy{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormProxies) UpdateMany(ctx context.Context, ids []uint, fields Fields) error {
+	return r.db.WithContext(ctx).Model(&models.Proxy{}).Where("id IN ?", ids).Updates(map[string]interface{}(fields)).Error
+}
+
+func (r gormProxies) Delete(ctx context.Context, id uint) error {
+	return updated(r.db.WithContext(ctx).Delete(&models.Proxy{}, id))
+}
+
+func (r gormProxies) Restore(ctx context.Context, ids []uint) error {
+	return restore(r.db.WithContext(ctx), &models.Proxy{}, ids)
+}
+
+type gormMappings struct{ db *gorm.DB }
+
+func (r gormMappings) List(ctx context.Context, filter MappingFilter) ([]models.Mapping, error) {
+	query := r.db.WithContext(ctx).Preload("Server").Preload("UpstreamProxy")
+	if filter.ServerID != nil {
+		query = query.Where("server_id = ?", *filter.ServerID)
+	}
+	if filter.IDs != nil {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if filter.UpstreamProxyIDs != nil {
+		query = query.Where("upstream_proxy_id IN ?", filter.UpstreamProxyIDs)
+	}
+
+	var mappings []models.Mapping
+	err := query.Order("id").Find(&mappings).Error
+	return mappings, err
+}
+
+func (r gormMappings) Get(ctx context.Context, id uint) (*models.Mapping, error) {
+	var mapping models.Mapping
+	if err := r.db.WithContext(ctx).Preload("Server").Preload("UpstreamProxy").First(&mapping, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &mapping, nil
+}
+
+func (r gormMappings) Create(ctx context.Context, mapping *models.Mapping) error {
+	return r.db.WithContext(ctx).Omit("Server", "UpstreamProxy").Create(mapping).Error
+}
+
+func (r gormMappings) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Mapping{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormMappings) UpdateMany(ctx context.Context, ids []uint, fields Fields) error {
+	return r.db.WithContext(ctx).Model(&models.Mapping{}).Where("id IN ?", ids).Updates(map[string]interface{}(fields)).Error
+}
+
+func (r gormMappings) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("mapping_id = ?", id).Delete(&models.MappingSchedule{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.Mapping{}, id))
+	})
+}
+
+func (r gormMappings) Restore(ctx context.Context, ids []uint) error {
+	return restore(r.db.WithContext(ctx), &models.Mapping{}, ids)
+}
+
+type gormSchedules struct{ db *gorm.DB }
+
+func (r gormSchedules) List(ctx context.Context, filter ScheduleFilter) ([]models.MappingSchedule, error) {
+	query := r.db.WithContext(ctx)
+	if filter.IDs != nil {
+		query = query.Where("id IN ?", filter.IDs)
+	}
+	if filter.MappingID != nil {
+		query = query.Where("mapping_id = ?", *filter.MappingID)
+	}
+	if filter.MappingIDs != nil {
+		query = query.Where("mapping_id IN ?", filter.MappingIDs)
+	}
+	if filter.UpstreamProxyIDs != nil {
+		query = query.Where("upstream_proxy_id IN ?", filter.UpstreamProxyIDs)
+	}
+	if filter.Live {
+		query = query.Where("enabled = ? OR active = ?", true, true)
+	}
+
+	var schedules []models.MappingSchedule
+	err := query.Order("id").Find(&schedules).Error
+	return schedules, err
+}
+
+func (r gormSchedules) Get(ctx context.Context, id uint) (*models.MappingSchedule, error) {
+	var schedule models.MappingSchedule
+	if err := r.db.WithContext(ctx).First(&schedule, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &schedule, nil
+}
+
+func (r gormSchedules) Create(ctx context.Context, schedule *models.MappingSchedule) error {
+	return r.db.WithContext(ctx).Create(schedule).Error
+}
+
+func (r gormSchedules) Save(ctx context.Context, schedule *models.MappingSchedule) error {
+	return r.db.WithContext(ctx).Save(schedule).Error
+}
+
+func (r gormSchedules) Delete(ctx context.Context, id uint) error {
+	return updated(r.db.WithContext(ctx).Delete(&models.MappingSchedule{}, id))
+}
+
+func (r gormSchedules) UpdateMany(ctx context.Context, ids []uint, fields Fields) error {
+	return r.db.WithContext(ctx).Model(&models.MappingSchedule{}).Where("id IN ?", ids).Updates(map[string]interface{}(fields)).Error
+}
+
+func (r gormSchedules) DeleteMany(ctx context.Context, ids []uint) error {
+	return r.db.WithContext(ctx).Where("id IN ?", ids).Delete(&models.MappingSchedule{}).Error
+}
+
+func (r gormSchedules) Restore(ctx context.Context, ids []uint) error {
+	return restore(r.db.WithContext(ctx), &models.MappingSchedule{}, ids)
+}
+
+type gormGroups struct{ db *gorm.DB }
+
+func (r gormGroups) List(ctx context.Context, withProxies bool) ([]models.ProxyGroup, error) {
+	query := r.db.WithContext(ctx)
+	if withProxies {
+		query = query.Preload("Proxies")
+	}
+
+	var groups []models.ProxyGroup
+	err := query.Order("id").Find(&groups).Error
+	return groups, err
+}
+
+func (r gormGroups) Get(ctx context.Context, id uint) (*models.ProxyGroup, error) {
+	var group models.ProxyGroup
+	if err := r.db.WithContext(ctx).First(&group, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &group, nil
+}
+
+func (r gormGroups) Create(ctx context.Context, group *models.ProxyGroup) error {
+	return r.db.WithContext(ctx).Omit("Proxies").Create(group).Error
+}
+
+func (r gormGroups) Save(ctx context.Context, group *models.ProxyGroup) error {
+	return r.db.WithContext(ctx).Omit("Proxies").Save(group).Error
+}
+
+func (r gormGroups) Delete(ctx context.Context, id uint) error {
+	return updated(r.db.WithContext(ctx).Delete(&models.ProxyGroup{}, id))
+}
+
+func (r gormGroups) Restore(ctx context.Context, ids []uint) error {
+	return restore(r.db.WithContext(ctx), &models.ProxyGroup{}, ids)
+}
+
+type gormUsers struct{ db *gorm.DB }
+
+func (r gormUsers) Get(ctx context.Context, id uint) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).First(&user, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) GetByEmail(ctx context.Context, email string) (*models.User, error) {
+	var user models.User
+	if err := r.db.WithContext(ctx).Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &user, nil
+}
+
+func (r gormUsers) Create(ctx context.Context, user *models.User) error {
+	return r.db.WithContext(ctx).Create(user).Error
+}
+
+type gormAudit struct{ db *gorm.DB }
+
+func (r gormAudit) Record(ctx context.Context, entry *models.AuditLog) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+func (r gormAudit) List(ctx context.Context, limit int) ([]models.AuditLog, error) {
+	var entries []models.AuditLog
+	err := r.db.WithContext(ctx).Order("id DESC").Limit(limit).Find(&entries).Error
+	return entries, err
+}
+
+type gormTrash struct{ db *gorm.DB }
+
+func (r gormTrash) Create(ctx context.Context, entry *models.TrashEntry) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+func (r gormTrash) List(ctx context.Context, resourceType string) ([]models.TrashEntry, error) {
+	query := r.db.WithContext(ctx)
+	if resourceType != "" {
+		query = query.Where("resource_type = ?", resourceType)
+	}
+
+	var entries []models.TrashEntry
+	err := query.Order("deleted_at DESC, id DESC").Find(&entries).Error
+	return entries, err
+}
+
+func (r gormTrash) Find(ctx context.Context, resourceType string, id uint) (*models.TrashEntry, error) {
+	var entry models.TrashEntry
+	err := r.db.WithContext(ctx).
+		Where("resource_type = ? AND resource_id = ?", resourceType, id).
+		Order("id DESC").
+		First(&entry).Error
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &entry, nil
+}
+
+func (r gormTrash) Delete(ctx context.Context, id uint) error {
+	return updated(r.db.WithContext(ctx).Delete(&models.TrashEntry{}, id))
+}
+
+func (r gormTrash) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// A session, so each statement starts from the unscoped base
+		all := tx.Unscoped().Session(&gorm.Session{})
+		expired := func(model interface{}) *gorm.DB {
+			return all.Model(model).Select("id").Where("deleted_at < ?", before)
+		}
+
+		// Children first. References from rows still in the trash to rows
+		// being purged are cleared, so the foreign keys do not refuse.
+		if err := all.Where("deleted_at < ?", before).Delete(&models.MappingSchedule{}).Error; err != nil {
+			return err
+		}
+		if err := all.Where("deleted_at < ?", before).Delete(&models.Mapping{}).Error; err != nil {
+			return err
+		}
+		if err := all.Model(&models.MappingSchedule{}).Where("upstream_proxy_id IN (?)", expired(&models.Proxy{})).
+			Updates(map[string]interface{}{"upstream_proxy_id": nil, "enabled": false, "active": false}).Error; err != nil {
+			return err
+		}
+		if err := all.Model(&models.Mapping{}).Where("upstream_proxy_id IN (?)", expired(&models.Proxy{})).
+			Updates(map[string]interface{}{"upstream_proxy_id": nil, "enabled": false}).Error; err != nil {
+			return err
+		}
+		if err := all.Where("deleted_at < ?", before).Delete(&models.Proxy{}).Error; err != nil {
+			return err
+		}
+		if err := all.Model(&models.Proxy{}).Where("group_id IN (?)", expired(&models.ProxyGroup{})).
+			Update("group_id", nil).Error; err != nil {
+			return err
+		}
+		if err := all.Where("deleted_at < ?", before).Delete(&models.ProxyGroup{}).Error; err != nil {
+			return err
+		}
+		// Takes the server's mappings along and unassigns its proxies
+		if err := all.Where("deleted_at < ?", before).Delete(&models.Server{}).Error; err != nil {
+			return err
+		}
+
+		result := tx.Where("deleted_at < ?", before).Delete(&models.TrashEntry{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+type gormWebhooks struct{ db *gorm.DB }
+
+func (r gormWebhooks) List(ctx context.Context) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	err := r.db.WithContext(ctx).Order("id").Find(&webhooks).Error
+	return webhooks, err
+}
+
+func (r gormWebhooks) Subscribed(ctx context.Context, event string) ([]models.Webhook, error) {
+	var webhooks []models.Webhook
+	if err := r.db.WithContext(ctx).Where("enabled = ?", true).Order("id").Find(&webhooks).Error; err != nil {
+		return nil, err
+	}
+
+	// Events are a JSON array, matched here so it works on every backend
+	subscribed := webhooks[:0]
+	for _, webhook := range webhooks {
+		var events []string
+		if err := json.Unmarshal([]byte(webhook.Events), &events); err != nil {
+			continue
+		}
+		for _, e := range events {
+			if e == event || e == "*" {
+				subscribed = append(subscribed, webhook)
+				break
+			}
+		}
+	}
+	return subscribed, nil
+}
+
+func (r gormWebhooks) Get(ctx context.Context, id uint) (*models.Webhook, error) {
+	var webhook models.Webhook
+	if err := r.db.WithContext(ctx).First(&webhook, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &webhook, nil
+}
+
+func (r gormWebhooks) Create(ctx context.Context, webhook *models.Webhook) error {
+	return r.db.WithContext(ctx).Create(webhook).Error
+}
+
+func (r gormWebhooks) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Webhook{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormWebhooks) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("webhook_id = ?", id).Delete(&models.WebhookDelivery{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.Webhook{}, id))
+	})
+}
+
+type gormDeliveries struct{ db *gorm.DB }
+
+func (r gormDeliveries) Create(ctx context.Context, delivery *models.WebhookDelivery) error {
+	return r.db.WithContext(ctx).Create(delivery).Error
+}
+
+func (r gormDeliveries) List(ctx context.Context, filter DeliveryFilter) ([]models.WebhookDelivery, error) {
+	query := r.db.WithContext(ctx)
+	if filter.WebhookID != nil {
+		query = query.Where("webhook_id = ?", *filter.WebhookID)
+	}
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var deliveries []models.WebhookDelivery
+	err := query.Order("id DESC").Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r gormDeliveries) Get(ctx context.Context, id uint) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	if err := r.db.WithContext(ctx).First(&delivery, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &delivery, nil
+}
+
+func (r gormDeliveries) Due(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.DeliveryPending, now).
+		Order("next_attempt_at, id").
+		Limit(limit).
+		Find(&deliveries).Error
+	return deliveries, err
+}
+
+func (r gormDeliveries) Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).
+		Where("id = ? AND status = ? AND attempts = ?", id, models.DeliveryPending, attempts).
+		Updates(map[string]interface{}{"attempts": attempts + 1, "next_attempt_at": leaseUntil})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormDeliveries) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.WebhookDelivery{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormDeliveries) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("status <> ? AND created_at < ?", models.DeliveryPending, before).
+		Delete(&models.WebhookDelivery{})
+	return result.RowsAffected, result.Error
+}
+
+type gormOutbox struct{ db *gorm.DB }
+
+func (r gormOutbox) Create(ctx context.Context, event *models.OutboxEvent) error {
+	return r.db.WithContext(ctx).Create(event).Error
+}
+
+func (r gormOutbox) Due(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error) {
+	var events []models.OutboxEvent
+	err := r.db.WithContext(ctx).
+		Where("processed_at IS NULL AND next_attempt_at <= ?", now).
+		Order("id").
+		Limit(limit).
+		Find(&events).Error
+	return events, err
+}
+
+func (r gormOutbox) Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.OutboxEvent{}).
+		Where("id = ? AND processed_at IS NULL AND attempts = ?", id, attempts).
+		Updates(map[string]interface{}{"attempts": attempts + 1, "next_attempt_at": leaseUntil})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormOutbox) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.OutboxEvent{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormOutbox) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).
+		Where("processed_at IS NOT NULL AND processed_at < ?", before).
+		Delete(&models.OutboxEvent{})
+	return result.RowsAffected, result.Error
+}
+
+type gormAlertRules struct{ db *gorm.DB }
+
+func (r gormAlertRules) List(ctx context.Context) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	err := r.db.WithContext(ctx).Order("id").Find(&rules).Error
+	return rules, err
+}
+
+func (r gormAlertRules) Get(ctx context.Context, id uint) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	if err := r.db.WithContext(ctx).First(&rule, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &rule, nil
+}
+
+func (r gormAlertRules) Create(ctx context.Context, rule *models.AlertRule) error {
+	return r.db.WithContext(ctx).Create(rule).Error
+}
+
+func (r gormAlertRules) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.AlertRule{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormAlertRules) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		alerts := tx.Model(&models.Alert{}).Select("id").Where("rule_id = ?", id)
+		if err := tx.Where("alert_id IN (?)", alerts).Delete(&models.AlertNotification{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("rule_id = ?", id).Delete(&models.Alert{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("rule_id = ?", id).Delete(&models.AlertSilence{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.AlertRule{}, id))
+	})
+}
+
+func (r gormAlertRules) Claim(ctx context.Context, id uint, dueBefore time.Time, now time.Time) (bool, error) {
+	// Not Updates, which would also touch updated_at
+	result := r.db.WithContext(ctx).Model(&models.AlertRule{}).
+		Where("id = ? AND (evaluated_at IS NULL OR evaluated_at <= ?)", id, dueBefore).
+		UpdateColumn("evaluated_at", now)
+	return result.RowsAffected > 0, result.Error
+}
+
+type gormAlerts struct{ db *gorm.DB }
+
+func (r gormAlerts) List(ctx context.Context, filter AlertFilter) ([]models.Alert, error) {
+	query := r.db.WithContext(ctx)
+	if filter.RuleID != nil {
+		query = query.Where("rule_id = ?", *filter.RuleID)
+	}
+	if len(filter.States) > 0 {
+		query = query.Where("state IN ?", filter.States)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+
+	var alerts []models.Alert
+	err := query.Order("id DESC").Find(&alerts).Error
+	return alerts, err
+}
+
+func (r gormAlerts) Get(ctx context.Context, id uint) (*models.Alert, error) {
+	var alert models.Alert
+	if err := r.db.WithContext(ctx).First(&alert, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &alert, nil
+}
+
+func (r gormAlerts) Create(ctx context.Context, alert *models.Alert) error {
+	return r.db.WithContext(ctx).Create(alert).Error
+}
+
+func (r gormAlerts) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Alert{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormAlerts) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("alert_id = ?", id).Delete(&models.AlertNotification{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.Alert{}, id))
+	})
+}
+
+func (r gormAlerts) Purge(ctx context.Context, before time.Time) (int64, error) {
+	var purged int64
+	err := r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		resolved := tx.Model(&models.Alert{}).Select("id").Where("state = ? AND resolved_at < ?", models.AlertResolved, before)
+		if err := tx.Where("alert_id IN (?)", resolved).Delete(&models.AlertNotification{}).Error; err != nil {
+			return err
+		}
+		result := tx.Where("state = ? AND resolved_at < ?", models.AlertResolved, before).Delete(&models.Alert{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+type gormAlertSilences struct{ db *gorm.DB }
+
+func (r gormAlertSilences) List(ctx context.Context, endingAfter time.Time) ([]models.AlertSilence, error) {
+	var silences []models.AlertSilence
+	err := r.db.WithContext(ctx).Where("ends_at > ?", endingAfter).Order("starts_at, id").Find(&silences).Error
+	return silences, err
+}
+
+func (r gormAlertSilences) Get(ctx context.Context, id uint) (*models.AlertSilence, error) {
+	var silence models.AlertSilence
+	if err := r.db.WithContext(ctx).First(&silence, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &silence, nil
+}
+
+func (r gormAlertSilences) Create(ctx context.Context, silence *models.AlertSilence) error {
+	return r.db.WithContext(ctx).Create(silence).Error
+}
+
+func (r gormAlertSilences) Delete(ctx context.Context, id uint) error {
+	return updated(r.db.WithContext(ctx).Delete(&models.AlertSilence{}, id))
+}
+
+type gormAlertChannels struct{ db *gorm.DB }
+
+func (r gormAlertChannels) List(ctx context.Context) ([]models.AlertChannel, error) {
+	var channels []models.AlertChannel
+	err := r.db.WithContext(ctx).Order("id").Find(&channels).Error
+	return channels, err
+}
+
+func (r gormAlertChannels) Get(ctx context.Context, id uint) (*models.AlertChannel, error) {
+	var channel models.AlertChannel
+	if err := r.db.WithContext(ctx).First(&channel, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &channel, nil
+}
+
+func (r gormAlertChannels) Create(ctx context.Context, channel *models.AlertChannel) error {
+	return r.db.WithContext(ctx).Create(channel).Error
+}
+
+func (r gormAlertChannels) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.AlertChannel{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormAlertChannels) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("channel_id = ?", id).Delete(&models.AlertNotification{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.AlertChannel{}, id))
+	})
+}
+
+type gormAlertNotifications struct{ db *gorm.DB }
+
+func (r gormAlertNotifications) Create(ctx context.Context, notification *models.AlertNotification) error {
+	return r.db.WithContext(ctx).Create(notification).Error
+}
+
+func (r gormAlertNotifications) List(ctx context.Context, alertID uint) ([]models.AlertNotification, error) {
+	var notifications []models.AlertNotification
+	err := r.db.WithContext(ctx).Where("alert_id = ?", alertID).Order("id").Find(&notifications).Error
+	return notifications, err
+}
+
+func (r gormAlertNotifications) Due(ctx context.Context, now time.Time, limit int) ([]models.AlertNotification, error) {
+	var notifications []models.AlertNotification
+	err := r.db.WithContext(ctx).
+		Where("status = ? AND next_attempt_at <= ?", models.NotificationPending, now).
+		Order("next_attempt_at, id").
+		Limit(limit).
+		Find(&notifications).Error
+	return notifications, err
+}
+
+func (r gormAlertNotifications) Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error) {
+	result := r.db.WithContext(ctx).Model(&models.AlertNotification{}).
+		Where("id = ? AND status = ? AND attempts = ?", id, models.NotificationPending, attempts).
+		Updates(map[string]interface{}{"attempts": attempts + 1, "next_attempt_at": leaseUntil})
+	return result.RowsAffected > 0, result.Error
+}
+
+func (r gormAlertNotifications) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.AlertNotification{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+type gormChecks struct{ db *gorm.DB }
+
+func (r gormChecks) Record(ctx context.Context, check *models.ProxyCheck) error {
+	return r.db.WithContext(ctx).Create(check).Error
+}
+
+func (r gormChecks) List(ctx context.Context, filter CheckFilter) ([]models.ProxyCheck, error) {
+	var checks []models.ProxyCheck
+	err := checkScope(r.db.WithContext(ctx), filter, "checked_at").Order("checked_at, id").Find(&checks).Error
+	return checks, err
+}
+
+func (r gormChecks) Latencies(ctx context.Context, proxyIDs []uint, since time.Time) (map[uint][]int, error) {
+	latencies := make(map[uint][]int)
+	if len(proxyIDs) == 0 {
+		return latencies, nil
+	}
+	var checks []models.ProxyCheck
+	err := r.db.WithContext(ctx).
+		Select("proxy_id", "latency_ms").
+		Where("proxy_id IN ? AND ok = ? AND checked_at >= ?", proxyIDs, true, since).
+		Find(&checks).Error
+	for _, check := range checks {
+		latencies[check.ProxyID] = append(latencies[check.ProxyID], check.LatencyMS)
+	}
+	return latencies, err
+}
+
+func (r gormChecks) Oldest(ctx context.Context) (*time.Time, error) {
+	var checks []models.ProxyCheck
+	if err := r.db.WithContext(ctx).Select("checked_at").Order("checked_at").Limit(1).Find(&checks).Error; err != nil || len(checks) == 0 {
+		return nil, err
+	}
+	return &checks[0].CheckedAt, nil
+}
+
+func (r gormChecks) Delete(ctx context.Context, filter CheckFilter) (int64, error) {
+	result := checkScope(r.db.WithContext(ctx), filter, "checked_at").Delete(&models.ProxyCheck{})
+	return result.RowsAffected, result.Error
+}
+
+func (r gormChecks) Rollups(ctx context.Context, filter CheckFilter) ([]models.ProxyCheckRollup, error) {
+	var rollups []models.ProxyCheckRollup
+	err := checkScope(r.db.WithContext(ctx), filter, "bucket_start").Order("bucket_start, proxy_id").Find(&rollups).Error
+	return rollups, err
+}
+
+func (r gormChecks) SaveRollup(ctx context.Context, rollup *models.ProxyCheckRollup) error {
+	return r.db.WithContext(ctx).Save(rollup).Error
+}
+
+func (r gormChecks) PurgeRollups(ctx context.Context, before time.Time) (int64, error) {
+	result := r.db.WithContext(ctx).Where("bucket_start < ?", before).Delete(&models.ProxyCheckRollup{})
+	return result.RowsAffected, result.Error
+}
+
+// checkScope applies a check filter to a query, with column as the time
+func checkScope(db *gorm.DB, filter CheckFilter, column string) *gorm.DB {
+	if len(filter.ProxyIDs) > 0 {
+		db = db.Where("proxy_id IN ?", filter.ProxyIDs)
+	}
+	if !filter.Since.IsZero() {
+		db = db.Where(column+" >= ?", filter.Since)
+	}
+	if !filter.Until.IsZero() {
+		db = db.Where(column+" < ?", filter.Until)
+	}
+	return db
+}
+
+type gormQuarantinePolicies struct{ db *gorm.DB }
+
+func (r gormQuarantinePolicies) List(ctx context.Context) ([]models.QuarantinePolicy, error) {
+	var policies []models.QuarantinePolicy
+	err := r.db.WithContext(ctx).Order("id").Find(&policies).Error
+	return policies, err
+}
+
+func (r gormQuarantinePolicies) Get(ctx context.Context, id uint) (*models.QuarantinePolicy, error) {
+	var policy models.QuarantinePolicy
+	if err := r.db.WithContext(ctx).First(&policy, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &policy, nil
+}
+
+func (r gormQuarantinePolicies) For(ctx context.Context, groupID *uint) (*models.QuarantinePolicy, error) {
+	query := r.db.WithContext(ctx).Where("group_id IS NULL")
+	if groupID != nil {
+		query = query.Or("group_id = ?", *groupID)
+	}
+	var policies []models.QuarantinePolicy
+	if err := query.Find(&policies).Error; err != nil {
+		return nil, err
+	}
+
+	var found *models.QuarantinePolicy
+	for i := range policies {
+		// The group's own policy wins over the default
+		if found == nil || policies[i].GroupID != nil {
+			found = &policies[i]
+		}
+	}
+	if found == nil || !found.Enabled {
+		return nil, nil
+	}
+	return found, nil
+}
+
+func (r gormQuarantinePolicies) Create(ctx context.Context, policy *models.QuarantinePolicy) error {
+	return r.db.WithContext(ctx).Create(policy).Error
+}
+
+func (r gormQuarantinePolicies) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.QuarantinePolicy{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormQuarantinePolicies) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Not Updates, which would also touch updated_at
+		err := tx.Model(&models.Proxy{}).Where("quarantine_policy_id = ?", id).
+			UpdateColumn("quarantine_policy_id", nil).Error
+		if err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.QuarantinePolicy{}, id))
+	})
+}
+
+type gormQuarantineTransitions struct{ db *gorm.DB }
+
+func (r gormQuarantineTransitions) Record(ctx context.Context, transition *models.QuarantineTransition) error {
+	return r.db.WithContext(ctx).Create(transition).Error
+}
+
+func (r gormQuarantineTransitions) List(ctx context.Context, filter TransitionFilter) ([]models.QuarantineTransition, error) {
+	query := r.db.WithContext(ctx).Order("created_at DESC, id DESC")
+	if filter.ProxyID != nil {
+		query = query.Where("proxy_id = ?", *filter.ProxyID)
+	}
+	if filter.Limit > 0 {
+		query = query.Limit(filter.Limit)
+	}
+	var transitions []models.QuarantineTransition
+	err := query.Find(&transitions).Error
+	return transitions, err
+}
+
+type gormProviders struct{ db *gorm.DB }
+
+func (r gormProviders) List(ctx context.Context) ([]models.Provider, error) {
+	var providers []models.Provider
+	err := r.db.WithContext(ctx).Order("name").Find(&providers).Error
+	return providers, err
+}
+
+func (r gormProviders) Get(ctx context.Context, id uint) (*models.Provider, error) {
+	var provider models.Provider
+	if err := r.db.WithContext(ctx).First(&provider, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &provider, nil
+}
+
+func (r gormProviders) Create(ctx context.Context, provider *models.Provider) error {
+	return r.db.WithContext(ctx).Create(provider).Error
+}
+
+func (r gormProviders) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.Provider{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormProviders) Delete(ctx context.Context, id uint) error {
+	// The key clears provider_id of its proxies, trashed ones too
+	return updated(r.db.WithContext(ctx).Delete(&models.Provider{}, id))
+}
+
+type gormSnapshots struct{ db *gorm.DB }
+
+// snapshots returns the database whose methods render and record snapshots
+func (r gormSnapshots) snapshots(ctx context.Context) *database.DB {
+	return &database.DB{DB: r.db.WithContext(ctx)}
+}
+
+func (r gormSnapshots) Record(ctx context.Context, serverID uint) error {
+	return r.snapshots(ctx).RecordConfigSnapshot(serverID)
+}
+
+func (r gormSnapshots) Get(ctx context.Context, serverID uint, version int) (*models.ConfigSnapshot, error) {
+	snapshot, err := r.snapshots(ctx).GetConfigSnapshot(serverID, version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return snapshot, nil
+}
+
+func (r gormSnapshots) List(ctx context.Context, serverID uint, limit int) ([]models.ConfigSnapshot, error) {
+	var snapshots []models.ConfigSnapshot
+	err := r.db.WithContext(ctx).
+		Select("id", "server_id", "version", "proxies", "mappings", "created_at").
+		Where("server_id = ?", serverID).
+		Order("version DESC").
+		Limit(limit).
+		Find(&snapshots).Error
+	return snapshots, err
+}
+
+func (r gormSnapshots) AgentConfig(ctx context.Context, serverID uint, version int) (*models.AgentPullResponse, error) {
+	config, err := r.snapshots(ctx).AgentConfig(serverID, version)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return config, nil
+}
+
+type gormChangeSets struct{ db *gorm.DB }
+
+func (r gormChangeSets) List(ctx context.Context, status string) ([]models.ChangeSet, error) {
+	query := r.db.WithContext(ctx).Preload("Targets", byWave)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var changeSets []models.ChangeSet
+	err := query.Order("id DESC").Find(&changeSets).Error
+	return changeSets, err
+}
+
+func (r gormChangeSets) Get(ctx context.Context, id uint) (*models.ChangeSet, error) {
+	var changeSet models.ChangeSet
+	if err := r.db.WithContext(ctx).Preload("Targets", byWave).First(&changeSet, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &changeSet, nil
+}
+
+func (r gormChangeSets) Create(ctx context.Context, changeSet *models.ChangeSet) error {
+	return r.db.WithContext(ctx).Create(changeSet).Error
+}
+
+func (r gormChangeSets) Update(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.ChangeSet{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+func (r gormChangeSets) SetTargets(ctx context.Context, id uint, targets []models.ChangeSetTarget) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("change_set_id = ?", id).Delete(&models.ChangeSetTarget{}).Error; err != nil {
+			return err
+		}
+		if len(targets) == 0 {
+			return nil
+		}
+		for i := range targets {
+			targets[i].ChangeSetID = id
+		}
+		return tx.Create(&targets).Error
+	})
+}
+
+func (r gormChangeSets) Delete(ctx context.Context, id uint) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("change_set_id = ?", id).Delete(&models.ChangeSetTarget{}).Error; err != nil {
+			return err
+		}
+		return updated(tx.Delete(&models.ChangeSet{}, id))
+	})
+}
+
+func (r gormChangeSets) Busy(ctx context.Context, serverIDs []uint) (bool, error) {
+	var busy int64
+	err := r.db.WithContext(ctx).Model(&models.ChangeSetTarget{}).
+		Joins("JOIN change_sets ON change_sets.id = change_set_targets.change_set_id").
+		Where("change_sets.status = ? AND change_set_targets.server_id IN ?", models.ChangeSetRunning, serverIDs).
+		Count(&busy).Error
+	return busy > 0, err
+}
+
+func (r gormChangeSets) Targets(ctx context.Context, changeSetID uint) ([]models.ChangeSetTarget, error) {
+	var targets []models.ChangeSetTarget
+	err := byWave(r.db.WithContext(ctx)).Where("change_set_id = ?", changeSetID).Find(&targets).Error
+	return targets, err
+}
+
+func (r gormChangeSets) AppliedTargets(ctx context.Context, changeSetID uint) ([]models.ChangeSetTarget, error) {
+	var targets []models.ChangeSetTarget
+	err := r.db.WithContext(ctx).
+		Where("change_set_id = ? AND applied_at IS NOT NULL AND status <> ?", changeSetID, models.TargetRolledBack).
+		Order("wave DESC, id").
+		Find(&targets).Error
+	return targets, err
+}
+
+func (r gormChangeSets) GetTarget(ctx context.Context, id uint) (*models.ChangeSetTarget, error) {
+	var target models.ChangeSetTarget
+	if err := r.db.WithContext(ctx).First(&target, id).Error; err != nil {
+		return nil, notFound(err)
+	}
+	return &target, nil
+}
+
+func (r gormChangeSets) UpdateTarget(ctx context.Context, id uint, fields Fields) error {
+	return updated(r.db.WithContext(ctx).Model(&models.ChangeSetTarget{}).Where("id = ?", id).Updates(map[string]interface{}(fields)))
+}
+
+// byWave orders change set targets by wave
+func byWave(db *gorm.DB) *gorm.DB {
+	return db.Order("wave, id")
+}
@@ -0,0 +1,450 @@
+// Package repository is the storage layer of the admin API. Handlers use
+// the interfaces here rather than GORM, so they run the same on every
+// database backend and can be tested against a store of fakes.
+package repository
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+)
+
+// ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("record not found")
+
+// Fields are column updates, keyed by column name
+type Fields map[string]interface{}
+
+type ServerRepository interface {
+	// List returns all servers, with their proxies and mappings if
+	// withRelations is set
+	List(ctx context.Context, withRelations bool) ([]models.Server, error)
+	Get(ctx context.Context, id uint, withRelations bool) (*models.Server, error)
+	// Create stores a server and records its empty initial config snapshot
+	Create(ctx context.Context, server *models.Server) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete moves a server with its mappings and their schedules to the
+	// trash, and unassigns its proxies
+	Delete(ctx context.Context, id uint) error
+	// Restore takes servers out of the trash, without their relations
+	Restore(ctx context.Context, ids []uint) error
+	// BumpConfigVersion increments a server's config version and records the
+	// config snapshot of the new version
+	BumpConfigVersion(ctx context.Context, id uint) error
+	// MarkOffline sets an online server offline if its agent was last seen
+	// before the given time. It reports whether the server was changed, so
+	// only one of several callers sees the transition.
+	MarkOffline(ctx context.Context, id uint, seenBefore time.Time) (bool, error)
+	// GetByAgentToken returns a server if token is the token of its agent
+	GetByAgentToken(ctx context.Context, id uint, token string) (*models.Server, error)
+	// Summary counts the inventory, taking servers seen after activeSince as
+	// active
+	Summary(ctx context.Context, activeSince time.Time) (*Summary, error)
+}
+
+// Summary is the inventory of the admin summary. Servers in maintenance and
+// their proxies and mappings are only counted in MaintenanceServers.
+type Summary struct {
+	Servers            int64
+	Proxies            int64
+	Mappings           int64
+	ActiveServers      int64
+	MaintenanceServers int64
+}
+
+// ProxyFilter selects proxies. The zero value selects all of them.
+type ProxyFilter struct {
+	ServerID   *uint
+	GroupID    *uint
+	Unassigned bool // only proxies without a server
+	IDs        []uint
+
+	// Exit IP and its location; empty matches any
+	ExitIP  string
+	Country string // ISO code, any case
+	City    string // any case
+	ASN     *uint
+
+	Anonymity string // transparent, anonymous or elite; empty matches any
+
+	Quarantined        *bool // only proxies in or out of quarantine; nil matches any
+	QuarantinePolicyID *uint // only proxies quarantined by this policy
+
+	ProviderID    *uint
+	ExpiresBefore *time.Time // only proxies with an expiry date before this
+	Disabled      *bool      // only proxies disabled or not; nil matches any
+
+	Health string // ok, fail or unknown; empty matches any
+}
+
+type ProxyRepository interface {
+	// List returns the proxies matching filter with their server and group
+	List(ctx context.Context, filter ProxyFilter) ([]models.Proxy, error)
+	// Get returns a proxy with its server and group
+	Get(ctx context.Context, id uint) (*models.Proxy, error)
+	// SharedExitIPs returns the exit IPs of more than one proxy, by IP
+	SharedExitIPs(ctx context.Context) (map[string][]models.Proxy, error)
+	Create(ctx context.Context, proxy *models.Proxy) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	UpdateMany(ctx context.Context, ids []uint, fields Fields) error
+	// Delete moves a proxy to the trash
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, ids []uint) error
+}
+
+// MappingFilter selects mappings. The zero value selects all of them.
+type MappingFilter struct {
+	ServerID         *uint
+	IDs              []uint
+	UpstreamProxyIDs []uint
+}
+
+type MappingRepository interface {
+	// List returns the mappings matching filter with their server and
+	// upstream proxy
+	List(ctx context.Context, filter MappingFilter) ([]models.Mapping, error)
+	// Get returns a mapping with its server and upstream proxy
+	Get(ctx context.Context, id uint) (*models.Mapping, error)
+	Create(ctx context.Context, mapping *models.Mapping) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	UpdateMany(ctx context.Context, ids []uint, fields Fields) error
+	// Delete moves a mapping and its schedules to the trash
+	Delete(ctx context.Context, id uint) error
+	// Restore takes mappings out of the trash, without their schedules
+	Restore(ctx context.Context, ids []uint) error
+}
+
+// ScheduleFilter selects mapping schedules. The zero value selects all of
+// them.
+type ScheduleFilter struct {
+	IDs              []uint
+	MappingID        *uint
+	MappingIDs       []uint
+	UpstreamProxyIDs []uint // switch_upstream targets
+	Live             bool   // only schedules that are enabled or whose window is open
+}
+
+type ScheduleRepository interface {
+	List(ctx context.Context, filter ScheduleFilter) ([]models.MappingSchedule, error)
+	Get(ctx context.Context, id uint) (*models.MappingSchedule, error)
+	Create(ctx context.Context, schedule *models.MappingSchedule) error
+	Save(ctx context.Context, schedule *models.MappingSchedule) error
+	Delete(ctx context.Context, id uint) error
+	UpdateMany(ctx context.Context, ids []uint, fields Fields) error
+	DeleteMany(ctx context.Context, ids []uint) error
+	Restore(ctx context.Context, ids []uint) error
+}
+
+type GroupRepository interface {
+	// List returns all groups, with their proxies if withProxies is set
+	List(ctx context.Context, withProxies bool) ([]models.ProxyGroup, error)
+	Get(ctx context.Context, id uint) (*models.ProxyGroup, error)
+	Create(ctx context.Context, group *models.ProxyGroup) error
+	Save(ctx context.Context, group *models.ProxyGroup) error
+	// Delete moves a group to the trash
+	Delete(ctx context.Context, id uint) error
+	Restore(ctx context.Context, ids []uint) error
+}
+
+type UserRepository interface {
+	Get(ctx context.Context, id uint) (*models.User, error)
+	GetByEmail(ctx context.Context, email string) (*models.User, error)
+	Create(ctx context.Context, user *models.User) error
+}
+
+type AuditRepository interface {
+	Record(ctx context.Context, entry *models.AuditLog) error
+	// List returns the newest entries first
+	List(ctx context.Context, limit int) ([]models.AuditLog, error)
+}
+
+type TrashRepository interface {
+	Create(ctx context.Context, entry *models.TrashEntry) error
+	// List returns the entries of a resource type, or of all types if it
+	// is empty, newest first
+	List(ctx context.Context, resourceType string) ([]models.TrashEntry, error)
+	// Find returns the newest entry of a resource
+	Find(ctx context.Context, resourceType string, id uint) (*models.TrashEntry, error)
+	Delete(ctx context.Context, id uint) error
+	// Purge permanently deletes every row that went to the trash before the
+	// given time, and their entries. It returns the number of entries.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type WebhookRepository interface {
+	List(ctx context.Context) ([]models.Webhook, error)
+	// Subscribed returns the enabled webhooks that receive an event type
+	Subscribed(ctx context.Context, event string) ([]models.Webhook, error)
+	Get(ctx context.Context, id uint) (*models.Webhook, error)
+	Create(ctx context.Context, webhook *models.Webhook) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete removes a webhook and its deliveries
+	Delete(ctx context.Context, id uint) error
+}
+
+// DeliveryFilter selects webhook deliveries. The zero value selects all of
+// them.
+type DeliveryFilter struct {
+	WebhookID *uint
+	Status    string
+	Limit     int
+}
+
+type DeliveryRepository interface {
+	Create(ctx context.Context, delivery *models.WebhookDelivery) error
+	// List returns the deliveries matching filter, newest first
+	List(ctx context.Context, filter DeliveryFilter) ([]models.WebhookDelivery, error)
+	Get(ctx context.Context, id uint) (*models.WebhookDelivery, error)
+	// Due returns pending deliveries whose next attempt is not after now,
+	// oldest first
+	Due(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	// Claim takes a due delivery for one more attempt by counting it and
+	// moving its next attempt to leaseUntil, so a sender that dies midway
+	// leaves it to be retried. It reports false if another sender claimed
+	// the delivery first.
+	Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error)
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Purge deletes finished deliveries created before the given time and
+	// returns their number
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type OutboxRepository interface {
+	Create(ctx context.Context, event *models.OutboxEvent) error
+	// Due returns unprocessed events whose next attempt is not after now,
+	// in the order they were written
+	Due(ctx context.Context, now time.Time, limit int) ([]models.OutboxEvent, error)
+	// Claim takes a due event for one more attempt like
+	// DeliveryRepository.Claim
+	Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error)
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Purge deletes events processed before the given time and returns
+	// their number
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type AlertRuleRepository interface {
+	List(ctx context.Context) ([]models.AlertRule, error)
+	Get(ctx context.Context, id uint) (*models.AlertRule, error)
+	Create(ctx context.Context, rule *models.AlertRule) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete removes a rule with its alerts, their notifications and the
+	// silences of the rule
+	Delete(ctx context.Context, id uint) error
+	// Claim records that a rule is evaluated at now if it was not
+	// evaluated after dueBefore, and reports false if another evaluator
+	// took it first
+	Claim(ctx context.Context, id uint, dueBefore time.Time, now time.Time) (bool, error)
+}
+
+// AlertFilter selects alerts. The zero value selects all of them.
+type AlertFilter struct {
+	RuleID *uint
+	States []string
+	Limit  int
+}
+
+type AlertRepository interface {
+	// List returns the alerts matching filter, newest first
+	List(ctx context.Context, filter AlertFilter) ([]models.Alert, error)
+	Get(ctx context.Context, id uint) (*models.Alert, error)
+	Create(ctx context.Context, alert *models.Alert) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	Delete(ctx context.Context, id uint) error
+	// Purge deletes alerts resolved before the given time with their
+	// notifications, and returns their number
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type AlertSilenceRepository interface {
+	// List returns the silences ending after the given time, by start
+	List(ctx context.Context, endingAfter time.Time) ([]models.AlertSilence, error)
+	Get(ctx context.Context, id uint) (*models.AlertSilence, error)
+	Create(ctx context.Context, silence *models.AlertSilence) error
+	Delete(ctx context.Context, id uint) error
+}
+
+type AlertChannelRepository interface {
+	List(ctx context.Context) ([]models.AlertChannel, error)
+	Get(ctx context.Context, id uint) (*models.AlertChannel, error)
+	Create(ctx context.Context, channel *models.AlertChannel) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete removes a channel and its notifications
+	Delete(ctx context.Context, id uint) error
+}
+
+type AlertNotificationRepository interface {
+	Create(ctx context.Context, notification *models.AlertNotification) error
+	// List returns the notifications of an alert, oldest first
+	List(ctx context.Context, alertID uint) ([]models.AlertNotification, error)
+	// Due returns pending notifications whose next attempt is not after
+	// now, oldest first
+	Due(ctx context.Context, now time.Time, limit int) ([]models.AlertNotification, error)
+	// Claim takes a due notification for one more attempt like
+	// DeliveryRepository.Claim
+	Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error)
+	Update(ctx context.Context, id uint, fields Fields) error
+}
+
+// CheckFilter selects check results and rollups from Since until before
+// Until. Zero times leave that end open, and no ProxyIDs selects every
+// proxy.
+type CheckFilter struct {
+	ProxyIDs []uint
+	Since    time.Time
+	Until    time.Time
+}
+
+type CheckRepository interface {
+	Record(ctx context.Context, check *models.ProxyCheck) error
+	// List returns the checks matching filter, oldest first
+	List(ctx context.Context, filter CheckFilter) ([]models.ProxyCheck, error)
+	// Latencies returns the latencies of the ok checks of proxies since the
+	// given time, in milliseconds, by proxy
+	Latencies(ctx context.Context, proxyIDs []uint, since time.Time) (map[uint][]int, error)
+	// Oldest returns the time of the oldest check, nil if there is none
+	Oldest(ctx context.Context) (*time.Time, error)
+	// Delete deletes the checks matching filter and returns their number
+	Delete(ctx context.Context, filter CheckFilter) (int64, error)
+
+	// Rollups returns the rollups whose bucket starts within filter,
+	// oldest first
+	Rollups(ctx context.Context, filter CheckFilter) ([]models.ProxyCheckRollup, error)
+	// SaveRollup creates a rollup, or updates it if it has an ID
+	SaveRollup(ctx context.Context, rollup *models.ProxyCheckRollup) error
+	// PurgeRollups deletes rollups whose bucket started before the given
+	// time and returns their number
+	PurgeRollups(ctx context.Context, before time.Time) (int64, error)
+}
+
+type QuarantinePolicyRepository interface {
+	List(ctx context.Context) ([]models.QuarantinePolicy, error)
+	Get(ctx context.Context, id uint) (*models.QuarantinePolicy, error)
+	// For returns the policy that applies to proxies of a group: the
+	// group's own policy, else the default policy without a group. It
+	// returns nil if that policy is disabled or there is none.
+	For(ctx context.Context, groupID *uint) (*models.QuarantinePolicy, error)
+	Create(ctx context.Context, policy *models.QuarantinePolicy) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete removes a policy. Proxies it quarantined stay quarantined
+	// without a policy.
+	Delete(ctx context.Context, id uint) error
+}
+
+// TransitionFilter selects quarantine transitions. The zero value selects
+// all of them.
+type TransitionFilter struct {
+	ProxyID *uint
+	Limit   int
+}
+
+type QuarantineTransitionRepository interface {
+	Record(ctx context.Context, transition *models.QuarantineTransition) error
+	// List returns the transitions matching filter, newest first
+	List(ctx context.Context, filter TransitionFilter) ([]models.QuarantineTransition, error)
+}
+
+type ProviderRepository interface {
+	List(ctx context.Context) ([]models.Provider, error)
+	Get(ctx context.Context, id uint) (*models.Provider, error)
+	Create(ctx context.Context, provider *models.Provider) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// Delete removes a provider. Its proxies are kept without one.
+	Delete(ctx context.Context, id uint) error
+}
+
+type SnapshotRepository interface {
+	// Record stores the config snapshot of a server's current version.
+	// Recording a version that already has a snapshot is a no-op.
+	Record(ctx context.Context, serverID uint) error
+	Get(ctx context.Context, serverID uint, version int) (*models.ConfigSnapshot, error)
+	// List returns the newest snapshots of a server, without their config
+	// and state
+	List(ctx context.Context, serverID uint, limit int) ([]models.ConfigSnapshot, error)
+	// AgentConfig returns the config an agent receives at a version of a
+	// server, rendering it if the version predates snapshots
+	AgentConfig(ctx context.Context, serverID uint, version int) (*models.AgentPullResponse, error)
+}
+
+type ChangeSetRepository interface {
+	// List returns the change sets with a status, or all of them if it is
+	// empty, newest first with their targets
+	List(ctx context.Context, status string) ([]models.ChangeSet, error)
+	// Get returns a change set with its targets by wave
+	Get(ctx context.Context, id uint) (*models.ChangeSet, error)
+	// Create stores a change set with its targets
+	Create(ctx context.Context, changeSet *models.ChangeSet) error
+	Update(ctx context.Context, id uint, fields Fields) error
+	// SetTargets replaces the targets of a change set
+	SetTargets(ctx context.Context, id uint, targets []models.ChangeSetTarget) error
+	// Delete removes a change set with its targets
+	Delete(ctx context.Context, id uint) error
+	// Busy reports whether any of the servers is a target of a running
+	// change set
+	Busy(ctx context.Context, serverIDs []uint) (bool, error)
+
+	// Targets returns the targets of a change set by wave
+	Targets(ctx context.Context, changeSetID uint) ([]models.ChangeSetTarget, error)
+	// AppliedTargets returns the targets of a change set that were applied
+	// and not rolled back, last wave first
+	AppliedTargets(ctx context.Context, changeSetID uint) ([]models.ChangeSetTarget, error)
+	GetTarget(ctx context.Context, id uint) (*models.ChangeSetTarget, error)
+	UpdateTarget(ctx context.Context, id uint, fields Fields) error
+}
+
+// Store is the set of repositories of one backend
+type Store struct {
+	Servers    ServerRepository
+	Proxies    ProxyRepository
+	Mappings   MappingRepository
+	Schedules  ScheduleRepository
+	Groups     GroupRepository
+	Users      UserRepository
+	Audit      AuditRepository
+	Trash      TrashRepository
+	Webhooks   WebhookRepository
+	Deliveries DeliveryRepository
+	Outbox     OutboxRepository
+
+	AlertRules         AlertRuleRepository
+	Alerts             AlertRepository
+	AlertSilences      AlertSilenceRepository
+	AlertChannels      AlertChannelRepository
+	AlertNotifications AlertNotificationRepository
+	Checks             CheckRepository
+
+	QuarantinePolicies    QuarantinePolicyRepository
+	QuarantineTransitions QuarantineTransitionRepository
+	Providers             ProviderRepository
+
+	Snapshots  SnapshotRepository
+	ChangeSets ChangeSetRepository
+
+	// ping checks that the backend is reachable. Stores without it, such as
+	// fakes in tests, always are.
+	ping func(ctx context.Context) error
+
+	// transaction runs fn with a store bound to one transaction. Stores
+	// without it, such as fakes in tests, run fn on themselves.
+	transaction func(ctx context.Context, fn func(tx *Store) error) error
+}
+
+// Transaction runs fn with a store whose repositories share one
+// transaction. The transaction is committed if fn returns nil and rolled
+// back otherwise.
+func (s *Store) Transaction(ctx context.Context, fn func(tx *Store) error) error {
+	if s.transaction == nil {
+		return fn(s)
+	}
+	return s.transaction(ctx, fn)
+}
+
+// Ping checks that the backend of the store is reachable
+func (s *Store) Ping(ctx context.Context) error {
+	if s.ping == nil {
+		return nil
+	}
+	return s.ping(ctx)
+}
@@ -0,0 +1,87 @@
+// Package retry works through tables of queued work that is retried with
+// backoff: the event outbox, webhook deliveries and alert notifications.
+// Rows are claimed with a lease before each attempt, so several API
+// instances can share a table, and a row whose attempt died midway is taken
+// up again once its lease runs out.
+package retry
+
+import (
+	"context"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Backoff is the wait before retrying a failed attempt. It starts at First
+// and doubles after each failed attempt up to Max.
+type Backoff struct {
+	First time.Duration
+	Max   time.Duration
+}
+
+// After returns the wait after the given number of failed attempts
+func (b Backoff) After(attempts int) time.Duration {
+	wait := b.First
+	for i := 1; i < attempts && wait < b.Max; i++ {
+		wait *= 2
+	}
+	return min(wait, b.Max)
+}
+
+// Table is the repository of a queue's rows
+type Table[T any] interface {
+	// Due returns rows whose next attempt is not after now
+	Due(ctx context.Context, now time.Time, limit int) ([]T, error)
+	// Claim counts one more attempt of a row with the given attempts and
+	// moves its next attempt to leaseUntil. It reports false if another
+	// worker claimed the row first.
+	Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error)
+	Update(ctx context.Context, id uint, fields repository.Fields) error
+}
+
+// Queue takes the due rows of a table for attempts
+type Queue[T any] struct {
+	Table Table[T]
+	Batch int           // rows taken per Work
+	Lease time.Duration // how long a claimed row is left alone
+	// Row returns the ID of a row and its attempts
+	Row func(row *T) (id uint, attempts *int)
+}
+
+// Attempt makes one attempt at a claimed row and returns how to update it.
+// An error stops Work with the row left to be retried after its lease.
+type Attempt[T any] func(ctx context.Context, row T) (repository.Fields, error)
+
+// Work claims every row due at now, up to the batch size, and makes an
+// attempt at it with the claimed attempt counted. Rows claimed by another
+// worker are skipped. It returns the number of rows attempted.
+func (q Queue[T]) Work(ctx context.Context, now time.Time, attempt Attempt[T]) (int, error) {
+	due, err := q.Table.Due(ctx, now, q.Batch)
+	if err != nil {
+		return 0, err
+	}
+
+	attempted := 0
+	for i := range due {
+		row := &due[i]
+		id, attempts := q.Row(row)
+		claimed, err := q.Table.Claim(ctx, id, *attempts, now.Add(q.Lease))
+		if err != nil {
+			return attempted, err
+		}
+		if !claimed {
+			continue
+		}
+		*attempts++
+
+		fields, err := attempt(ctx, *row)
+		if err != nil {
+			return attempted, err
+		}
+		if err := q.Table.Update(ctx, id, fields); err != nil {
+			return attempted, err
+		}
+		attempted++
+	}
+	return attempted, nil
+}
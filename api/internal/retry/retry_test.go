@@ -0,0 +1,122 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+func TestBackoffAfter(t *testing.T) {
+	b := Backoff{First: 30 * time.Second, Max: time.Hour}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, 30 * time.Second},
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := b.After(tt.attempts); got != tt.want {
+			t.Errorf("After(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
+
+type testRow struct {
+	ID       uint
+	Attempts int
+}
+
+// testTable is a table of rows that are all due, where the rows in taken
+// were claimed by another worker
+type testTable struct {
+	rows    []testRow
+	taken   map[uint]bool
+	leases  map[uint]time.Time
+	updates map[uint]repository.Fields
+}
+
+func (t *testTable) Due(ctx context.Context, now time.Time, limit int) ([]testRow, error) {
+	return append([]testRow(nil), t.rows[:min(limit, len(t.rows))]...), nil
+}
+
+func (t *testTable) Claim(ctx context.Context, id uint, attempts int, leaseUntil time.Time) (bool, error) {
+	if t.taken[id] {
+		return false, nil
+	}
+	t.leases[id] = leaseUntil
+	return true, nil
+}
+
+func (t *testTable) Update(ctx context.Context, id uint, fields repository.Fields) error {
+	t.updates[id] = fields
+	return nil
+}
+
+func newTestQueue(table *testTable) Queue[testRow] {
+	return Queue[testRow]{
+		Table: table,
+		Batch: 3,
+		Lease: time.Minute,
+		Row:   func(row *testRow) (uint, *int) { return row.ID, &row.Attempts },
+	}
+}
+
+func TestQueueWork(t *testing.T) {
+	table := &testTable{
+		rows:    []testRow{{ID: 1}, {ID: 2, Attempts: 4}, {ID: 3}, {ID: 4}},
+		taken:   map[uint]bool{3: true},
+		leases:  map[uint]time.Time{},
+		updates: map[uint]repository.Fields{},
+	}
+	now := time.Now()
+
+	attempted, err := newTestQueue(table).Work(context.Background(), now, func(ctx context.Context, row testRow) (repository.Fields, error) {
+		return repository.Fields{"attempts": row.Attempts}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Row 3 went to another worker and row 4 is past the batch
+	if attempted != 2 {
+		t.Fatalf("attempted %d rows, want 2", attempted)
+	}
+	want := map[uint]repository.Fields{1: {"attempts": 1}, 2: {"attempts": 5}}
+	if !reflect.DeepEqual(table.updates, want) {
+		t.Fatalf("updates = %v, want %v", table.updates, want)
+	}
+	if lease := table.leases[1]; !lease.Equal(now.Add(time.Minute)) {
+		t.Fatalf("row 1 leased until %s, want a minute after now", lease)
+	}
+}
+
+func TestQueueWorkStopsOnError(t *testing.T) {
+	table := &testTable{
+		rows:    []testRow{{ID: 1}, {ID: 2}},
+		leases:  map[uint]time.Time{},
+		updates: map[uint]repository.Fields{},
+	}
+	failure := errors.New("database is gone")
+
+	attempted, err := newTestQueue(table).Work(context.Background(), time.Now(), func(ctx context.Context, row testRow) (repository.Fields, error) {
+		return nil, failure
+	})
+	if !errors.Is(err, failure) || attempted != 0 {
+		t.Fatalf("attempted %d rows, %v; want none and the error", attempted, err)
+	}
+	// The claimed row waits for its lease to run out
+	if len(table.updates) != 0 || len(table.leases) != 1 {
+		t.Fatalf("updates %v, leases %v; want one lease and no update", table.updates, table.leases)
+	}
+}
@@ -0,0 +1,483 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/checkhistory"
+	"github.com/Chinsusu/proxy-manager/api/internal/configstate"
+	"github.com/Chinsusu/proxy-manager/api/internal/healthcheck"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// pollInterval is how often running change sets are advanced while waiting
+// for agent acks and health checks.
+const pollInterval = 10 * time.Second
+
+// Defaults for change sets that do not set their own timings
+const (
+	DefaultAckTimeoutSeconds = 300
+	DefaultHealthWaitSeconds = 60
+)
+
+// Actor is the actor of the changes rollouts apply and roll back
+const Actor = "rollout"
+
+// ErrInvalidState is returned when a change set is not in a status that
+// allows the requested operation.
+var ErrInvalidState = errors.New("change set is not in a valid state for this operation")
+
+// AckOK reports whether an agent ack status means the config was applied
+func AckOK(status string) bool {
+	switch strings.ToLower(status) {
+	case "ok", "success", "applied":
+		return true
+	}
+	return false
+}
+
+// Runner advances running change sets wave by wave. It applies a wave's
+// documents, waits for the agents to ack and their proxies to stay healthy,
+// and rolls every applied server back when a target fails. It checks a
+// target's proxies itself before the apply and on every step of the health
+// wait, rather than relying on the periodic health checks, which may not
+// run within the wait.
+type Runner struct {
+	store   *repository.Store
+	checker *healthcheck.Checker
+	wake    chan struct{}
+	mu      sync.Mutex // serializes rollout steps with start, halt and rollback
+}
+
+func New(store *repository.Store, checker *healthcheck.Checker) *Runner {
+	return &Runner{store: store, checker: checker, wake: make(chan struct{}, 1)}
+}
+
+// Wake asks the runner to advance change sets now, e.g. after a start
+func (r *Runner) Wake() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run advances change sets until ctx is cancelled
+func (r *Runner) Run(ctx context.Context) {
+	for {
+		if err := r.Step(ctx, time.Now()); err != nil {
+			slog.Error("Rollout step failed", "component", "rollout", "error", err)
+		}
+
+		timer := time.NewTimer(pollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-r.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Step advances every running change set as far as it can go at now
+func (r *Runner) Step(ctx context.Context, now time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changeSets, err := r.store.ChangeSets.List(ctx, models.ChangeSetRunning)
+	if err != nil {
+		return err
+	}
+
+	for i := range changeSets {
+		if err := r.advance(ctx, &changeSets[i], now); err != nil {
+			slog.Error("Change set step failed", "component", "rollout", "change_set_id", changeSets[i].ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// Start begins rolling out a draft change set with its first wave
+func (r *Runner) Start(ctx context.Context, id uint) (*models.ChangeSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cs, err := r.store.ChangeSets.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Status != models.ChangeSetDraft {
+		return nil, ErrInvalidState
+	}
+	if len(cs.Targets) == 0 {
+		return nil, fmt.Errorf("change set has no targets")
+	}
+
+	serverIDs := make([]uint, 0, len(cs.Targets))
+	for _, target := range cs.Targets {
+		serverIDs = append(serverIDs, target.ServerID)
+	}
+	busy, err := r.store.ChangeSets.Busy(ctx, serverIDs)
+	if err != nil {
+		return nil, err
+	}
+	if busy {
+		return nil, fmt.Errorf("a target server is part of another running change set")
+	}
+
+	now := time.Now()
+	if err := r.store.ChangeSets.Update(ctx, id, repository.Fields{
+		"status":       models.ChangeSetRunning,
+		"current_wave": firstWave(cs.Targets),
+		"started_at":   &now,
+		"error":        "",
+	}); err != nil {
+		return nil, err
+	}
+
+	r.Wake()
+	return r.store.ChangeSets.Get(ctx, id)
+}
+
+// Halt stops a running change set. Servers already changed keep their new
+// config until the change set is rolled back.
+func (r *Runner) Halt(ctx context.Context, id uint) (*models.ChangeSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cs, err := r.store.ChangeSets.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Status != models.ChangeSetRunning {
+		return nil, ErrInvalidState
+	}
+
+	now := time.Now()
+	if err := r.store.ChangeSets.Update(ctx, id, repository.Fields{
+		"status":      models.ChangeSetHalted,
+		"finished_at": &now,
+	}); err != nil {
+		return nil, err
+	}
+	return r.store.ChangeSets.Get(ctx, id)
+}
+
+// Rollback restores every server a halted or completed change set changed
+// to the version it had before.
+func (r *Runner) Rollback(ctx context.Context, id uint) (*models.ChangeSet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cs, err := r.store.ChangeSets.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if cs.Status != models.ChangeSetHalted && cs.Status != models.ChangeSetCompleted {
+		return nil, ErrInvalidState
+	}
+
+	if err := r.rollback(ctx, cs, "rolled back on request", time.Now()); err != nil {
+		return nil, err
+	}
+	return r.store.ChangeSets.Get(ctx, id)
+}
+
+// advance moves a running change set through its waves
+func (r *Runner) advance(ctx context.Context, cs *models.ChangeSet, now time.Time) error {
+	targets, err := r.store.ChangeSets.Targets(ctx, cs.ID)
+	if err != nil {
+		return err
+	}
+
+	for {
+		done := true
+		for i := range targets {
+			target := &targets[i]
+			if target.Wave != cs.CurrentWave {
+				continue
+			}
+
+			switch target.Status {
+			case models.TargetPending:
+				if err := r.apply(ctx, target, now); err != nil {
+					return err
+				}
+			case models.TargetApplied:
+				if err := r.check(ctx, cs, target, now); err != nil {
+					return err
+				}
+			}
+
+			switch target.Status {
+			case models.TargetFailed:
+				return r.rollback(ctx, cs, fmt.Sprintf("server %d failed in wave %d: %s", target.ServerID, target.Wave, target.Error), now)
+			case models.TargetSucceeded, models.TargetSkipped:
+			default:
+				done = false
+			}
+		}
+		if !done {
+			return nil
+		}
+
+		next, ok := nextWave(targets, cs.CurrentWave)
+		if !ok {
+			return r.store.ChangeSets.Update(ctx, cs.ID, repository.Fields{
+				"status":      models.ChangeSetCompleted,
+				"finished_at": &now,
+			})
+		}
+		if err := r.store.ChangeSets.Update(ctx, cs.ID, repository.Fields{"current_wave": next}); err != nil {
+			return err
+		}
+		cs.CurrentWave = next
+	}
+}
+
+// apply makes a target server match its document
+func (r *Runner) apply(ctx context.Context, target *models.ChangeSetTarget, now time.Time) error {
+	doc, err := configstate.Parse([]byte(target.Document), "application/json")
+	if err == nil {
+		err = doc.Open()
+	}
+	if err != nil {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetFailed,
+			"error":  err.Error(),
+		})
+	}
+
+	server, err := r.store.Servers.Get(ctx, target.ServerID, false)
+	if errors.Is(err, repository.ErrNotFound) {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetFailed,
+			"error":  "server not found",
+		})
+	}
+	if err != nil {
+		return err
+	}
+	if server.ServiceState == models.ServiceMaintenance {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetSkipped,
+			"error":  "server is in maintenance",
+		})
+	}
+
+	// Make sure the version to roll back to has a snapshot
+	if err := r.store.Snapshots.Record(ctx, server.ID); err != nil {
+		return err
+	}
+
+	failing, err := r.failing(ctx, server.ID)
+	if err != nil {
+		return err
+	}
+
+	base := server.ConfigVersion
+	result, err := configstate.ApplyServer(ctx, r.store, server.ID, doc, &base, Actor)
+	if errors.Is(err, configstate.ErrVersionConflict) {
+		// The server changed under us; try again on the next step
+		return nil
+	}
+	if err != nil {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetFailed,
+			"error":  err.Error(),
+		})
+	}
+
+	updates := repository.Fields{
+		"base_version":    base,
+		"applied_version": result.ConfigVersion,
+		"failing_before":  failing,
+		"applied_at":      &now,
+		"status":          models.TargetApplied,
+	}
+	if !result.Applied {
+		// Nothing to change, so there is nothing for the agent to ack
+		updates["status"] = models.TargetSucceeded
+	}
+	return r.setTarget(ctx, target, updates)
+}
+
+// check looks for the agent ack of an applied target and, once acked,
+// watches its proxies' health for the change set's health wait.
+func (r *Runner) check(ctx context.Context, cs *models.ChangeSet, target *models.ChangeSetTarget, now time.Time) error {
+	server, err := r.store.Servers.Get(ctx, target.ServerID, false)
+	if errors.Is(err, repository.ErrNotFound) {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetFailed,
+			"error":  "server not found",
+		})
+	}
+	if err != nil {
+		return err
+	}
+
+	// Versions only go up, so an ack of a later version covers this one
+	if server.AppliedVersion < target.AppliedVersion {
+		timeout := time.Duration(cs.AckTimeoutSeconds) * time.Second
+		if now.Sub(*target.AppliedAt) > timeout {
+			return r.setTarget(ctx, target, repository.Fields{
+				"status": models.TargetFailed,
+				"error":  fmt.Sprintf("agent did not ack version %d within %s", target.AppliedVersion, timeout),
+			})
+		}
+		return nil
+	}
+
+	if !AckOK(server.AppliedStatus) {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status":   models.TargetFailed,
+			"error":    fmt.Sprintf("agent reported status %q for version %d", server.AppliedStatus, server.AppliedVersion),
+			"acked_at": server.AppliedAt,
+		})
+	}
+	if target.AckedAt == nil {
+		if err := r.setTarget(ctx, target, repository.Fields{"acked_at": server.AppliedAt}); err != nil {
+			return err
+		}
+	}
+
+	failing, err := r.failing(ctx, server.ID)
+	if err != nil {
+		return err
+	}
+	if failing > target.FailingBefore {
+		return r.setTarget(ctx, target, repository.Fields{
+			"status": models.TargetFailed,
+			"error":  fmt.Sprintf("%d proxies failing health checks (was %d)", failing, target.FailingBefore),
+		})
+	}
+
+	if now.Sub(*target.AckedAt) < time.Duration(cs.HealthWaitSeconds)*time.Second {
+		return nil
+	}
+	return r.setTarget(ctx, target, repository.Fields{"status": models.TargetSucceeded})
+}
+
+// rollback restores every server the change set changed to its base version
+// and marks the change set rolled back. Failures to restore a server are
+// recorded on its target and do not stop the other servers.
+func (r *Runner) rollback(ctx context.Context, cs *models.ChangeSet, reason string, now time.Time) error {
+	targets, err := r.store.ChangeSets.AppliedTargets(ctx, cs.ID)
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for i := range targets {
+		target := &targets[i]
+		if target.AppliedVersion == target.BaseVersion {
+			continue
+		}
+
+		if err := r.restore(ctx, target); err != nil {
+			slog.Error("Failed to roll back server", "component", "rollout", "change_set_id", cs.ID, "server_id", target.ServerID, "error", err)
+			failed = append(failed, fmt.Sprintf("server %d: %v", target.ServerID, err))
+			if err := r.setTarget(ctx, target, repository.Fields{"error": "rollback failed: " + err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+
+		updates := repository.Fields{"status": models.TargetRolledBack}
+		if target.Status != models.TargetFailed {
+			updates["error"] = ""
+		}
+		if err := r.setTarget(ctx, target, updates); err != nil {
+			return err
+		}
+	}
+
+	if len(failed) > 0 {
+		reason += "; rollback failed for " + strings.Join(failed, ", ")
+	}
+	return r.store.ChangeSets.Update(ctx, cs.ID, repository.Fields{
+		"status":      models.ChangeSetRolledBack,
+		"error":       reason,
+		"finished_at": &now,
+	})
+}
+
+// restore applies the snapshot of a target's base version to its server. It
+// fails with configstate.ErrVersionConflict rather than overwrite changes
+// made to the server since the change set applied its document.
+func (r *Runner) restore(ctx context.Context, target *models.ChangeSetTarget) error {
+	snapshot, err := r.store.Snapshots.Get(ctx, target.ServerID, target.BaseVersion)
+	if err != nil {
+		return fmt.Errorf("no snapshot of version %d: %w", target.BaseVersion, err)
+	}
+	doc, err := configstate.FromSnapshot(snapshot)
+	if err != nil {
+		return err
+	}
+	_, err = configstate.ApplyServer(ctx, r.store, target.ServerID, doc, &target.AppliedVersion, Actor)
+	if errors.Is(err, configstate.ErrVersionConflict) {
+		return fmt.Errorf("server changed after version %d, restore it by hand: %w", target.AppliedVersion, err)
+	}
+	return err
+}
+
+// failing checks a server's proxies now, records the results and returns
+// the number failing
+func (r *Runner) failing(ctx context.Context, serverID uint) (int, error) {
+	proxies, err := r.store.Proxies.List(ctx, repository.ProxyFilter{ServerID: &serverID})
+	if err != nil {
+		return 0, err
+	}
+	results, err := checkhistory.Check(ctx, r.store, r.checker, proxies)
+	if err != nil {
+		return 0, err
+	}
+
+	failing := 0
+	for _, result := range results {
+		if result.Health == healthcheck.HealthFail {
+			failing++
+		}
+	}
+	return failing, nil
+}
+
+func (r *Runner) setTarget(ctx context.Context, target *models.ChangeSetTarget, updates repository.Fields) error {
+	if err := r.store.ChangeSets.UpdateTarget(ctx, target.ID, updates); err != nil {
+		return err
+	}
+	updated, err := r.store.ChangeSets.GetTarget(ctx, target.ID)
+	if err != nil {
+		return err
+	}
+	*target = *updated
+	return nil
+}
+
+func firstWave(targets []models.ChangeSetTarget) int {
+	first := targets[0].Wave
+	for _, target := range targets[1:] {
+		if target.Wave < first {
+			first = target.Wave
+		}
+	}
+	return first
+}
+
+// nextWave returns the lowest wave after the current one
+func nextWave(targets []models.ChangeSetTarget, current int) (int, bool) {
+	next, ok := 0, false
+	for _, target := range targets {
+		if target.Wave > current && (!ok || target.Wave < next) {
+			next, ok = target.Wave, true
+		}
+	}
+	return next, ok
+}
@@ -0,0 +1,142 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// maxSleep bounds how long the scheduler waits between evaluations, so
+// schedules edited without a Wake are still picked up.
+const maxSleep = time.Minute
+
+// Scheduler opens and closes mapping schedule windows and bumps the config
+// version of every server whose effective config changed.
+type Scheduler struct {
+	store *repository.Store
+	wake  chan struct{}
+}
+
+func New(store *repository.Store) *Scheduler {
+	return &Scheduler{store: store, wake: make(chan struct{}, 1)}
+}
+
+// Wake asks the scheduler to re-evaluate now, e.g. after a schedule changed
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run evaluates schedules until ctx is cancelled, sleeping until the next
+// window opens or closes.
+func (s *Scheduler) Run(ctx context.Context) {
+	for {
+		next, err := s.Evaluate(ctx, time.Now())
+		if err != nil {
+			slog.Error("Schedule evaluation failed", "component", "scheduler", "error", err)
+		}
+
+		wait := maxSleep
+		if !next.IsZero() {
+			if until := time.Until(next); until < wait {
+				wait = until
+			}
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Evaluate applies every window transition due at now and returns the time
+// of the next one (zero if there is none).
+func (s *Scheduler) Evaluate(ctx context.Context, now time.Time) (time.Time, error) {
+	schedules, err := s.store.Schedules.List(ctx, repository.ScheduleFilter{Live: true})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var next time.Time
+	var transitions []*models.MappingSchedule
+
+	for i := range schedules {
+		schedule := &schedules[i]
+
+		active := false
+		if schedule.Enabled {
+			window, err := Compile(schedule)
+			if err != nil {
+				slog.Warn("Skipping schedule", "component", "scheduler", "schedule_id", schedule.ID, "error", err)
+				continue
+			}
+			active = window.ActiveAt(now)
+			if t := window.NextTransition(now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+				next = t
+			}
+		}
+
+		if active != schedule.Active {
+			schedule.Active = active
+			transitions = append(transitions, schedule)
+		}
+	}
+
+	if len(transitions) == 0 {
+		return next, nil
+	}
+
+	// The windows and the config versions of their servers change together,
+	// so an agent never misses a transition that was recorded
+	err = s.store.Transaction(ctx, func(tx *repository.Store) error {
+		mappingIDs := make([]uint, 0, len(transitions))
+		for _, schedule := range transitions {
+			if err := tx.Schedules.UpdateMany(ctx, []uint{schedule.ID}, repository.Fields{
+				"active":             schedule.Active,
+				"last_transition_at": &now,
+			}); err != nil {
+				return fmt.Errorf("failed to update schedule %d: %w", schedule.ID, err)
+			}
+			mappingIDs = append(mappingIDs, schedule.MappingID)
+		}
+
+		mappings, err := tx.Mappings.List(ctx, repository.MappingFilter{IDs: mappingIDs})
+		if err != nil {
+			return err
+		}
+		serverIDs := make([]uint, 0, len(mappings))
+		seen := make(map[uint]bool, len(mappings))
+		for _, mapping := range mappings {
+			if !seen[mapping.ServerID] {
+				seen[mapping.ServerID] = true
+				serverIDs = append(serverIDs, mapping.ServerID)
+			}
+		}
+		sort.Slice(serverIDs, func(i, j int) bool { return serverIDs[i] < serverIDs[j] })
+
+		for _, serverID := range serverIDs {
+			if err := tx.Servers.BumpConfigVersion(ctx, serverID); err != nil {
+				return fmt.Errorf("failed to bump config version for server %d: %w", serverID, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return next, err
+	}
+
+	return next, nil
+}
@@ -0,0 +1,133 @@
+package scheduler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/robfig/cron/v3"
+)
+
+// Schedule kinds
+const (
+	KindCron   = "cron"
+	KindWeekly = "weekly"
+)
+
+var cronParser = cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+var weekdayNames = map[string]string{
+	"sun": "0", "mon": "1", "tue": "2", "wed": "3", "thu": "4", "fri": "5", "sat": "6",
+}
+
+// Window is a compiled schedule: a window opens on every fire time of the
+// cron expression and stays open for the duration.
+type Window struct {
+	sched    cron.Schedule
+	duration time.Duration
+}
+
+// Compile validates a schedule and turns it into a Window
+func Compile(s *models.MappingSchedule) (*Window, error) {
+	if _, err := time.LoadLocation(s.Timezone); err != nil {
+		return nil, fmt.Errorf("invalid timezone %q", s.Timezone)
+	}
+
+	var expr string
+	var duration time.Duration
+
+	switch s.Kind {
+	case KindCron:
+		upper := strings.ToUpper(strings.TrimSpace(s.CronExpr))
+		if strings.HasPrefix(upper, "CRON_TZ=") || strings.HasPrefix(upper, "TZ=") {
+			return nil, fmt.Errorf("set the time zone with the timezone field, not inside cron_expr")
+		}
+		if s.DurationMinutes <= 0 {
+			return nil, fmt.Errorf("duration_minutes must be greater than 0")
+		}
+		expr = s.CronExpr
+		duration = time.Duration(s.DurationMinutes) * time.Minute
+
+	case KindWeekly:
+		days, err := parseWeekdays(s.Weekdays)
+		if err != nil {
+			return nil, err
+		}
+		start, err := parseClock(s.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_time: %w", err)
+		}
+		end, err := parseClock(s.EndTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end_time: %w", err)
+		}
+		duration = end - start
+		if duration <= 0 {
+			// Window wraps past midnight
+			duration += 24 * time.Hour
+		}
+		expr = fmt.Sprintf("%d %d * * %s", int(start.Minutes())%60, int(start.Hours()), days)
+
+	default:
+		return nil, fmt.Errorf("invalid kind %q. Must be: cron, weekly", s.Kind)
+	}
+
+	sched, err := cronParser.Parse("CRON_TZ=" + s.Timezone + " " + expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression: %w", err)
+	}
+
+	return &Window{sched: sched, duration: duration}, nil
+}
+
+// ActiveAt reports whether a window is open at t
+func (w *Window) ActiveAt(t time.Time) bool {
+	start := w.sched.Next(t.Add(-w.duration))
+	return !start.IsZero() && !start.After(t)
+}
+
+// NextTransition returns the first time after t at which the window opens
+// or closes. Overlapping windows are treated as one.
+func (w *Window) NextTransition(t time.Time) time.Time {
+	if !w.ActiveAt(t) {
+		return w.sched.Next(t)
+	}
+
+	// Find the latest opening at or before t; the window closes one
+	// duration after it.
+	start := w.sched.Next(t.Add(-w.duration))
+	for {
+		next := w.sched.Next(start)
+		if next.IsZero() || next.After(t) {
+			break
+		}
+		start = next
+	}
+	return start.Add(w.duration)
+}
+
+func parseWeekdays(s string) (string, error) {
+	if strings.TrimSpace(s) == "" {
+		return "", fmt.Errorf("weekdays cannot be empty")
+	}
+
+	var days []string
+	for _, part := range strings.Split(s, ",") {
+		name := strings.ToLower(strings.TrimSpace(part))
+		day, ok := weekdayNames[name]
+		if !ok {
+			return "", fmt.Errorf("invalid weekday %q. Must be: mon, tue, wed, thu, fri, sat, sun", part)
+		}
+		days = append(days, day)
+	}
+	return strings.Join(days, ","), nil
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("must be HH:MM")
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
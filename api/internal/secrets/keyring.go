@@ -0,0 +1,224 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// KeySize is the size of key-encryption keys and data keys (AES-256)
+const KeySize = 32
+
+// prefix marks a sealed value: enc:v1:<key id>:<wrapped data key>:<ciphertext>
+const prefix = "enc:v1:"
+
+// SealedLike is a SQL LIKE pattern matching stored texts that hold a sealed
+// value
+const SealedLike = "%" + prefix + "%"
+
+// sealedValue matches a sealed value anywhere in a text
+var sealedValue = regexp.MustCompile(regexp.QuoteMeta(prefix) + `[0-9a-f]+:[A-Za-z0-9+/]+:[A-Za-z0-9+/]+`)
+
+var (
+	// ErrUnknownKey is returned when a value was sealed with a key that is not
+	// in the keyring.
+	ErrUnknownKey = errors.New("value was sealed with an unknown key")
+
+	// ErrMalformed is returned when a sealed value cannot be parsed
+	ErrMalformed = errors.New("malformed sealed value")
+)
+
+type key struct {
+	id   string
+	aead cipher.AEAD
+}
+
+// Keyring seals values with envelope encryption: every value gets its own
+// random data key, which is stored with it wrapped by the current key.
+// Previous keys are kept so values sealed before a rotation can be opened.
+type Keyring struct {
+	current *key
+	keys    map[string]*key
+}
+
+// NewKeyring builds a keyring that seals with current and opens values sealed
+// with current or any of the previous keys.
+func NewKeyring(current []byte, previous ...[]byte) (*Keyring, error) {
+	k, err := newKey(current)
+	if err != nil {
+		return nil, err
+	}
+
+	ring := &Keyring{current: k, keys: map[string]*key{k.id: k}}
+	for _, raw := range previous {
+		prev, err := newKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("previous key: %w", err)
+		}
+		if _, ok := ring.keys[prev.id]; !ok {
+			ring.keys[prev.id] = prev
+		}
+	}
+	return ring, nil
+}
+
+func newKey(raw []byte) (*key, error) {
+	if len(raw) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(raw))
+	}
+	aead, err := newAEAD(raw)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(raw)
+	return &key{id: hex.EncodeToString(sum[:4]), aead: aead}, nil
+}
+
+func newAEAD(raw []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// KeyID returns the ID of the key new values are sealed with
+func (k *Keyring) KeyID() string {
+	return k.current.id
+}
+
+// IsSealed reports whether a stored value is sealed rather than plain text
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts a value. The empty string stays empty.
+func (k *Keyring) Seal(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	wrapped, err := seal(k.current.aead, dataKey, []byte(k.current.id))
+	if err != nil {
+		return "", err
+	}
+	ciphertext, err := seal(dataAEAD, []byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return prefix + k.current.id + ":" +
+		base64.RawStdEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawStdEncoding.EncodeToString(ciphertext), nil
+}
+
+// Open decrypts a sealed value. Values that are not sealed are returned as
+// they are, so rows written before encryption keep working until rotated.
+func (k *Keyring) Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", ErrMalformed
+	}
+	kek, ok := k.keys[parts[0]]
+	if !ok {
+		return "", ErrUnknownKey
+	}
+	wrapped, err := base64.RawStdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", ErrMalformed
+	}
+	ciphertext, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	dataKey, err := open(kek.aead, wrapped, []byte(kek.id))
+	if err != nil {
+		return "", err
+	}
+	dataAEAD, err := newAEAD(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataAEAD, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// FindSealed returns the first sealed value in text, such as a JSON document
+// holding sealed credentials, or "" if it has none
+func FindSealed(text string) string {
+	return sealedValue.FindString(text)
+}
+
+// Verify checks that the keyring opens every one of sealed, which it cannot
+// if the key they were sealed with is not in it
+func (k *Keyring) Verify(sealed []string) error {
+	for _, value := range sealed {
+		if _, err := k.Open(value); err != nil {
+			keyID, _, _ := strings.Cut(strings.TrimPrefix(value, prefix), ":")
+			return fmt.Errorf("credentials key does not open the stored credentials sealed with key %s: %w", keyID, err)
+		}
+	}
+	return nil
+}
+
+// NeedsRotation reports whether a stored value is plain text or sealed with
+// a key other than the current one.
+func (k *Keyring) NeedsRotation(value string) bool {
+	if value == "" {
+		return false
+	}
+	return !strings.HasPrefix(value, prefix+k.current.id+":")
+}
+
+// Reseal opens a value and seals it again with the current key
+func (k *Keyring) Reseal(value string) (string, error) {
+	plaintext, err := k.Open(value)
+	if err != nil {
+		return "", err
+	}
+	return k.Seal(plaintext)
+}
+
+func seal(aead cipher.AEAD, plaintext, additional []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func open(aead cipher.AEAD, data, additional []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, additional)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+)
+
+// Load builds the keyring from config and checks that it opens sealed, a
+// sample of the values already stored. The current key comes from
+// CREDENTIALS_KEY or, if unset, the key file. The key file is created with a
+// new random key only while nothing is sealed yet: one that went missing
+// with an unmounted volume or another working directory is reported rather
+// than replaced by a key that opens nothing.
+func Load(cfg *config.Config, sealed []string) (*Keyring, error) {
+	current, err := loadCurrentKey(cfg.CredentialsKey, cfg.CredentialsKeyFile, len(sealed) > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous [][]byte
+	for _, encoded := range splitList(cfg.CredentialsPreviousKeys) {
+		k, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("CREDENTIALS_PREVIOUS_KEYS: %w", err)
+		}
+		previous = append(previous, k)
+	}
+	for _, path := range splitList(cfg.CredentialsPreviousKeyFiles) {
+		k, err := readKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, k)
+	}
+
+	keyring, err := NewKeyring(current, previous...)
+	if err != nil {
+		return nil, err
+	}
+	if err := keyring.Verify(sealed); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// ParseKey decodes a base64 key
+func ParseKey(encoded string) ([]byte, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("key is not valid base64: %w", err)
+	}
+	if len(k) != KeySize {
+		return nil, fmt.Errorf("key must be %d bytes, got %d", KeySize, len(k))
+	}
+	return k, nil
+}
+
+// GenerateKey returns a new random key, base64 encoded
+func GenerateKey() (string, error) {
+	k := make([]byte, KeySize)
+	if _, err := rand.Read(k); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(k), nil
+}
+
+func loadCurrentKey(encoded, path string, inUse bool) ([]byte, error) {
+	if encoded != "" {
+		k, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("CREDENTIALS_KEY: %w", err)
+		}
+		return k, nil
+	}
+
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if inUse {
+			return nil, fmt.Errorf("key file %s does not exist but the database holds sealed credentials; restore it or set CREDENTIALS_KEY", path)
+		}
+		if err := CreateKeyFile(path); err != nil {
+			return nil, err
+		}
+		slog.Info("Created credentials key file", "path", path)
+	}
+	return readKeyFile(path)
+}
+
+// CreateKeyFile writes a new random key to path. It refuses to replace an
+// existing file, whose key may have sealed stored values.
+func CreateKeyFile(path string) error {
+	encoded, err := GenerateKey()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("failed to create key file directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create key file: %w", err)
+	}
+	if _, err := f.WriteString(encoded + "\n"); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+	return f.Close()
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	k, err := ParseKey(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("key file %s: %w", path, err)
+	}
+	return k, nil
+}
+
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
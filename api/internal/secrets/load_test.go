@@ -0,0 +1,109 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+)
+
+// sealedWith seals a value with a keyring of key alone
+func sealedWith(t *testing.T, key []byte) string {
+	t.Helper()
+
+	k, err := NewKeyring(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := k.Seal("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sealed
+}
+
+func TestLoadCreatesKeyFileWhileNothingIsSealed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "credentials.key")
+
+	k, err := Load(&config.Config{CredentialsKeyFile: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("key file was not created: %v", err)
+	}
+
+	// The next start reads the same key
+	again, err := Load(&config.Config{CredentialsKeyFile: path}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.KeyID() != k.KeyID() {
+		t.Fatalf("key changed between starts: %s, then %s", k.KeyID(), again.KeyID())
+	}
+}
+
+func TestLoadRefusesMissingKeyFileWithSealedValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.key")
+	sealed := []string{sealedWith(t, bytes.Repeat([]byte{1}, KeySize))}
+
+	if _, err := Load(&config.Config{CredentialsKeyFile: path}, sealed); err == nil {
+		t.Fatal("Load created a key although values are sealed")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("key file was created: %v", err)
+	}
+}
+
+func TestLoadChecksKeyOpensSealedValues(t *testing.T) {
+	old := bytes.Repeat([]byte{1}, KeySize)
+	current := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, KeySize))
+	sealed := []string{sealedWith(t, old)}
+
+	if _, err := Load(&config.Config{CredentialsKey: current}, sealed); err == nil {
+		t.Fatal("Load accepted a key that does not open the sealed values")
+	}
+
+	// Mid-rotation, the old key is still there to open them
+	cfg := &config.Config{CredentialsKey: current, CredentialsPreviousKeys: base64.StdEncoding.EncodeToString(old)}
+	if _, err := Load(cfg, sealed); err != nil {
+		t.Fatalf("Load with the old key as a previous key: %v", err)
+	}
+}
+
+func TestCreateKeyFileKeepsExistingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.key")
+	if err := CreateKeyFile(path); err != nil {
+		t.Fatal(err)
+	}
+	before, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := CreateKeyFile(path); err == nil {
+		t.Fatal("CreateKeyFile replaced an existing key file")
+	}
+	after, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(before, after) {
+		t.Fatal("key file changed")
+	}
+}
+
+func TestFindSealed(t *testing.T) {
+	sealed := sealedWith(t, bytes.Repeat([]byte{1}, KeySize))
+
+	document := `{"proxies":[{"host":"10.0.0.1","password":"` + sealed + `"}]}`
+	if got := FindSealed(document); got != sealed {
+		t.Fatalf("FindSealed = %q, want %q", got, sealed)
+	}
+	if got := FindSealed(`{"password":"plain"}`); got != "" {
+		t.Fatalf("FindSealed of plain text = %q", got)
+	}
+}
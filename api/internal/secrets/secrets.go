@@ -0,0 +1,138 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+)
+
+// Mask is shown in place of credentials in the admin API
+const Mask = "********"
+
+// ErrNoKeyring is returned when values are sealed or opened before a keyring
+// is configured.
+var ErrNoKeyring = errors.New("credentials keyring is not configured")
+
+var (
+	mu            sync.RWMutex
+	defaultRing   *Keyring
+	sealUsernames bool
+)
+
+// Configure sets the keyring used by Secret and Text values, and whether
+// proxy usernames are sealed as well as passwords.
+func Configure(k *Keyring, usernames bool) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultRing = k
+	sealUsernames = usernames
+}
+
+// Default returns the configured keyring
+func Default() (*Keyring, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if defaultRing == nil {
+		return nil, ErrNoKeyring
+	}
+	return defaultRing, nil
+}
+
+func sealsUsernames() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sealUsernames
+}
+
+// Secret is a credential as stored: sealed, or plain text written before
+// encryption was enabled. It is always masked when encoded as JSON.
+type Secret string
+
+// SealSecret seals a plain text credential with the configured keyring
+func SealSecret(plaintext string) (Secret, error) {
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := k.Seal(plaintext)
+	return Secret(sealed), err
+}
+
+// Open returns the plain text of the credential
+func (s Secret) Open() (string, error) {
+	return openValue(string(s))
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	if s == "" {
+		return json.Marshal("")
+	}
+	return json.Marshal(Mask)
+}
+
+// Update returns what to store for a credential sent to the admin API, and
+// whether to store it at all. Nil and the mask, which is what the API
+// showed, leave the stored value unchanged; the empty string clears it;
+// anything else is sealed.
+func Update(req *string) (Secret, bool, error) {
+	if req == nil || *req == Mask {
+		return "", false, nil
+	}
+	if *req == "" {
+		return "", true, nil
+	}
+	sealed, err := SealSecret(*req)
+	return sealed, err == nil, err
+}
+
+// Text is a value that is sealed only when configured to be, such as a proxy
+// username. Plain values are encoded as they are; sealed ones are masked.
+type Text string
+
+// SealText seals a value if usernames are configured to be sealed
+func SealText(plaintext string) (Text, error) {
+	if !sealsUsernames() {
+		return Text(plaintext), nil
+	}
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	sealed, err := k.Seal(plaintext)
+	return Text(sealed), err
+}
+
+// UpdateText is Update for Text values
+func UpdateText(req *string) (Text, bool, error) {
+	if req == nil || *req == Mask {
+		return "", false, nil
+	}
+	if *req == "" {
+		return "", true, nil
+	}
+	sealed, err := SealText(*req)
+	return sealed, err == nil, err
+}
+
+// Open returns the plain text of the value
+func (t Text) Open() (string, error) {
+	return openValue(string(t))
+}
+
+func (t Text) MarshalJSON() ([]byte, error) {
+	if IsSealed(string(t)) {
+		return json.Marshal(Mask)
+	}
+	return json.Marshal(string(t))
+}
+
+func openValue(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+	k, err := Default()
+	if err != nil {
+		return "", err
+	}
+	return k.Open(value)
+}
@@ -0,0 +1,49 @@
+package secrets
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUpdate(t *testing.T) {
+	k, err := NewKeyring(bytes.Repeat([]byte{1}, KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	Configure(k, true)
+	t.Cleanup(func() { Configure(nil, false) })
+
+	str := func(s string) *string { return &s }
+	tests := []struct {
+		name        string
+		req         *string
+		wantChanged bool
+		wantOpen    string
+	}{
+		{"omitted", nil, false, ""},
+		{"masked", str(Mask), false, ""},
+		{"cleared", str(""), true, ""},
+		{"set", str("hunter2"), true, "hunter2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, changed, err := Update(tt.req)
+			if err != nil || changed != tt.wantChanged {
+				t.Fatalf("Update: changed %v, %v; want changed %v", changed, err, tt.wantChanged)
+			}
+			text, changed, err := UpdateText(tt.req)
+			if err != nil || changed != tt.wantChanged {
+				t.Fatalf("UpdateText: changed %v, %v; want changed %v", changed, err, tt.wantChanged)
+			}
+
+			if tt.wantOpen != "" && (!IsSealed(string(secret)) || !IsSealed(string(text))) {
+				t.Fatalf("stored %q and %q, want them sealed", secret, text)
+			}
+			for _, value := range []interface{ Open() (string, error) }{secret, text} {
+				if opened, err := value.Open(); err != nil || opened != tt.wantOpen {
+					t.Fatalf("opened %q, %v; want %q", opened, err, tt.wantOpen)
+				}
+			}
+		})
+	}
+}
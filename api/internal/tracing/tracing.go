@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation is the name the spans of the API are recorded under
+const instrumentation = "github.com/Chinsusu/proxy-manager/api"
+
+// defaultServiceName is used unless OTEL_SERVICE_NAME says otherwise
+const defaultServiceName = "pgm-api"
+
+// Setup installs the tracer provider and propagator of cfg globally. With
+// tracing disabled it installs nothing, so spans are no-ops. The returned
+// shutdown flushes the spans still batched and stops the exporter; call it
+// before exiting.
+func Setup(ctx context.Context, cfg *config.Config) (shutdown func(context.Context) error, err error) {
+	switch cfg.TracesExporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// Endpoint, headers and timeout come from the OTEL_EXPORTER_OTLP_*
+		// variables
+		exporter, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OTLP exporter: %w", err)
+		}
+		provider, err := NewProvider(ctx, exporter)
+		if err != nil {
+			return nil, err
+		}
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+		return provider.Shutdown, nil
+	default:
+		return nil, fmt.Errorf("unsupported traces exporter %q, expected otlp or none", cfg.TracesExporter)
+	}
+}
+
+// NewProvider returns a tracer provider that batches spans to exporter.
+// Tests can pass an in-memory exporter and install the provider with
+// otel.SetTracerProvider.
+func NewProvider(ctx context.Context, exporter sdktrace.SpanExporter) (*sdktrace.TracerProvider, error) {
+	// Later options override earlier ones, so OTEL_SERVICE_NAME and
+	// OTEL_RESOURCE_ATTRIBUTES win over the default name
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", defaultServiceName)),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build trace resource: %w", err)
+	}
+
+	// The sampler follows OTEL_TRACES_SAMPLER, parent-based always-on
+	// by default
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// tracer is looked up on each use, so it follows the provider Setup
+// installed
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
@@ -0,0 +1,95 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/config"
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestMiddlewareContinuesIncomingTrace(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	provider, err := NewProvider(ctx, exporter)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previousProvider, previousPropagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(ctx)
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(Middleware())
+	r.GET("/proxies/:id", func(c *gin.Context) {
+		c.Status(http.StatusInternalServerError)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/proxies/7", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929b0e0e4736-00f067aa0ba902b7-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	// The provider batches spans; the in-memory exporter drops them on
+	// shutdown, so flush instead
+	if err := provider.ForceFlush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name != "GET /proxies/:id" || span.SpanKind != trace.SpanKindServer {
+		t.Fatalf("span %q of kind %v, want a server span named GET /proxies/:id", span.Name, span.SpanKind)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "4bf92f3577b34da6a3ce929b0e0e4736" {
+		t.Fatalf("trace ID = %s, want the incoming one", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Fatalf("parent span ID = %s, want the incoming one", got)
+	}
+	if span.Status.Code != codes.Error {
+		t.Fatalf("status = %v, want error for a 500", span.Status.Code)
+	}
+
+	attributes := make(map[attribute.Key]attribute.Value, len(span.Attributes))
+	for _, kv := range span.Attributes {
+		attributes[kv.Key] = kv.Value
+	}
+	if got := attributes["http.route"].AsString(); got != "/proxies/:id" {
+		t.Fatalf("http.route = %q", got)
+	}
+	if got := attributes["http.response.status_code"].AsInt64(); got != http.StatusInternalServerError {
+		t.Fatalf("http.response.status_code = %d", got)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	shutdown, err := Setup(context.Background(), &config.Config{TracesExporter: "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown with tracing off: %v", err)
+	}
+
+	if _, err := Setup(context.Background(), &config.Config{TracesExporter: "zipkin"}); err == nil {
+		t.Fatal("unsupported exporter: got no error")
+	}
+}
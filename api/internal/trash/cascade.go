@@ -0,0 +1,71 @@
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Cascade is what a delete took along to the trash or detached from the
+// deleted item. It is stored with the trash entry, so a restore can rebuild
+// the relations.
+type Cascade struct {
+	Proxies           []uint             `json:"proxies,omitempty"`
+	Mappings          []uint             `json:"mappings,omitempty"`
+	Schedules         []uint             `json:"schedules,omitempty"`
+	Unassigned        []uint             `json:"unassigned,omitempty"`         // proxies taken off a deleted server
+	Ungrouped         []uint             `json:"ungrouped,omitempty"`          // proxies taken out of a deleted group
+	Released          []ReleasedMapping  `json:"released,omitempty"`           // mappings that lost their upstream
+	ReleasedSchedules []ReleasedSchedule `json:"released_schedules,omitempty"` // schedules that lost their upstream
+}
+
+type ReleasedMapping struct {
+	ID              uint `json:"id"`
+	UpstreamProxyID uint `json:"upstream_proxy_id"`
+	Enabled         bool `json:"enabled"`
+}
+
+type ReleasedSchedule struct {
+	ID              uint `json:"id"`
+	UpstreamProxyID uint `json:"upstream_proxy_id"`
+	Enabled         bool `json:"enabled"`
+}
+
+// DecodeCascade reads the cascade of an entry. Entries without one took
+// nothing along.
+func DecodeCascade(stored string) Cascade {
+	var cascade Cascade
+	if stored != "" {
+		json.Unmarshal([]byte(stored), &cascade)
+	}
+	return cascade
+}
+
+// Record records a delete in the trash, with what it took along
+func Record(ctx context.Context, tx *repository.Store, entry models.TrashEntry, cascade *Cascade) error {
+	if cascade != nil {
+		encoded, err := json.Marshal(cascade)
+		if err != nil {
+			return err
+		}
+		entry.Cascaded = string(encoded)
+	}
+	entry.DeletedAt = time.Now()
+	return tx.Trash.Create(ctx, &entry)
+}
+
+// DeleteMapping moves a mapping and its schedules to the trash and records
+// the schedules in cascade
+func DeleteMapping(ctx context.Context, tx *repository.Store, id uint, cascade *Cascade) error {
+	schedules, err := tx.Schedules.List(ctx, repository.ScheduleFilter{MappingID: &id})
+	if err != nil {
+		return err
+	}
+	for _, schedule := range schedules {
+		cascade.Schedules = append(cascade.Schedules, schedule.ID)
+	}
+	return tx.Mappings.Delete(ctx, id)
+}
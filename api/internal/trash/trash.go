@@ -0,0 +1,54 @@
+package trash
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// purgeInterval is how often the trash is checked for expired items
+const purgeInterval = time.Hour
+
+// Purger deletes items for good once they have been in the trash longer
+// than the retention
+type Purger struct {
+	store     *repository.Store
+	retention time.Duration
+}
+
+// New returns a purger. A retention of zero keeps the trash until it is
+// restored, and Run returns at once.
+func New(store *repository.Store, retention time.Duration) *Purger {
+	return &Purger{store: store, retention: retention}
+}
+
+// Run purges the trash every purgeInterval until ctx is cancelled
+func (p *Purger) Run(ctx context.Context) {
+	if p.retention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(purgeInterval)
+	defer ticker.Stop()
+	for {
+		if purged, err := p.Purge(ctx, time.Now()); err != nil {
+			slog.Error("Trash purge failed", "component", "trash", "error", err)
+		} else if purged > 0 {
+			slog.Info("Purged trash", "component", "trash", "entries", purged, "retention", p.retention.String())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge deletes what went to the trash before now minus the retention and
+// returns the number of trash entries removed
+func (p *Purger) Purge(ctx context.Context, now time.Time) (int64, error) {
+	return p.store.Trash.Purge(ctx, now.Add(-p.retention))
+}
@@ -0,0 +1,70 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrNotPublic is returned when dialling an address that is not public
+var ErrNotPublic = errors.New("address is not public")
+
+// nonPublic are ranges not covered by the netip.Addr predicates
+var nonPublic = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // this network
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),   // reserved, and broadcast
+}
+
+// Public reports whether an address is on the public internet: not
+// loopback, private, link-local, multicast or otherwise reserved
+func Public(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsValid() || addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() || addr.IsInterfaceLocalMulticast() || addr.IsMulticast() {
+		return false
+	}
+	for _, prefix := range nonPublic {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// dialPublic is a net.Dialer Control function that refuses to connect to
+// addresses that are not public. It sees the address after name
+// resolution, so a name resolving to a private address is refused too.
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !Public(addr) {
+		return fmt.Errorf("%w: %s", ErrNotPublic, addr.Unmap())
+	}
+	return nil
+}
+
+// NewTransport returns the transport of requests to URLs that users of the
+// API set, such as webhooks. Unless allowPrivate, it only connects to
+// public addresses, so those URLs cannot reach the API's own host or the
+// network it runs in.
+func NewTransport(allowPrivate bool) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if !allowPrivate {
+		dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second, Control: dialPublic}
+		transport.DialContext = dialer.DialContext
+	}
+	return transport
+}
@@ -0,0 +1,174 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+	"github.com/Chinsusu/proxy-manager/api/internal/retry"
+)
+
+const (
+	// pollInterval is how often the outbox is checked for due deliveries
+	pollInterval = 5 * time.Second
+	// batchSize bounds the deliveries sent per poll
+	batchSize = 50
+	// MaxAttempts is how often a delivery is tried before it is failed
+	MaxAttempts = 10
+	// firstRetry doubles after each failed attempt up to maxRetry
+	firstRetry = 30 * time.Second
+	maxRetry   = time.Hour
+	// lease is how long a claimed delivery is left alone. A sender that
+	// dies midway leaves it to be retried after this.
+	lease = 2 * time.Minute
+	// requestTimeout bounds one attempt
+	requestTimeout = 10 * time.Second
+	// responseBodyLimit is how much of a response is kept in the log
+	responseBodyLimit = 1024
+	// retention is how long finished deliveries stay in the log
+	retention = 30 * 24 * time.Hour
+)
+
+var backoff = retry.Backoff{First: firstRetry, Max: maxRetry}
+
+// Dispatcher sends due deliveries from the outbox
+type Dispatcher struct {
+	store  *repository.Store
+	queue  retry.Queue[models.WebhookDelivery]
+	client *http.Client
+}
+
+// NewDispatcher returns a dispatcher. Redirects are not followed; a
+// webhook answering one is a failed attempt. Unless allowPrivate, webhooks
+// are only sent to public addresses.
+func NewDispatcher(store *repository.Store, allowPrivate bool) *Dispatcher {
+	return &Dispatcher{
+		store: store,
+		queue: retry.Queue[models.WebhookDelivery]{
+			Table: store.Deliveries,
+			Batch: batchSize,
+			Lease: lease,
+			Row:   func(delivery *models.WebhookDelivery) (uint, *int) { return delivery.ID, &delivery.Attempts },
+		},
+		client: &http.Client{
+			Transport: NewTransport(allowPrivate),
+			Timeout:   requestTimeout,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+}
+
+// Run sends due deliveries every pollInterval and purges old ones every
+// hour, until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var lastPurge time.Time
+	for {
+		now := time.Now()
+		if _, err := d.Dispatch(ctx, now); err != nil {
+			slog.Error("Webhook dispatch failed", "component", "webhooks", "error", err)
+		}
+		if now.Sub(lastPurge) >= time.Hour {
+			if purged, err := d.store.Deliveries.Purge(ctx, now.Add(-retention)); err != nil {
+				slog.Error("Webhook delivery purge failed", "component", "webhooks", "error", err)
+			} else if purged > 0 {
+				slog.Info("Purged webhook deliveries", "component", "webhooks", "deliveries", purged)
+			}
+			lastPurge = now
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Dispatch makes one attempt at every delivery due at now and returns the
+// number attempted. Deliveries claimed by another dispatcher are skipped.
+func (d *Dispatcher) Dispatch(ctx context.Context, now time.Time) (int, error) {
+	webhooks := make(map[uint]*models.Webhook)
+	return d.queue.Work(ctx, now, func(ctx context.Context, delivery models.WebhookDelivery) (repository.Fields, error) {
+		webhook, ok := webhooks[delivery.WebhookID]
+		if !ok {
+			var err error
+			if webhook, err = d.store.Webhooks.Get(ctx, delivery.WebhookID); err != nil && !errors.Is(err, repository.ErrNotFound) {
+				return nil, err
+			}
+			webhooks[delivery.WebhookID] = webhook
+		}
+		return d.attempt(ctx, webhook, delivery), nil
+	})
+}
+
+// attempt sends a delivery once and returns how to update it
+func (d *Dispatcher) attempt(ctx context.Context, webhook *models.Webhook, delivery models.WebhookDelivery) repository.Fields {
+	start := time.Now()
+	fields := repository.Fields{"last_attempt_at": start, "response_status": 0, "response_body": "", "error": ""}
+
+	status, body, err := d.send(ctx, webhook, delivery, start)
+	fields["response_status"] = status
+	fields["response_body"] = body
+	if err == nil && status >= 200 && status < 300 {
+		fields["status"] = models.DeliverySucceeded
+		return fields
+	}
+
+	if err == nil {
+		err = fmt.Errorf("webhook answered %d", status)
+	}
+	fields["error"] = err.Error()
+	if webhook == nil || delivery.Attempts >= MaxAttempts {
+		fields["status"] = models.DeliveryFailed
+		slog.Warn("Webhook delivery failed", "component", "webhooks", "webhook_id", delivery.WebhookID, "delivery_id", delivery.ID, "event", delivery.Event, "attempts", delivery.Attempts, "error", err)
+	} else {
+		fields["next_attempt_at"] = start.Add(backoff.After(delivery.Attempts))
+	}
+	return fields
+}
+
+func (d *Dispatcher) send(ctx context.Context, webhook *models.Webhook, delivery models.WebhookDelivery, now time.Time) (int, string, error) {
+	if webhook == nil {
+		return 0, "", errors.New("webhook was deleted")
+	}
+	secret, err := webhook.Secret.Open()
+	if err != nil {
+		return 0, "", fmt.Errorf("failed to decrypt webhook secret: %w", err)
+	}
+
+	body := []byte(delivery.Payload)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, "", err
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "pgm-webhooks")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatUint(uint64(delivery.ID), 10))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+	excerpt, _ := io.ReadAll(io.LimitReader(resp.Body, responseBodyLimit))
+	// Postgres text takes neither invalid UTF-8 nor NUL
+	text := strings.ReplaceAll(strings.ToValidUTF8(string(excerpt), "\uFFFD"), "\x00", "")
+	return resp.StatusCode, text, nil
+}
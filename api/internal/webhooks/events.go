@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"context"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/events"
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Subscriber queues the domain events webhooks report, as an event bus
+// handler
+func Subscriber(ctx context.Context, tx *repository.Store, record events.Record) error {
+	var event string
+	var data interface{}
+	switch e := record.Event.(type) {
+	case events.ProxyHealthChanged:
+		event, data = models.EventProxyHealthChanged, NewProxyHealth(e)
+	case events.ProxyQuarantined:
+		event, data = models.EventProxyQuarantine, ProxyQuarantine{
+			ProxyID:       e.Proxy.ID,
+			Label:         e.Proxy.Label,
+			ServerID:      e.Proxy.ServerID,
+			Action:        models.QuarantineEntered,
+			Reason:        e.Reason,
+			Actor:         "system",
+			ConfigVersion: e.ConfigVersion,
+		}
+	case events.ProxyRestored:
+		event, data = models.EventProxyQuarantine, ProxyQuarantine{
+			ProxyID:       e.Proxy.ID,
+			Label:         e.Proxy.Label,
+			ServerID:      e.Proxy.ServerID,
+			Action:        e.Action,
+			Reason:        e.Reason,
+			Actor:         e.Actor,
+			ConfigVersion: e.ConfigVersion,
+		}
+	case events.ProxyExpiring:
+		event, data = models.EventProxyExpiring, ProxyExpiry{
+			ProxyID:   e.Proxy.ID,
+			Label:     e.Proxy.Label,
+			ServerID:  e.Proxy.ServerID,
+			ExpiresAt: e.ExpiresAt,
+		}
+	case events.ProxyExpired:
+		event, data = models.EventProxyExpired, ProxyExpiry{
+			ProxyID:       e.Proxy.ID,
+			Label:         e.Proxy.Label,
+			ServerID:      e.Proxy.ServerID,
+			ExpiresAt:     e.ExpiresAt,
+			Disabled:      true,
+			ConfigVersion: e.ConfigVersion,
+		}
+	case events.ServerOffline:
+		event, data = models.EventServerOffline, NewServerStatus(e.Server, "offline")
+	case events.ServerOnline:
+		event, data = models.EventServerOnline, NewServerStatus(e.Server, "online")
+	case events.AgentAcked:
+		if e.OK {
+			return nil
+		}
+		event, data = models.EventAgentApplyFailed, AgentApplyFailed{
+			ServerID:      e.Server.ID,
+			Name:          e.Server.Name,
+			Version:       e.Version,
+			Status:        e.Status,
+			ConfigVersion: e.ConfigVersion,
+		}
+	case events.MappingCreated:
+		event, data = models.EventMappingChanged, NewMappingChanged(MappingCreated, e.Mapping, e.Actor)
+	case events.MappingUpdated:
+		event, data = models.EventMappingChanged, NewMappingChanged(MappingUpdated, e.After, e.Actor)
+	case events.MappingDeleted:
+		event, data = models.EventMappingChanged, NewMappingChanged(MappingDeleted, e.Mapping, e.Actor)
+	case events.BulkFinished:
+		event, data = models.EventBulkFinished, BulkFinished(e)
+	default:
+		return nil
+	}
+	return enqueueSubscribed(ctx, tx, event, data, record.OccurredAt)
+}
+
+// ProxyHealth is the data of proxy.health_changed
+type ProxyHealth struct {
+	ProxyID  uint   `json:"proxy_id"`
+	Label    string `json:"label"`
+	ServerID *uint  `json:"server_id"`
+	Previous string `json:"previous"`
+	Health   string `json:"health"`
+	Error    string `json:"error,omitempty"` // why the check failed
+}
+
+// NewProxyHealth reports a proxy going from its previous health to the new
+// one
+func NewProxyHealth(e events.ProxyHealthChanged) ProxyHealth {
+	return ProxyHealth{
+		ProxyID:  e.Proxy.ID,
+		Label:    e.Proxy.Label,
+		ServerID: e.Proxy.ServerID,
+		Previous: e.Proxy.Health,
+		Health:   e.Health,
+		Error:    e.Error,
+	}
+}
+
+// ProxyQuarantine is the data of proxy.quarantine
+type ProxyQuarantine struct {
+	ProxyID       uint   `json:"proxy_id"`
+	Label         string `json:"label"`
+	ServerID      *uint  `json:"server_id"`
+	Action        string `json:"action"` // quarantined, restored or released
+	Reason        string `json:"reason"`
+	Actor         string `json:"actor"`
+	ConfigVersion int    `json:"config_version"` // of the proxy's server after the change
+}
+
+// ProxyExpiry is the data of proxy.expiring and proxy.expired
+type ProxyExpiry struct {
+	ProxyID       uint      `json:"proxy_id"`
+	Label         string    `json:"label"`
+	ServerID      *uint     `json:"server_id"`
+	ExpiresAt     time.Time `json:"expires_at"`
+	Disabled      bool      `json:"disabled"`
+	ConfigVersion int       `json:"config_version,omitempty"` // of the proxy's server after it was disabled
+}
+
+// ServerStatus is the data of server.offline and server.online
+type ServerStatus struct {
+	ServerID     uint       `json:"server_id"`
+	Name         string     `json:"name"`
+	Status       string     `json:"status"`
+	LastSeenAt   *time.Time `json:"last_seen_at"`
+	ServiceState string     `json:"service_state"`
+}
+
+// NewServerStatus reports a server going to status
+func NewServerStatus(server events.ServerState, status string) ServerStatus {
+	return ServerStatus{
+		ServerID:     server.ID,
+		Name:         server.Name,
+		Status:       status,
+		LastSeenAt:   server.LastSeenAt,
+		ServiceState: server.ServiceState,
+	}
+}
+
+// AgentApplyFailed is the data of agent.apply_failed
+type AgentApplyFailed struct {
+	ServerID      uint   `json:"server_id"`
+	Name          string `json:"name"`
+	Version       int    `json:"version"` // version the agent failed to apply
+	Status        string `json:"status"`  // as reported by the agent
+	ConfigVersion int    `json:"config_version"`
+}
+
+// MappingChanged is the data of mapping.changed
+type MappingChanged struct {
+	Action          string `json:"action"` // created, updated or deleted
+	MappingID       uint   `json:"mapping_id"`
+	ServerID        uint   `json:"server_id"`
+	ClientCIDR      string `json:"client_cidr"`
+	UpstreamProxyID *uint  `json:"upstream_proxy_id"`
+	Enabled         bool   `json:"enabled"`
+	Actor           string `json:"actor"`
+}
+
+// Mapping change actions
+const (
+	MappingCreated = "created"
+	MappingUpdated = "updated"
+	MappingDeleted = "deleted"
+)
+
+// NewMappingChanged reports a mapping as it is after the change, or as it
+// was for a delete
+func NewMappingChanged(action string, mapping events.MappingState, actor string) MappingChanged {
+	return MappingChanged{
+		Action:          action,
+		MappingID:       mapping.ID,
+		ServerID:        mapping.ServerID,
+		ClientCIDR:      mapping.ClientCIDR,
+		UpstreamProxyID: mapping.UpstreamProxyID,
+		Enabled:         mapping.Enabled,
+		Actor:           actor,
+	}
+}
+
+// BulkFinished is the data of bulk.finished, sent when a bulk operation
+// is committed
+type BulkFinished struct {
+	Resource  string `json:"resource"` // proxies or mappings
+	Operation string `json:"operation"`
+	Matched   int    `json:"matched"`
+	Succeeded int    `json:"succeeded"`
+	Skipped   int    `json:"skipped"`
+	Actor     string `json:"actor"`
+}
@@ -0,0 +1,131 @@
+// Package webhooks reports events to webhook subscriptions. Subscriber
+// queues domain events from the event bus as deliveries, and a Dispatcher
+// sends them as signed HTTP POSTs, retrying with backoff.
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/Chinsusu/proxy-manager/api/internal/models"
+	"github.com/Chinsusu/proxy-manager/api/internal/repository"
+)
+
+// Headers of a delivery. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" keyed with the webhook secret, prefixed "sha256=".
+const (
+	EventHeader     = "X-PGM-Event"
+	DeliveryHeader  = "X-PGM-Delivery"
+	TimestampHeader = "X-PGM-Timestamp"
+	SignatureHeader = "X-PGM-Signature"
+)
+
+// Events are the event types webhooks can subscribe to
+var Events = []string{
+	models.EventProxyHealthChanged,
+	models.EventProxyQuarantine,
+	models.EventProxyExpiring,
+	models.EventProxyExpired,
+	models.EventServerOffline,
+	models.EventServerOnline,
+	models.EventAgentApplyFailed,
+	models.EventMappingChanged,
+	models.EventBulkFinished,
+}
+
+// Payload is the body of a delivery
+type Payload struct {
+	ID        string      `json:"id"` // same for every webhook the event is sent to
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// enqueueSubscribed queues an event that occurred at the given time for
+// every enabled webhook subscribed to it
+func enqueueSubscribed(ctx context.Context, tx *repository.Store, event string, data interface{}, occurredAt time.Time) error {
+	webhooks, err := tx.Webhooks.Subscribed(ctx, event)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+	_, err = enqueue(ctx, tx, webhooks, event, data, occurredAt)
+	return err
+}
+
+// Send queues an event for one webhook, whether or not it is subscribed
+// to it. Alert channels route alerts to their webhook this way.
+func Send(ctx context.Context, tx *repository.Store, webhook models.Webhook, event string, data interface{}, occurredAt time.Time) (*models.WebhookDelivery, error) {
+	deliveries, err := enqueue(ctx, tx, []models.Webhook{webhook}, event, data, occurredAt)
+	if err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+// Ping queues a ping event for one webhook, whether or not it is enabled
+// or subscribed to anything
+func Ping(ctx context.Context, store *repository.Store, webhook models.Webhook) (*models.WebhookDelivery, error) {
+	data := map[string]interface{}{"webhook_id": webhook.ID, "name": webhook.Name}
+	deliveries, err := enqueue(ctx, store, []models.Webhook{webhook}, models.EventPing, data, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &deliveries[0], nil
+}
+
+func enqueue(ctx context.Context, tx *repository.Store, webhooks []models.Webhook, event string, data interface{}, occurredAt time.Time) ([]models.WebhookDelivery, error) {
+	now := time.Now()
+	payload := Payload{ID: newEventID(), Event: event, CreatedAt: occurredAt.UTC(), Data: data}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		delivery := models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			EventID:       payload.ID,
+			Event:         event,
+			Payload:       string(body),
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now,
+		}
+		if err := tx.Deliveries.Create(ctx, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// Sign returns the signature header value of a body sent at timestamp
+// (Unix seconds). Receivers compute the same and compare in constant time.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// NewSecret returns a random webhook secret
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,6 +1,5 @@
 #!/usr/bin/env bash
 set -euo pipefail
-# chạy migration cho API (tùy framework)
-# ví dụ: docker compose exec api /app/migrate up
-echo "Run your DB migrations here."
-echo "Example: docker compose exec api /app/migrate up"
+# Chạy migration của API trong container: up | down [steps] | status
+# ví dụ: ./scripts/migrate.sh status
+docker compose exec api ./main migrate "${@:-status}"
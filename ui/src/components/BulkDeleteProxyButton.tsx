@@ -2,6 +2,7 @@ import React from 'react';
 import { useMutation, useQueryClient } from '@tanstack/react-query';
 import { Trash2 } from 'lucide-react';
 import { api } from '../lib/api';
+import { BulkResponse } from '../types';
 import toast from 'react-hot-toast';
 
 interface BulkDeleteProxyButtonProps {
@@ -18,20 +19,27 @@ export const BulkDeleteProxyButton: React.FC<BulkDeleteProxyButtonProps> = ({
   const queryClient = useQueryClient();
 
   const bulkDeleteMutation = useMutation({
+    // One request and one transaction: either all proxies are deleted or none
     mutationFn: async (proxyIds: string[]) => {
-      // Delete proxies sequentially to avoid overwhelming the API
-      const deletePromises = proxyIds.map(proxyId => 
-        api.delete(`/proxies/${proxyId}`)
-      );
-      return Promise.all(deletePromises);
+      const { data } = await api.post<BulkResponse>('/proxies/bulk', {
+        operation: 'delete',
+        ids: proxyIds.map(Number),
+      });
+      return data;
     },
-    onSuccess: (results) => {
-      toast.success(`Successfully deleted ${results.length} proxy(ies)`);
+    onSuccess: (result) => {
+      toast.success(`Successfully deleted ${result.succeeded} proxy(ies)`);
       queryClient.invalidateQueries({ queryKey: ['servers'] });
       onClearSelection();
     },
     onError: (error: any) => {
-      toast.error(error.response?.data?.error || 'Failed to delete some proxies');
+      const result: BulkResponse | undefined = error.response?.data;
+      const failed = result?.results?.find(item => item.status === 'failed');
+      if (failed) {
+        toast.error(`Nothing deleted: proxy ${failed.id}: ${failed.error} (${result!.failed} failed)`);
+        return;
+      }
+      toast.error(error.response?.data?.error || 'Failed to delete proxies');
     },
   });
 
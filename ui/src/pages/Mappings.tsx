@@ -68,7 +68,7 @@ export const Mappings: React.FC = () => {
                     <div className="bg-green-50 px-3 py-2 rounded">
                       <div className="text-xs font-medium text-green-700 uppercase tracking-wide">Upstream Proxy</div>
                       <div className="text-sm text-green-900">
-                        {mapping.upstream_proxy?.label || `Proxy #${mapping.upstream_proxy_id}`}
+                        {mapping.upstream_proxy?.label || (mapping.upstream_proxy_id ? `Proxy #${mapping.upstream_proxy_id}` : 'No upstream')}
                       </div>
                       {mapping.upstream_proxy && (
                         <div className="text-xs text-green-700 font-mono">
@@ -118,7 +118,7 @@ export const Mappings: React.FC = () => {
                   </span>
                   <span>→</span>
                   <span className="px-2 py-1 bg-green-100 text-green-800 rounded text-xs font-medium">
-                    {mapping.upstream_proxy?.label || `Proxy #${mapping.upstream_proxy_id}`}
+                    {mapping.upstream_proxy?.label || (mapping.upstream_proxy_id ? `Proxy #${mapping.upstream_proxy_id}` : 'No upstream')}
                   </span>
                 </div>
               </div>
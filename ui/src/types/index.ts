@@ -54,8 +54,25 @@ export interface Proxy {
   username: string;
   password: string;
   health: 'ok' | 'fail' | 'unknown';
+  tags: string; // JSON array as string
+  exit_ip: string; // empty until discovered
+  country: string; // ISO 3166-1 alpha-2
+  city: string;
+  asn: number;
+  as_org: string;
+  anonymity: '' | 'transparent' | 'anonymous' | 'elite';
   created_at: string;
   updated_at: string;
+  consecutive_failures: number;
+  consecutive_successes: number;
+  quarantined_at: string | null;
+  quarantine_policy_id: number | null;
+  provider_id: number | null;
+  expires_at: string | null;
+  cost: number;
+  order_ref: string;
+  expiry_warned_at: string | null;
+  disabled_at: string | null;
   server?: Server;
 }
 
@@ -67,6 +84,10 @@ export interface CreateProxyRequest {
   port: number;
   username?: string;
   password?: string;
+  provider_id?: number;
+  expires_at?: string;
+  cost?: number;
+  order_ref?: string;
 }
 
 export interface UpdateProxyRequest {
@@ -77,6 +98,10 @@ export interface UpdateProxyRequest {
   username?: string;
   password?: string;
   health?: 'ok' | 'fail' | 'unknown';
+  provider_id?: number; // 0 clears it
+  expires_at?: string; // '' clears it
+  cost?: number;
+  order_ref?: string;
 }
 
 export interface Mapping {
@@ -84,15 +109,321 @@ export interface Mapping {
   server_id: number;
   client_cidr: string;
   dst_ports: string; // JSON array as string
-  upstream_proxy_id: number;
+  upstream_proxy_id: number | null; // null after its proxy was deleted with force=disable
   enabled: boolean;
   notes: string;
+  tags: string; // JSON array as string
   created_at: string;
   updated_at: string;
   server?: Server;
   upstream_proxy?: Proxy;
 }
 
+export interface BulkRequest {
+  operation: 'delete' | 'enable' | 'disable' | 'set_credentials' | 'set_type' | 'recheck_health' | 'tag' | 'untag';
+  ids?: number[];
+  filter?: string;
+  dry_run?: boolean;
+  force?: 'disable' | 'remove';
+  username?: string;
+  password?: string;
+  type?: 'http' | 'https' | 'socks4' | 'socks5';
+  tags?: string[];
+}
+
+export interface BulkItemResult {
+  id: number;
+  status: 'ok' | 'skipped' | 'failed';
+  error?: string;
+  health?: 'ok' | 'fail';
+  latency_ms?: number;
+  check_error?: string;
+}
+
+export interface BulkResponse {
+  operation: string;
+  dry_run: boolean;
+  committed: boolean;
+  matched: number;
+  succeeded: number;
+  skipped: number;
+  failed: number;
+  results: BulkItemResult[];
+}
+
+export interface TrashCascade {
+  proxies: number;
+  mappings: number;
+  schedules: number;
+  detached: number;
+}
+
+export interface TrashEntry {
+  id: number;
+  resource_type: 'server' | 'proxy' | 'mapping' | 'group';
+  resource_id: number;
+  label: string;
+  server_id: number | null;
+  deleted_by: string;
+  deleted_at: string;
+  purge_at: string | null;
+  cascade: TrashCascade;
+}
+
+export interface RestoreResponse {
+  message: string;
+  entry: TrashEntry;
+  restored: TrashCascade;
+  notes: string[];
+}
+
+export type WebhookEvent =
+  | 'proxy.health_changed'
+  | 'server.offline'
+  | 'server.online'
+  | 'agent.apply_failed'
+  | 'mapping.changed'
+  | 'bulk.finished'
+  | 'proxy.quarantine'
+  | 'proxy.expiring'
+  | 'proxy.expired';
+
+export interface Webhook {
+  id: number;
+  name: string;
+  url: string;
+  secret: string;
+  events: (WebhookEvent | '*')[];
+  enabled: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface WebhookDelivery {
+  id: number;
+  webhook_id: number;
+  event_id: string;
+  event: WebhookEvent | 'ping';
+  status: 'pending' | 'succeeded' | 'failed';
+  attempts: number;
+  next_attempt_at: string | null;
+  last_attempt_at: string | null;
+  response_status: number;
+  response_body: string;
+  error: string;
+  payload: unknown;
+  created_at: string;
+}
+
+export interface ProxyStats {
+  checks: number;
+  failures: number;
+  uptime_percent: number | null;
+  latency_p50_ms: number | null;
+  latency_p95_ms: number | null;
+  failure_streak: number;
+  longest_failure_streak: number;
+  last_checked_at: string | null;
+}
+
+export interface ProxyStatsEntry extends ProxyStats {
+  proxy_id: number;
+  label: string;
+  server_id: number | null;
+  group_id: number | null;
+  health: string;
+  window_seconds: number;
+}
+
+export interface GroupStats extends ProxyStats {
+  group_id: number;
+  name: string;
+  proxies: number;
+  window_seconds: number;
+}
+
+export interface ProxyCheck {
+  id: number;
+  proxy_id: number;
+  checked_at: string;
+  ok: boolean;
+  latency_ms: number;
+  error: string;
+  error_class: '' | 'timeout' | 'refused' | 'dns' | 'unreachable' | 'reset' | 'other';
+  exit_ip: string;
+}
+
+export interface ProxyCheckRollup {
+  proxy_id: number;
+  bucket_start: string;
+  checks: number;
+  failures: number;
+  latency_p50_ms: number;
+  latency_p95_ms: number;
+  latency_max_ms: number;
+}
+
+export interface ProxyChecks {
+  proxy_id: number;
+  window_seconds: number;
+  rollups: ProxyCheckRollup[];
+  checks: ProxyCheck[];
+}
+
+export interface SharedExitIP {
+  exit_ip: string;
+  country: string;
+  city: string;
+  asn: number;
+  as_org: string;
+  proxies: Pick<Proxy, 'id' | 'label' | 'type' | 'host' | 'port' | 'health'>[];
+}
+
+export type AlertRuleKind = 'group_failing' | 'server_offline' | 'version_lag' | 'latency_p95' | 'proxy_expiring';
+
+export interface AlertRule {
+  id: number;
+  name: string;
+  kind: AlertRuleKind;
+  group_id: number | null;
+  server_id: number | null;
+  threshold: number;
+  for_seconds: number;
+  interval_seconds: number;
+  window_seconds: number;
+  channels: number[];
+  enabled: boolean;
+  evaluated_at: string | null;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AlertNotification {
+  id: number;
+  alert_id: number;
+  channel_id: number;
+  event: 'alert.firing' | 'alert.resolved';
+  status: 'pending' | 'sent' | 'failed' | 'silenced';
+  attempts: number;
+  next_attempt_at: string;
+  last_attempt_at: string | null;
+  error: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface Alert {
+  id: number;
+  rule_id: number;
+  rule: string;
+  subject: string;
+  summary: string;
+  state: 'pending' | 'firing' | 'resolved';
+  value: number;
+  active_at: string;
+  fired_at: string | null;
+  resolved_at: string | null;
+  silenced: boolean;
+  notifications?: AlertNotification[];
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AlertChannel {
+  id: number;
+  name: string;
+  type: 'email' | 'webhook' | 'telegram';
+  target: string;
+  url: string;
+  token: string;
+  webhook_id: number | null;
+  enabled: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface AlertSilence {
+  id: number;
+  rule_id: number | null;
+  subject: string;
+  comment: string;
+  created_by: string;
+  starts_at: string;
+  ends_at: string;
+  created_at: string;
+}
+
+export interface QuarantinePolicy {
+  id: number;
+  name: string;
+  group_id: number | null;
+  failures: number;
+  successes: number;
+  fallback_proxy_id: number | null;
+  fallback_group_id: number | null;
+  enabled: boolean;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface QuarantineTransition {
+  id: number;
+  proxy_id: number;
+  policy_id: number | null;
+  server_id: number | null;
+  action: 'quarantined' | 'restored' | 'released';
+  reason: string;
+  actor: string;
+  config_version: number;
+  created_at: string;
+}
+
+export interface Provider {
+  id: number;
+  name: string;
+  website: string;
+  notes: string;
+  created_at: string;
+  updated_at: string;
+}
+
+export interface SpendLine {
+  id: number | null;
+  name: string;
+  proxies: number;
+  active: number;
+  monthly_cost: number;
+  expiring: number;
+  expiring_cost: number;
+  expired: number;
+}
+
+export interface ExpiringProxy {
+  id: number;
+  label: string;
+  type: string;
+  host: string;
+  port: number;
+  health: string;
+  server_id: number | null;
+  group_id: number | null;
+  provider_id: number | null;
+  expires_at: string;
+  cost: number;
+  order_ref: string;
+  expired: boolean;
+  disabled: boolean;
+}
+
+export interface ProviderReport {
+  days: number;
+  generated_at: string;
+  total: SpendLine;
+  providers: SpendLine[];
+  groups: SpendLine[];
+  expiring: ExpiringProxy[];
+}
+
 export interface CreateMappingRequest {
   server_id: number;
   client_cidr: string;